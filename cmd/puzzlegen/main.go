@@ -0,0 +1,56 @@
+// Command puzzlegen extracts tactics puzzles from the games stored in the
+// database. It is meant to run offline, e.g. from a nightly job, against the
+// same DATABASE_URL as the server.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"chess-app/internal/analysis"
+	"chess-app/internal/bot"
+	"chess-app/internal/models"
+	"chess-app/internal/puzzle"
+	"chess-app/internal/uci"
+)
+
+func main() {
+	limit := flag.Int("limit", 100, "maximum number of puzzles to create")
+	enginePath := flag.String("engine", os.Getenv("UCI_ENGINE_PATH"), "UCI engine binary; the built-in search is used if empty")
+	engines := flag.Int("engines", 1, "number of UCI engine processes")
+	analyze := flag.Bool("analyze", true, "analyse finished games that have no analysis yet")
+	flag.Parse()
+
+	// The server owns the schema, so no migrations here
+	db, err := models.ConnectDatabase()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	var evaluator analysis.Evaluator = analysis.NewSearchEvaluator(bot.Limits{Depth: 6, MoveTime: 300 * time.Millisecond})
+	if *enginePath != "" {
+		pool := uci.NewPool(*enginePath, *engines, map[string]string{"Threads": "1"})
+		defer pool.Close()
+		evaluator = uci.NewEvaluator(pool, filepath.Base(*enginePath), uci.GoLimits{Depth: 18, MoveTime: time.Second})
+	}
+
+	var analyzer *analysis.Service
+	if *analyze {
+		analyzer = analysis.NewService(db, evaluator)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	start := time.Now()
+	created, err := puzzle.NewGenerator(db, evaluator, analyzer).Run(ctx, *limit)
+	if err != nil {
+		log.Printf("Puzzle generation stopped: %v", err)
+	}
+	log.Printf("Created %d puzzles in %s", created, time.Since(start).Round(time.Second))
+}
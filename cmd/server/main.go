@@ -3,12 +3,28 @@ package main
 import (
 	"log"
 	"net/http"
+	"path/filepath"
+	"time"
 
+	"chess-app/internal/account"
+	"chess-app/internal/admin"
+	"chess-app/internal/analysis"
 	"chess-app/internal/auth"
+	"chess-app/internal/avatar"
+	"chess-app/internal/bot"
+	"chess-app/internal/chess"
 	"chess-app/internal/config"
+	"chess-app/internal/explorer"
 	"chess-app/internal/game"
+	"chess-app/internal/mail"
 	"chess-app/internal/middleware"
 	"chess-app/internal/models"
+	"chess-app/internal/notification"
+	"chess-app/internal/puzzle"
+	"chess-app/internal/ratelimit"
+	"chess-app/internal/social"
+	"chess-app/internal/tournament"
+	"chess-app/internal/uci"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,19 +49,154 @@ func main() {
 
 	log.Println("Database connected and migrated successfully")
 
+	// Build the opening index up front so the first move isn't delayed
+	go chess.LoadOpenings()
+
 	// Initialize services
-	authService := auth.NewService(db)
+	mailer, err := mail.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up mail: %v", err)
+	}
+	authService := auth.NewService(db, mailer, cfg)
 	authHandler := auth.NewHandler(authService, cfg)
 	
 	gameService := game.NewService(db)
-	matchmakingService := game.NewMatchmakingService(db)
+	matchmakingService := game.NewMatchmakingService(gameService)
 	gameHandler := game.NewHandlerWithMatchmaking(gameService, matchmakingService)
 	gameHub := game.NewHub()
+	if cfg.HubBroker == "postgres" {
+		dsn, err := models.DatabaseDSN()
+		if err != nil {
+			log.Fatalf("Failed to configure hub broker: %v", err)
+		}
+		broker, err := game.NewPostgresBroker(db, dsn)
+		if err != nil {
+			log.Fatalf("Failed to start hub broker: %v", err)
+		}
+		defer broker.Close()
+		gameHub = game.NewHubWithBroker(broker)
+	}
 	go gameHub.Run()
+	gameService.OnEvent(gameHub.BroadcastEvent)
 	wsHandler := game.NewWSHandler(gameHub, gameService, cfg)
 
+	// Built-in computer opponent
+	botUser, err := bot.EnsureUser(db)
+	if err != nil {
+		log.Fatalf("Failed to create bot account: %v", err)
+	}
+	var botEngine bot.Engine = bot.SearchEngine{}
+	var evaluator analysis.Evaluator = analysis.NewSearchEvaluator(bot.Limits{Depth: 8, MoveTime: 500 * time.Millisecond})
+	if cfg.UCIEnginePath != "" {
+		enginePool := uci.NewPool(cfg.UCIEnginePath, cfg.UCIMaxEngines, map[string]string{"Threads": "1"})
+		defer enginePool.Close()
+		botEngine = uci.NewBotEngine(enginePool, gameService)
+		evaluator = uci.NewEvaluator(enginePool, filepath.Base(cfg.UCIEnginePath), uci.GoLimits{Depth: 18, MoveTime: time.Second})
+		log.Printf("Bot using UCI engine %s (max %d processes)", cfg.UCIEnginePath, cfg.UCIMaxEngines)
+	}
+	botPlayer := bot.NewPlayer(gameService, botEngine, botUser.ID)
+	if err := botPlayer.Resume(); err != nil {
+		log.Printf("Failed to resume bot games: %v", err)
+	}
+	botHandler := bot.NewHandler(botPlayer)
+
+	// Lichess-style API for bot accounts played by programs
+	botAPI := bot.NewAPI(gameService, gameHub)
+	gameService.OnChallenge(botAPI.OnChallenge)
+	gameService.OnGameStarted(botAPI.OnGameStarted)
+	gameService.OnGameFinished(botAPI.OnGameFinished)
+	botAPIHandler := bot.NewAPIHandler(botAPI, gameHub)
+	go matchmakingService.StartFallback(60*time.Second, botPlayer.MatchmakingFallback)
+
+	// Post-game analysis
+	analysisService := analysis.NewService(db, evaluator)
+	analysisService.Start(1)
+	gameService.OnGameFinished(analysisService.OnGameFinished)
+	analysisHandler := analysis.NewHandler(analysisService)
+
+	// Opening explorer over the server's own games
+	explorerService := explorer.NewService(db)
+	gameService.OnGameFinished(explorerService.OnGameFinished)
+	gameService.OnGameAnnulled(explorerService.OnGameAnnulled)
+	go func() {
+		if err := explorerService.Backfill(); err != nil {
+			log.Printf("Failed to backfill opening explorer: %v", err)
+		}
+	}()
+	explorerHandler := explorer.NewHandler(explorerService)
+
+	// Tactics puzzles, generated offline by cmd/puzzlegen
+	puzzleHandler := puzzle.NewHandler(puzzle.NewService(db))
+
+	// Notifications, pushed on each user's stream
+	notificationService := notification.NewService(db, gameHub)
+	gameService.OnChallenge(notificationService.OnChallenge)
+	gameService.OnMove(notificationService.OnMove)
+	matchmakingService.OnMatch(notificationService.OnMatch)
+	notificationHandler := notification.NewHandler(notificationService)
+
+	// Tournaments
+	tournamentService := tournament.NewService(gameService, gameHub, notificationService)
+	gameService.OnGameFinished(tournamentService.OnGameFinished)
+	gameService.OnGameAnnulled(tournamentService.OnGameAnnulled)
+	if err := tournamentService.Resume(); err != nil {
+		log.Printf("Failed to resume tournaments: %v", err)
+	}
+	tournamentHandler := tournament.NewHandler(tournamentService)
+
+	// Friends, follows, blocks and presence
+	socialHandler := social.NewHandler(social.NewService(gameService, gameHub, notificationService))
+
+	// Moderation
+	adminService := admin.NewService(db, gameService, authService, gameHub)
+	if n, err := adminService.PromoteAdmins(cfg.AdminEmails); err != nil {
+		log.Printf("Failed to promote admins: %v", err)
+	} else if n > 0 {
+		log.Printf("Promoted %d account(s) to admin", n)
+	}
+	adminHandler := admin.NewHandler(adminService)
+
+	// Avatars
+	var avatarStorage avatar.Storage
+	if cfg.AvatarStorage == "s3" {
+		avatarStorage, err = avatar.NewS3Storage(avatar.S3Options{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			PathStyle:       cfg.S3.PathStyle,
+		})
+	} else {
+		avatarStorage, err = avatar.NewLocalStorage(cfg.AvatarDir)
+	}
+	if err != nil {
+		log.Fatalf("Failed to set up avatar storage: %v", err)
+	}
+	avatarService := avatar.NewService(db, avatarStorage)
+	if n, err := avatarService.ClearExternalURLs(); err != nil {
+		log.Printf("Failed to clear external avatar URLs: %v", err)
+	} else if n > 0 {
+		log.Printf("Cleared %d external avatar URL(s)", n)
+	}
+	avatarHandler := avatar.NewHandler(avatarService)
+
+	// Personal data export and account deletion
+	accountHandler := account.NewHandler(account.NewService(db, gameService, tournamentService, avatarService, authService, gameHub, cfg.AppURL))
+
+	// Rate limiting, shared by instances with the postgres store
+	var limitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimitStore == "postgres" {
+		limitStore = ratelimit.NewPostgresStore(db)
+	}
+
 	// Setup router
 	r := gin.Default()
+	// Only the configured proxies may name the client in X-Forwarded-For,
+	// which the rate limits per address rely on
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
 
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
@@ -62,7 +213,7 @@ func main() {
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type, Retry-After")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -79,38 +230,170 @@ func main() {
 
 	// API routes
 	api := r.Group("/api")
+	api.Use(middleware.RateLimitMiddleware(limitStore, "api", cfg.APIIPLimit, middleware.ByIP))
 	{
-		// Auth routes (public)
-		api.POST("/auth/register", authHandler.Register)
-		api.POST("/auth/login", authHandler.Login)
+		// Auth routes (public), with stricter limits against password guessing
+		credentials := api.Group("")
+		credentials.Use(
+			middleware.RateLimitMiddleware(limitStore, "auth", cfg.AuthIPLimit, middleware.ByIP),
+			middleware.RateLimitMiddleware(limitStore, "auth", cfg.AuthAccountLimit, middleware.ByEmail),
+		)
+		credentials.POST("/auth/register", authHandler.Register)
+		credentials.POST("/auth/login", authHandler.Login)
+		credentials.POST("/auth/login/2fa", authHandler.LoginMFA)
+		credentials.POST("/auth/verify", authHandler.VerifyEmail)
+		credentials.POST("/auth/forgot", authHandler.ForgotPassword)
+		credentials.POST("/auth/reset", authHandler.ResetPassword)
+		api.GET("/auth/oidc/providers", authHandler.ListOIDCProviders)
+		api.GET("/auth/oidc/:provider/login", authHandler.OIDCLogin)
+		api.GET("/auth/oidc/:provider/callback", authHandler.OIDCCallback)
 
 		// Auth routes (public)
 		api.POST("/auth/refresh", authHandler.RefreshToken)
 		api.POST("/auth/logout", authHandler.Logout)
+		api.POST("/token/test", authHandler.TestAPITokens)
+		api.GET("/avatars/:id", avatarHandler.Serve)
 
-		// Protected routes
-		protected := api.Group("")
-		protected.Use(middleware.JWTAuthMiddleware(cfg))
+		// Protected routes. Groups created with scopes also accept personal
+		// access tokens that have one of them.
+		userLimit := middleware.RateLimitMiddleware(limitStore, "api", cfg.APIUserLimit, middleware.ByUser)
+		authenticated := func(scopes ...string) *gin.RouterGroup {
+			group := api.Group("")
+			group.Use(middleware.JWTAuthMiddleware(cfg, authService, scopes...), userLimit)
+			return group
+		}
+		protected := authenticated()
+		gameRead := authenticated(auth.ScopeGameRead)
+		gamePlay := authenticated(auth.ScopeGamePlay)
+		challengeWrite := authenticated(auth.ScopeChallengeWrite)
+		sockets := authenticated(auth.ScopeGamePlay, auth.ScopeBotPlay)
+		botPlay := authenticated(auth.ScopeBotPlay)
+		challenges := authenticated(auth.ScopeChallengeWrite, auth.ScopeBotPlay)
 		{
 			// Auth routes
 			protected.GET("/auth/me", authHandler.GetProfile)
-			protected.PUT("/auth/avatar", authHandler.UpdateAvatar)
+			protected.DELETE("/auth/me", accountHandler.Delete)
+			protected.GET("/auth/me/export", accountHandler.Export)
+			protected.PUT("/auth/avatar", avatarHandler.Upload)
+			protected.DELETE("/auth/avatar", avatarHandler.Remove)
+			protected.POST("/auth/verify/resend", authHandler.ResendVerification)
+			protected.POST("/auth/2fa/setup", authHandler.SetupTOTP)
+			protected.POST("/auth/2fa/confirm", authHandler.ConfirmTOTP)
+			protected.POST("/auth/2fa/disable", authHandler.DisableTOTP)
+			protected.POST("/auth/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+			protected.GET("/auth/identities", authHandler.ListIdentities)
+			protected.POST("/auth/oidc/:provider/link", authHandler.OIDCLink)
+			protected.DELETE("/auth/identities/:provider", authHandler.UnlinkIdentity)
+			protected.GET("/auth/sessions", authHandler.ListSessions)
+			protected.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
+			protected.GET("/auth/tokens", authHandler.ListAPITokens)
+			protected.POST("/auth/tokens", authHandler.CreateAPIToken)
+			protected.DELETE("/auth/tokens/:id", authHandler.RevokeAPIToken)
 			
 			// Game routes
-			protected.POST("/games", gameHandler.CreateGame)
-			protected.GET("/games", gameHandler.GetUserGames)
-			protected.GET("/games/:id", gameHandler.GetGame)
-			protected.POST("/games/:id/join", gameHandler.JoinGame)
-			protected.GET("/games/:id/history", gameHandler.GetGameHistory)
+			challengeWrite.POST("/games", gameHandler.CreateGame)
+			gameRead.GET("/games", gameHandler.GetUserGames)
+			gameRead.GET("/games/openings", gameHandler.GetOpeningStats)
+			gameRead.GET("/games/:id", gameHandler.GetGame)
+			gamePlay.POST("/games/:id/join", gameHandler.JoinGame)
+			gameRead.GET("/games/:id/history", gameHandler.GetGameHistory)
+			gameRead.GET("/games/:id/analysis", analysisHandler.GetAnalysis)
+			protected.POST("/games/:id/analysis", analysisHandler.RequestAnalysis)
 			
 			// Matchmaking routes
-			protected.POST("/matchmaking/find", gameHandler.FindMatch)
-			protected.POST("/matchmaking/cancel", gameHandler.CancelMatchmaking)
-			protected.GET("/matchmaking/status", gameHandler.GetQueueStatus)
+			gamePlay.POST("/matchmaking/find", gameHandler.FindMatch)
+			gamePlay.POST("/matchmaking/cancel", gameHandler.CancelMatchmaking)
+			gamePlay.GET("/matchmaking/status", gameHandler.GetQueueStatus)
+
+			// Opening explorer
+			protected.GET("/explorer", explorerHandler.Explore)
+
+			// Puzzle routes
+			protected.GET("/puzzles/next", puzzleHandler.Next)
+			protected.POST("/puzzles/:id/attempt", puzzleHandler.Attempt)
+
+			// Tournament routes
+			protected.POST("/tournaments", tournamentHandler.CreateTournament)
+			protected.GET("/tournaments", tournamentHandler.ListTournaments)
+			protected.GET("/tournaments/:id", tournamentHandler.GetTournament)
+			protected.POST("/tournaments/:id/join", tournamentHandler.Join)
+			protected.POST("/tournaments/:id/withdraw", tournamentHandler.Withdraw)
+			protected.POST("/tournaments/:id/start", tournamentHandler.Start)
+			protected.POST("/tournaments/:id/games/:gameId/berserk", tournamentHandler.Berserk)
+			protected.GET("/tournaments/:id/standings", tournamentHandler.GetStandings)
+			protected.GET("/tournaments/:id/rounds/:round", tournamentHandler.GetRound)
+			protected.GET("/tournaments/:id/crosstable", tournamentHandler.GetCrosstable)
+
+			// Social routes
+			protected.GET("/friends", socialHandler.ListFriends)
+			protected.GET("/friends/requests", socialHandler.ListRequests)
+			protected.POST("/friends/:id", socialHandler.RequestFriend)
+			protected.POST("/friends/:id/accept", socialHandler.AcceptFriend)
+			protected.DELETE("/friends/:id", socialHandler.RemoveFriend)
+			protected.GET("/follows", socialHandler.ListFollowing)
+			protected.GET("/followers", socialHandler.ListFollowers)
+			protected.POST("/follows/:id", socialHandler.Follow)
+			protected.DELETE("/follows/:id", socialHandler.Unfollow)
+			protected.GET("/blocks", socialHandler.ListBlocks)
+			protected.POST("/blocks/:id", socialHandler.Block)
+			protected.DELETE("/blocks/:id", socialHandler.Unblock)
+
+			// Notification routes
+			protected.GET("/notifications", notificationHandler.List)
+			protected.POST("/notifications/read", notificationHandler.MarkAllRead)
+			protected.POST("/notifications/:id/read", notificationHandler.MarkRead)
+
+			// Bot routes
+			challengeWrite.GET("/bot/levels", botHandler.GetLevels)
+			challengeWrite.POST("/bot/challenge", botHandler.Challenge)
+
+			// Lichess-style bot API
+			botPlay.GET("/account", botAPIHandler.Account)
+			botPlay.GET("/account/playing", botAPIHandler.Playing)
+			botPlay.POST("/bot/account/upgrade", botAPIHandler.Upgrade)
+			botPlay.GET("/bot/stream/event", botAPIHandler.StreamEvents)
+			botPlay.GET("/stream/event", botAPIHandler.StreamEvents)
+			botPlay.GET("/bot/game/stream/:id", botAPIHandler.StreamGame)
+			botPlay.POST("/bot/game/:id/move/:uci", botAPIHandler.Move)
+			botPlay.POST("/bot/game/:id/resign", botAPIHandler.Resign)
+			challenges.POST("/challenge/:id/accept", botAPIHandler.AcceptChallenge)
+			challenges.POST("/challenge/:id/decline", botAPIHandler.DeclineChallenge)
+
+			// WebSocket tickets
+			sockets.POST("/ws/ticket", wsHandler.IssueTicket)
+
+			// Reports, reviewed by moderators
+			protected.POST("/reports", adminHandler.CreateReport)
+
+			// Moderation routes
+			moderation := protected.Group("/admin")
+			moderation.Use(middleware.RequireRole(authService, models.RoleModerator))
+			{
+				moderation.GET("/users", adminHandler.SearchUsers)
+				moderation.GET("/users/:id", adminHandler.GetUser)
+				moderation.POST("/users/:id/ban", adminHandler.Ban)
+				moderation.POST("/users/:id/suspend", adminHandler.Suspend)
+				moderation.POST("/users/:id/unban", adminHandler.Unban)
+				moderation.POST("/games/:id/end", adminHandler.EndGame)
+				moderation.GET("/reports", adminHandler.ListReports)
+				moderation.POST("/reports/:id/resolve", adminHandler.ResolveReport)
+			}
+
+			// Admin routes
+			administration := protected.Group("/admin")
+			administration.Use(middleware.RequireRole(authService, models.RoleAdmin))
+			{
+				administration.PUT("/users/:id/rating", adminHandler.AdjustRating)
+				administration.PUT("/users/:id/role", adminHandler.SetRole)
+				administration.POST("/games/:id/annul", adminHandler.AnnulGame)
+				administration.GET("/audit", adminHandler.ListAuditLog)
+			}
 		}
 		
-		// WebSocket route (auth handled in handler via query param)
+		// WebSocket routes (auth handled in handler via ?ticket=)
 		api.GET("/ws/games/:id", wsHandler.HandleWebSocket)
+		api.GET("/ws/tournaments/:id", wsHandler.HandleTournamentWebSocket)
+		api.GET("/ws/user", wsHandler.HandleUserWebSocket)
 	}
 
 	// Start server
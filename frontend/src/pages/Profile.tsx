@@ -1,13 +1,14 @@
-import { useState, useEffect } from 'react'
+import { useState, useEffect, ChangeEvent } from 'react'
 import { useNavigate } from 'react-router-dom'
 import { useAuth } from '../contexts/AuthContext'
 import { api } from '../services/api'
 import './Profile.css'
 
 export default function Profile() {
-  const { token, user, logout } = useAuth()
+  const { token, user, setAuth, logout } = useAuth()
   const navigate = useNavigate()
   const [avatarUrl, setAvatarUrl] = useState('')
+  const [file, setFile] = useState<File | null>(null)
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState('')
   const [success, setSuccess] = useState('')
@@ -18,14 +19,22 @@ export default function Profile() {
     }
   }, [user])
 
+  const handleFileChange = (e: ChangeEvent<HTMLInputElement>) => {
+    const selected = e.target.files?.[0] || null
+    setFile(selected)
+    setAvatarUrl(selected ? URL.createObjectURL(selected) : user?.avatarUrl || '')
+  }
+
   const handleSaveAvatar = async () => {
-    if (!token) return
+    if (!token || !user || !file) return
     setLoading(true)
     setError('')
     setSuccess('')
 
     try {
-      await api.updateAvatar(token, avatarUrl)
+      const res = await api.uploadAvatar(token, file)
+      setAuth(token, { ...user, avatarUrl: res.avatarUrl })
+      setFile(null)
       setSuccess('Avatar mis à jour avec succès')
       setTimeout(() => setSuccess(''), 3000)
     } catch (err: any) {
@@ -60,13 +69,12 @@ export default function Profile() {
         <div className="avatar-section">
           <h2>Avatar</h2>
           <p className="help-text">
-            Entrez l'URL de votre image d'avatar
+            Choisissez une image PNG, JPEG ou GIF (4 Mo maximum), recadrée en carré
           </p>
           <input
-            type="url"
-            value={avatarUrl}
-            onChange={(e) => setAvatarUrl(e.target.value)}
-            placeholder="https://example.com/avatar.jpg"
+            type="file"
+            accept="image/png,image/jpeg,image/gif"
+            onChange={handleFileChange}
             className="avatar-input"
           />
           {avatarUrl && (
@@ -76,7 +84,7 @@ export default function Profile() {
               }} />
             </div>
           )}
-          <button onClick={handleSaveAvatar} disabled={loading}>
+          <button onClick={handleSaveAvatar} disabled={loading || !file}>
             {loading ? 'Sauvegarde...' : 'Sauvegarder'}
           </button>
         </div>
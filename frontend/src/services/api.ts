@@ -125,11 +125,14 @@ class ApiClient {
     return res.json()
   }
 
-  async updateAvatar(token: string, avatarUrl: string) {
+  async uploadAvatar(token: string, file: File): Promise<{ avatarUrl: string }> {
+    const body = new FormData()
+    body.append('avatar', file)
     const res = await fetch(`${API_BASE}/auth/avatar`, {
       method: 'PUT',
-      headers: this.getHeaders(token),
-      body: JSON.stringify({ avatarUrl }),
+      // No Content-Type: the browser sets the multipart boundary
+      headers: { Authorization: `Bearer ${token}` },
+      body,
       credentials: 'include',
     })
     if (!res.ok) {
@@ -236,6 +239,20 @@ class ApiClient {
     return res.json()
   }
 
+  async getWSTicket(token: string, target: { gameId?: number; tournamentId?: number } = {}): Promise<string> {
+    const res = await fetch(`${API_BASE}/ws/ticket`, {
+      method: 'POST',
+      headers: this.getHeaders(token),
+      body: JSON.stringify(target),
+    })
+    if (!res.ok) {
+      const err = await res.json()
+      throw new Error(err.error || 'Failed to get WebSocket ticket')
+    }
+    const data = await res.json()
+    return data.ticket
+  }
+
   async getMatchmakingStatus(token: string) {
     const res = await fetch(`${API_BASE}/matchmaking/status`, {
       headers: this.getHeaders(token),
@@ -1,3 +1,5 @@
+import { api } from './api'
+
 export interface WSMessage {
   type: string
   [key: string]: any
@@ -26,7 +28,7 @@ export class WebSocketClient {
     this.onError = onError
   }
 
-  connect() {
+  async connect() {
     if (this.ws && (this.ws.readyState === WebSocket.CONNECTING || this.ws.readyState === WebSocket.OPEN)) {
       return
     }
@@ -36,9 +38,21 @@ export class WebSocketClient {
       this.reconnectTimeout = null
     }
 
+    // Each connection needs a fresh single-use ticket
+    let ticket: string
+    try {
+      ticket = await api.getWSTicket(this.token, { gameId: this.gameId })
+    } catch (err) {
+      this.onError(err instanceof Error ? err : new Error('Failed to get WebSocket ticket'))
+      return
+    }
+    if (!this.shouldReconnect) {
+      return
+    }
+
     const wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
     const wsHost = import.meta.env.DEV ? 'localhost:8080' : window.location.host
-    const wsUrl = `${wsProtocol}//${wsHost}/api/ws/games/${this.gameId}?token=${this.token}`
+    const wsUrl = `${wsProtocol}//${wsHost}/api/ws/games/${this.gameId}?ticket=${encodeURIComponent(ticket)}`
 
     try {
       this.ws = new WebSocket(wsUrl)
@@ -0,0 +1,340 @@
+package account
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"chess-app/internal/admin"
+	"chess-app/internal/models"
+
+	"github.com/notnil/chess"
+	"gorm.io/gorm"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// export holds everything an archive is made of, loaded before any of it is
+// written
+type export struct {
+	user          models.User
+	identities    []models.UserIdentity
+	sessions      []models.Session
+	tokens        []models.APIToken
+	games         []models.Game
+	moves         map[uint][]models.Move // By game
+	adjustments   []models.AuditLog
+	puzzles       []models.PuzzleAttempt
+	friendships   []models.Friendship
+	follows       []models.Follow
+	blocks        []models.Block
+	tournaments   []models.TournamentPlayer
+	reports       []models.Report
+	notifications []models.Notification
+}
+
+// Export returns a zip archive of a user's personal data: their profile,
+// their games as PGN and their moves, their rating history and their social
+// links. The site has no chat, so there are no messages to include.
+func (s *Service) Export(userID uint) ([]byte, error) {
+	data, err := s.load(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name  string
+		write func(*bytes.Buffer) error
+	}{
+		{"profile.json", func(b *bytes.Buffer) error {
+			return writeJSON(b, map[string]interface{}{
+				"user":       data.user,
+				"identities": data.identities,
+				"sessions":   data.sessions,
+				"apiTokens":  data.tokens,
+				"exportedAt": time.Now().UTC(),
+			})
+		}},
+		{"games.pgn", func(b *bytes.Buffer) error { return s.writePGN(b, data) }},
+		{"moves.csv", func(b *bytes.Buffer) error { return writeMoves(b, data) }},
+		{"rating_history.csv", func(b *bytes.Buffer) error { return writeRatingHistory(b, data) }},
+		{"puzzles.csv", func(b *bytes.Buffer) error { return writePuzzles(b, data) }},
+		{"social.json", func(b *bytes.Buffer) error {
+			return writeJSON(b, map[string]interface{}{
+				"friendships": data.friendships,
+				"follows":     data.follows,
+				"blocks":      data.blocks,
+				"tournaments": data.tournaments,
+				"reports":     data.reports,
+			})
+		}},
+		{"notifications.json", func(b *bytes.Buffer) error { return writeJSON(b, data.notifications) }},
+	}
+	for _, f := range files {
+		var content bytes.Buffer
+		if err := f.write(&content); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(content.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Service) load(userID uint) (*export, error) {
+	data := &export{moves: make(map[uint][]models.Move)}
+	if err := s.db.First(&data.user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	// Games waiting for an opponent have no moves and no result yet
+	if err := s.db.Preload("WhitePlayer").Preload("BlackPlayer").
+		Where("status <> ? AND (white_player_id = ? OR black_player_id = ?)", models.GameStatusWaiting, userID, userID).
+		Order("created_at, id").
+		Find(&data.games).Error; err != nil {
+		return nil, err
+	}
+	if len(data.games) > 0 {
+		ids := make([]uint, len(data.games))
+		for i, g := range data.games {
+			ids[i] = g.ID
+		}
+		var moves []models.Move
+		if err := s.db.Where("game_id IN ?", ids).Order("game_id, ply_number").Find(&moves).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range moves {
+			data.moves[m.GameID] = append(data.moves[m.GameID], m)
+		}
+	}
+
+	queries := []struct {
+		dest  interface{}
+		where string
+	}{
+		{&data.identities, "user_id = @id"},
+		{&data.sessions, "user_id = @id"},
+		{&data.tokens, "user_id = @id"},
+		{&data.puzzles, "user_id = @id"},
+		{&data.friendships, "requester_id = @id OR addressee_id = @id"},
+		{&data.follows, "follower_id = @id OR followee_id = @id"},
+		{&data.blocks, "blocker_id = @id"},
+		{&data.tournaments, "user_id = @id"},
+		{&data.reports, "reporter_id = @id"},
+		{&data.notifications, "user_id = @id"},
+	}
+	for _, q := range queries {
+		if err := s.db.Where(q.where, map[string]interface{}{"id": userID}).Order("created_at").Find(q.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.Where("action = ? AND target_type = ? AND target_id = ?", admin.ActionAdjustRating, "user", userID).
+		Order("created_at").
+		Find(&data.adjustments).Error; err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}) error {
+	enc := json.NewEncoder(b)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writePGN writes every game with its moves. The PGN stored on a game is not
+// used: it only holds the moves since the game was last loaded.
+func (s *Service) writePGN(b *bytes.Buffer, data *export) error {
+	for _, g := range data.games {
+		event := "Casual game"
+		if g.Rated {
+			event = "Rated game"
+		}
+		result := pgnResult(g.Result)
+		writeTag := func(name, value string) {
+			fmt.Fprintf(b, "[%s \"%s\"]\n", name, strings.ReplaceAll(value, "\"", "'"))
+		}
+		writeTag("Event", event)
+		writeTag("Site", fmt.Sprintf("%s/game/%d", strings.TrimSuffix(s.siteURL, "/"), g.ID))
+		writeTag("Date", g.CreatedAt.Format("2006.01.02"))
+		writeTag("White", playerName(g.WhitePlayer))
+		writeTag("Black", playerName(g.BlackPlayer))
+		writeTag("Result", result)
+		if g.Rated && g.Status == models.GameStatusFinished && !g.Annulled {
+			writeTag("WhiteRatingDiff", fmt.Sprintf("%+d", g.WhiteRatingDiff))
+			writeTag("BlackRatingDiff", fmt.Sprintf("%+d", g.BlackRatingDiff))
+		}
+		if g.ECO != "" {
+			writeTag("ECO", g.ECO)
+			writeTag("Opening", g.Opening)
+		}
+		writeTag("TimeControl", strconv.Itoa(g.TimeControl))
+		if g.Annulled {
+			writeTag("Annotator", "Annulled by a moderator")
+		}
+		b.WriteString("\n")
+
+		pos := chess.StartingPosition()
+		for _, m := range data.moves[g.ID] {
+			move, err := chess.UCINotation{}.Decode(pos, m.MoveNotation)
+			if err != nil {
+				return fmt.Errorf("game %d, ply %d: %w", g.ID, m.PlyNumber, err)
+			}
+			if pos.Turn() == chess.White {
+				fmt.Fprintf(b, "%d. ", (m.PlyNumber+1)/2)
+			}
+			b.WriteString(chess.AlgebraicNotation{}.Encode(pos, move))
+			b.WriteString(" ")
+			pos = pos.Update(move)
+		}
+		b.WriteString(result)
+		b.WriteString("\n\n")
+	}
+	return nil
+}
+
+func writeMoves(b *bytes.Buffer, data *export) error {
+	w := csv.NewWriter(b)
+	w.Write([]string{"game_id", "ply", "color", "uci", "fen_after", "played_at"})
+	for _, g := range data.games {
+		for _, m := range data.moves[g.ID] {
+			color := "white"
+			if m.PlyNumber%2 == 0 {
+				color = "black"
+			}
+			w.Write([]string{
+				strconv.FormatUint(uint64(g.ID), 10),
+				strconv.Itoa(m.PlyNumber),
+				color,
+				m.MoveNotation,
+				m.BoardState,
+				m.CreatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ratingChange moves a user's rating by change at some point
+type ratingChange struct {
+	at        time.Time
+	kind      string // "game" or "adjustment"
+	reference uint   // Game or audit log ID
+	change    int
+}
+
+// writeRatingHistory rebuilds the rating after each rated game and each
+// adjustment by an admin, walking back from the current rating. Annulled
+// games are left out along with their rollback, which cancel each other.
+func writeRatingHistory(b *bytes.Buffer, data *export) error {
+	var changes []ratingChange
+	for _, g := range data.games {
+		if !g.Rated || g.Annulled || g.Status != models.GameStatusFinished {
+			continue
+		}
+		change := g.BlackRatingDiff
+		if g.WhitePlayerID != nil && *g.WhitePlayerID == data.user.ID {
+			change = g.WhiteRatingDiff
+		}
+		changes = append(changes, ratingChange{at: g.UpdatedAt, kind: "game", reference: g.ID, change: change})
+	}
+	for _, entry := range data.adjustments {
+		changes = append(changes, ratingChange{
+			at:        entry.CreatedAt,
+			kind:      "adjustment",
+			reference: entry.ID,
+			change:    detailInt(entry.Details, "to") - detailInt(entry.Details, "from"),
+		})
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].at.Before(changes[j].at) })
+
+	ratings := make([]int, len(changes))
+	rating := data.user.ELORating
+	for i := len(changes) - 1; i >= 0; i-- {
+		ratings[i] = rating
+		rating -= changes[i].change
+	}
+
+	w := csv.NewWriter(b)
+	w.Write([]string{"date", "kind", "reference", "change", "rating"})
+	for i, c := range changes {
+		w.Write([]string{
+			c.at.UTC().Format(time.RFC3339),
+			c.kind,
+			strconv.FormatUint(uint64(c.reference), 10),
+			fmt.Sprintf("%+d", c.change),
+			strconv.Itoa(ratings[i]),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writePuzzles(b *bytes.Buffer, data *export) error {
+	w := csv.NewWriter(b)
+	w.Write([]string{"date", "puzzle_id", "solved", "rating_before", "rating_after"})
+	for _, a := range data.puzzles {
+		w.Write([]string{
+			a.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(a.PuzzleID), 10),
+			strconv.FormatBool(a.Solved),
+			strconv.Itoa(a.RatingBefore),
+			strconv.Itoa(a.RatingAfter),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// detailInt reads a number from audit log details, which come back from the
+// database as JSON
+func detailInt(details models.JSONMap, key string) int {
+	switch v := details[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+func pgnResult(result models.GameResult) string {
+	switch result {
+	case models.GameResultWhiteWins:
+		return "1-0"
+	case models.GameResultBlackWins:
+		return "0-1"
+	case models.GameResultDraw:
+		return "1/2-1/2"
+	}
+	return "*"
+}
+
+func playerName(user *models.User) string {
+	if user == nil {
+		return "?"
+	}
+	return user.Username
+}
@@ -0,0 +1,152 @@
+// Package account lets users export their personal data and delete their
+// account. Deleted accounts are anonymised rather than removed, so that
+// their opponents' games stay intact.
+package account
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"chess-app/internal/auth"
+	"chess-app/internal/avatar"
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+	"chess-app/internal/tournament"
+
+	"gorm.io/gorm"
+)
+
+type Service struct {
+	db          *gorm.DB
+	games       *game.Service
+	tournaments *tournament.Service
+	avatars     *avatar.Service
+	auth        *auth.Service
+	hub         *game.Hub
+	siteURL     string
+}
+
+// NewService creates the account service. siteURL names the server in
+// exported PGN files.
+func NewService(db *gorm.DB, games *game.Service, tournaments *tournament.Service, avatars *avatar.Service, authService *auth.Service, hub *game.Hub, siteURL string) *Service {
+	return &Service{db: db, games: games, tournaments: tournaments, avatars: avatars, auth: authService, hub: hub, siteURL: siteURL}
+}
+
+// Delete anonymises a user's account once their password, and second factor
+// if they have one, is confirmed. Their games in progress are resigned,
+// their open games and challenges are cancelled, and everything that is not
+// needed to keep other users' games and standings intact is deleted. They
+// are then signed out everywhere.
+func (s *Service) Delete(userID uint, password, code string) error {
+	user, err := s.auth.ConfirmPassword(userID, password, code)
+	if err != nil {
+		return err
+	}
+
+	if err := s.endGames(user.ID); err != nil {
+		return err
+	}
+	if err := s.avatars.Remove(context.Background(), user.ID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"username":             fmt.Sprintf("%s%d", models.DeletedUsernamePrefix, user.ID),
+			"email":                fmt.Sprintf("%s%d@deleted.invalid", models.DeletedUsernamePrefix, user.ID),
+			"password_hash":        "!", // Not a valid bcrypt hash, so every login attempt fails
+			"email_verified":       false,
+			"totp_secret":          "",
+			"totp_enabled":         false,
+			"totp_last_step":       0,
+			"failed_logins":        0,
+			"last_failed_login_at": nil,
+			"locked_until":         nil,
+			"role":                 models.RoleUser,
+			"deleted_at":           now,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Either side of a friendship or a follow, but only the blocks the
+		// user made: those made against them still protect other users
+		id := map[string]interface{}{"id": user.ID}
+		deletions := []struct {
+			model interface{}
+			where string
+		}{
+			{&models.RefreshToken{}, "user_id = @id"},
+			{&models.Session{}, "user_id = @id"},
+			{&models.RecoveryCode{}, "user_id = @id"},
+			{&models.UserIdentity{}, "user_id = @id"},
+			{&models.APIToken{}, "user_id = @id"},
+			{&models.WSTicket{}, "user_id = @id"},
+			{&models.Notification{}, "user_id = @id"},
+			{&models.PuzzleAttempt{}, "user_id = @id"},
+			{&models.Friendship{}, "requester_id = @id OR addressee_id = @id"},
+			{&models.Follow{}, "follower_id = @id OR followee_id = @id"},
+			{&models.Block{}, "blocker_id = @id"},
+		}
+		for _, d := range deletions {
+			if err := tx.Where(d.where, id).Delete(d.model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.hub.DisconnectUser(user.ID)
+	return nil
+}
+
+// endGames resigns a user's games in progress, cancels the games and
+// challenges waiting for them or for an opponent, and withdraws them from
+// tournaments
+func (s *Service) endGames(userID uint) error {
+	var games []models.Game
+	if err := s.db.Where("status IN @statuses AND (white_player_id = @id OR black_player_id = @id OR invited_player_id = @id)",
+		map[string]interface{}{
+			"statuses": []models.GameStatus{models.GameStatusWaiting, models.GameStatusActive},
+			"id":       userID,
+		}).
+		Find(&games).Error; err != nil {
+		return err
+	}
+
+	for _, g := range games {
+		var err error
+		switch {
+		case g.Status == models.GameStatusActive:
+			_, err = s.games.Resign(g.ID, userID)
+		case g.InvitedPlayerID != nil && *g.InvitedPlayerID == userID:
+			err = s.games.DeclineChallenge(g.ID, userID)
+		default:
+			err = s.games.CancelGame(g.ID, userID)
+		}
+		// The game may have ended or started in the meantime
+		if err != nil {
+			log.Printf("account: failed to end game %d of user %d: %v", g.ID, userID, err)
+		}
+	}
+
+	var entries []models.TournamentPlayer
+	if err := s.db.Joins("JOIN tournaments ON tournaments.id = tournament_players.tournament_id").
+		Where("tournament_players.user_id = ? AND NOT tournament_players.withdrawn AND tournaments.status <> ?",
+			userID, models.TournamentStatusFinished).
+		Find(&entries).Error; err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// Knockout brackets keep their players once drawn
+		if err := s.tournaments.Withdraw(entry.TournamentID, userID); err != nil {
+			log.Printf("account: failed to withdraw user %d from tournament %d: %v", userID, entry.TournamentID, err)
+		}
+	}
+	return nil
+}
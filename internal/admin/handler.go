@@ -0,0 +1,348 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// ReasonRequest explains a moderation action, for the audit log
+type ReasonRequest struct {
+	Reason string `json:"reason"`
+}
+
+type SuspendRequest struct {
+	Hours  int    `json:"hours" binding:"required,min=1"`
+	Reason string `json:"reason"`
+}
+
+type RatingRequest struct {
+	Rating *int   `json:"rating" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+type RoleRequest struct {
+	Role   string `json:"role" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+type EndGameRequest struct {
+	Result models.GameResult `json:"result" binding:"required"`
+	Reason string            `json:"reason"`
+}
+
+type ResolveReportRequest struct {
+	Status     models.ReportStatus `json:"status" binding:"required"`
+	Resolution string              `json:"resolution"`
+}
+
+type CreateReportRequest struct {
+	UserID  uint   `json:"userId" binding:"required"`
+	GameID  *uint  `json:"gameId"`
+	Reason  string `json:"reason" binding:"required"`
+	Details string `json:"details"`
+}
+
+// SearchUsers lists users: GET /api/admin/users?q=&role=&status=&limit=&offset=
+func (h *Handler) SearchUsers(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	users, total, err := h.service.SearchUsers(UserFilter{
+		Query:  c.Query("q"),
+		Role:   c.Query("role"),
+		Status: c.Query("status"),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total})
+}
+
+// GetUser returns a user and the reports against them
+func (h *Handler) GetUser(c *gin.Context) {
+	userID, ok := paramID(c, "user")
+	if !ok {
+		return
+	}
+
+	user, reports, err := h.service.GetUser(userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user, "reports": reports})
+}
+
+// Ban bans a user for good
+func (h *Handler) Ban(c *gin.Context) {
+	userID, ok := paramID(c, "user")
+	if !ok {
+		return
+	}
+	var req ReasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.Ban(c.MustGet("userID").(uint), userID, req.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Suspend bans a user for a number of hours
+func (h *Handler) Suspend(c *gin.Context) {
+	userID, ok := paramID(c, "user")
+	if !ok {
+		return
+	}
+	var req SuspendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.Suspend(c.MustGet("userID").(uint), userID, time.Duration(req.Hours)*time.Hour, req.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Unban lifts a ban or a suspension
+func (h *Handler) Unban(c *gin.Context) {
+	userID, ok := paramID(c, "user")
+	if !ok {
+		return
+	}
+	var req ReasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.Unban(c.MustGet("userID").(uint), userID, req.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// AdjustRating sets a user's rating
+func (h *Handler) AdjustRating(c *gin.Context) {
+	userID, ok := paramID(c, "user")
+	if !ok {
+		return
+	}
+	var req RatingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.AdjustRating(c.MustGet("userID").(uint), userID, *req.Rating, req.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// SetRole grants a role to a user
+func (h *Handler) SetRole(c *gin.Context) {
+	userID, ok := paramID(c, "user")
+	if !ok {
+		return
+	}
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.SetRole(c.MustGet("userID").(uint), userID, req.Role, req.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// EndGame ends a game in progress with a result
+func (h *Handler) EndGame(c *gin.Context) {
+	gameID, ok := paramID(c, "game")
+	if !ok {
+		return
+	}
+	var req EndGameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	g, err := h.service.EndGame(c.MustGet("userID").(uint), gameID, req.Result, req.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, g)
+}
+
+// AnnulGame cancels a finished game and rolls back its rating changes
+func (h *Handler) AnnulGame(c *gin.Context) {
+	gameID, ok := paramID(c, "game")
+	if !ok {
+		return
+	}
+	var req ReasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	g, err := h.service.AnnulGame(c.MustGet("userID").(uint), gameID, req.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, g)
+}
+
+// ListReports lists reports, open ones by default:
+// GET /api/admin/reports?status=open&limit=&offset=
+func (h *Handler) ListReports(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	status := models.ReportStatus(c.DefaultQuery("status", string(models.ReportStatusOpen)))
+	if status == "all" {
+		status = ""
+	}
+
+	reports, total, err := h.service.ListReports(status, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reports": reports, "total": total})
+}
+
+// ResolveReport closes a report
+func (h *Handler) ResolveReport(c *gin.Context) {
+	reportID, ok := paramID(c, "report")
+	if !ok {
+		return
+	}
+	var req ResolveReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.service.ResolveReport(c.MustGet("userID").(uint), reportID, req.Status, req.Resolution)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ListAuditLog lists moderation actions, newest first:
+// GET /api/admin/audit?actorId=&action=&targetType=&targetId=&limit=&offset=
+func (h *Handler) ListAuditLog(c *gin.Context) {
+	actorID, _ := strconv.ParseUint(c.Query("actorId"), 10, 32)
+	targetID, _ := strconv.ParseUint(c.Query("targetId"), 10, 32)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	entries, total, err := h.service.ListAuditLog(AuditFilter{
+		ActorID:    uint(actorID),
+		Action:     c.Query("action"),
+		TargetType: c.Query("targetType"),
+		TargetID:   uint(targetID),
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "total": total})
+}
+
+// CreateReport lets any user report another: POST /api/reports
+func (h *Handler) CreateReport(c *gin.Context) {
+	var req CreateReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.service.CreateReport(c.MustGet("userID").(uint), req.UserID, req.GameID, req.Reason, req.Details)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, report)
+}
+
+// paramID parses the :id parameter. It writes the error response itself.
+func paramID(c *gin.Context, kind string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + kind + " ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrReportNotFound), errors.Is(err, game.ErrGameNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrOutranked):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidRole), errors.Is(err, ErrInvalidRating), errors.Is(err, ErrInvalidStatus),
+		errors.Is(err, ErrInvalidReport), errors.Is(err, ErrReasonRequired), errors.Is(err, game.ErrInvalidResult):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, game.ErrGameNotActive), errors.Is(err, game.ErrGameNotFinished), errors.Is(err, game.ErrAlreadyAnnulled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,435 @@
+// Package admin lets moderators and admins act on users, games and reports.
+// Every action is written to the audit log along with the change itself.
+package admin
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"chess-app/internal/auth"
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Audit log actions
+const (
+	ActionBan           = "user.ban"
+	ActionSuspend       = "user.suspend"
+	ActionUnban         = "user.unban"
+	ActionAdjustRating  = "user.rating"
+	ActionSetRole       = "user.role"
+	ActionEndGame       = "game.end"
+	ActionAnnulGame     = "game.annul"
+	ActionResolveReport = "report.resolve"
+)
+
+// Page size of the admin listings
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrReportNotFound = errors.New("report not found")
+	ErrOutranked      = errors.New("you can only moderate users with a lower role than yours")
+	ErrInvalidRole    = errors.New("role must be user, moderator or admin")
+	ErrInvalidRating  = errors.New("rating must be between 0 and 4000")
+	ErrInvalidStatus  = errors.New("status must be resolved or dismissed")
+	ErrInvalidReport  = errors.New("you cannot report yourself")
+	ErrReasonRequired = errors.New("a reason is required")
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+type Service struct {
+	db    *gorm.DB
+	games *game.Service
+	auth  *auth.Service
+	hub   *game.Hub
+}
+
+// NewService creates the admin service. Banned users are signed out through
+// auth and disconnected through the hub.
+func NewService(db *gorm.DB, games *game.Service, authService *auth.Service, hub *game.Hub) *Service {
+	return &Service{db: db, games: games, auth: authService, hub: hub}
+}
+
+// UserFilter narrows down a user search
+type UserFilter struct {
+	Query  string // Part of a username or email, or a user ID
+	Role   string
+	Status string // "banned" or "suspended"
+	Limit  int
+	Offset int
+}
+
+// SearchUsers returns the users matching a filter, newest first, and how
+// many match in all
+func (s *Service) SearchUsers(filter UserFilter) ([]models.User, int64, error) {
+	query := s.db.Model(&models.User{})
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(q)) + "%"
+		if id, err := strconv.ParseUint(q, 10, 32); err == nil {
+			query = query.Where("id = ? OR LOWER(username) LIKE ? OR LOWER(email) LIKE ?", id, pattern, pattern)
+		} else {
+			query = query.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+		}
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	switch filter.Status {
+	case "banned":
+		query = query.Where("banned_at IS NOT NULL")
+	case "suspended":
+		query = query.Where("banned_at IS NULL AND suspended_until > ?", time.Now())
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []models.User
+	err := query.Order("id DESC").Limit(pageSize(filter.Limit)).Offset(filter.Offset).Find(&users).Error
+	return users, total, err
+}
+
+// GetUser returns a user with the reports made against them
+func (s *Service) GetUser(userID uint) (*models.User, []models.Report, error) {
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrUserNotFound
+		}
+		return nil, nil, err
+	}
+	var reports []models.Report
+	err := s.db.Where("reported_user_id = ?", userID).Order("created_at DESC").Limit(maxLimit).Find(&reports).Error
+	return &user, reports, err
+}
+
+// Ban bans a user for good, signs them out and closes their connections
+func (s *Service) Ban(actorID, userID uint, reason string) (*models.User, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	user, err := s.moderate(actorID, userID, ActionBan, reason, func(tx *gorm.DB, user *models.User) (models.JSONMap, error) {
+		now := time.Now()
+		user.BannedAt = &now
+		user.BanReason = reason
+		err := tx.Model(user).Updates(map[string]interface{}{"banned_at": now, "ban_reason": reason}).Error
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.signOut(userID)
+	return user, nil
+}
+
+// Suspend bans a user for a while, signs them out and closes their
+// connections
+func (s *Service) Suspend(actorID, userID uint, d time.Duration, reason string) (*models.User, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	user, err := s.moderate(actorID, userID, ActionSuspend, reason, func(tx *gorm.DB, user *models.User) (models.JSONMap, error) {
+		until := time.Now().Add(d)
+		user.SuspendedUntil = &until
+		user.BanReason = reason
+		err := tx.Model(user).Updates(map[string]interface{}{"suspended_until": until, "ban_reason": reason}).Error
+		return models.JSONMap{"until": until, "duration": d.String()}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.signOut(userID)
+	return user, nil
+}
+
+// Unban lifts a user's ban or suspension
+func (s *Service) Unban(actorID, userID uint, reason string) (*models.User, error) {
+	return s.moderate(actorID, userID, ActionUnban, reason, func(tx *gorm.DB, user *models.User) (models.JSONMap, error) {
+		details := models.JSONMap{"bannedAt": user.BannedAt, "suspendedUntil": user.SuspendedUntil, "banReason": user.BanReason}
+		user.BannedAt = nil
+		user.SuspendedUntil = nil
+		user.BanReason = ""
+		err := tx.Model(user).Updates(map[string]interface{}{"banned_at": nil, "suspended_until": nil, "ban_reason": ""}).Error
+		return details, err
+	})
+}
+
+// AdjustRating sets a user's rating, e.g. to give back what a cheater's
+// opponents lost
+func (s *Service) AdjustRating(actorID, userID uint, rating int, reason string) (*models.User, error) {
+	if rating < 0 || rating > 4000 {
+		return nil, ErrInvalidRating
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	return s.moderate(actorID, userID, ActionAdjustRating, reason, func(tx *gorm.DB, user *models.User) (models.JSONMap, error) {
+		details := models.JSONMap{"from": user.ELORating, "to": rating}
+		user.ELORating = rating
+		return details, tx.Model(user).Update("elo_rating", rating).Error
+	})
+}
+
+// SetRole grants a role to a user. Nobody can change their own role, so that
+// the last admin cannot demote themselves.
+func (s *Service) SetRole(actorID, userID uint, role, reason string) (*models.User, error) {
+	if !models.ValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+	return s.moderate(actorID, userID, ActionSetRole, reason, func(tx *gorm.DB, user *models.User) (models.JSONMap, error) {
+		details := models.JSONMap{"from": user.Role, "to": role}
+		user.Role = role
+		return details, tx.Model(user).Update("role", role).Error
+	})
+}
+
+// EndGame ends a game in progress with the given result
+func (s *Service) EndGame(actorID, gameID uint, result models.GameResult, reason string) (*models.Game, error) {
+	return s.games.ForceEnd(gameID, result, func(tx *gorm.DB, g *models.Game) error {
+		return writeAudit(tx, actorID, ActionEndGame, "game", g.ID, reason, models.JSONMap{
+			"result":          result,
+			"whiteRatingDiff": g.WhiteRatingDiff,
+			"blackRatingDiff": g.BlackRatingDiff,
+		})
+	})
+}
+
+// AnnulGame cancels a finished game and rolls back the rating changes it
+// caused
+func (s *Service) AnnulGame(actorID, gameID uint, reason string) (*models.Game, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	return s.games.Annul(gameID, func(tx *gorm.DB, g *models.Game) error {
+		return writeAudit(tx, actorID, ActionAnnulGame, "game", g.ID, reason, models.JSONMap{
+			"result":          g.Result,
+			"whiteRatingDiff": g.WhiteRatingDiff,
+			"blackRatingDiff": g.BlackRatingDiff,
+		})
+	})
+}
+
+// CreateReport files a user's complaint about another user. gameID may be
+// nil.
+func (s *Service) CreateReport(reporterID, reportedUserID uint, gameID *uint, reason, details string) (*models.Report, error) {
+	if reporterID == reportedUserID {
+		return nil, ErrInvalidReport
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	if err := s.db.First(&models.User{}, reportedUserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if gameID != nil {
+		if err := s.db.First(&models.Game{}, *gameID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, game.ErrGameNotFound
+			}
+			return nil, err
+		}
+	}
+
+	report := &models.Report{
+		ReporterID:     reporterID,
+		ReportedUserID: reportedUserID,
+		GameID:         gameID,
+		Reason:         strings.TrimSpace(reason),
+		Details:        details,
+		Status:         models.ReportStatusOpen,
+	}
+	if err := s.db.Create(report).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ListReports returns reports with a status, all of them if status is
+// empty, oldest first so that the queue is worked in order
+func (s *Service) ListReports(status models.ReportStatus, limit, offset int) ([]models.Report, int64, error) {
+	query := s.db.Model(&models.Report{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var reports []models.Report
+	err := query.Preload("Reporter").Preload("ReportedUser").
+		Order("created_at ASC").Limit(pageSize(limit)).Offset(offset).
+		Find(&reports).Error
+	return reports, total, err
+}
+
+// ResolveReport closes a report as resolved or dismissed
+func (s *Service) ResolveReport(actorID, reportID uint, status models.ReportStatus, resolution string) (*models.Report, error) {
+	if status != models.ReportStatusResolved && status != models.ReportStatusDismissed {
+		return nil, ErrInvalidStatus
+	}
+
+	var report models.Report
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&report, reportID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrReportNotFound
+			}
+			return err
+		}
+
+		details := models.JSONMap{"from": report.Status, "to": status}
+		now := time.Now()
+		report.Status = status
+		report.Resolution = resolution
+		report.ResolvedByID = &actorID
+		report.ResolvedAt = &now
+		if err := tx.Save(&report).Error; err != nil {
+			return err
+		}
+		return writeAudit(tx, actorID, ActionResolveReport, "report", report.ID, resolution, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// AuditFilter narrows down the audit log
+type AuditFilter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	Limit      int
+	Offset     int
+}
+
+// ListAuditLog returns audit entries matching a filter, newest first
+func (s *Service) ListAuditLog(filter AuditFilter) ([]models.AuditLog, int64, error) {
+	query := s.db.Model(&models.AuditLog{})
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []models.AuditLog
+	err := query.Preload("Actor").
+		Order("created_at DESC, id DESC").Limit(pageSize(filter.Limit)).Offset(filter.Offset).
+		Find(&entries).Error
+	return entries, total, err
+}
+
+// PromoteAdmins makes admins of the verified accounts with the given
+// emails, so that a fresh deployment has someone to grant roles
+func (s *Service) PromoteAdmins(emails []string) (int64, error) {
+	if len(emails) == 0 {
+		return 0, nil
+	}
+	result := s.db.Model(&models.User{}).
+		Where("email IN ? AND email_verified AND role <> ?", emails, models.RoleAdmin).
+		Update("role", models.RoleAdmin)
+	return result.RowsAffected, result.Error
+}
+
+// moderate applies an action to a user on behalf of actor, who must outrank
+// them, and audits it in the same transaction. change updates the locked
+// user and returns details for the audit entry.
+func (s *Service) moderate(actorID, userID uint, action, reason string, change func(tx *gorm.DB, user *models.User) (models.JSONMap, error)) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var actor models.User
+		if err := tx.First(&actor, actorID).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if !canModerate(&actor, &user, action) {
+			return ErrOutranked
+		}
+
+		details, err := change(tx, &user)
+		if err != nil {
+			return err
+		}
+		return writeAudit(tx, actorID, action, "user", user.ID, reason, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// canModerate reports whether actor may apply action to user: they must
+// outrank them, except that admins may change each other's roles. Nobody
+// acts on themselves.
+func canModerate(actor, user *models.User, action string) bool {
+	if actor.ID == user.ID {
+		return false
+	}
+	if action == ActionSetRole && actor.HasRole(models.RoleAdmin) {
+		return true
+	}
+	return actor.Outranks(user)
+}
+
+// signOut ends every session of a banned user and closes their sockets.
+// Access tokens already issued stay valid until they expire.
+func (s *Service) signOut(userID uint) {
+	if err := s.auth.DeleteUserRefreshTokens(userID); err != nil {
+		log.Printf("admin: failed to revoke sessions of user %d: %v", userID, err)
+	}
+	s.hub.DisconnectUser(userID)
+}
+
+func writeAudit(tx *gorm.DB, actorID uint, action, targetType string, targetID uint, reason string, details models.JSONMap) error {
+	return tx.Create(&models.AuditLog{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Reason:     reason,
+		Details:    details,
+	}).Error
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
@@ -0,0 +1,47 @@
+package analysis
+
+import (
+	"math"
+
+	"chess-app/internal/models"
+)
+
+// Thresholds on the drop in winning chances (on a -1..1 scale) caused by a move
+const (
+	inaccuracyThreshold = 0.1
+	mistakeThreshold    = 0.2
+	blunderThreshold    = 0.3
+)
+
+// winningChances maps a centipawn score to the expected outcome on a -1..1
+// scale, using the logistic curve fitted by Lichess on rated games
+func winningChances(cp int) float64 {
+	cp = max(-1000, min(1000, cp))
+	return 2/(1+math.Exp(-0.00368208*float64(cp))) - 1
+}
+
+// classify grades a move by the winning chances it gave away
+func classify(before, after int) models.MoveClassification {
+	drop := winningChances(before) - winningChances(after)
+	switch {
+	case drop >= blunderThreshold:
+		return models.MoveClassificationBlunder
+	case drop >= mistakeThreshold:
+		return models.MoveClassificationMistake
+	case drop >= inaccuracyThreshold:
+		return models.MoveClassificationInaccuracy
+	}
+	return models.MoveClassificationNone
+}
+
+// moveAccuracy converts the drop in win percentage caused by a move into a
+// 0-100 accuracy score
+func moveAccuracy(before, after int) float64 {
+	winBefore := 50 + 50*winningChances(before)
+	winAfter := 50 + 50*winningChances(after)
+	if winAfter >= winBefore {
+		return 100
+	}
+	accuracy := 103.1668*math.Exp(-0.04354*(winBefore-winAfter)) - 3.1669
+	return max(0, min(100, accuracy))
+}
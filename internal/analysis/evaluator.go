@@ -0,0 +1,68 @@
+// Package analysis evaluates finished games with an engine and grades every
+// move.
+package analysis
+
+import (
+	"context"
+
+	"chess-app/internal/bot"
+
+	"github.com/notnil/chess"
+)
+
+// MateCP is the centipawn value used in place of a forced mate
+const MateCP = 10000
+
+// Evaluation is an engine's verdict on a position, from the side to move's
+// point of view
+type Evaluation struct {
+	CP       int  // Centipawns; ±MateCP for forced mates
+	Mate     int  // Moves to mate if IsMate; negative if the side to move is being mated
+	IsMate   bool
+	BestMove string // UCI; empty in terminal positions
+}
+
+// Evaluator evaluates positions given in FEN notation
+type Evaluator interface {
+	Evaluate(ctx context.Context, fen string) (*Evaluation, error)
+	Name() string
+}
+
+// SearchEvaluator evaluates positions with the built-in bot search
+type SearchEvaluator struct {
+	Limits bot.Limits
+}
+
+// NewSearchEvaluator creates an evaluator searching each position within limits
+func NewSearchEvaluator(limits bot.Limits) *SearchEvaluator {
+	return &SearchEvaluator{Limits: limits}
+}
+
+// Name implements Evaluator
+func (e *SearchEvaluator) Name() string {
+	return "built-in"
+}
+
+// Evaluate implements Evaluator
+func (e *SearchEvaluator) Evaluate(ctx context.Context, fen string) (*Evaluation, error) {
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := bot.NewSearcher().Search(chess.NewGame(opt).Position(), e.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	eval := &Evaluation{CP: result.Score, BestMove: result.Move}
+	if result.IsMate() {
+		eval.IsMate = true
+		eval.Mate = result.MateIn()
+		eval.CP = MateCP
+		if eval.Mate < 0 {
+			eval.CP = -MateCP
+		}
+	}
+	return eval, nil
+}
@@ -0,0 +1,80 @@
+package analysis
+
+import (
+	"net/http"
+	"strconv"
+
+	"chess-app/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// GetAnalysis returns the analysis of a game. With ?format=pgn the moves
+// are returned as PGN with [%eval] comments instead.
+func (h *Handler) GetAnalysis(c *gin.Context) {
+	gameID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid game ID"})
+		return
+	}
+
+	analysis, err := h.service.GetAnalysis(uint(gameID))
+	if err != nil {
+		if err == ErrAnalysisNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "analysis not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if analysis.Status != models.AnalysisStatusDone {
+		c.JSON(http.StatusAccepted, analysis)
+		return
+	}
+
+	if c.Query("format") == "pgn" {
+		pgn, err := h.service.AnnotatedPGN(uint(gameID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "application/x-chess-pgn; charset=utf-8", []byte(pgn))
+		return
+	}
+
+	c.JSON(http.StatusOK, analysis)
+}
+
+// RequestAnalysis queues a finished game for analysis, e.g. one that
+// finished before analysis was enabled or whose analysis failed
+func (h *Handler) RequestAnalysis(c *gin.Context) {
+	gameID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid game ID"})
+		return
+	}
+
+	analysis, err := h.service.Request(uint(gameID))
+	if err != nil {
+		switch err {
+		case ErrAnalysisNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+		case ErrGameNotFinished:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, analysis)
+}
@@ -0,0 +1,386 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"chess-app/internal/models"
+
+	"github.com/notnil/chess"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAnalysisNotFound = errors.New("analysis not found")
+	ErrGameNotFinished  = errors.New("game is not finished")
+)
+
+// Number of games that can wait for analysis before new requests are left
+// pending until the next restart
+const queueSize = 256
+
+type Service struct {
+	db        *gorm.DB
+	evaluator Evaluator
+	queue     chan uint
+}
+
+func NewService(db *gorm.DB, evaluator Evaluator) *Service {
+	return &Service{
+		db:        db,
+		evaluator: evaluator,
+		queue:     make(chan uint, queueSize),
+	}
+}
+
+// Start launches the background workers and re-queues analyses left
+// unfinished by a previous run
+func (s *Service) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+
+	var pending []models.GameAnalysis
+	if err := s.db.Where("status IN ?", []models.AnalysisStatus{models.AnalysisStatusPending, models.AnalysisStatusRunning}).
+		Find(&pending).Error; err != nil {
+		log.Printf("analysis: failed to load pending analyses: %v", err)
+		return
+	}
+	for _, a := range pending {
+		s.enqueue(a.GameID)
+	}
+}
+
+// OnGameFinished queues a finished game for analysis. It is registered as a
+// game.Service listener.
+func (s *Service) OnGameFinished(game *models.Game) {
+	if _, err := s.Request(game.ID); err != nil {
+		log.Printf("analysis: failed to queue game %d: %v", game.ID, err)
+	}
+}
+
+// Request queues a finished game for analysis unless it has already been
+// analysed or is in progress
+func (s *Service) Request(gameID uint) (*models.GameAnalysis, error) {
+	analysis, queued, err := s.prepare(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if queued {
+		s.enqueue(gameID)
+	}
+	return analysis, nil
+}
+
+// Run analyses a finished game synchronously unless it has already been
+// analysed. It is meant for offline tools that have no background workers.
+func (s *Service) Run(ctx context.Context, gameID uint) error {
+	_, queued, err := s.prepare(gameID)
+	if err != nil || !queued {
+		return err
+	}
+	if err := s.Analyze(ctx, gameID); err != nil {
+		s.markFailed(gameID, err)
+		return err
+	}
+	return nil
+}
+
+// prepare creates or resets the analysis row of a finished game and reports
+// whether the game needs to be analysed
+func (s *Service) prepare(gameID uint) (*models.GameAnalysis, bool, error) {
+	var game models.Game
+	if err := s.db.First(&game, gameID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, ErrAnalysisNotFound
+		}
+		return nil, false, err
+	}
+	if game.Status != models.GameStatusFinished {
+		return nil, false, ErrGameNotFinished
+	}
+
+	var analysis models.GameAnalysis
+	err := s.db.Where("game_id = ?", gameID).First(&analysis).Error
+	switch {
+	case err == nil && analysis.Status != models.AnalysisStatusFailed:
+		return &analysis, false, nil
+	case err == nil:
+		// Retry a failed analysis
+		analysis.Status = models.AnalysisStatusPending
+		analysis.Error = ""
+		if err := s.db.Save(&analysis).Error; err != nil {
+			return nil, false, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		analysis = models.GameAnalysis{GameID: gameID, Status: models.AnalysisStatusPending}
+		if err := s.db.Create(&analysis).Error; err != nil {
+			return nil, false, err
+		}
+	default:
+		return nil, false, err
+	}
+
+	return &analysis, true, nil
+}
+
+// GetAnalysis returns the analysis of a game with its per-ply evaluations
+func (s *Service) GetAnalysis(gameID uint) (*models.GameAnalysis, error) {
+	var analysis models.GameAnalysis
+	if err := s.db.Where("game_id = ?", gameID).
+		Preload("Moves", func(db *gorm.DB) *gorm.DB { return db.Order("ply_number ASC") }).
+		First(&analysis).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAnalysisNotFound
+		}
+		return nil, err
+	}
+	return &analysis, nil
+}
+
+func (s *Service) enqueue(gameID uint) {
+	select {
+	case s.queue <- gameID:
+	default:
+		log.Printf("analysis: queue full, game %d stays pending", gameID)
+	}
+}
+
+func (s *Service) work() {
+	for gameID := range s.queue {
+		if err := s.Analyze(context.Background(), gameID); err != nil {
+			log.Printf("analysis: game %d failed: %v", gameID, err)
+			s.markFailed(gameID, err)
+		}
+	}
+}
+
+func (s *Service) markFailed(gameID uint, cause error) {
+	s.db.Model(&models.GameAnalysis{}).Where("game_id = ?", gameID).
+		Updates(map[string]interface{}{"status": models.AnalysisStatusFailed, "error": cause.Error()})
+}
+
+// Analyze evaluates every position of a game and stores the graded moves
+func (s *Service) Analyze(ctx context.Context, gameID uint) error {
+	var moves []models.Move
+	if err := s.db.Where("game_id = ?", gameID).Order("ply_number ASC").Find(&moves).Error; err != nil {
+		return err
+	}
+
+	if err := s.db.Model(&models.GameAnalysis{}).Where("game_id = ?", gameID).
+		Update("status", models.AnalysisStatusRunning).Error; err != nil {
+		return err
+	}
+
+	// Replay the game to get every position, starting with the initial one
+	positions := []*chess.Position{chess.StartingPosition()}
+	for _, move := range moves {
+		pos := positions[len(positions)-1]
+		m, err := chess.UCINotation{}.Decode(pos, move.MoveNotation)
+		if err != nil {
+			return fmt.Errorf("ply %d: %w", move.PlyNumber, err)
+		}
+		positions = append(positions, pos.Update(m))
+	}
+
+	evals := make([]*Evaluation, len(positions))
+	for i, pos := range positions {
+		eval, err := s.evaluate(ctx, pos)
+		if err != nil {
+			return fmt.Errorf("ply %d: %w", i, err)
+		}
+		evals[i] = eval
+	}
+
+	summary := models.GameAnalysis{
+		Status: models.AnalysisStatusDone,
+		Engine: s.evaluator.Name(),
+	}
+	var whiteAccuracy, blackAccuracy []float64
+	results := make([]models.MoveAnalysis, len(moves))
+	for i, move := range moves {
+		whiteMoved := positions[i].Turn() == chess.White
+
+		// Scores from the mover's point of view: the evaluation before the
+		// move is theirs, the one after belongs to the opponent
+		before := evals[i].CP
+		after := -evals[i+1].CP
+
+		result := models.MoveAnalysis{
+			GameID:         gameID,
+			PlyNumber:      move.PlyNumber,
+			Move:           move.MoveNotation,
+			BestMove:       evals[i].BestMove,
+			Accuracy:       moveAccuracy(before, after),
+			Classification: classify(before, after),
+		}
+		if move.MoveNotation == evals[i].BestMove {
+			// Differences between searches of consecutive positions are noise
+			result.Accuracy = 100
+			result.Classification = models.MoveClassificationNone
+		}
+
+		// Stored evaluations are from white's point of view
+		result.EvalCP = -evals[i+1].CP
+		if evals[i+1].IsMate {
+			mate := -evals[i+1].Mate
+			result.EvalMate = &mate
+		}
+		if !whiteMoved {
+			result.EvalCP = -result.EvalCP
+			if result.EvalMate != nil {
+				*result.EvalMate = -*result.EvalMate
+			}
+		}
+
+		if whiteMoved {
+			whiteAccuracy = append(whiteAccuracy, result.Accuracy)
+			countClassification(result.Classification, &summary.WhiteInaccuracies, &summary.WhiteMistakes, &summary.WhiteBlunders)
+		} else {
+			blackAccuracy = append(blackAccuracy, result.Accuracy)
+			countClassification(result.Classification, &summary.BlackInaccuracies, &summary.BlackMistakes, &summary.BlackBlunders)
+		}
+		results[i] = result
+	}
+	summary.WhiteAccuracy = mean(whiteAccuracy)
+	summary.BlackAccuracy = mean(blackAccuracy)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("game_id = ?", gameID).Delete(&models.MoveAnalysis{}).Error; err != nil {
+			return err
+		}
+		if len(results) > 0 {
+			if err := tx.Create(&results).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.GameAnalysis{}).Where("game_id = ?", gameID).
+			Select("status", "engine", "white_accuracy", "black_accuracy",
+				"white_inaccuracies", "white_mistakes", "white_blunders",
+				"black_inaccuracies", "black_mistakes", "black_blunders", "error").
+			Updates(&summary).Error
+	})
+}
+
+// evaluate handles positions without legal moves itself, since engines
+// cannot search them
+func (s *Service) evaluate(ctx context.Context, pos *chess.Position) (*Evaluation, error) {
+	switch pos.Status() {
+	case chess.Checkmate:
+		return &Evaluation{CP: -MateCP, IsMate: true}, nil
+	case chess.Stalemate:
+		return &Evaluation{}, nil
+	}
+	return s.evaluator.Evaluate(ctx, pos.String())
+}
+
+// AnnotatedPGN returns the game's moves in PGN with [%eval] comments after
+// every analysed ply
+func (s *Service) AnnotatedPGN(gameID uint) (string, error) {
+	analysis, err := s.GetAnalysis(gameID)
+	if err != nil {
+		return "", err
+	}
+
+	var game models.Game
+	if err := s.db.Preload("WhitePlayer").Preload("BlackPlayer").First(&game, gameID).Error; err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	writeTag := func(name, value string) {
+		fmt.Fprintf(&b, "[%s \"%s\"]\n", name, strings.ReplaceAll(value, "\"", "'"))
+	}
+	writeTag("Event", "Casual game")
+	writeTag("Site", "chess-app")
+	writeTag("Date", game.CreatedAt.Format("2006.01.02"))
+	writeTag("White", playerName(game.WhitePlayer))
+	writeTag("Black", playerName(game.BlackPlayer))
+	writeTag("Result", pgnResult(game.Result))
+	if game.ECO != "" {
+		writeTag("ECO", game.ECO)
+		writeTag("Opening", game.Opening)
+	}
+	writeTag("Annotator", analysis.Engine)
+	b.WriteString("\n")
+
+	pos := chess.StartingPosition()
+	for i, ma := range analysis.Moves {
+		move, err := chess.UCINotation{}.Decode(pos, ma.Move)
+		if err != nil {
+			return "", fmt.Errorf("ply %d: %w", ma.PlyNumber, err)
+		}
+		if pos.Turn() == chess.White {
+			fmt.Fprintf(&b, "%d. ", (ma.PlyNumber+1)/2)
+		} else if i == 0 {
+			fmt.Fprintf(&b, "%d... ", (ma.PlyNumber+1)/2)
+		}
+		b.WriteString(chess.AlgebraicNotation{}.Encode(pos, move))
+		if comment := evalComment(ma); comment != "" {
+			fmt.Fprintf(&b, " { %s }", comment)
+		}
+		b.WriteString(" ")
+		pos = pos.Update(move)
+	}
+	b.WriteString(pgnResult(game.Result))
+	b.WriteString("\n")
+
+	return b.String(), nil
+}
+
+// evalComment formats the evaluation after a ply for a PGN comment
+func evalComment(ma models.MoveAnalysis) string {
+	if ma.EvalMate != nil {
+		if *ma.EvalMate == 0 {
+			// The move delivered mate; there is nothing left to evaluate
+			return ""
+		}
+		return fmt.Sprintf("[%%eval #%d]", *ma.EvalMate)
+	}
+	return fmt.Sprintf("[%%eval %.2f]", float64(ma.EvalCP)/100)
+}
+
+func pgnResult(result models.GameResult) string {
+	switch result {
+	case models.GameResultWhiteWins:
+		return "1-0"
+	case models.GameResultBlackWins:
+		return "0-1"
+	case models.GameResultDraw:
+		return "1/2-1/2"
+	}
+	return "*"
+}
+
+func playerName(user *models.User) string {
+	if user == nil {
+		return "?"
+	}
+	return user.Username
+}
+
+func countClassification(c models.MoveClassification, inaccuracies, mistakes, blunders *int) {
+	switch c {
+	case models.MoveClassificationInaccuracy:
+		*inaccuracies++
+	case models.MoveClassificationMistake:
+		*mistakes++
+	case models.MoveClassificationBlunder:
+		*blunders++
+	}
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return math.Round(sum/float64(len(values))*10) / 10
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Scopes of personal access tokens. A token can only call the routes of
+// its scopes; the other routes need a login.
+const (
+	ScopeGameRead       = "game:read"       // Read games, history and analysis
+	ScopeGamePlay       = "game:play"       // Join games, matchmaking and play moves over WebSocket
+	ScopeChallengeWrite = "challenge:write" // Create games and challenge players or the computer
+	ScopeBotPlay        = "bot:play"        // Play through the bot API
+)
+
+// Scopes lists every token scope
+var Scopes = []string{ScopeGameRead, ScopeGamePlay, ScopeChallengeWrite, ScopeBotPlay}
+
+// APITokenPrefix starts every personal access token, which tells them apart
+// from JWTs
+const APITokenPrefix = "pat_"
+
+const (
+	maxAPITokens = 50
+	// Last use is only written this often per token
+	apiTokenTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIToken  = errors.New("invalid or expired token")
+	ErrAPITokenNotFound = errors.New("token not found")
+	ErrInvalidScope     = errors.New("unknown scope")
+	ErrNoScopes         = errors.New("a token needs at least one scope")
+	ErrTooManyAPITokens = errors.New("too many tokens; revoke some first")
+)
+
+// CreateAPIToken creates a personal access token and returns it along with
+// its value, which is not stored and cannot be shown again. A zero ttl makes
+// a token that never expires.
+func (s *Service) CreateAPIToken(userID uint, name string, scopes []string, ttl time.Duration) (*models.APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", errors.New("name is required")
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.APIToken{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, "", err
+	}
+	if count >= maxAPITokens {
+		return nil, "", ErrTooManyAPITokens
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	value := APITokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(value),
+		Prefix:    value[:len(APITokenPrefix)+6],
+		Scopes:    scopes,
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := s.db.Create(token).Error; err != nil {
+		return nil, "", err
+	}
+	return token, value, nil
+}
+
+// ListAPITokens returns a user's tokens, newest first
+func (s *Service) ListAPITokens(userID uint) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeAPIToken deletes one of a user's tokens
+func (s *Service) RevokeAPIToken(userID, tokenID uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&models.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIToken returns the token and user of a personal access
+// token. Tokens of banned or suspended users are refused.
+func (s *Service) AuthenticateAPIToken(value string) (*models.APIToken, *models.User, error) {
+	var token models.APIToken
+	if err := s.db.Where("token_hash = ?", hashToken(value)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIToken
+		}
+		return nil, nil, err
+	}
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, nil, ErrInvalidAPIToken
+	}
+
+	user, err := s.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	if err := checkStanding(user); err != nil {
+		return nil, nil, err
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval {
+		s.db.Model(&models.APIToken{}).Where("id = ?", token.ID).Update("last_used_at", now)
+		token.LastUsedAt = &now
+	}
+	return &token, user, nil
+}
+
+// normalizeScopes checks scopes and removes duplicates
+func normalizeScopes(scopes []string) (models.ScopeList, error) {
+	seen := make(map[string]bool)
+	var list models.ScopeList
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !validScope(scope) {
+			return nil, ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			list = append(list, scope)
+		}
+	}
+	if len(list) == 0 {
+		return nil, ErrNoScopes
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
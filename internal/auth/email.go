@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"chess-app/internal/mail"
+	"chess-app/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Purposes of the tokens sent by email. They are signed like access tokens
+// but never accepted as one.
+const (
+	TokenTypeVerifyEmail   = "verify_email"
+	TokenTypeResetPassword = "reset_password"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+var ErrInvalidEmailToken = errors.New("invalid or expired link")
+
+// emailClaims identify the user and what the token lets them do. The
+// fingerprint ties the token to the state it acts on, so that a reset link
+// stops working once the password has changed.
+type emailClaims struct {
+	UserID      uint   `json:"userId"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fp"`
+	jwt.RegisteredClaims
+}
+
+// SendVerificationEmail mails a user a link to confirm their address
+func (s *Service) SendVerificationEmail(user *models.User) error {
+	token, err := s.emailToken(user, TokenTypeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := s.config.AppURL + "/verify-email?token=" + url.QueryEscape(token)
+	s.send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirmez votre adresse email",
+		Body: fmt.Sprintf("Bonjour %s,\n\nConfirmez votre adresse pour jouer des parties classées :\n%s\n\nCe lien expire dans 48 heures.\n",
+			user.Username, link),
+	})
+	return nil
+}
+
+// VerifyEmail marks the address of a verification token's user as verified
+func (s *Service) VerifyEmail(token string) (*models.User, error) {
+	user, err := s.parseEmailToken(token, TokenTypeVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Update("email_verified", true).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// RequestPasswordReset mails a reset link if an account uses email. It
+// reports no error for unknown addresses, so that callers cannot tell.
+func (s *Service) RequestPasswordReset(email string) error {
+	email = strings.TrimSpace(strings.ToLower(email))
+
+	var user models.User
+	if err := s.db.Where("email = ? AND is_bot = ?", email, false).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := s.emailToken(&user, TokenTypeResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	link := s.config.AppURL + "/reset-password?token=" + url.QueryEscape(token)
+	s.send(mail.Message{
+		To:      user.Email,
+		Subject: "Réinitialisation de votre mot de passe",
+		Body: fmt.Sprintf("Bonjour %s,\n\nChoisissez un nouveau mot de passe :\n%s\n\nCe lien expire dans une heure. Ignorez ce message si vous n'avez rien demandé.\n",
+			user.Username, link),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password from a reset token and signs the user
+// out everywhere. The link proves access to the mailbox, so it also
+// verifies the address.
+func (s *Service) ResetPassword(token, password string) error {
+	password = strings.TrimSpace(password)
+	if password == "" {
+		return errors.New("password is required")
+	}
+
+	user, err := s.parseEmailToken(token, TokenTypeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"password_hash":  string(hash),
+		"email_verified": true,
+	}).Error; err != nil {
+		return err
+	}
+	return s.DeleteUserRefreshTokens(user.ID)
+}
+
+func (s *Service) emailToken(user *models.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := emailClaims{
+		UserID:      user.ID,
+		Type:        purpose,
+		Fingerprint: fingerprint(user, purpose),
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.config.JWTSecret))
+}
+
+func (s *Service) parseEmailToken(token, purpose string) (*models.User, error) {
+	var claims emailClaims
+	parsed, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.config.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !parsed.Valid || claims.Type != purpose {
+		return nil, ErrInvalidEmailToken
+	}
+
+	user, err := s.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, ErrInvalidEmailToken
+	}
+	if claims.Fingerprint != fingerprint(user, purpose) {
+		return nil, ErrInvalidEmailToken
+	}
+	return user, nil
+}
+
+// fingerprint summarises what a token must still match when used: the
+// address to verify, or the password to replace
+func fingerprint(user *models.User, purpose string) string {
+	state := user.Email
+	if purpose == TokenTypeResetPassword {
+		state = user.PasswordHash
+	}
+	sum := sha256.Sum256([]byte(purpose + "\x00" + state))
+	return hex.EncodeToString(sum[:12])
+}
+
+// send delivers a message in the background, so that requests neither wait
+// for the mail server nor reveal by their timing whether a mail was sent
+func (s *Service) send(msg mail.Message) {
+	go func() {
+		if err := s.mailer.Send(msg); err != nil {
+			log.Printf("auth: failed to send mail to %s: %v", msg.To, err)
+		}
+	}()
+}
@@ -1,10 +1,18 @@
 package auth
 
 import (
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"chess-app/internal/config"
+	"chess-app/internal/models"
+	"chess-app/internal/ratelimit"
 
 	"github.com/gin-gonic/gin"
 )
@@ -38,12 +46,20 @@ type AuthResponse struct {
 	User         interface{} `json:"user"`
 }
 
+// MFARequiredResponse answers a login with the right password when a
+// second factor is still needed
+type MFARequiredResponse struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+}
+
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 
 type RefreshTokenResponse struct {
-	AccessToken string `json:"accessToken"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
 }
 
 // Register handles user registration
@@ -67,256 +83,713 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate access token (15 minutes)
-	accessToken, err := GenerateAccessToken(user.ID, user.Username, []byte(h.config.JWTSecret))
+	if err := h.service.SendVerificationEmail(user); err != nil {
+		log.Printf("auth: failed to send verification email: %v", err)
+	}
+
+	h.respondWithTokens(c, http.StatusCreated, user)
+}
+
+// Login handles user login
+func (h *Handler) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.Login(req.Email, req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate access token"})
+		var locked *AccountLockedError
+		switch {
+		case err == ErrInvalidCredentials:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		case errors.As(err, &locked):
+			respondLocked(c, locked)
+		case errors.Is(err, ErrAccountBanned), errors.Is(err, ErrAccountSuspended):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// With two-factor authentication, the password only earns a short-lived
+	// token to exchange along with a code at /auth/login/2fa
+	if user.TOTPEnabled {
+		mfaToken, err := h.service.GenerateMFAToken(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, MFARequiredResponse{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+
+	h.respondWithTokens(c, http.StatusOK, user)
+}
+
+// GetProfile returns the current user's profile
+func (h *Handler) GetProfile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	// Generate refresh token (7 days)
-	refreshTokenString, err := GenerateRefreshTokenString()
+	user, err := h.service.GetUserByID(userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate refresh token"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
 
-	refreshTokenJWT, err := GenerateRefreshToken(user.ID, user.Username, []byte(h.config.JWTSecret))
+	c.JSON(http.StatusOK, gin.H{
+		"id":            user.ID,
+		"username":      user.Username,
+		"email":         user.Email,
+		"avatarUrl":     user.AvatarURL,
+		"eloRating":     user.ELORating,
+		"gamesPlayed":   user.GamesPlayed,
+		"wins":          user.Wins,
+		"losses":        user.Losses,
+		"draws":         user.Draws,
+		"emailVerified": user.EmailVerified,
+		"totpEnabled":   user.TOTPEnabled,
+		"createdAt":     user.CreatedAt,
+	})
+}
+
+// respondWithTokens starts a session for a user who just authenticated and
+// sends their tokens. The refresh token is also set as an HTTP-only cookie.
+func (h *Handler) respondWithTokens(c *gin.Context, status int, user *models.User) {
+	// Generate access token (15 minutes)
+	accessToken, err := GenerateAccessToken(user.ID, user.Username, []byte(h.config.JWTSecret))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate access token"})
 		return
 	}
 
-	// Save refresh token to database
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	if err := h.service.SaveRefreshToken(user.ID, refreshTokenString, expiresAt); err != nil {
+	refreshToken, err := h.service.StartSession(user.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save refresh token"})
 		return
 	}
+	setRefreshCookie(c, refreshToken)
 
-	// Set secure HTTP-only cookie for refresh token
+	c.JSON(status, AuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		User: gin.H{
+			"id":            user.ID,
+			"username":      user.Username,
+			"email":         user.Email,
+			"avatarUrl":     user.AvatarURL,
+			"eloRating":     user.ELORating,
+			"gamesPlayed":   user.GamesPlayed,
+			"wins":          user.Wins,
+			"losses":        user.Losses,
+			"draws":         user.Draws,
+			"emailVerified": user.EmailVerified,
+			"totpEnabled":   user.TOTPEnabled,
+		},
+	})
+}
+
+// setRefreshCookie sets the refresh token as a secure HTTP-only cookie, or
+// clears it if token is empty
+func setRefreshCookie(c *gin.Context, token string) {
+	maxAge := int(RefreshTokenTTL.Seconds())
+	if token == "" {
+		maxAge = -1
+	}
 	c.SetCookie(
 		"refresh_token",
-		refreshTokenString,
-		int(7*24*time.Hour.Seconds()),
+		token,
+		maxAge,
 		"/",
 		"",
-		true,  // Secure (HTTPS only in production)
-		true,  // HttpOnly
+		true, // Secure (HTTPS only in production)
+		true, // HttpOnly
 	)
+}
+
+// requestRefreshToken reads the refresh token from the cookie, or from the
+// body for clients without cookies
+func requestRefreshToken(c *gin.Context) string {
+	if cookie, err := c.Cookie("refresh_token"); err == nil && cookie != "" {
+		return cookie
+	}
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return ""
+	}
+	return req.RefreshToken
+}
 
-	c.JSON(http.StatusCreated, AuthResponse{
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once.
+func (h *Handler) RefreshToken(c *gin.Context) {
+	refreshTokenString := requestRefreshToken(c)
+	if refreshTokenString == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token required"})
+		return
+	}
+
+	user, refreshToken, err := h.service.RotateRefreshToken(refreshTokenString, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidRefreshToken), errors.Is(err, ErrRefreshTokenReused):
+			setRefreshCookie(c, "")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrAccountBanned), errors.Is(err, ErrAccountSuspended):
+			setRefreshCookie(c, "")
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Generate new access token
+	accessToken, err := GenerateAccessToken(user.ID, user.Username, []byte(h.config.JWTSecret))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate access token"})
+		return
+	}
+
+	setRefreshCookie(c, refreshToken)
+	c.JSON(http.StatusOK, RefreshTokenResponse{
 		AccessToken:  accessToken,
-		RefreshToken: refreshTokenJWT,
-		User: gin.H{
-			"id":          user.ID,
-			"username":    user.Username,
-			"email":       user.Email,
-			"avatarUrl":   user.AvatarURL,
-			"eloRating":   user.ELORating,
-			"gamesPlayed": user.GamesPlayed,
-			"wins":        user.Wins,
-			"losses":      user.Losses,
-			"draws":       user.Draws,
-		},
+		RefreshToken: refreshToken,
 	})
 }
 
-// Login handles user login
-func (h *Handler) Login(c *gin.Context) {
-	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// Logout handles user logout
+func (h *Handler) Logout(c *gin.Context) {
+	// Revoke the session of the refresh token
+	if refreshTokenString := requestRefreshToken(c); refreshTokenString != "" {
+		h.service.EndSession(refreshTokenString)
+	}
+
+	setRefreshCookie(c, "")
+	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+}
+
+// SessionResponse is a session as listed to its user
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"` // The session making the request
+}
+
+// ListSessions returns the devices the user is logged in on
+func (h *Handler) ListSessions(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	sessions, err := h.service.ListSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := h.service.Login(req.Email, req.Password)
+	var currentID uint
+	if cookie, err := c.Cookie("refresh_token"); err == nil && cookie != "" {
+		if current, err := h.service.SessionOf(cookie); err == nil {
+			currentID = current.ID
+		}
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{Session: session, Current: session.ID == currentID}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession logs the user out of one device
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		if err == ErrInvalidCredentials {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		return
+	}
+
+	if err := h.service.RevokeSession(userID, uint(sessionID)); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
 
-	// Generate access token (15 minutes)
-	accessToken, err := GenerateAccessToken(user.ID, user.Username, []byte(h.config.JWTSecret))
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expiresInDays"` // 0 for a token that never expires
+}
+
+// CreateAPITokenResponse holds the token's value, shown only once
+type CreateAPITokenResponse struct {
+	Token    string           `json:"token"`
+	APIToken *models.APIToken `json:"apiToken"`
+}
+
+// ListAPITokens returns the user's personal access tokens and the scopes
+// a token can have
+func (h *Handler) ListAPITokens(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	tokens, err := h.service.ListAPITokens(userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate access token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Generate refresh token (7 days)
-	refreshTokenString, err := GenerateRefreshTokenString()
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens, "scopes": Scopes})
+}
+
+// CreateAPIToken creates a personal access token for scripts and bots
+func (h *Handler) CreateAPIToken(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresInDays must not be negative"})
+		return
+	}
+
+	token, value, err := h.service.CreateAPIToken(userID, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate refresh token"})
+		switch {
+		case errors.Is(err, ErrTooManyAPITokens):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrInvalidScope), errors.Is(err, ErrNoScopes):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "scopes": Scopes})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
-	refreshTokenJWT, err := GenerateRefreshToken(user.ID, user.Username, []byte(h.config.JWTSecret))
+	c.JSON(http.StatusCreated, CreateAPITokenResponse{Token: value, APIToken: token})
+}
+
+// RevokeAPIToken deletes a personal access token
+func (h *Handler) RevokeAPIToken(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	tokenID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate refresh token"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token ID"})
 		return
 	}
 
-	// Save refresh token to database
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	if err := h.service.SaveRefreshToken(user.ID, refreshTokenString, expiresAt); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save refresh token"})
+	if err := h.service.RevokeAPIToken(userID, uint(tokenID)); err != nil {
+		if errors.Is(err, ErrAPITokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
-	// Set secure HTTP-only cookie for refresh token
-	c.SetCookie(
-		"refresh_token",
-		refreshTokenString,
-		int(7*24*time.Hour.Seconds()),
-		"/",
-		"",
-		true,  // Secure (HTTPS only in production)
-		true,  // HttpOnly
-	)
+	c.JSON(http.StatusOK, gin.H{"message": "token revoked"})
+}
 
-	c.JSON(http.StatusOK, AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshTokenJWT,
-		User: gin.H{
-			"id":          user.ID,
-			"username":    user.Username,
-			"email":       user.Email,
-			"avatarUrl":   user.AvatarURL,
-			"eloRating":   user.ELORating,
-			"gamesPlayed": user.GamesPlayed,
-			"wins":        user.Wins,
-			"losses":      user.Losses,
-			"draws":       user.Draws,
-		},
-	})
+// Most tokens checked by one TestAPITokens request
+const maxTestedTokens = 100
+
+// TestAPITokens checks the comma-separated tokens of the request body, in
+// the shape of Lichess's POST /api/token/test: each token maps to its
+// scopes, user and expiry, or to null if it is not valid.
+func (h *Handler) TestAPITokens(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 64<<10))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	values := strings.Split(string(body), ",")
+	if len(values) > maxTestedTokens {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many tokens"})
+		return
+	}
+
+	result := make(map[string]interface{}, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		token, user, err := h.service.AuthenticateAPIToken(value)
+		if err != nil {
+			if errors.Is(err, ErrInvalidAPIToken) || errors.Is(err, ErrAccountBanned) || errors.Is(err, ErrAccountSuspended) {
+				result[value] = nil
+				continue
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var expires interface{}
+		if token.ExpiresAt != nil {
+			expires = token.ExpiresAt.UnixMilli()
+		}
+		result[value] = gin.H{
+			"scopes":  strings.Join(token.Scopes, ","),
+			"userId":  strings.ToLower(user.Username),
+			"expires": expires,
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
 }
 
-// GetProfile returns the current user's profile
-func (h *Handler) GetProfile(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// VerifyEmail confirms the user's address from the emailed link
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := h.service.GetUserByID(userID.(uint))
+	if _, err := h.service.VerifyEmail(req.Token); err != nil {
+		if errors.Is(err, ErrInvalidEmailToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+// ResendVerification sends the logged-in user a new verification link
+func (h *Handler) ResendVerification(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	user, err := h.service.GetUserByID(userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already verified"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":          user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"avatarUrl":   user.AvatarURL,
-		"eloRating":   user.ELORating,
-		"gamesPlayed": user.GamesPlayed,
-		"wins":        user.Wins,
-		"losses":      user.Losses,
-		"draws":       user.Draws,
-		"createdAt":   user.CreatedAt,
-	})
+	if err := h.service.SendVerificationEmail(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
 }
 
-type UpdateAvatarRequest struct {
-	AvatarURL string `json:"avatarUrl" binding:"required"`
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
 }
 
-// UpdateAvatar updates user avatar URL
-func (h *Handler) UpdateAvatar(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+// ForgotPassword emails a reset link. The response is the same whether or
+// not the address belongs to an account.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var req UpdateAvatarRequest
+	if err := h.service.RequestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if an account uses this email, a reset link has been sent"})
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// ResetPassword sets a new password from the emailed link and signs the user
+// out of every session
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := h.service.UpdateAvatar(userID.(uint), req.AvatarURL); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update avatar"})
+	if err := h.service.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, ErrInvalidEmailToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "avatar updated", "avatarUrl": req.AvatarURL})
+	c.JSON(http.StatusOK, gin.H{"message": "password reset; please log in again"})
 }
 
-// RefreshToken handles refresh token requests
-func (h *Handler) RefreshToken(c *gin.Context) {
-	// Try to get refresh token from cookie first, then from body
-	refreshTokenString := ""
-	if cookie, err := c.Cookie("refresh_token"); err == nil {
-		refreshTokenString = cookie
-	} else {
-		var req RefreshTokenRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "refresh token required"})
-			return
-		}
-		refreshTokenString = req.RefreshToken
+type LoginMFARequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required"` // From the authenticator app, or a recovery code
+}
+
+// LoginMFA completes a login with the second factor
+func (h *Handler) LoginMFA(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	if refreshTokenString == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token required"})
+	user, err := h.service.CompleteMFALogin(req.MFAToken, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
 		return
 	}
 
-	// Validate refresh token from database
-	refreshToken, err := h.service.GetRefreshToken(refreshTokenString)
+	h.respondWithTokens(c, http.StatusOK, user)
+}
+
+// SetupTOTP starts two-factor enrollment
+func (h *Handler) SetupTOTP(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	secret, uri, err := h.service.SetupTOTP(userID)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		respondMFAError(c, err)
 		return
 	}
 
-	// Get user
-	user, err := h.service.GetUserByID(refreshToken.UserID)
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauthUri": uri})
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// ConfirmTOTP enables two-factor authentication with a first code and
+// returns the recovery codes
+func (h *Handler) ConfirmTOTP(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.ConfirmTOTP(userID, req.Code)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		respondMFAError(c, err)
 		return
 	}
 
-	// Generate new access token
-	accessToken, err := GenerateAccessToken(user.ID, user.Username, []byte(h.config.JWTSecret))
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// DisableTOTP turns two-factor authentication off
+func (h *Handler) DisableTOTP(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.DisableTOTP(userID, req.Code); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(userID, req.Code)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate access token"})
+		respondMFAError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, RefreshTokenResponse{
-		AccessToken: accessToken,
-	})
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
 }
 
-// Logout handles user logout
-func (h *Handler) Logout(c *gin.Context) {
-	// Delete refresh token from cookie
-	refreshTokenString := ""
-	if cookie, err := c.Cookie("refresh_token"); err == nil {
-		refreshTokenString = cookie
+func respondMFAError(c *gin.Context, err error) {
+	var locked *AccountLockedError
+	switch {
+	case errors.As(err, &locked):
+		respondLocked(c, locked)
+	case errors.Is(err, ErrAccountBanned), errors.Is(err, ErrAccountSuspended):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTOTPAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTOTPNotSetUp), errors.Is(err, ErrTOTPNotEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
+}
+
+// respondLocked tells a client when it may try to log in again
+func respondLocked(c *gin.Context, err *AccountLockedError) {
+	c.Header("Retry-After", ratelimit.RetryAfter(err.RetryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+}
+
+// oidcCookie keeps an authorization request between the redirect to the
+// provider and its callback
+const oidcCookie = "oidc_state"
 
-	if refreshTokenString != "" {
-		h.service.DeleteRefreshToken(refreshTokenString)
+// ListOIDCProviders returns the single sign-on providers users can log in
+// with
+func (h *Handler) ListOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.OIDCProviders())
+}
+
+// OIDCLogin redirects the browser to a provider's login page
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	authURL, state, err := h.service.BeginOIDC(c.Request.Context(), c.Param("provider"), 0)
+	if err != nil {
+		h.redirectOIDC(c, url.Values{"error": {err.Error()}})
+		return
 	}
 
-	// Clear cookie
-	c.SetCookie(
-		"refresh_token",
-		"",
-		-1,
-		"/",
-		"",
-		true,
-		true,
-	)
+	h.setOIDCCookie(c, state, int(oidcStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+// OIDCLink starts linking a provider to the logged-in user. It returns the
+// URL to open, since a browser navigation cannot carry the access token.
+func (h *Handler) OIDCLink(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	authURL, state, err := h.service.BeginOIDC(c.Request.Context(), c.Param("provider"), userID)
+	if err != nil {
+		if errors.Is(err, ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	h.setOIDCCookie(c, state, int(oidcStateTTL.Seconds()))
+	c.JSON(http.StatusOK, gin.H{"authorizationUrl": authURL})
+}
+
+// OIDCCallback finishes a login or a link when the provider redirects back,
+// then sends the browser to the frontend's /oidc/callback page with the
+// outcome in the URL fragment. After a login, the refresh token cookie is
+// set and the frontend gets its access token from /auth/refresh.
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	cookie, _ := c.Cookie(oidcCookie)
+	h.setOIDCCookie(c, "", -1)
+
+	if providerError := c.Query("error"); providerError != "" {
+		h.redirectOIDC(c, url.Values{"error": {providerError}})
+		return
+	}
+
+	provider := c.Param("provider")
+	result, err := h.service.FinishOIDC(c.Request.Context(), provider, c.Query("code"), c.Query("state"), cookie)
+	if err != nil {
+		h.redirectOIDC(c, url.Values{"error": {err.Error()}})
+		return
+	}
+
+	if result.Linked {
+		h.redirectOIDC(c, url.Values{"linked": {provider}})
+		return
+	}
+
+	// The provider stands in for the password, not for the second factor
+	if result.User.TOTPEnabled {
+		mfaToken, err := h.service.GenerateMFAToken(result.User)
+		if err != nil {
+			h.redirectOIDC(c, url.Values{"error": {"failed to generate token"}})
+			return
+		}
+		h.redirectOIDC(c, url.Values{"mfaToken": {mfaToken}})
+		return
+	}
+
+	refreshToken, err := h.service.StartSession(result.User.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		h.redirectOIDC(c, url.Values{"error": {"failed to save refresh token"}})
+		return
+	}
+	setRefreshCookie(c, refreshToken)
+	h.redirectOIDC(c, url.Values{"status": {"ok"}})
+}
+
+// ListIdentities returns the providers linked to the user
+func (h *Handler) ListIdentities(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	identities, err := h.service.ListIdentities(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// UnlinkIdentity removes a provider from the user's account
+func (h *Handler) UnlinkIdentity(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	if err := h.service.UnlinkIdentity(userID, c.Param("provider")); err != nil {
+		switch {
+		case errors.Is(err, ErrIdentityNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrLastLoginMethod):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "provider unlinked"})
+}
+
+// setOIDCCookie stores the authorization request. SameSite=Lax lets the
+// browser send it on the provider's redirect back.
+func (h *Handler) setOIDCCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, value, maxAge, "/api/auth/oidc", "", true, true)
+}
+
+func (h *Handler) redirectOIDC(c *gin.Context, fragment url.Values) {
+	c.Redirect(http.StatusFound, h.config.AppURL+"/oidc/callback#"+fragment.Encode())
 }
@@ -11,14 +11,11 @@ import (
 type Claims struct {
 	UserID   uint   `json:"userId"`
 	Username string `json:"username"`
-	Type     string `json:"type"` // "access" or "refresh"
+	Type     string `json:"type"` // Always "access"; refresh tokens are opaque
 	jwt.RegisteredClaims
 }
 
-const (
-	TokenTypeAccess  = "access"
-	TokenTypeRefresh = "refresh"
-)
+const TokenTypeAccess = "access"
 
 // GenerateAccessToken generates a short-lived JWT access token
 func GenerateAccessToken(userID uint, username string, secret []byte) (string, error) {
@@ -37,23 +34,6 @@ func GenerateAccessToken(userID uint, username string, secret []byte) (string, e
 	return token.SignedString(secret)
 }
 
-// GenerateRefreshToken generates a long-lived refresh token
-func GenerateRefreshToken(userID uint, username string, secret []byte) (string, error) {
-	now := time.Now()
-	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		Type:     TokenTypeRefresh,
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(7 * 24 * time.Hour)), // 7 days
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
-}
-
 // GenerateRefreshTokenString generates a random string for refresh token storage
 func GenerateRefreshTokenString() (string, error) {
 	b := make([]byte, 32)
@@ -92,15 +72,3 @@ func ValidateAccessToken(tokenString string, secret []byte) (*Claims, error) {
 	}
 	return claims, nil
 }
-
-// ValidateRefreshToken validates a refresh token specifically
-func ValidateRefreshToken(tokenString string, secret []byte) (*Claims, error) {
-	claims, err := ValidateToken(tokenString, secret)
-	if err != nil {
-		return nil, err
-	}
-	if claims.Type != TokenTypeRefresh {
-		return nil, jwt.ErrTokenInvalidClaims
-	}
-	return claims, nil
-}
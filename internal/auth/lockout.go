@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"chess-app/internal/models"
+)
+
+// Failed logins lock an account once they reach lockoutThreshold. The first
+// lockout lasts lockoutBase and each further failure doubles it, up to
+// lockoutMax. Failures older than lockoutWindow are forgotten.
+const (
+	lockoutThreshold = 5
+	lockoutBase      = time.Minute
+	lockoutMax       = time.Hour
+	lockoutWindow    = 24 * time.Hour
+)
+
+var (
+	ErrAccountLocked    = errors.New("too many failed login attempts, try again later")
+	ErrAccountBanned    = errors.New("account banned")
+	ErrAccountSuspended = errors.New("account suspended")
+)
+
+// AccountLockedError is returned for logins to a locked account. It wraps
+// ErrAccountLocked.
+type AccountLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *AccountLockedError) Error() string { return ErrAccountLocked.Error() }
+
+func (e *AccountLockedError) Unwrap() error { return ErrAccountLocked }
+
+// checkLockout refuses logins to a locked account, whatever the password
+func checkLockout(user *models.User) error {
+	if user.LockedUntil == nil {
+		return nil
+	}
+	if wait := time.Until(*user.LockedUntil); wait > 0 {
+		return &AccountLockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// checkStanding refuses logins of banned and suspended users. It runs once
+// the password is known to be right, so that guessing reveals nothing.
+func checkStanding(user *models.User) error {
+	now := time.Now()
+	if !user.Suspended(now) {
+		return nil
+	}
+	if user.BannedAt != nil {
+		return ErrAccountBanned
+	}
+	return fmt.Errorf("%w until %s", ErrAccountSuspended, user.SuspendedUntil.Format(time.RFC3339))
+}
+
+// recordLoginFailure counts a wrong password or code and locks the account
+// when there have been too many
+func (s *Service) recordLoginFailure(userID uint) error {
+	now := time.Now()
+	var failures int
+	if err := s.db.Raw(`UPDATE users SET
+			failed_logins = CASE WHEN last_failed_login_at > ? THEN failed_logins + 1 ELSE 1 END,
+			last_failed_login_at = ?
+		WHERE id = ? RETURNING failed_logins`,
+		now.Add(-lockoutWindow), now, userID).Scan(&failures).Error; err != nil {
+		return err
+	}
+	if failures < lockoutThreshold {
+		return nil
+	}
+	return s.db.Model(&models.User{}).Where("id = ?", userID).
+		Update("locked_until", now.Add(lockoutDuration(failures))).Error
+}
+
+// resetLoginFailures forgets failed logins after a successful one
+func (s *Service) resetLoginFailures(user *models.User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return s.db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_logins":        0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	}).Error
+}
+
+// lockoutDuration is how long an account stays locked after failures
+// consecutive failed logins
+func lockoutDuration(failures int) time.Duration {
+	d := lockoutBase
+	for i := lockoutThreshold; i < failures && d < lockoutMax; i++ {
+		d *= 2
+	}
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+	return d
+}
@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"chess-app/internal/models"
+	"chess-app/internal/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// TokenTypeOIDCState is the type of the token that carries an authorization
+// request in a cookie until the provider redirects back
+const TokenTypeOIDCState = "oidc_state"
+
+// Time the user has to log in at the provider
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownProvider    = errors.New("unknown login provider")
+	ErrInvalidOIDCState   = errors.New("login request expired or was started in another browser")
+	ErrProviderEmail      = errors.New("the provider did not share a verified email")
+	ErrAccountExists      = errors.New("an account already uses this email; log in and link the provider from your profile")
+	ErrIdentityTaken      = errors.New("this provider account is already linked to another user")
+	ErrIdentityNotFound   = errors.New("provider not linked")
+	ErrLastLoginMethod    = errors.New("set a password before unlinking your only login method")
+	ErrProviderAlreadySet = errors.New("a different account of this provider is already linked")
+)
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// ProviderInfo describes a login provider to clients
+type ProviderInfo struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+}
+
+// oidcState is an authorization request in progress. LinkUserID is set when
+// a logged-in user links the provider instead of logging in with it.
+type oidcState struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"verifier"`
+	LinkUserID   uint   `json:"linkUserId,omitempty"`
+	Type         string `json:"type"`
+	jwt.RegisteredClaims
+}
+
+// OIDCResult is how a provider callback ended
+type OIDCResult struct {
+	User   *models.User
+	Linked bool // A logged-in user linked the provider; no login happened
+}
+
+// OIDCProviders lists the configured login providers
+func (s *Service) OIDCProviders() []ProviderInfo {
+	providers := make([]ProviderInfo, 0, len(s.config.OIDCProviders))
+	for _, p := range s.config.OIDCProviders {
+		providers = append(providers, ProviderInfo{Name: p.Name, Label: p.Label})
+	}
+	return providers
+}
+
+// BeginOIDC starts an authorization request. It returns the provider URL
+// to redirect to and the state to keep in a cookie until the callback.
+func (s *Service) BeginOIDC(ctx context.Context, name string, linkUserID uint) (string, string, error) {
+	provider, ok := s.oidc[name]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		return "", "", err
+	}
+	authURL, err := provider.AuthURL(ctx, req)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	state, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcState{
+		Provider:     name,
+		State:        req.State,
+		Nonce:        req.Nonce,
+		CodeVerifier: req.CodeVerifier,
+		LinkUserID:   linkUserID,
+		Type:         TokenTypeOIDCState,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(oidcStateTTL)),
+		},
+	}).SignedString([]byte(s.config.JWTSecret))
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// FinishOIDC handles the provider's redirect: it checks the state, redeems
+// the code and then links the provider or finds the user to log in. A new
+// account is created for an unknown user whose email the provider verified.
+func (s *Service) FinishOIDC(ctx context.Context, name, code, state, cookie string) (*OIDCResult, error) {
+	var pending oidcState
+	token, err := jwt.ParseWithClaims(cookie, &pending, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.config.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid || pending.Type != TokenTypeOIDCState ||
+		pending.Provider != name || pending.State == "" || pending.State != state {
+		return nil, ErrInvalidOIDCState
+	}
+
+	provider, ok := s.oidc[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	claims, err := provider.Exchange(ctx, code, &oidc.AuthRequest{
+		State:        pending.State,
+		Nonce:        pending.Nonce,
+		CodeVerifier: pending.CodeVerifier,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if pending.LinkUserID != 0 {
+		user, err := s.linkIdentity(pending.LinkUserID, name, claims)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCResult{User: user, Linked: true}, nil
+	}
+
+	user, err := s.oidcUser(name, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStanding(user); err != nil {
+		return nil, err
+	}
+	return &OIDCResult{User: user}, nil
+}
+
+// oidcUser finds the user a provider account logs in as: the linked user,
+// else the verified account with the same verified email, else a new one
+func (s *Service) oidcUser(name string, claims *oidc.Claims) (*models.User, error) {
+	var identity models.UserIdentity
+	err := s.db.Where("provider = ? AND subject = ?", name, claims.Subject).First(&identity).Error
+	if err == nil {
+		return s.GetUserByID(identity.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	email := strings.TrimSpace(strings.ToLower(claims.Email))
+	if email == "" || !claims.EmailVerified {
+		return nil, ErrProviderEmail
+	}
+
+	var user models.User
+	err = s.db.Where("email = ?", email).First(&user).Error
+	if err == nil {
+		// An unverified account may have been registered by someone else
+		// with this address, who would then share it
+		if !user.EmailVerified || user.IsBot {
+			return nil, ErrAccountExists
+		}
+		return s.linkIdentity(user.ID, name, claims)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		username, err := availableUsername(tx, claims)
+		if err != nil {
+			return err
+		}
+		user = models.User{
+			Username:      username,
+			Email:         email,
+			PasswordHash:  "!", // Not a valid bcrypt hash: no password until one is set with a reset link
+			ELORating:     1200,
+			EmailVerified: true,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: name,
+			Subject:  claims.Subject,
+			Email:    email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// linkIdentity links a provider account to a user
+func (s *Service) linkIdentity(userID uint, name string, claims *oidc.Claims) (*models.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []models.UserIdentity
+	if err := s.db.Where("(provider = ? AND subject = ?) OR (provider = ? AND user_id = ?)",
+		name, claims.Subject, name, userID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	for _, identity := range existing {
+		switch {
+		case identity.UserID == userID && identity.Subject == claims.Subject:
+			return user, nil // Already linked
+		case identity.UserID != userID:
+			return nil, ErrIdentityTaken
+		default:
+			return nil, ErrProviderAlreadySet
+		}
+	}
+
+	if err := s.db.Create(&models.UserIdentity{
+		UserID:   userID,
+		Provider: name,
+		Subject:  claims.Subject,
+		Email:    strings.ToLower(claims.Email),
+	}).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListIdentities returns the providers linked to a user
+func (s *Service) ListIdentities(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := s.db.Where("user_id = ?", userID).Order("provider").Find(&identities).Error
+	return identities, err
+}
+
+// UnlinkIdentity removes a provider from a user, unless it is the only way
+// they can log in
+func (s *Service) UnlinkIdentity(userID uint, name string) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	identities, err := s.ListIdentities(userID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, identity := range identities {
+		found = found || identity.Provider == name
+	}
+	if !found {
+		return ErrIdentityNotFound
+	}
+	if len(identities) == 1 && !hasPassword(user) {
+		return ErrLastLoginMethod
+	}
+	return s.db.Where("user_id = ? AND provider = ?", userID, name).Delete(&models.UserIdentity{}).Error
+}
+
+// hasPassword reports whether a user can log in with a password
+func hasPassword(user *models.User) bool {
+	return strings.HasPrefix(user.PasswordHash, "$2")
+}
+
+// availableUsername derives a free username from the provider's claims
+func availableUsername(tx *gorm.DB, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameUnsafe.ReplaceAllString(base, ""), "-_")
+	if len(base) > 20 {
+		base = base[:20]
+	}
+	// Registration rules apply to the derived name too
+	if base == "" || validateUsername(base) != nil {
+		base = "player"
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+		var count int64
+		if err := tx.Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", ErrUsernameExists
+}
@@ -0,0 +1,295 @@
+//go:build postgres
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"chess-app/internal/models"
+	"chess-app/internal/oidc"
+	"chess-app/internal/oidc/oidctest"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testDB migrates a schema of its own, dropped after the test, so that the
+// database in DATABASE_URL is left as it was.
+// Run with a database: DATABASE_URL=... go test -tags postgres ./internal/auth
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn, err := models.DatabaseDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := models.ConnectDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("auth_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+schema), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := models.AutoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func createUser(t *testing.T, db *gorm.DB, user models.User) *models.User {
+	t.Helper()
+	if user.PasswordHash == "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.PasswordHash = string(hash)
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func finishOIDC(t *testing.T, s *Service, mock *oidctest.Provider, linkUserID uint, user oidctest.User) (*OIDCResult, error) {
+	t.Helper()
+	code, state, cookie := beginOIDC(t, s, mock, linkUserID, user)
+	return s.FinishOIDC(context.Background(), testProvider, code, state, cookie)
+}
+
+func TestOIDCCreatesAccount(t *testing.T) {
+	db := testDB(t)
+	s, mock := newOIDCService(t, db)
+
+	result, err := finishOIDC(t, s, mock, 0, testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := result.User
+	if result.Linked || user.Username != "alice" || user.Email != testUser.Email || !user.EmailVerified || hasPassword(user) {
+		t.Errorf("unexpected new account %+v", user)
+	}
+
+	// The next login finds the linked account, even with a new email
+	again := testUser
+	again.Email = "alice@elsewhere.example"
+	result, err = finishOIDC(t, s, mock, 0, again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.ID != user.ID {
+		t.Errorf("second login as user %d, want %d", result.User.ID, user.ID)
+	}
+}
+
+func TestOIDCRefusesUnverifiedEmail(t *testing.T) {
+	db := testDB(t)
+	s, mock := newOIDCService(t, db)
+
+	for _, user := range []oidctest.User{
+		{Subject: "sub-1", Email: "alice@example.com"},
+		{Subject: "sub-1", EmailVerified: true},
+	} {
+		if _, err := finishOIDC(t, s, mock, 0, user); !errors.Is(err, ErrProviderEmail) {
+			t.Errorf("%+v: got %v, want ErrProviderEmail", user, err)
+		}
+	}
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d accounts created", count)
+	}
+}
+
+func TestOIDCAutoLinksVerifiedAccountOnly(t *testing.T) {
+	db := testDB(t)
+	s, mock := newOIDCService(t, db)
+
+	verified := createUser(t, db, models.User{Username: "alice", Email: "alice@example.com", EmailVerified: true})
+	createUser(t, db, models.User{Username: "bob", Email: "bob@example.com"})
+	createUser(t, db, models.User{Username: "bot", Email: "bot@example.com", EmailVerified: true, IsBot: true})
+
+	// The provider's email is matched case-insensitively
+	alice := testUser
+	alice.Email = "Alice@Example.com"
+	result, err := finishOIDC(t, s, mock, 0, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.ID != verified.ID {
+		t.Errorf("logged in as user %d, want %d", result.User.ID, verified.ID)
+	}
+	identities, err := s.ListIdentities(verified.ID)
+	if err != nil || len(identities) != 1 || identities[0].Subject != alice.Subject {
+		t.Errorf("identities %+v, %v", identities, err)
+	}
+
+	for i, email := range []string{"bob@example.com", "bot@example.com"} {
+		user := oidctest.User{Subject: fmt.Sprintf("sub-%d", i+2), Email: email, EmailVerified: true}
+		if _, err := finishOIDC(t, s, mock, 0, user); !errors.Is(err, ErrAccountExists) {
+			t.Errorf("%s: got %v, want ErrAccountExists", email, err)
+		}
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	db := testDB(t)
+	s, mock := newOIDCService(t, db)
+	alice := createUser(t, db, models.User{Username: "alice", Email: "alice@example.com"})
+	bob := createUser(t, db, models.User{Username: "bob", Email: "bob@example.com"})
+
+	// Linking does not need the emails to match or be verified
+	result, err := finishOIDC(t, s, mock, alice.ID, oidctest.User{Subject: "sub-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Linked || result.User.ID != alice.ID {
+		t.Errorf("unexpected result %+v", result)
+	}
+	// Linking again is harmless
+	if _, err := finishOIDC(t, s, mock, alice.ID, oidctest.User{Subject: "sub-1"}); err != nil {
+		t.Errorf("linking again: %v", err)
+	}
+
+	if _, err := finishOIDC(t, s, mock, bob.ID, oidctest.User{Subject: "sub-1"}); !errors.Is(err, ErrIdentityTaken) {
+		t.Errorf("got %v, want ErrIdentityTaken", err)
+	}
+	if _, err := finishOIDC(t, s, mock, alice.ID, oidctest.User{Subject: "sub-2"}); !errors.Is(err, ErrProviderAlreadySet) {
+		t.Errorf("got %v, want ErrProviderAlreadySet", err)
+	}
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	db := testDB(t)
+	s, mock := newOIDCService(t, db)
+
+	result, err := finishOIDC(t, s, mock, 0, testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := result.User.ID
+	if err := s.UnlinkIdentity(userID, "other"); !errors.Is(err, ErrIdentityNotFound) {
+		t.Errorf("got %v, want ErrIdentityNotFound", err)
+	}
+	if err := s.UnlinkIdentity(userID, testProvider); !errors.Is(err, ErrLastLoginMethod) {
+		t.Errorf("got %v, want ErrLastLoginMethod", err)
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Update("password_hash", string(hash)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnlinkIdentity(userID, testProvider); err != nil {
+		t.Errorf("unlinking with a password: %v", err)
+	}
+	if identities, _ := s.ListIdentities(userID); len(identities) != 0 {
+		t.Errorf("%d identities left", len(identities))
+	}
+}
+
+func TestAvailableUsername(t *testing.T) {
+	db := testDB(t)
+	createUser(t, db, models.User{Username: "alice", Email: "alice@example.com"})
+	createUser(t, db, models.User{Username: "player", Email: "player@example.com"})
+
+	tests := []struct {
+		preferred, email, want string
+	}{
+		{"alice", "", "alice2"},
+		{"", "carol.smith+chess@example.com", "carolsmithchess"},
+		{"Dave the Great!", "", "DavetheGreat"},
+		{"deleted-42", "", "player2"},
+		{"__", "", "player2"},
+		{"a-very-long-username-indeed", "", "a-very-long-username"},
+	}
+	for _, tt := range tests {
+		got, err := availableUsername(db, &oidc.Claims{PreferredUsername: tt.preferred, Email: tt.email})
+		if err != nil || got != tt.want {
+			t.Errorf("%q, %q: got %q, %v, want %q", tt.preferred, tt.email, got, err, tt.want)
+		}
+	}
+}
+
+// The provider stands in for the password: a user with two-factor
+// authentication still has to enter a code
+func TestOIDCCallbackAsksForSecondFactor(t *testing.T) {
+	db := testDB(t)
+	s, mock := newOIDCService(t, db)
+	h := NewHandler(s, s.config)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/auth/oidc/:provider/callback", h.OIDCCallback)
+
+	callback := func(user oidctest.User) (url.Values, *httptest.ResponseRecorder) {
+		code, state, cookie := beginOIDC(t, s, mock, 0, user)
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/"+testProvider+"/callback?"+
+			url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+		req.AddCookie(&http.Cookie{Name: oidcCookie, Value: cookie})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || w.Code != http.StatusFound {
+			t.Fatalf("status %d, location %q", w.Code, w.Header().Get("Location"))
+		}
+		fragment, err := url.ParseQuery(location.Fragment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fragment, w
+	}
+	refreshCookie := func(w *httptest.ResponseRecorder) string {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "refresh_token" {
+				return c.Value
+			}
+		}
+		return ""
+	}
+
+	fragment, w := callback(testUser)
+	if fragment.Get("status") != "ok" || refreshCookie(w) == "" {
+		t.Fatalf("login without a second factor: %v", fragment)
+	}
+
+	if err := db.Model(&models.User{}).Where("email = ?", testUser.Email).Update("totp_enabled", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	fragment, w = callback(testUser)
+	if refreshCookie(w) != "" || fragment.Get("status") != "" {
+		t.Error("a session was started before the second factor")
+	}
+	claims, err := ValidateToken(fragment.Get("mfaToken"), []byte(s.config.JWTSecret))
+	if err != nil || claims.Type != TokenTypeMFAPending {
+		t.Errorf("fragment %v: not an MFA token: %v", fragment, err)
+	}
+	if !strings.HasPrefix(w.Header().Get("Location"), s.config.AppURL+"/oidc/callback#") {
+		t.Errorf("redirected to %q", w.Header().Get("Location"))
+	}
+}
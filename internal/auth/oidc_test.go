@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"chess-app/internal/config"
+	"chess-app/internal/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const testProvider = "test"
+
+// newOIDCService returns a service whose only login provider is a mock one
+// started for the test
+func newOIDCService(t *testing.T, db *gorm.DB) (*Service, *oidctest.Provider) {
+	t.Helper()
+	mock, err := oidctest.NewProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+	cfg := &config.Config{
+		JWTSecret: "test-secret",
+		AppURL:    "https://chess.example",
+		OIDCProviders: []config.OIDCProvider{{
+			Name:         testProvider,
+			Label:        "Test",
+			Issuer:       mock.Issuer(),
+			ClientID:     mock.ClientID,
+			ClientSecret: mock.ClientSecret,
+			Scopes:       []string{"email", "profile"},
+		}},
+	}
+	return NewService(db, nil, cfg), mock
+}
+
+// beginOIDC starts a login or link and logs in at the provider as user. It
+// returns the code and state of the callback and the cookie set at the
+// start.
+func beginOIDC(t *testing.T, s *Service, mock *oidctest.Provider, linkUserID uint, user oidctest.User) (string, string, string) {
+	t.Helper()
+	authURL, cookie, err := s.BeginOIDC(context.Background(), testProvider, linkUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := mock.Authorize(authURL, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, state, cookie
+}
+
+var testUser = oidctest.User{
+	Subject:           "sub-1",
+	Email:             "alice@example.com",
+	EmailVerified:     true,
+	PreferredUsername: "alice",
+}
+
+func TestBeginOIDCUnknownProvider(t *testing.T) {
+	s, _ := newOIDCService(t, nil)
+	if _, _, err := s.BeginOIDC(context.Background(), "other", 0); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("got %v, want ErrUnknownProvider", err)
+	}
+}
+
+// The state checks happen before any database access
+func TestFinishOIDCRejectsState(t *testing.T) {
+	s, mock := newOIDCService(t, nil)
+	code, state, cookie := beginOIDC(t, s, mock, 0, testUser)
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcState{
+		Provider: testProvider,
+		State:    state,
+		Type:     TokenTypeOIDCState,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	}).SignedString([]byte(s.config.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcState{
+		Provider: testProvider,
+		State:    state,
+		Type:     TokenTypeOIDCState,
+	}).SignedString([]byte("another-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An access token is signed with the same secret but is not a state
+	access, err := GenerateAccessToken(1, "alice", []byte(s.config.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, provider, state, cookie string
+	}{
+		{"state mismatch", testProvider, state + "x", cookie},
+		{"no state", testProvider, "", cookie},
+		{"no cookie", testProvider, state, ""},
+		{"other provider", "other", state, cookie},
+		{"expired cookie", testProvider, state, expired},
+		{"forged cookie", testProvider, state, forged},
+		{"access token", testProvider, state, access},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.FinishOIDC(context.Background(), tt.provider, code, tt.state, tt.cookie)
+			if !errors.Is(err, ErrInvalidOIDCState) {
+				t.Errorf("got %v, want ErrInvalidOIDCState", err)
+			}
+		})
+	}
+}
+
+// A code obtained in one browser cannot be redeemed with the state and PKCE
+// verifier of another login request
+func TestFinishOIDCRejectsCodeOfAnotherRequest(t *testing.T) {
+	s, mock := newOIDCService(t, nil)
+	code, _, _ := beginOIDC(t, s, mock, 0, testUser)
+	_, state, cookie := beginOIDC(t, s, mock, 0, testUser)
+
+	_, err := s.FinishOIDC(context.Background(), testProvider, code, state, cookie)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("got %v, want invalid_grant", err)
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	for _, name := range []string{"deleted-42", "Deleted-Bob", "ChessBot", "chessbot"} {
+		if err := validateUsername(name); !errors.Is(err, ErrUsernameExists) {
+			t.Errorf("%q: got %v, want ErrUsernameExists", name, err)
+		}
+	}
+	for _, name := range []string{"alice", "deleted", "undeleted-bob"} {
+		if err := validateUsername(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package auth
 
 import (
 	"errors"
+	"log"
 	"strings"
-	"time"
 
+	"chess-app/internal/config"
+	"chess-app/internal/mail"
 	"chess-app/internal/models"
+	"chess-app/internal/oidc"
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -16,14 +19,28 @@ var (
 	ErrEmailExists        = errors.New("email already exists")
 	ErrUsernameExists     = errors.New("username already exists")
 	ErrUserNotFound       = errors.New("user not found")
+	ErrNoPassword         = errors.New("set a password with a reset link first")
 )
 
 type Service struct {
-	db *gorm.DB
+	db     *gorm.DB
+	mailer mail.Mailer
+	config *config.Config
+	oidc   map[string]*oidc.Provider // Login providers by name
 }
 
-func NewService(db *gorm.DB) *Service {
-	return &Service{db: db}
+func NewService(db *gorm.DB, mailer mail.Mailer, cfg *config.Config) *Service {
+	providers := make(map[string]*oidc.Provider, len(cfg.OIDCProviders))
+	for _, p := range cfg.OIDCProviders {
+		providers[p.Name] = oidc.NewProvider(oidc.Config{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  cfg.AppURL + "/api/auth/oidc/" + p.Name + "/callback",
+			Scopes:       p.Scopes,
+		})
+	}
+	return &Service{db: db, mailer: mailer, config: cfg, oidc: providers}
 }
 
 // Register creates a new user account
@@ -35,6 +52,9 @@ func (s *Service) Register(username, email, password string) (*models.User, erro
 	if username == "" || email == "" || password == "" {
 		return nil, errors.New("username, email, and password are required")
 	}
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
 
 	// Check if email exists
 	var existingEmail models.User
@@ -78,6 +98,18 @@ func (s *Service) Register(username, email, password string) (*models.User, erro
 	return user, nil
 }
 
+// validateUsername refuses the names new accounts cannot take: those of
+// deleted accounts and the bot's are reserved
+func validateUsername(username string) error {
+	if strings.HasPrefix(strings.ToLower(username), models.DeletedUsernamePrefix) {
+		return ErrUsernameExists
+	}
+	if strings.EqualFold(username, models.BotUsername) {
+		return ErrUsernameExists
+	}
+	return nil
+}
+
 // Login authenticates a user and returns the user
 func (s *Service) Login(email, password string) (*models.User, error) {
 	email = strings.TrimSpace(strings.ToLower(email))
@@ -90,14 +122,67 @@ func (s *Service) Login(email, password string) (*models.User, error) {
 		return nil, err
 	}
 
+	// A locked account refuses even the right password, or guessing could go on
+	if err := checkLockout(&user); err != nil {
+		return nil, err
+	}
+
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		if err := s.recordLoginFailure(user.ID); err != nil {
+			log.Printf("auth: failed to record failed login: %v", err)
+		}
 		return nil, ErrInvalidCredentials
 	}
 
+	if err := checkStanding(&user); err != nil {
+		return nil, err
+	}
+
+	// With two-factor authentication the login is not over yet
+	if !user.TOTPEnabled {
+		if err := s.resetLoginFailures(&user); err != nil {
+			log.Printf("auth: failed to reset failed logins: %v", err)
+		}
+	}
+
 	return &user, nil
 }
 
+// ConfirmPassword checks the password of a logged-in user before a
+// sensitive change, along with a second factor if they have one. Wrong
+// passwords count toward a lockout like failed logins.
+func (s *Service) ConfirmPassword(userID uint, password, code string) (*models.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !hasPassword(user) {
+		return nil, ErrNoPassword
+	}
+	if err := checkLockout(user); err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		if err := s.recordLoginFailure(user.ID); err != nil {
+			log.Printf("auth: failed to record failed login: %v", err)
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if user.TOTPEnabled {
+		if err := s.VerifySecondFactor(user, code); err != nil {
+			if errors.Is(err, ErrInvalidMFACode) {
+				if err := s.recordLoginFailure(user.ID); err != nil {
+					log.Printf("auth: failed to record failed login: %v", err)
+				}
+			}
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
 // GetUserByID retrieves a user by ID
 func (s *Service) GetUserByID(id uint) (*models.User, error) {
 	var user models.User
@@ -110,11 +195,6 @@ func (s *Service) GetUserByID(id uint) (*models.User, error) {
 	return &user, nil
 }
 
-// UpdateAvatar updates user avatar URL
-func (s *Service) UpdateAvatar(userID uint, avatarURL string) error {
-	return s.db.Model(&models.User{}).Where("id = ?", userID).Update("avatar_url", avatarURL).Error
-}
-
 // UpdateUserStats updates user statistics after a game
 func (s *Service) UpdateUserStats(userID uint, result string) error {
 	user, err := s.GetUserByID(userID)
@@ -137,32 +217,3 @@ func (s *Service) UpdateUserStats(userID uint, result string) error {
 
 	return s.db.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error
 }
-
-// SaveRefreshToken saves a refresh token to the database
-func (s *Service) SaveRefreshToken(userID uint, token string, expiresAt time.Time) error {
-	refreshToken := &models.RefreshToken{
-		UserID:    userID,
-		Token:     token,
-		ExpiresAt: expiresAt,
-	}
-	return s.db.Create(refreshToken).Error
-}
-
-// GetRefreshToken retrieves a refresh token from the database
-func (s *Service) GetRefreshToken(token string) (*models.RefreshToken, error) {
-	var refreshToken models.RefreshToken
-	if err := s.db.Where("token = ? AND expires_at > ?", token, time.Now()).First(&refreshToken).Error; err != nil {
-		return nil, err
-	}
-	return &refreshToken, nil
-}
-
-// DeleteRefreshToken deletes a refresh token
-func (s *Service) DeleteRefreshToken(token string) error {
-	return s.db.Where("token = ?", token).Delete(&models.RefreshToken{}).Error
-}
-
-// DeleteUserRefreshTokens deletes all refresh tokens for a user
-func (s *Service) DeleteUserRefreshTokens(userID uint) error {
-	return s.db.Where("user_id = ?", userID).Delete(&models.RefreshToken{}).Error
-}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// RefreshTokenTTL is how long a refresh token stays valid if unused
+	RefreshTokenTTL = 7 * 24 * time.Hour
+	// RefreshGracePeriod is how long after its rotation a token may be
+	// presented again, e.g. by two tabs refreshing at once or a client that
+	// lost the response, and get the same successor back
+	RefreshGracePeriod = 10 * time.Second
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used; session revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// StartSession opens a session for a user who just logged in and returns
+// its first refresh token
+func (s *Service) StartSession(userID uint, userAgent, ip string) (string, error) {
+	var token string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		session := models.Session{UserID: userID, UserAgent: userAgent, IP: ip, LastUsedAt: time.Now()}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		token, err = issueRefreshToken(tx, &session)
+		return err
+	})
+	return token, err
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one of the same
+// session and returns the session's user. Presenting a token that was
+// already exchanged revokes its whole session, since either the legitimate
+// client or an attacker holds a stolen copy, unless it is the token just
+// before the current one and within RefreshGracePeriod: then the successor
+// already issued is returned again.
+func (s *Service) RotateRefreshToken(token, userAgent, ip string) (*models.User, string, error) {
+	var user models.User
+	var next string
+	var reused bool
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Session").
+			Where("token_hash = ?", hashToken(token)).
+			First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		session := current.Session
+		if session == nil || session.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+		now := time.Now()
+		if current.UsedAt != nil {
+			successor, err := replayedSuccessor(tx, &current, token, now)
+			if err != nil {
+				return err
+			}
+			if successor == "" {
+				reused = true
+				return revokeSessions(tx, session.UserID, session.ID)
+			}
+			next = successor
+		} else {
+			if now.After(current.ExpiresAt) {
+				return ErrInvalidRefreshToken
+			}
+			var err error
+			if next, err = issueRefreshToken(tx, session); err != nil {
+				return err
+			}
+			sealed, err := sealSuccessor(token, next)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&current).Updates(map[string]interface{}{
+				"used_at":   now,
+				"successor": sealed,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(session).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"user_agent":   userAgent,
+			"ip":           ip,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&user, session.UserID).Error; err != nil {
+			return err
+		}
+		return checkStanding(&user)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if reused {
+		return nil, "", ErrRefreshTokenReused
+	}
+	return &user, next, nil
+}
+
+// replayedSuccessor returns the token issued in exchange for a used one if
+// that was within RefreshGracePeriod and the successor is itself unused, so
+// that the used token is the one just before the current one. Otherwise it
+// returns an empty string.
+func replayedSuccessor(tx *gorm.DB, used *models.RefreshToken, token string, now time.Time) (string, error) {
+	if used.Successor == "" || now.Sub(*used.UsedAt) > RefreshGracePeriod {
+		return "", nil
+	}
+	successor, err := openSuccessor(token, used.Successor)
+	if err != nil {
+		return "", nil
+	}
+	var count int64
+	if err := tx.Model(&models.RefreshToken{}).
+		Where("token_hash = ? AND session_id = ? AND used_at IS NULL", hashToken(successor), used.SessionID).
+		Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", nil
+	}
+	return successor, nil
+}
+
+// sealSuccessor encrypts a token's successor with a key derived from the
+// token, so that only its holder can get the successor back and the
+// database alone does not reveal it
+func sealSuccessor(token, successor string) (string, error) {
+	gcm, err := successorCipher(token)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(successor), nil)), nil
+}
+
+func openSuccessor(token, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := successorCipher(token)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", ErrInvalidRefreshToken
+	}
+	successor, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(successor), nil
+}
+
+func successorCipher(token string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("refresh-successor\x00" + token))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SessionOf returns the session a refresh token belongs to, used or not
+func (s *Service) SessionOf(token string) (*models.Session, error) {
+	var current models.RefreshToken
+	if err := s.db.Preload("Session").Where("token_hash = ?", hashToken(token)).First(&current).Error; err != nil {
+		return nil, err
+	}
+	if current.Session == nil {
+		return nil, ErrSessionNotFound
+	}
+	return current.Session, nil
+}
+
+// EndSession revokes the session of a refresh token, on logout
+func (s *Service) EndSession(token string) error {
+	session, err := s.SessionOf(token)
+	if err != nil {
+		return err
+	}
+	return revokeSessions(s.db, session.UserID, session.ID)
+}
+
+// ListSessions returns a user's active sessions, most recently used first
+func (s *Service) ListSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND last_used_at > ?", userID, time.Now().Add(-RefreshTokenTTL)).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession signs a user out of one of their sessions
+func (s *Service) RevokeSession(userID, sessionID uint) error {
+	result := s.db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return s.db.Where("session_id = ?", sessionID).Delete(&models.RefreshToken{}).Error
+}
+
+// DeleteUserRefreshTokens signs a user out everywhere
+func (s *Service) DeleteUserRefreshTokens(userID uint) error {
+	return revokeSessions(s.db, userID, 0)
+}
+
+// revokeSessions revokes one of a user's sessions, or all of them if
+// sessionID is zero, and deletes their refresh tokens
+func revokeSessions(db *gorm.DB, userID, sessionID uint) error {
+	sessions := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	tokens := db.Where("user_id = ?", userID)
+	if sessionID != 0 {
+		sessions = sessions.Where("id = ?", sessionID)
+		tokens = tokens.Where("session_id = ?", sessionID)
+	}
+	if err := sessions.Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return tokens.Delete(&models.RefreshToken{}).Error
+}
+
+// issueRefreshToken stores a new refresh token for a session and returns it
+func issueRefreshToken(tx *gorm.DB, session *models.Session) (string, error) {
+	token, err := GenerateRefreshTokenString()
+	if err != nil {
+		return "", err
+	}
+	err = tx.Create(&models.RefreshToken{
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}).Error
+	return token, err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
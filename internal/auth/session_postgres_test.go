@@ -0,0 +1,69 @@
+//go:build postgres
+
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"chess-app/internal/config"
+	"chess-app/internal/models"
+)
+
+func startTestSession(t *testing.T) (*Service, string) {
+	t.Helper()
+	db := testDB(t)
+	s := NewService(db, nil, &config.Config{JWTSecret: "test-secret"})
+	user := createUser(t, db, models.User{Username: "alice", Email: "alice@example.com"})
+	token, err := s.StartSession(user.ID, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, token
+}
+
+func TestRotateRefreshTokenGracePeriod(t *testing.T) {
+	s, first := startTestSession(t)
+
+	_, second, err := s.RotateRefreshToken(first, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second tab refreshing with the same token gets the same successor
+	_, again, err := s.RotateRefreshToken(first, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("replay within the grace period: %v", err)
+	}
+	if again != second {
+		t.Error("replay got a different token")
+	}
+
+	// Once the successor has been used, the old token is no longer the one
+	// just before the current one
+	if _, _, err := s.RotateRefreshToken(second, "test", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RotateRefreshToken(first, "test", "127.0.0.1"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("got %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := s.RotateRefreshToken(second, "test", "127.0.0.1"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("session not revoked: got %v", err)
+	}
+}
+
+func TestRotateRefreshTokenAfterGracePeriod(t *testing.T) {
+	s, first := startTestSession(t)
+	if _, _, err := s.RotateRefreshToken(first, "test", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	usedAt := time.Now().Add(-RefreshGracePeriod - time.Second)
+	if err := s.db.Model(&models.RefreshToken{}).Where("token_hash = ?", hashToken(first)).
+		Update("used_at", usedAt).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RotateRefreshToken(first, "test", "127.0.0.1"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("got %v, want ErrRefreshTokenReused", err)
+	}
+}
@@ -0,0 +1,21 @@
+package auth
+
+import "testing"
+
+func TestSealSuccessor(t *testing.T) {
+	sealed, err := sealSuccessor("old-token", "new-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := openSuccessor("old-token", sealed)
+	if err != nil || got != "new-token" {
+		t.Fatalf("opened %q, %v", got, err)
+	}
+	// Only the holder of the previous token can get the successor back
+	if _, err := openSuccessor("other-token", sealed); err == nil {
+		t.Error("opened with another token")
+	}
+	if _, err := openSuccessor("old-token", "AAAA"); err == nil {
+		t.Error("opened a truncated value")
+	}
+}
@@ -0,0 +1,114 @@
+package avatar
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Room left for the rest of a multipart body around the image
+const multipartOverhead = 64 << 10
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Upload replaces the current user's avatar with the image sent in the
+// "avatar" field of a multipart form: PUT /api/auth/avatar
+func (h *Handler) Upload(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxUploadSize+multipartOverhead)
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrTooLarge.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send the image in the \"avatar\" field of a multipart form"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	url, err := h.service.Upload(c.Request.Context(), c.MustGet("userID").(uint), file)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrUnsupportedType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrInvalidImage), errors.Is(err, ErrDimensions):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "avatar updated", "avatarUrl": url})
+}
+
+// Remove deletes the current user's avatar: DELETE /api/auth/avatar
+func (h *Handler) Remove(c *gin.Context) {
+	if err := h.service.Remove(c.Request.Context(), c.MustGet("userID").(uint)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "avatar removed"})
+}
+
+// Serve sends an avatar as PNG, 256 pixels wide unless ?size=64 asks for
+// the small one: GET /api/avatars/:id. An avatar never changes once
+// uploaded, so it may be cached for good.
+func (h *Handler) Serve(c *gin.Context) {
+	size := DefaultSize
+	if v := c.Query("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || !validSize(n) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be one of %v", Sizes)})
+			return
+		}
+		size = n
+	}
+
+	id := c.Param("id")
+	etag := fmt.Sprintf("\"%s-%d\"", id, size)
+	if validID(id) && c.GetHeader("If-None-Match") == etag {
+		c.Header("ETag", etag)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	data, err := h.service.Open(c.Request.Context(), id, size)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load avatar"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", etag)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'")
+	c.Data(http.StatusOK, "image/png", data)
+}
@@ -0,0 +1,113 @@
+package avatar
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/png"
+	"net/http"
+
+	// Formats accepted for uploads
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// Content types accepted for uploads, as sniffed from their first bytes
+var accepted = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// decode checks an uploaded file and decodes it. The dimensions are read
+// from the header before the pixels are decoded.
+func decode(data []byte) (image.Image, error) {
+	if len(data) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+	// Trust the bytes rather than the file name or the client's content type
+	if !accepted[http.DetectContentType(data)] {
+		return nil, ErrUnsupportedType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return nil, ErrDimensions
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil || img.Bounds().Empty() {
+		return nil, ErrInvalidImage
+	}
+	return img, nil
+}
+
+// squareCrop returns the largest centred square of an image
+func squareCrop(img image.Image) image.Rectangle {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// resize scales a square region of an image to size x size pixels. Each
+// pixel is the average of the source pixels it covers, which keeps
+// downscaled images smooth.
+func resize(img image.Image, region image.Rectangle, size int) *image.RGBA {
+	// Premultiplied alpha, so that transparent pixels do not darken their
+	// neighbours
+	src := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+	draw.Draw(src, src.Bounds(), img, region.Min, draw.Src)
+
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, side, size)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, side, size)
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels covered by destination pixel i, at least
+// one when upscaling
+func span(i, side, size int) (int, int) {
+	start := i * side / size
+	end := (i + 1) * side / size
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,210 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{255, 0, 0, 255}
+	blue = color.RGBA{0, 0, 255, 255}
+)
+
+// filled returns an image of the given bounds painted by fill
+func filled(bounds image.Rectangle, fill func(x, y int) color.RGBA) *image.RGBA {
+	img := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.SetRGBA(x, y, fill(x, y))
+		}
+	}
+	return img
+}
+
+func encode(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader returns the start of a PNG file that claims the given
+// dimensions, without the pixels to go with them
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8-bit RGBA
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestDecodeAcceptsFormats(t *testing.T) {
+	img := filled(image.Rect(0, 0, 40, 30), func(x, y int) color.RGBA { return red })
+	for _, format := range []string{"png", "jpeg", "gif"} {
+		decoded, err := decode(encode(t, img, format))
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if decoded.Bounds().Dx() != 40 || decoded.Bounds().Dy() != 30 {
+			t.Errorf("%s: decoded as %v", format, decoded.Bounds())
+		}
+	}
+}
+
+func TestDecodeSniffsContent(t *testing.T) {
+	webp := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), make([]byte, 16)...)
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), ErrUnsupportedType},
+		{"html", []byte("<html><body>hello</body></html>"), ErrUnsupportedType},
+		{"bmp", append([]byte("BM"), make([]byte, 64)...), ErrUnsupportedType},
+		{"webp", webp, ErrUnsupportedType},
+		{"empty", nil, ErrUnsupportedType},
+		{"truncated png", pngHeader(10, 10), ErrInvalidImage},
+		{"corrupt png", append([]byte("\x89PNG\r\n\x1a\n"), "garbage"...), ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		if _, err := decode(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	// A few bytes may claim a huge image; it is refused before decoding
+	for _, header := range [][]byte{pngHeader(MaxDimension+1, 1), pngHeader(1, MaxDimension+1), pngHeader(100000, 100000)} {
+		if _, err := decode(header); !errors.Is(err, ErrDimensions) {
+			t.Errorf("got %v, want ErrDimensions", err)
+		}
+	}
+
+	wide := encode(t, image.NewRGBA(image.Rect(0, 0, MaxDimension, 1)), "png")
+	if _, err := decode(wide); err != nil {
+		t.Errorf("%d pixels wide: %v", MaxDimension, err)
+	}
+
+	large := append(pngHeader(10, 10), make([]byte, MaxUploadSize)...)
+	if _, err := decode(large); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestSquareCrop(t *testing.T) {
+	tests := []struct {
+		bounds, want image.Rectangle
+	}{
+		{image.Rect(0, 0, 300, 100), image.Rect(100, 0, 200, 100)},
+		{image.Rect(0, 0, 100, 300), image.Rect(0, 100, 100, 200)},
+		{image.Rect(0, 0, 64, 64), image.Rect(0, 0, 64, 64)},
+		{image.Rect(10, 20, 110, 70), image.Rect(35, 20, 85, 70)},
+		{image.Rect(0, 0, 101, 100), image.Rect(0, 0, 100, 100)},
+	}
+	for _, tt := range tests {
+		if got := squareCrop(image.NewRGBA(tt.bounds)); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.bounds, got, tt.want)
+		}
+	}
+}
+
+func TestResizeKeepsCentre(t *testing.T) {
+	// Red on the left half, blue on the right: the centred square keeps both
+	img := filled(image.Rect(0, 0, 512, 256), func(x, y int) color.RGBA {
+		if x < 256 {
+			return red
+		}
+		return blue
+	})
+	for _, size := range Sizes {
+		dst := resize(img, squareCrop(img), size)
+		if dst.Bounds() != image.Rect(0, 0, size, size) {
+			t.Fatalf("resized to %v", dst.Bounds())
+		}
+		if got := dst.RGBAAt(0, size/2); got != red {
+			t.Errorf("%d: left edge %v", size, got)
+		}
+		if got := dst.RGBAAt(size/2-1, 0); got != red {
+			t.Errorf("%d: left of centre %v", size, got)
+		}
+		if got := dst.RGBAAt(size/2, size-1); got != blue {
+			t.Errorf("%d: right of centre %v", size, got)
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	// Downscaling a fine checkerboard gives grey rather than either colour
+	board := filled(image.Rect(0, 0, 128, 128), func(x, y int) color.RGBA {
+		if (x+y)%2 == 0 {
+			return color.RGBA{255, 255, 255, 255}
+		}
+		return color.RGBA{0, 0, 0, 255}
+	})
+	dst := resize(board, board.Bounds(), 64)
+	if got := dst.RGBAAt(10, 10); got != (color.RGBA{127, 127, 127, 255}) {
+		t.Errorf("checkerboard averaged to %v", got)
+	}
+
+	// Transparent pixels do not darken their neighbours
+	half := filled(image.Rect(0, 0, 2, 2), func(x, y int) color.RGBA {
+		if x == 0 {
+			return color.RGBA{}
+		}
+		return red
+	})
+	got := resize(half, half.Bounds(), 1).RGBAAt(0, 0)
+	if got.A != 127 || got.R != got.A || got.G != 0 || got.B != 0 {
+		t.Errorf("half transparent red averaged to %v", got)
+	}
+}
+
+func TestResizeUpscales(t *testing.T) {
+	small := filled(image.Rect(0, 0, 3, 3), func(x, y int) color.RGBA {
+		return color.RGBA{uint8(x * 100), uint8(y * 100), 0, 255}
+	})
+	dst := resize(small, small.Bounds(), 64)
+	if got := dst.RGBAAt(0, 0); got != small.RGBAAt(0, 0) {
+		t.Errorf("top left %v", got)
+	}
+	if got := dst.RGBAAt(63, 63); got != small.RGBAAt(2, 2) {
+		t.Errorf("bottom right %v", got)
+	}
+
+	data, err := encodePNG(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "png" || config.Width != 64 || config.Height != 64 {
+		t.Errorf("encoded as %s %dx%d: %v", format, config.Width, config.Height, err)
+	}
+}
@@ -0,0 +1,187 @@
+package avatar
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxUploadSize is the largest image accepted, in bytes
+	MaxUploadSize = 4 << 20
+	// MaxDimension is the largest width or height accepted, in pixels, so
+	// that small files cannot decode to huge images
+	MaxDimension = 4096
+	// DefaultSize is the size served when none is asked for
+	DefaultSize = 256
+	// URLPrefix starts the URL of every uploaded avatar
+	URLPrefix = "/api/avatars/"
+)
+
+// Sizes avatars are stored in, in pixels
+var Sizes = []int{256, 64}
+
+var (
+	ErrTooLarge        = fmt.Errorf("avatars must be at most %d MB", MaxUploadSize>>20)
+	ErrUnsupportedType = errors.New("avatars must be PNG, JPEG or GIF images")
+	ErrInvalidImage    = errors.New("the image could not be read")
+	ErrDimensions      = fmt.Errorf("avatars must be at most %dx%d pixels", MaxDimension, MaxDimension)
+	ErrInvalidSize     = errors.New("unknown avatar size")
+	ErrUserNotFound    = errors.New("user not found")
+)
+
+type Service struct {
+	db      *gorm.DB
+	storage Storage
+}
+
+func NewService(db *gorm.DB, storage Storage) *Service {
+	return &Service{db: db, storage: storage}
+}
+
+// Upload replaces a user's avatar with an image read from r. The image is
+// cropped to a square and re-encoded as PNG in every size, which also drops
+// its metadata. The avatar gets a new ID, so that cached copies of the old
+// one are never served in its place.
+func (s *Service) Upload(ctx context.Context, userID uint, r io.Reader) (string, error) {
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return "", err
+	}
+	img, err := decode(data)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	region := squareCrop(img)
+	for _, size := range Sizes {
+		encoded, err := encodePNG(resize(img, region, size))
+		if err != nil {
+			return "", err
+		}
+		if err := s.storage.Put(ctx, key(id, size), encoded, "image/png"); err != nil {
+			s.deleteFiles(ctx, id)
+			return "", err
+		}
+	}
+
+	url := URLPrefix + id
+	if err := s.db.Model(&user).Update("avatar_url", url).Error; err != nil {
+		s.deleteFiles(ctx, id)
+		return "", err
+	}
+	if old, ok := parseURL(user.AvatarURL); ok {
+		s.deleteFiles(ctx, old)
+	}
+	return url, nil
+}
+
+// Remove deletes a user's avatar, if they have one
+func (s *Service) Remove(ctx context.Context, userID uint) error {
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if user.AvatarURL == "" {
+		return nil
+	}
+	if err := s.db.Model(&user).Update("avatar_url", "").Error; err != nil {
+		return err
+	}
+	if id, ok := parseURL(user.AvatarURL); ok {
+		s.deleteFiles(ctx, id)
+	}
+	return nil
+}
+
+// Open returns an avatar as PNG in one of the stored sizes
+func (s *Service) Open(ctx context.Context, id string, size int) ([]byte, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	if !validSize(size) {
+		return nil, ErrInvalidSize
+	}
+	return s.storage.Get(ctx, key(id, size))
+}
+
+// ClearExternalURLs removes the avatars users set as links to other sites,
+// before uploads replaced them. It returns how many were removed.
+func (s *Service) ClearExternalURLs() (int64, error) {
+	result := s.db.Model(&models.User{}).
+		Where("avatar_url <> '' AND avatar_url NOT LIKE ?", URLPrefix+"%").
+		Update("avatar_url", "")
+	return result.RowsAffected, result.Error
+}
+
+// deleteFiles deletes every size of an avatar. Failures only leave unused
+// files behind, so they are logged.
+func (s *Service) deleteFiles(ctx context.Context, id string) {
+	for _, size := range Sizes {
+		if err := s.storage.Delete(ctx, key(id, size)); err != nil {
+			log.Printf("avatar: failed to delete %s: %v", key(id, size), err)
+		}
+	}
+}
+
+func key(id string, size int) string {
+	return fmt.Sprintf("%s-%d.png", id, size)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseURL returns the ID of an uploaded avatar from its URL
+func parseURL(url string) (string, bool) {
+	id := strings.TrimPrefix(url, URLPrefix)
+	if id == url || !validID(id) {
+		return "", false
+	}
+	return id, true
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
+
+func validSize(size int) bool {
+	for _, s := range Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
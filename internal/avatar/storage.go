@@ -0,0 +1,22 @@
+// Package avatar handles profile pictures: uploaded images are checked,
+// cropped and re-encoded to fixed sizes, then kept in a Storage and served
+// under /api/avatars/:id. Users cannot point their avatar at other sites.
+package avatar
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by storages for keys they do not hold
+var ErrNotFound = errors.New("avatar not found")
+
+// Storage keeps avatar images by key. Keys are made of lowercase letters,
+// digits, dashes and dots.
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns ErrNotFound for unknown keys
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete succeeds for unknown keys
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"errors"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotBot       = errors.New("this endpoint is only for bot accounts")
+	ErrAlreadyBot   = errors.New("this account is already a bot account")
+	ErrHasPlayed    = errors.New("only accounts that have not played any game can become bot accounts")
+	ErrUnverified   = errors.New("verify your email before upgrading to a bot account")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// API backs the Lichess-style bot API. Bot accounts are user accounts
+// played by a program through a personal access token; their events are
+// published on their notification streams, where the event stream picks
+// them up.
+type API struct {
+	service *game.Service
+	hub     *game.Hub
+}
+
+// NewAPI creates the bot API. Its listeners must be registered with the
+// game service for event streams to receive anything.
+func NewAPI(service *game.Service, hub *game.Hub) *API {
+	return &API{service: service, hub: hub}
+}
+
+// Upgrade turns an account that has never played into a bot account, for
+// good
+func (a *API) Upgrade(userID uint) (*models.User, error) {
+	user, err := a.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsBot {
+		return nil, ErrAlreadyBot
+	}
+	// Bots play rated games, which unverified accounts may not
+	if !user.EmailVerified {
+		return nil, ErrUnverified
+	}
+
+	var games int64
+	if err := a.service.GetDB().Model(&models.Game{}).
+		Where("white_player_id = ? OR black_player_id = ?", userID, userID).
+		Count(&games).Error; err != nil {
+		return nil, err
+	}
+	if games > 0 {
+		return nil, ErrHasPlayed
+	}
+
+	if err := a.service.GetDB().Model(user).Update("is_bot", true).Error; err != nil {
+		return nil, err
+	}
+	user.IsBot = true
+	return user, nil
+}
+
+// Bot returns a bot account, or ErrNotBot for other accounts
+func (a *API) Bot(userID uint) (*models.User, error) {
+	user, err := a.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsBot {
+		return nil, ErrNotBot
+	}
+	return user, nil
+}
+
+// Playing returns the games a user is playing, seen from their side
+func (a *API) Playing(userID uint) ([]*GameInfo, error) {
+	var games []models.Game
+	if err := a.service.GetDB().Preload("WhitePlayer").Preload("BlackPlayer").
+		Where("status = ? AND (white_player_id = ? OR black_player_id = ?)", models.GameStatusActive, userID, userID).
+		Order("updated_at DESC").
+		Find(&games).Error; err != nil {
+		return nil, err
+	}
+
+	infos := make([]*GameInfo, 0, len(games))
+	for i := range games {
+		lastMove, err := a.lastMove(games[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, newGameInfo(&games[i], userID, lastMove))
+	}
+	return infos, nil
+}
+
+// PendingEvents returns what an event stream starts with: a gameStart for
+// each game in progress and a challenge for each one waiting for an answer
+func (a *API) PendingEvents(userID uint) ([]*Event, error) {
+	playing, err := a.Playing(userID)
+	if err != nil {
+		return nil, err
+	}
+	var events []*Event
+	for _, info := range playing {
+		events = append(events, &Event{Type: EventGameStart, Game: info})
+	}
+
+	var challenges []models.Game
+	if err := a.service.GetDB().Preload("WhitePlayer").
+		Where("status = ? AND invited_player_id = ?", models.GameStatusWaiting, userID).
+		Order("created_at").
+		Find(&challenges).Error; err != nil {
+		return nil, err
+	}
+	if len(challenges) > 0 {
+		dest, err := a.user(userID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range challenges {
+			events = append(events, &Event{
+				Type:      EventChallenge,
+				Challenge: newChallengeInfo(&challenges[i], challenges[i].WhitePlayer, dest),
+			})
+		}
+	}
+	return events, nil
+}
+
+// GameFull returns the opening message of a game stream. Only the game's
+// players may follow it.
+func (a *API) GameFull(gameID, userID uint) (*GameFull, error) {
+	g, err := a.service.GetGame(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if !isPlayer(g, userID) {
+		return nil, game.ErrNotInGame
+	}
+	moves, err := a.service.GetGameHistory(gameID)
+	if err != nil {
+		return nil, err
+	}
+	return newGameFull(g, moves), nil
+}
+
+// GameState returns the current state of a game
+func (a *API) GameState(gameID uint) (*GameState, error) {
+	var g models.Game
+	if err := a.service.GetDB().First(&g, gameID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, game.ErrGameNotFound
+		}
+		return nil, err
+	}
+	moves, err := a.service.GetGameHistory(gameID)
+	if err != nil {
+		return nil, err
+	}
+	return newGameState(&g, moves), nil
+}
+
+// OnChallenge tells a challenged bot account about the challenge. It is
+// registered as a game.Service listener.
+func (a *API) OnChallenge(g *models.Game) {
+	if g.InvitedPlayerID == nil || g.WhitePlayerID == nil {
+		return
+	}
+	dest, err := a.user(*g.InvitedPlayerID)
+	if err != nil || !dest.IsBot {
+		return
+	}
+	challenger, err := a.user(*g.WhitePlayerID)
+	if err != nil {
+		return
+	}
+	a.hub.SendToUser(dest.ID, &Event{Type: EventChallenge, Challenge: newChallengeInfo(g, challenger, dest)})
+}
+
+// OnGameStarted sends a gameStart event to the bot accounts playing a
+// game. It is registered as a game.Service listener.
+func (a *API) OnGameStarted(g *models.Game) {
+	a.sendGameEvent(EventGameStart, g)
+}
+
+// OnGameFinished sends a gameFinish event to the bot accounts that played
+// a game. It is registered as a game.Service listener.
+func (a *API) OnGameFinished(g *models.Game) {
+	a.sendGameEvent(EventGameFinish, g)
+}
+
+func (a *API) sendGameEvent(kind string, g *models.Game) {
+	lastMove, loaded := "", false
+	for _, player := range []*models.User{g.WhitePlayer, g.BlackPlayer} {
+		if player == nil || !player.IsBot {
+			continue
+		}
+		if !loaded {
+			var err error
+			if lastMove, err = a.lastMove(g.ID); err != nil {
+				return
+			}
+			loaded = true
+		}
+		a.hub.SendToUser(player.ID, &Event{Type: kind, Game: newGameInfo(g, player.ID, lastMove)})
+	}
+}
+
+func (a *API) user(userID uint) (*models.User, error) {
+	var user models.User
+	if err := a.service.GetDB().First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// lastMove returns the last move of a game in UCI notation, empty if none
+func (a *API) lastMove(gameID uint) (string, error) {
+	var moves []models.Move
+	err := a.service.GetDB().Where("game_id = ?", gameID).
+		Order("ply_number DESC").Limit(1).Find(&moves).Error
+	if err != nil || len(moves) == 0 {
+		return "", err
+	}
+	return moves[0].MoveNotation, nil
+}
+
+func isPlayer(g *models.Game, userID uint) bool {
+	return (g.WhitePlayerID != nil && *g.WhitePlayerID == userID) ||
+		(g.BlackPlayerID != nil && *g.BlackPlayerID == userID)
+}
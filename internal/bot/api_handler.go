@@ -0,0 +1,325 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"chess-app/internal/game"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Streams send an empty line this often, so that clients can tell a quiet
+// stream from a dead one
+const streamKeepAlive = 6 * time.Second
+
+// APIHandler serves the Lichess-style bot API
+type APIHandler struct {
+	api *API
+	hub *game.Hub
+}
+
+func NewAPIHandler(api *API, hub *game.Hub) *APIHandler {
+	return &APIHandler{api: api, hub: hub}
+}
+
+// Account returns the current account as Lichess describes it, with the BOT
+// title for bot accounts: GET /api/account
+func (h *APIHandler) Account(c *gin.Context) {
+	user, err := h.api.user(c.MustGet("userID").(uint))
+	if err != nil {
+		respondAPIError(c, err)
+		return
+	}
+
+	info := newPlayerInfo(user)
+	c.JSON(http.StatusOK, gin.H{
+		"id":        info.ID,
+		"username":  info.Name,
+		"title":     info.Title,
+		"createdAt": user.CreatedAt.UnixMilli(),
+		"perfs": gin.H{
+			"classical": gin.H{"rating": user.ELORating},
+			"rapid":     gin.H{"rating": user.ELORating},
+			"blitz":     gin.H{"rating": user.ELORating},
+			"bullet":    gin.H{"rating": user.ELORating},
+		},
+	})
+}
+
+// Playing lists the games in progress: GET /api/account/playing
+func (h *APIHandler) Playing(c *gin.Context) {
+	games, err := h.api.Playing(c.MustGet("userID").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"nowPlaying": games})
+}
+
+// Upgrade turns the current account into a bot account:
+// POST /api/bot/account/upgrade
+func (h *APIHandler) Upgrade(c *gin.Context) {
+	if _, err := h.api.Upgrade(c.MustGet("userID").(uint)); err != nil {
+		respondAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// StreamEvents streams the bot's games starting and finishing and the
+// challenges it receives, as NDJSON: GET /api/bot/stream/event. It starts
+// with the games in progress and the pending challenges.
+func (h *APIHandler) StreamEvents(c *gin.Context) {
+	userID, ok := h.bot(c)
+	if !ok {
+		return
+	}
+
+	// Subscribe first so that nothing is missed between the pending events
+	// and the live ones
+	client := h.hub.Subscribe(0, 0, userID)
+	defer h.hub.Unsubscribe(client)
+
+	pending, err := h.api.PendingEvents(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	startStream(c)
+	for _, event := range pending {
+		if !writeLine(c, event) {
+			return
+		}
+	}
+
+	h.follow(c, client, func(message interface{}) bool {
+		event, ok := decodeEvent(message)
+		if !ok {
+			return true // A notification for the user's other streams
+		}
+		return writeLine(c, event)
+	})
+}
+
+// StreamGame streams a game the bot plays as NDJSON: the full game, then
+// its state after each change, until it ends: GET /api/bot/game/stream/:id
+func (h *APIHandler) StreamGame(c *gin.Context) {
+	userID, ok := h.bot(c)
+	if !ok {
+		return
+	}
+	gameID, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid game ID"})
+		return
+	}
+
+	full, err := h.api.GameFull(gameID, userID)
+	if err != nil {
+		respondAPIError(c, err)
+		return
+	}
+
+	client := h.hub.Subscribe(gameID, 0, userID)
+	defer h.hub.Unsubscribe(client)
+
+	startStream(c)
+	if !writeLine(c, full) {
+		return
+	}
+
+	// Catch up with changes made before the subscription, then follow
+	last := full.State
+	update := func() bool {
+		state, err := h.api.GameState(gameID)
+		if err != nil {
+			return false
+		}
+		if *state != *last {
+			if !writeLine(c, state) {
+				return false
+			}
+			last = state
+		}
+		return last.Status == StatusStarted || last.Status == StatusCreated
+	}
+	if !update() {
+		return
+	}
+	h.follow(c, client, func(interface{}) bool { return update() })
+}
+
+// Move plays a move in UCI notation: POST /api/bot/game/:id/move/:uci
+func (h *APIHandler) Move(c *gin.Context) {
+	userID, ok := h.bot(c)
+	if !ok {
+		return
+	}
+	gameID, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid game ID"})
+		return
+	}
+
+	if _, err := h.api.service.MakeMove(gameID, userID, c.Param("uci")); err != nil {
+		respondAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// Resign resigns a game in progress: POST /api/bot/game/:id/resign
+func (h *APIHandler) Resign(c *gin.Context) {
+	userID, ok := h.bot(c)
+	if !ok {
+		return
+	}
+	gameID, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid game ID"})
+		return
+	}
+
+	if _, err := h.api.service.Resign(gameID, userID); err != nil {
+		respondAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// AcceptChallenge accepts a challenge: POST /api/challenge/:id/accept
+func (h *APIHandler) AcceptChallenge(c *gin.Context) {
+	gameID, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid challenge ID"})
+		return
+	}
+
+	if _, err := h.api.service.AcceptChallenge(gameID, c.MustGet("userID").(uint)); err != nil {
+		respondAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// DeclineChallenge declines a challenge: POST /api/challenge/:id/decline
+func (h *APIHandler) DeclineChallenge(c *gin.Context) {
+	gameID, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid challenge ID"})
+		return
+	}
+
+	if err := h.api.service.DeclineChallenge(gameID, c.MustGet("userID").(uint)); err != nil {
+		respondAPIError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// bot returns the current user if it is a bot account. It writes the error
+// response itself.
+func (h *APIHandler) bot(c *gin.Context) (uint, bool) {
+	user, err := h.api.Bot(c.MustGet("userID").(uint))
+	if err != nil {
+		respondAPIError(c, err)
+		return 0, false
+	}
+	return user.ID, true
+}
+
+// follow hands the client's messages to handle, with keepalives in
+// between, until handle returns false or the stream ends
+func (h *APIHandler) follow(c *gin.Context, client *game.Client, handle func(message interface{}) bool) {
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-client.Done():
+			return
+		case message := <-client.Send:
+			if !handle(message) {
+				return
+			}
+		case <-ticker.C:
+			if _, err := c.Writer.Write([]byte("\n")); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+func startStream(c *gin.Context) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Keep proxies from buffering the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+}
+
+// writeLine writes a value as one line of JSON
+func writeLine(c *gin.Context, v interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	if _, err := c.Writer.Write(append(data, '\n')); err != nil {
+		return false
+	}
+	c.Writer.Flush()
+	return true
+}
+
+// decodeEvent reads a message from a user's notification stream back as a
+// bot event. Messages come as *Event from this instance and as raw JSON
+// from others.
+func decodeEvent(message interface{}) (*Event, bool) {
+	if event, ok := message.(*Event); ok {
+		return event, true
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, false
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, false
+	}
+	switch event.Type {
+	case EventGameStart, EventGameFinish:
+		return &event, event.Game != nil
+	case EventChallenge, EventChallengeCanceled, EventChallengeDeclined:
+		return &event, event.Challenge != nil
+	}
+	return nil, false
+}
+
+func respondAPIError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, game.ErrGameNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotBot), errors.Is(err, ErrUnverified), errors.Is(err, game.ErrNotInGame),
+		errors.Is(err, game.ErrNotInvited), errors.Is(err, game.ErrBlocked):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAlreadyBot), errors.Is(err, ErrHasPlayed), errors.Is(err, game.ErrNotYourTurn),
+		errors.Is(err, game.ErrInvalidMove), errors.Is(err, game.ErrIllegalMove), errors.Is(err, game.ErrGameFinished),
+		errors.Is(err, game.ErrGameNotActive), errors.Is(err, game.ErrGameNotWaiting):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	player *Player
+}
+
+func NewHandler(player *Player) *Handler {
+	return &Handler{player: player}
+}
+
+type ChallengeRequest struct {
+	Level       int    `json:"level"`       // 1-8 (default: 3)
+	Color       string `json:"color"`       // Color for the challenger: "white", "black" or "random"
+	TimeControl int    `json:"timeControl"` // Time in seconds per player (default: 600 = 10 minutes)
+	Increment   int    `json:"increment"`   // Seconds added after each move (default: 0)
+}
+
+// Challenge starts a game against the bot
+func (h *Handler) Challenge(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req ChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	game, err := h.player.Challenge(userID, req.Level, req.Color, req.TimeControl, req.Increment)
+	if err != nil {
+		switch err {
+		case ErrInvalidLevel, ErrInvalidColor:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, game)
+}
+
+// GetLevels lists the available bot strength levels
+func (h *Handler) GetLevels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"botUserId": h.player.UserID(),
+		"levels":    Levels(),
+	})
+}
@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidLevel = errors.New("invalid bot level")
+
+// Level describes how strongly the bot plays
+type Level struct {
+	Level    int           `json:"level"`
+	Depth    int           `json:"depth"`
+	MoveTime time.Duration `json:"-"`
+	Rating   int           `json:"rating"` // Approximate playing strength, used to pick a level for matchmaking
+}
+
+const (
+	MinLevel     = 1
+	MaxLevel     = 8
+	DefaultLevel = 3
+)
+
+// levels is indexed by level number; index 0 is unused
+var levels = []Level{
+	{},
+	{Level: 1, Depth: 1, MoveTime: 100 * time.Millisecond, Rating: 800},
+	{Level: 2, Depth: 2, MoveTime: 200 * time.Millisecond, Rating: 1000},
+	{Level: 3, Depth: 3, MoveTime: 300 * time.Millisecond, Rating: 1200},
+	{Level: 4, Depth: 4, MoveTime: 500 * time.Millisecond, Rating: 1400},
+	{Level: 5, Depth: 5, MoveTime: 1 * time.Second, Rating: 1600},
+	{Level: 6, Depth: 6, MoveTime: 2 * time.Second, Rating: 1800},
+	{Level: 7, Depth: 8, MoveTime: 3 * time.Second, Rating: 2000},
+	{Level: 8, Depth: 12, MoveTime: 5 * time.Second, Rating: 2200},
+}
+
+// GetLevel returns the settings for a level number
+func GetLevel(level int) (Level, error) {
+	if level < MinLevel || level > MaxLevel {
+		return Level{}, ErrInvalidLevel
+	}
+	return levels[level], nil
+}
+
+// Levels returns all available levels, weakest first
+func Levels() []Level {
+	return append([]Level(nil), levels[MinLevel:]...)
+}
+
+// LevelForRating returns the level whose strength is closest to a rating
+func LevelForRating(rating int) int {
+	best := MinLevel
+	for _, l := range levels[MinLevel:] {
+		if abs(l.Rating-rating) < abs(levels[best].Rating-rating) {
+			best = l.Level
+		}
+	}
+	return best
+}
+
+// Limits returns the search limits for the level
+func (l Level) Limits() Limits {
+	return Limits{Depth: l.Depth, MoveTime: l.MoveTime}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,348 @@
+package bot
+
+import (
+	"strconv"
+	"strings"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"github.com/notnil/chess"
+)
+
+// Shapes of the Lichess Bot API (https://lichess.org/api#tag/Bot), followed
+// closely enough for bot clients such as lichess-bot to work against this
+// server. Users and games are identified by strings, as on Lichess: the
+// lowercased username and the game ID in decimal.
+
+// Event types of the event stream
+const (
+	EventGameStart         = "gameStart"
+	EventGameFinish        = "gameFinish"
+	EventChallenge         = "challenge"
+	EventChallengeCanceled = "challengeCanceled"
+	EventChallengeDeclined = "challengeDeclined"
+)
+
+// Message types of the game stream
+const (
+	MsgGameFull  = "gameFull"
+	MsgGameState = "gameState"
+)
+
+// Game statuses, named as on Lichess
+const (
+	StatusCreated   = "created"
+	StatusStarted   = "started"
+	StatusAborted   = "aborted"
+	StatusMate      = "mate"
+	StatusResign    = "resign"
+	StatusStalemate = "stalemate"
+	StatusDraw      = "draw"
+)
+
+// Title of bot accounts
+const botTitle = "BOT"
+
+// Variant is always standard chess here
+type Variant struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Short string `json:"short"`
+}
+
+var standard = Variant{Key: "standard", Name: "Standard", Short: "Std"}
+
+// PlayerInfo describes a player of a game or challenge
+type PlayerInfo struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Title  string `json:"title,omitempty"`
+	Rating int    `json:"rating"`
+}
+
+// Opponent describes the other player in a gameStart event
+type Opponent struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+}
+
+// Perf names the rating category of a time control
+type Perf struct {
+	Name string `json:"name"`
+}
+
+// Clock is a game's time control in milliseconds
+type Clock struct {
+	Initial   int `json:"initial"`
+	Increment int `json:"increment"`
+}
+
+// TimeControl is a challenge's time control in seconds
+type TimeControl struct {
+	Type      string `json:"type"`
+	Limit     int    `json:"limit"`
+	Increment int    `json:"increment"`
+	Show      string `json:"show"`
+}
+
+// Compat tells which APIs can play a game
+type Compat struct {
+	Bot   bool `json:"bot"`
+	Board bool `json:"board"`
+}
+
+// GameInfo is the game of a gameStart or gameFinish event, seen by one of
+// its players
+type GameInfo struct {
+	ID          string   `json:"id"`
+	GameID      string   `json:"gameId"`
+	FullID      string   `json:"fullId"`
+	Color       string   `json:"color"`
+	FEN         string   `json:"fen"`
+	HasMoved    bool     `json:"hasMoved"`
+	IsMyTurn    bool     `json:"isMyTurn"`
+	LastMove    string   `json:"lastMove"`
+	Opponent    Opponent `json:"opponent"`
+	Perf        string   `json:"perf"`
+	Rated       bool     `json:"rated"`
+	SecondsLeft int      `json:"secondsLeft"`
+	Source      string   `json:"source"`
+	Speed       string   `json:"speed"`
+	Variant     Variant  `json:"variant"`
+	Winner      string   `json:"winner,omitempty"`
+	Compat      Compat   `json:"compat"`
+}
+
+// ChallengeInfo is a challenge waiting for an answer
+type ChallengeInfo struct {
+	ID          string      `json:"id"`
+	Status      string      `json:"status"`
+	Challenger  PlayerInfo  `json:"challenger"`
+	DestUser    PlayerInfo  `json:"destUser"`
+	Variant     Variant     `json:"variant"`
+	Rated       bool        `json:"rated"`
+	Speed       string      `json:"speed"`
+	TimeControl TimeControl `json:"timeControl"`
+	Color       string      `json:"color"`
+	FinalColor  string      `json:"finalColor"`
+	Perf        Perf        `json:"perf"`
+}
+
+// Event is a line of the event stream
+type Event struct {
+	Type      string         `json:"type"`
+	Game      *GameInfo      `json:"game,omitempty"`
+	Challenge *ChallengeInfo `json:"challenge,omitempty"`
+}
+
+// GameState is the changing part of a game: the moves in UCI notation,
+// separated by spaces, and the clocks in milliseconds
+type GameState struct {
+	Type   string `json:"type"`
+	Moves  string `json:"moves"`
+	WTime  int    `json:"wtime"`
+	BTime  int    `json:"btime"`
+	WInc   int    `json:"winc"`
+	BInc   int    `json:"binc"`
+	Status string `json:"status"`
+	Winner string `json:"winner,omitempty"`
+}
+
+// GameFull opens the game stream
+type GameFull struct {
+	Type       string     `json:"type"`
+	ID         string     `json:"id"`
+	Rated      bool       `json:"rated"`
+	Variant    Variant    `json:"variant"`
+	Clock      Clock      `json:"clock"`
+	Speed      string     `json:"speed"`
+	Perf       Perf       `json:"perf"`
+	CreatedAt  int64      `json:"createdAt"`
+	White      PlayerInfo `json:"white"`
+	Black      PlayerInfo `json:"black"`
+	InitialFen string     `json:"initialFen"`
+	State      *GameState `json:"state"`
+}
+
+func formatID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// parseID reads a game ID given as a string
+func parseID(s string) (uint, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint(id), err
+}
+
+func newPlayerInfo(user *models.User) PlayerInfo {
+	if user == nil {
+		return PlayerInfo{}
+	}
+	info := PlayerInfo{
+		ID:     strings.ToLower(user.Username),
+		Name:   user.Username,
+		Rating: user.ELORating,
+	}
+	if user.IsBot {
+		info.Title = botTitle
+	}
+	return info
+}
+
+// speedOf classifies a game's time control the way Lichess does, by the
+// estimated duration of a 40-move game
+func speedOf(g *models.Game) string {
+	estimate := g.TimeControl + 40*g.Increment
+	switch {
+	case estimate < 30:
+		return "ultraBullet"
+	case estimate < 180:
+		return "bullet"
+	case estimate < 480:
+		return "blitz"
+	case estimate < 1500:
+		return "rapid"
+	}
+	return "classical"
+}
+
+func perfOf(speed string) Perf {
+	if speed == "ultraBullet" {
+		return Perf{Name: "UltraBullet"}
+	}
+	return Perf{Name: strings.ToUpper(speed[:1]) + speed[1:]}
+}
+
+// statusOf returns a game's status and winner color, if any
+func statusOf(g *models.Game) (string, string) {
+	if g.Annulled {
+		return StatusAborted, ""
+	}
+	switch g.Status {
+	case models.GameStatusWaiting:
+		return StatusCreated, ""
+	case models.GameStatusActive:
+		return StatusStarted, ""
+	}
+
+	method := chess.NoMethod
+	if fen, err := chess.FEN(g.CurrentFEN); err == nil {
+		method = chess.NewGame(fen).Position().Status()
+	}
+	switch g.Result {
+	case models.GameResultWhiteWins, models.GameResultBlackWins:
+		winner := "white"
+		if g.Result == models.GameResultBlackWins {
+			winner = "black"
+		}
+		if method == chess.Checkmate {
+			return StatusMate, winner
+		}
+		return StatusResign, winner
+	case models.GameResultDraw:
+		if method == chess.Stalemate {
+			return StatusStalemate, ""
+		}
+		return StatusDraw, ""
+	}
+	return StatusAborted, ""
+}
+
+func newGameState(g *models.Game, moves []models.Move) *GameState {
+	ucis := make([]string, len(moves))
+	for i, move := range moves {
+		ucis[i] = move.MoveNotation
+	}
+	status, winner := statusOf(g)
+	return &GameState{
+		Type:   MsgGameState,
+		Moves:  strings.Join(ucis, " "),
+		WTime:  g.WhiteTimeLeft * 1000,
+		BTime:  g.BlackTimeLeft * 1000,
+		WInc:   g.Increment * 1000,
+		BInc:   g.Increment * 1000,
+		Status: status,
+		Winner: winner,
+	}
+}
+
+func newGameFull(g *models.Game, moves []models.Move) *GameFull {
+	speed := speedOf(g)
+	return &GameFull{
+		Type:       MsgGameFull,
+		ID:         formatID(g.ID),
+		Rated:      g.Rated,
+		Variant:    standard,
+		Clock:      Clock{Initial: g.TimeControl * 1000, Increment: g.Increment * 1000},
+		Speed:      speed,
+		Perf:       perfOf(speed),
+		CreatedAt:  g.CreatedAt.UnixMilli(),
+		White:      newPlayerInfo(g.WhitePlayer),
+		Black:      newPlayerInfo(g.BlackPlayer),
+		InitialFen: "startpos",
+		State:      newGameState(g, moves),
+	}
+}
+
+// newGameInfo describes a game to one of its players, whose players must
+// be loaded
+func newGameInfo(g *models.Game, userID uint, lastMove string) *GameInfo {
+	white := g.WhitePlayerID != nil && *g.WhitePlayerID == userID
+	color, opponent, secondsLeft := "black", g.WhitePlayer, g.BlackTimeLeft
+	if white {
+		color, opponent, secondsLeft = "white", g.BlackPlayer, g.WhiteTimeLeft
+	}
+
+	info := &GameInfo{
+		ID:          formatID(g.ID),
+		GameID:      formatID(g.ID),
+		FullID:      formatID(g.ID),
+		Color:       color,
+		FEN:         g.CurrentFEN,
+		HasMoved:    lastMove != "",
+		LastMove:    lastMove,
+		Rated:       g.Rated,
+		SecondsLeft: secondsLeft,
+		Source:      "friend",
+		Speed:       speedOf(g),
+		Variant:     standard,
+		Compat:      Compat{Bot: true, Board: true},
+	}
+	info.Perf = info.Speed
+	if opponent != nil {
+		player := newPlayerInfo(opponent)
+		info.Opponent = Opponent{ID: player.ID, Username: player.Name, Rating: player.Rating}
+	}
+	if fen, err := chess.FEN(g.CurrentFEN); err == nil && g.Status == models.GameStatusActive {
+		turn := chess.NewGame(fen).Position().Turn()
+		info.IsMyTurn = (turn == chess.White) == white
+	}
+	_, info.Winner = statusOf(g)
+	return info
+}
+
+// newChallengeInfo describes a challenge. The challenger always plays
+// white.
+func newChallengeInfo(g *models.Game, challenger, dest *models.User) *ChallengeInfo {
+	speed := speedOf(g)
+	return &ChallengeInfo{
+		ID:         formatID(g.ID),
+		Status:     StatusCreated,
+		Challenger: newPlayerInfo(challenger),
+		DestUser:   newPlayerInfo(dest),
+		Variant:    standard,
+		Rated:      game.CanPlayRated(challenger) && game.CanPlayRated(dest),
+		Speed:      speed,
+		TimeControl: TimeControl{
+			Type:      "clock",
+			Limit:     g.TimeControl,
+			Increment: g.Increment,
+			Show:      strconv.FormatFloat(float64(g.TimeControl)/60, 'f', -1, 64) + "+" + strconv.Itoa(g.Increment),
+		},
+		Color:      "white",
+		FinalColor: "white",
+		Perf:       perfOf(speed),
+	}
+}
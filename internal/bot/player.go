@@ -0,0 +1,278 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"github.com/notnil/chess"
+	"gorm.io/gorm"
+)
+
+const (
+	// Username is the name of the built-in bot account
+	Username = models.BotUsername
+	botEmail = "bot@chess-app.local"
+	// Starting rating of the bot account
+	botRating = 1500
+)
+
+var (
+	ErrInvalidColor = errors.New("color must be white, black or random")
+	ErrNameTaken    = errors.New("bot username or email belongs to a player account")
+)
+
+// EnsureUser returns the bot account, creating it on first start.
+// The account has no usable password, so nobody can log in as the bot.
+// It is found by its own address, never by name: a player holding the name
+// or the address is refused rather than turned into the bot.
+func EnsureUser(db *gorm.DB) (*models.User, error) {
+	var user models.User
+	err := db.Where("email = ?", botEmail).First(&user).Error
+	if err == nil {
+		if !user.IsBot {
+			return nil, ErrNameTaken
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var taken int64
+	if err := db.Model(&models.User{}).Where("LOWER(username) = LOWER(?)", Username).Count(&taken).Error; err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, ErrNameTaken
+	}
+
+	user = models.User{
+		Username:     Username,
+		Email:        botEmail,
+		PasswordHash: "!", // Not a valid bcrypt hash, so every login attempt fails
+		ELORating:    botRating,
+		IsBot:        true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Engine chooses moves for the bot account
+type Engine interface {
+	BestMove(ctx context.Context, g *models.Game, level Level) (string, error)
+}
+
+// SearchEngine is the built-in Engine backed by Searcher
+type SearchEngine struct{}
+
+// searcherKey is the context key under which Player hands a game's
+// searcherSlot to SearchEngine
+type searcherKey struct{}
+
+// searcherSlot holds the Searcher of one game, so that its transposition
+// table carries over from move to move. SearchEngine creates the Searcher
+// on first use; other engines leave the slot empty.
+type searcherSlot struct {
+	searcher *Searcher
+}
+
+// BestMove searches the game's current position within the level's limits
+func (SearchEngine) BestMove(ctx context.Context, g *models.Game, level Level) (string, error) {
+	fen, err := chess.FEN(g.CurrentFEN)
+	if err != nil {
+		return "", err
+	}
+	slot, _ := ctx.Value(searcherKey{}).(*searcherSlot)
+	if slot == nil {
+		slot = &searcherSlot{}
+	}
+	if slot.searcher == nil {
+		slot.searcher = NewSearcher()
+	}
+	result, err := slot.searcher.Search(chess.NewGame(fen).Position(), level.Limits())
+	if err != nil {
+		return "", err
+	}
+	return result.Move, nil
+}
+
+// Player plays the bot account's moves in every game it takes part in.
+// Moves go through game.Service.MakeMove like any other player's.
+type Player struct {
+	service *game.Service
+	engine  Engine
+	userID  uint
+
+	mu        sync.Mutex
+	thinking  map[uint]bool          // gameID -> search in progress
+	searchers map[uint]*searcherSlot // gameID -> Searcher kept until the game ends
+
+	// Searches are CPU bound, so no more run at once than there are
+	// processors
+	searchSlots chan struct{}
+}
+
+// NewPlayer creates a bot player for the given account and subscribes it
+// to game events
+func NewPlayer(service *game.Service, engine Engine, userID uint) *Player {
+	p := &Player{
+		service:     service,
+		engine:      engine,
+		userID:      userID,
+		thinking:    make(map[uint]bool),
+		searchers:   make(map[uint]*searcherSlot),
+		searchSlots: make(chan struct{}, runtime.GOMAXPROCS(0)),
+	}
+	service.OnGameStarted(p.onGameStarted)
+	service.OnMove(p.onMove)
+	service.OnGameFinished(p.onGameFinished)
+	return p
+}
+
+// UserID returns the ID of the bot account
+func (p *Player) UserID() uint {
+	return p.userID
+}
+
+// Resume picks up active bot games, e.g. after a server restart
+func (p *Player) Resume() error {
+	var games []models.Game
+	if err := p.service.GetDB().
+		Where("status = ? AND (white_player_id = ? OR black_player_id = ?)", models.GameStatusActive, p.userID, p.userID).
+		Find(&games).Error; err != nil {
+		return err
+	}
+	for i := range games {
+		p.maybePlay(&games[i])
+	}
+	return nil
+}
+
+// Challenge creates a game between a user and the bot. Color is the color
+// the user wants to play: "white", "black" or "random".
+func (p *Player) Challenge(userID uint, level int, color string, timeControl, increment int) (*models.Game, error) {
+	if level == 0 {
+		level = DefaultLevel
+	}
+	if _, err := GetLevel(level); err != nil {
+		return nil, err
+	}
+
+	switch color {
+	case "", "random":
+		color = "white"
+		if rand.Intn(2) == 0 {
+			color = "black"
+		}
+	case "white", "black":
+	default:
+		return nil, ErrInvalidColor
+	}
+
+	whiteID, blackID := userID, p.userID
+	if color == "black" {
+		whiteID, blackID = p.userID, userID
+	}
+
+	// The level is stored with the game, since starting it may trigger the
+	// bot's first move
+	return p.service.StartGame(whiteID, blackID, timeControl, increment, level)
+}
+
+// MatchmakingFallback pairs a user who found no opponent with the bot at a
+// level close to their rating
+func (p *Player) MatchmakingFallback(userID uint, elo int) (*models.Game, error) {
+	return p.Challenge(userID, LevelForRating(elo), "random", 600, 0)
+}
+
+func (p *Player) onGameStarted(g *models.Game) {
+	p.maybePlay(g)
+}
+
+func (p *Player) onMove(g *models.Game, _ *models.Move) {
+	p.maybePlay(g)
+}
+
+// onGameFinished drops the game's Searcher
+func (p *Player) onGameFinished(g *models.Game) {
+	p.mu.Lock()
+	delete(p.searchers, g.ID)
+	p.mu.Unlock()
+}
+
+// maybePlay starts a search in the background if it is the bot's turn
+func (p *Player) maybePlay(g *models.Game) {
+	if g.Status != models.GameStatusActive {
+		return
+	}
+
+	var color chess.Color
+	switch {
+	case g.WhitePlayerID != nil && *g.WhitePlayerID == p.userID:
+		color = chess.White
+	case g.BlackPlayerID != nil && *g.BlackPlayerID == p.userID:
+		color = chess.Black
+	default:
+		return
+	}
+
+	fen, err := chess.FEN(g.CurrentFEN)
+	if err != nil {
+		log.Printf("bot: game %d has invalid FEN: %v", g.ID, err)
+		return
+	}
+	if chess.NewGame(fen).Position().Turn() != color {
+		return
+	}
+
+	level, err := GetLevel(g.BotLevel)
+	if err != nil {
+		level = levels[DefaultLevel]
+	}
+
+	p.mu.Lock()
+	if p.thinking[g.ID] {
+		p.mu.Unlock()
+		return
+	}
+	p.thinking[g.ID] = true
+	slot := p.searchers[g.ID]
+	if slot == nil {
+		slot = &searcherSlot{}
+		p.searchers[g.ID] = slot
+	}
+	p.mu.Unlock()
+
+	go p.play(*g, level, slot)
+}
+
+func (p *Player) play(g models.Game, level Level, slot *searcherSlot) {
+	p.searchSlots <- struct{}{}
+	ctx := context.WithValue(context.Background(), searcherKey{}, slot)
+	move, err := p.engine.BestMove(ctx, &g, level)
+	<-p.searchSlots
+
+	// Clear the flag before moving: the opponent's reply may arrive before
+	// MakeMove returns and must be able to start the next search
+	p.mu.Lock()
+	delete(p.thinking, g.ID)
+	p.mu.Unlock()
+
+	if err != nil {
+		log.Printf("bot: no move found in game %d: %v", g.ID, err)
+		return
+	}
+
+	if _, err := p.service.MakeMove(g.ID, p.userID, move); err != nil {
+		log.Printf("bot: move %s rejected in game %d: %v", move, g.ID, err)
+	}
+}
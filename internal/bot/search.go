@@ -0,0 +1,375 @@
+package bot
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+const (
+	// MateScore is the score of a checkmate at the root; mates further away
+	// score lower so the search prefers the quickest one
+	MateScore = 100000
+	// mateThreshold separates mate scores from regular evaluations
+	mateThreshold = MateScore - 1000
+	infinity      = MateScore + 1
+
+	// Default number of transposition table entries
+	defaultTTSize = 1 << 16
+)
+
+var ErrNoLegalMoves = errors.New("no legal moves")
+
+// Limits bounds a search by depth and wall-clock time. A zero value means
+// no limit for that dimension.
+type Limits struct {
+	Depth    int
+	MoveTime time.Duration
+}
+
+// Result is the outcome of a search
+type Result struct {
+	Move  string // Best move in UCI notation
+	Score int    // Centipawns from the side to move's point of view
+	Depth int    // Deepest fully completed iteration
+	Nodes int64
+	PV    []string
+}
+
+// IsMate returns true if the score is a forced mate for either side
+func (r *Result) IsMate() bool {
+	return r.Score >= mateThreshold || r.Score <= -mateThreshold
+}
+
+// MateIn returns the number of moves to mate (negative if the side to move
+// is getting mated), or 0 if the score is not a mate score
+func (r *Result) MateIn() int {
+	switch {
+	case r.Score >= mateThreshold:
+		return (MateScore - r.Score + 1) / 2
+	case r.Score <= -mateThreshold:
+		return -(MateScore + r.Score + 1) / 2
+	}
+	return 0
+}
+
+type ttFlag uint8
+
+const (
+	ttExact ttFlag = iota
+	ttLower
+	ttUpper
+)
+
+type ttEntry struct {
+	key   [16]byte
+	move  string
+	score int
+	depth int
+	flag  ttFlag
+	used  bool
+}
+
+// transpositionTable is a fixed-size, always-replace hash table of search results
+type transpositionTable struct {
+	entries []ttEntry
+}
+
+func newTranspositionTable(size int) *transpositionTable {
+	return &transpositionTable{entries: make([]ttEntry, size)}
+}
+
+func (t *transpositionTable) slot(key [16]byte) *ttEntry {
+	return &t.entries[binary.LittleEndian.Uint64(key[:8])%uint64(len(t.entries))]
+}
+
+func (t *transpositionTable) probe(key [16]byte) (*ttEntry, bool) {
+	e := t.slot(key)
+	if !e.used || e.key != key {
+		return nil, false
+	}
+	return e, true
+}
+
+func (t *transpositionTable) store(key [16]byte, move string, score, depth int, flag ttFlag) {
+	e := t.slot(key)
+	// Keep deeper results for the same position
+	if e.used && e.key == key && e.depth > depth {
+		return
+	}
+	*e = ttEntry{key: key, move: move, score: score, depth: depth, flag: flag, used: true}
+}
+
+// Searcher runs an alpha-beta search with iterative deepening. The
+// transposition table persists between searches, so reuse a Searcher for
+// consecutive moves of the same game. A Searcher is not safe for
+// concurrent use.
+type Searcher struct {
+	tt       *transpositionTable
+	nodes    int64
+	deadline time.Time
+	stopped  bool
+	rootMove string
+}
+
+// NewSearcher creates a searcher with a default-sized transposition table
+func NewSearcher() *Searcher {
+	return &Searcher{tt: newTranspositionTable(defaultTTSize)}
+}
+
+// Search finds the best move for the side to move in pos
+func (s *Searcher) Search(pos *chess.Position, limits Limits) (*Result, error) {
+	moves := pos.ValidMoves()
+	if len(moves) == 0 {
+		return nil, ErrNoLegalMoves
+	}
+
+	maxDepth := limits.Depth
+	if maxDepth <= 0 {
+		maxDepth = 64
+	}
+	s.nodes = 0
+	s.stopped = false
+	s.deadline = time.Time{}
+	if limits.MoveTime > 0 {
+		s.deadline = time.Now().Add(limits.MoveTime)
+	}
+
+	// Always have a legal move to return, even if the first iteration is cut short
+	result := &Result{Move: moves[0].String()}
+
+	for depth := 1; depth <= maxDepth; depth++ {
+		score := s.negamax(pos, depth, 0, -infinity, infinity)
+		if s.stopped {
+			break
+		}
+
+		result.Move = s.rootMove
+		result.Score = score
+		result.Depth = depth
+		result.PV = s.principalVariation(pos, s.rootMove, depth)
+
+		// No point searching deeper once a forced mate has been found
+		if score >= mateThreshold || score <= -mateThreshold {
+			break
+		}
+	}
+
+	result.Nodes = s.nodes
+	return result, nil
+}
+
+func (s *Searcher) shouldStop() bool {
+	if s.stopped {
+		return true
+	}
+	// Checking the clock is comparatively expensive, so only do it periodically
+	if !s.deadline.IsZero() && s.nodes&1023 == 0 && time.Now().After(s.deadline) {
+		s.stopped = true
+	}
+	return s.stopped
+}
+
+func (s *Searcher) negamax(pos *chess.Position, depth, ply, alpha, beta int) int {
+	s.nodes++
+	if s.shouldStop() {
+		return 0
+	}
+
+	if ply > 0 && pos.HalfMoveClock() >= 100 {
+		return 0
+	}
+
+	key := pos.Hash()
+	ttMove := ""
+	if entry, ok := s.tt.probe(key); ok {
+		ttMove = entry.move
+		if ply > 0 && entry.depth >= depth {
+			score := scoreFromTT(entry.score, ply)
+			switch entry.flag {
+			case ttExact:
+				return score
+			case ttLower:
+				alpha = max(alpha, score)
+			case ttUpper:
+				beta = min(beta, score)
+			}
+			if alpha >= beta {
+				return score
+			}
+		}
+	}
+
+	moves := pos.ValidMoves()
+	if len(moves) == 0 {
+		if pos.Status() == chess.Checkmate {
+			return -MateScore + ply
+		}
+		return 0
+	}
+
+	if depth <= 0 {
+		return s.quiesce(pos, ply, alpha, beta)
+	}
+
+	orderMoves(pos, moves, ttMove)
+
+	alphaOrig := alpha
+	bestScore := -infinity
+	bestMove := ""
+	for _, move := range moves {
+		score := -s.negamax(pos.Update(move), depth-1, ply+1, -beta, -alpha)
+		if s.stopped {
+			return 0
+		}
+
+		if score > bestScore {
+			bestScore = score
+			bestMove = move.String()
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+
+	flag := ttExact
+	switch {
+	case bestScore <= alphaOrig:
+		flag = ttUpper
+	case bestScore >= beta:
+		flag = ttLower
+	}
+	s.tt.store(key, bestMove, scoreToTT(bestScore, ply), depth, flag)
+	if ply == 0 {
+		s.rootMove = bestMove
+	}
+
+	return bestScore
+}
+
+// quiesce extends the search along captures and promotions so the static
+// evaluation is never taken in the middle of an exchange
+func (s *Searcher) quiesce(pos *chess.Position, ply, alpha, beta int) int {
+	s.nodes++
+	if s.shouldStop() {
+		return 0
+	}
+
+	standPat := Evaluate(pos)
+	if standPat >= beta {
+		return standPat
+	}
+	if standPat > alpha {
+		alpha = standPat
+	}
+
+	moves := pos.ValidMoves()
+	tactical := moves[:0]
+	for _, move := range moves {
+		if move.HasTag(chess.Capture) || move.Promo() != chess.NoPieceType {
+			tactical = append(tactical, move)
+		}
+	}
+	orderMoves(pos, tactical, "")
+
+	for _, move := range tactical {
+		score := -s.quiesce(pos.Update(move), ply+1, -beta, -alpha)
+		if s.stopped {
+			return 0
+		}
+		if score >= beta {
+			return score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	return alpha
+}
+
+// principalVariation follows the transposition table from pos, starting
+// with the best root move
+func (s *Searcher) principalVariation(pos *chess.Position, first string, maxLen int) []string {
+	var pv []string
+	uci := first
+	for len(pv) < maxLen && uci != "" {
+		var next *chess.Move
+		for _, move := range pos.ValidMoves() {
+			if move.String() == uci {
+				next = move
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		pv = append(pv, uci)
+		pos = pos.Update(next)
+
+		uci = ""
+		if entry, ok := s.tt.probe(pos.Hash()); ok {
+			uci = entry.move
+		}
+	}
+	return pv
+}
+
+// orderMoves sorts moves so the most promising are searched first: the
+// transposition table move, then captures by MVV-LVA, promotions and checks
+func orderMoves(pos *chess.Position, moves []*chess.Move, ttMove string) {
+	board := pos.Board()
+	scores := make(map[*chess.Move]int, len(moves))
+	for _, move := range moves {
+		score := 0
+		switch {
+		case move.String() == ttMove:
+			score = 1 << 20
+		case move.HasTag(chess.Capture):
+			victim := pieceValues[board.Piece(move.S2()).Type()]
+			if move.HasTag(chess.EnPassant) {
+				victim = pieceValues[chess.Pawn]
+			}
+			attacker := pieceValues[board.Piece(move.S1()).Type()]
+			score = 10000 + victim*10 - attacker/10
+		}
+		if move.Promo() != chess.NoPieceType {
+			score += 5000 + pieceValues[move.Promo()]
+		}
+		if move.HasTag(chess.Check) {
+			score += 1000
+		}
+		scores[move] = score
+	}
+	sort.SliceStable(moves, func(i, j int) bool {
+		return scores[moves[i]] > scores[moves[j]]
+	})
+}
+
+// Mate scores are stored relative to the node so they stay correct when the
+// same position is reached at a different ply
+func scoreToTT(score, ply int) int {
+	switch {
+	case score >= mateThreshold:
+		return score + ply
+	case score <= -mateThreshold:
+		return score - ply
+	}
+	return score
+}
+
+func scoreFromTT(score, ply int) int {
+	switch {
+	case score >= mateThreshold:
+		return score - ply
+	case score <= -mateThreshold:
+		return score + ply
+	}
+	return score
+}
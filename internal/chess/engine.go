@@ -164,3 +164,9 @@ func (e *Engine) GetMoveHistory() []string {
 	}
 	return sanMoves
 }
+
+// SetTag sets a PGN tag pair such as "ECO" or "Opening"
+func (e *Engine) SetTag(key, value string) {
+	e.game.RemoveTagPair(key)
+	e.game.AddTagPair(key, value)
+}
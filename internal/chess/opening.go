@@ -0,0 +1,90 @@
+package chess
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/notnil/chess"
+)
+
+// eco.tsv is the Lichess chess-openings database (CC0): ECO code, name,
+// PGN, UCI moves and EPD of the final position, one opening per line
+//
+//go:embed eco.tsv
+var ecoTSV []byte
+
+// Opening is a named opening line from the Encyclopaedia of Chess Openings
+type Opening struct {
+	ECO   string `json:"eco"`   // e.g. "C50"
+	Name  string `json:"name"`  // e.g. "Italian Game"
+	Moves string `json:"moves"` // UCI moves from the starting position, space separated
+}
+
+var (
+	openingsOnce       sync.Once
+	openingsByPosition map[string]*Opening // normalised FEN -> opening
+)
+
+// LoadOpenings builds the opening index. It happens on first use anyway;
+// calling it at startup keeps the delay off the first move played.
+func LoadOpenings() {
+	openingsOnce.Do(loadOpenings)
+}
+
+// ClassifyFEN returns the opening whose line ends in the given position,
+// or nil if the position is not a named opening
+func ClassifyFEN(fen string) *Opening {
+	openingsOnce.Do(loadOpenings)
+	return openingsByPosition[NormalizeFEN(fen)]
+}
+
+// NormalizeFEN drops the half-move clock and move number from a FEN so the
+// same position reached at different moves compares equal
+func NormalizeFEN(fen string) string {
+	fields := strings.Fields(fen)
+	if len(fields) > 4 {
+		fields = fields[:4]
+	}
+	return strings.Join(fields, " ")
+}
+
+// loadOpenings replays every opening line so positions are keyed exactly
+// like the FENs produced by Engine
+func loadOpenings() {
+	openingsByPosition = make(map[string]*Opening)
+
+	scanner := bufio.NewScanner(bytes.NewReader(ecoTSV))
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 4 {
+			continue
+		}
+		opening := &Opening{ECO: fields[0], Name: fields[1], Moves: fields[3]}
+
+		pos := chess.StartingPosition()
+		valid := true
+		for _, uci := range strings.Fields(opening.Moves) {
+			move, err := chess.UCINotation{}.Decode(pos, uci)
+			if err != nil {
+				valid = false
+				break
+			}
+			pos = pos.Update(move)
+		}
+		if !valid {
+			log.Printf("skipping invalid opening %s %q", opening.ECO, opening.Name)
+			continue
+		}
+
+		// Transpositions keep the first (lowest ECO code) name
+		key := NormalizeFEN(pos.String())
+		if _, exists := openingsByPosition[key]; !exists {
+			openingsByPosition[key] = opening
+		}
+	}
+}
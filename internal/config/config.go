@@ -2,13 +2,94 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+
+	"chess-app/internal/ratelimit"
 )
 
 type Config struct {
 	Port        string
 	JWTSecret   string
 	DatabaseURL string
+
+	// Optional UCI engine binary used by the bot instead of the built-in search
+	UCIEnginePath string
+	UCIMaxEngines int
+
+	// How hubs of several server instances share messages: "memory" (single
+	// instance, default) or "postgres" (LISTEN/NOTIFY)
+	HubBroker string
+
+	// Browser origins allowed to open WebSockets, besides the server's own
+	AllowedOrigins []string
+
+	// Addresses or CIDR ranges of the reverse proxies in front of the
+	// server. Only they are believed about the client address in
+	// X-Forwarded-For; with none, the connection's address is used.
+	TrustedProxies []string
+
+	// Public URL of the frontend, used in links sent by email
+	AppURL string
+
+	// How emails are sent: "log" (default), "file" (.eml files in MailDir)
+	// or "smtp"
+	MailTransport string
+	MailFrom      string
+	MailDir       string
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUsername  string
+	SMTPPassword  string
+
+	// Single sign-on providers, see OIDCProvider
+	OIDCProviders []OIDCProvider
+
+	// Verified accounts made admins on startup
+	AdminEmails []string
+
+	// Where rate limit buckets are kept: "memory" (single instance, default)
+	// or "postgres" (shared by all instances)
+	RateLimitStore string
+	// Requests allowed per client. The auth limits apply to login,
+	// registration and password reset, per address and per account; the
+	// API limits to every route, per address and per logged-in user.
+	AuthIPLimit      ratelimit.Limit
+	AuthAccountLimit ratelimit.Limit
+	APIIPLimit       ratelimit.Limit
+	APIUserLimit     ratelimit.Limit
+
+	// Where uploaded avatars are kept: "local" (files in AvatarDir, default)
+	// or "s3" (a bucket of an S3-compatible storage, see S3Config)
+	AvatarStorage string
+	AvatarDir     string
+	S3            S3Config
+}
+
+// S3Config is read from S3_ENDPOINT, S3_REGION, S3_BUCKET,
+// S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY and S3_PATH_STYLE
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool // Needed by MinIO and most self-hosted storages
+}
+
+// OIDCProvider is an OpenID Connect provider users can log in with. Each
+// provider named in OIDC_PROVIDERS (e.g. "company") is read from
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and
+// the optional OIDC_<NAME>_LABEL and OIDC_<NAME>_SCOPES.
+type OIDCProvider struct {
+	Name         string // Used in URLs
+	Label        string // Shown on the login button
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
 }
 
 func Load() (*Config, error) {
@@ -30,9 +111,223 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("DATABASE_URL or SCALINGO_POSTGRESQL_URL environment variable is required")
 	}
 
+	uciMaxEngines := 2
+	if v := os.Getenv("UCI_MAX_ENGINES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("UCI_MAX_ENGINES must be a positive integer")
+		}
+		uciMaxEngines = n
+	}
+
+	hubBroker := os.Getenv("HUB_BROKER")
+	if hubBroker == "" {
+		hubBroker = "memory"
+	}
+	if hubBroker != "memory" && hubBroker != "postgres" {
+		return nil, fmt.Errorf("HUB_BROKER must be \"memory\" or \"postgres\"")
+	}
+
+	allowedOrigins := []string{"http://localhost:3000", "http://127.0.0.1:3000"}
+	if v := os.Getenv("ALLOWED_ORIGINS"); v != "" {
+		allowedOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+				allowedOrigins = append(allowedOrigins, origin)
+			}
+		}
+	}
+
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+			}
+		}
+		trustedProxies = append(trustedProxies, proxy)
+	}
+
+	appURL := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	if appURL == "" {
+		appURL = "http://localhost:3000"
+	}
+
+	mailTransport := os.Getenv("MAIL_TRANSPORT")
+	if mailTransport == "" {
+		mailTransport = "log"
+	}
+	if mailTransport != "log" && mailTransport != "file" && mailTransport != "smtp" {
+		return nil, fmt.Errorf("MAIL_TRANSPORT must be \"log\", \"file\" or \"smtp\"")
+	}
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "no-reply@localhost"
+	}
+	mailDir := os.Getenv("MAIL_DIR")
+	if mailDir == "" {
+		mailDir = "mail"
+	}
+	smtpHost := os.Getenv("SMTP_HOST")
+	if mailTransport == "smtp" && smtpHost == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required when MAIL_TRANSPORT is \"smtp\"")
+	}
+	smtpPort := 587
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("SMTP_PORT must be a positive integer")
+		}
+		smtpPort = n
+	}
+
+	oidcProviders, err := loadOIDCProviders()
+	if err != nil {
+		return nil, err
+	}
+
+	var adminEmails []string
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			adminEmails = append(adminEmails, email)
+		}
+	}
+
+	rateLimitStore := os.Getenv("RATE_LIMIT_STORE")
+	if rateLimitStore == "" {
+		rateLimitStore = "memory"
+	}
+	if rateLimitStore != "memory" && rateLimitStore != "postgres" {
+		return nil, fmt.Errorf("RATE_LIMIT_STORE must be \"memory\" or \"postgres\"")
+	}
+	limits := make(map[string]ratelimit.Limit)
+	for name, def := range map[string]string{
+		"RATE_LIMIT_AUTH_IP":      "20/1m",
+		"RATE_LIMIT_AUTH_ACCOUNT": "5/1m",
+		"RATE_LIMIT_API_IP":       "600/1m",
+		"RATE_LIMIT_API_USER":     "300/1m",
+	} {
+		v := os.Getenv(name)
+		if v == "" {
+			v = def
+		}
+		limit, err := ratelimit.ParseLimit(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		limits[name] = limit
+	}
+
+	avatarStorage := os.Getenv("AVATAR_STORAGE")
+	if avatarStorage == "" {
+		avatarStorage = "local"
+	}
+	if avatarStorage != "local" && avatarStorage != "s3" {
+		return nil, fmt.Errorf("AVATAR_STORAGE must be \"local\" or \"s3\"")
+	}
+	avatarDir := os.Getenv("AVATAR_DIR")
+	if avatarDir == "" {
+		avatarDir = "avatars"
+	}
+	s3, err := loadS3Config(avatarStorage == "s3")
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
-		Port:        port,
-		JWTSecret:   jwtSecret,
-		DatabaseURL: databaseURL,
+		Port:           port,
+		JWTSecret:      jwtSecret,
+		DatabaseURL:    databaseURL,
+		UCIEnginePath:  os.Getenv("UCI_ENGINE_PATH"),
+		UCIMaxEngines:  uciMaxEngines,
+		HubBroker:      hubBroker,
+		AllowedOrigins: allowedOrigins,
+		TrustedProxies: trustedProxies,
+		AppURL:         appURL,
+		MailTransport:  mailTransport,
+		MailFrom:       mailFrom,
+		MailDir:        mailDir,
+		SMTPHost:       smtpHost,
+		SMTPPort:       smtpPort,
+		SMTPUsername:   os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:   os.Getenv("SMTP_PASSWORD"),
+		OIDCProviders:  oidcProviders,
+		AdminEmails:    adminEmails,
+
+		RateLimitStore:   rateLimitStore,
+		AuthIPLimit:      limits["RATE_LIMIT_AUTH_IP"],
+		AuthAccountLimit: limits["RATE_LIMIT_AUTH_ACCOUNT"],
+		APIIPLimit:       limits["RATE_LIMIT_API_IP"],
+		APIUserLimit:     limits["RATE_LIMIT_API_USER"],
+
+		AvatarStorage: avatarStorage,
+		AvatarDir:     avatarDir,
+		S3:            s3,
 	}, nil
 }
+
+func loadS3Config(required bool) (S3Config, error) {
+	cfg := S3Config{
+		Endpoint:        strings.TrimRight(os.Getenv("S3_ENDPOINT"), "/"),
+		Region:          os.Getenv("S3_REGION"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+		pathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("S3_PATH_STYLE must be true or false")
+		}
+		cfg.PathStyle = pathStyle
+	}
+	if required && (cfg.Bucket == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "") {
+		return cfg, fmt.Errorf("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required when AVATAR_STORAGE is \"s3\"")
+	}
+	return cfg, nil
+}
+
+func loadOIDCProviders() ([]OIDCProvider, error) {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		for _, r := range name {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+				return nil, fmt.Errorf("OIDC provider name %q may only contain letters, digits and underscores", name)
+			}
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := OIDCProvider{
+			Name:         name,
+			Label:        os.Getenv(prefix + "LABEL"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+		if provider.Label == "" {
+			provider.Label = name
+		}
+		if v := os.Getenv(prefix + "SCOPES"); v != "" {
+			provider.Scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
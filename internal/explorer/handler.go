@@ -0,0 +1,49 @@
+package explorer
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Explore returns the moves played from a position:
+// GET /api/explorer?fen=...&ratingMin=1500&timeControl=blitz
+func (h *Handler) Explore(c *gin.Context) {
+	q := Query{
+		FEN:         c.Query("fen"),
+		TimeControl: c.Query("timeControl"),
+	}
+	if q.FEN == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fen is required"})
+		return
+	}
+	if v := c.Query("ratingMin"); v != "" {
+		ratingMin, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ratingMin"})
+			return
+		}
+		q.RatingMin = ratingMin
+	}
+
+	position, err := h.service.Explore(q)
+	if err != nil {
+		if err == ErrInvalidFEN {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, position)
+}
@@ -0,0 +1,338 @@
+// Package explorer aggregates the moves played on the server into an
+// opening explorer.
+package explorer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"chess-app/internal/chess"
+	"chess-app/internal/models"
+
+	notnil "github.com/notnil/chess"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidFEN = errors.New("invalid FEN")
+
+// Number of top games returned per position
+const topGamesLimit = 5
+
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// Query selects the games the explorer aggregates
+type Query struct {
+	FEN       string
+	RatingMin int
+	// Either a speed name (bullet, blitz, rapid, classical) or a number of
+	// seconds per player; empty for all games
+	TimeControl string
+}
+
+// MoveStats aggregates the games in which a move was played from a position
+type MoveStats struct {
+	UCI           string  `json:"uci"`
+	SAN           string  `json:"san"`
+	Games         int     `json:"games"`
+	WhitePercent  float64 `json:"whitePercent"`
+	DrawPercent   float64 `json:"drawPercent"`
+	BlackPercent  float64 `json:"blackPercent"`
+	AverageRating int     `json:"averageRating"`
+}
+
+// PlayerSummary identifies a player in a top game
+type PlayerSummary struct {
+	ID       *uint  `json:"id"`
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+}
+
+// TopGame is a highly rated game that reached the position
+type TopGame struct {
+	GameID      uint              `json:"gameId"`
+	Move        string            `json:"move"` // SAN of the move played from the position
+	White       PlayerSummary     `json:"white"`
+	Black       PlayerSummary     `json:"black"`
+	Result      models.GameResult `json:"result"`
+	TimeControl int               `json:"timeControl"`
+	PlayedAt    string            `json:"playedAt"`
+}
+
+// Position is the explorer's answer for one position
+type Position struct {
+	FEN      string      `json:"fen"`
+	Games    int         `json:"games"`
+	White    int         `json:"white"`
+	Draws    int         `json:"draws"`
+	Black    int         `json:"black"`
+	Moves    []MoveStats `json:"moves"`
+	TopGames []TopGame   `json:"topGames"`
+}
+
+// Speed classifies a time control the way the explorer filters it
+func Speed(timeControl int) string {
+	switch {
+	case timeControl < 180:
+		return "bullet"
+	case timeControl < 480:
+		return "blitz"
+	case timeControl < 1500:
+		return "rapid"
+	}
+	return "classical"
+}
+
+// Explore returns the moves played from a position
+func (s *Service) Explore(q Query) (*Position, error) {
+	if _, err := notnil.FEN(q.FEN); err != nil {
+		return nil, ErrInvalidFEN
+	}
+	key := chess.NormalizeFEN(q.FEN)
+
+	filtered := func() *gorm.DB {
+		db := s.db.Model(&models.ExplorerMove{}).Where("position_key = ?", key)
+		if q.RatingMin > 0 {
+			db = db.Where("average_rating >= ?", q.RatingMin)
+		}
+		if tc := strings.TrimSpace(q.TimeControl); tc != "" {
+			if seconds, err := strconv.Atoi(tc); err == nil {
+				db = db.Where("time_control = ?", seconds)
+			} else {
+				db = db.Where("speed = ?", strings.ToLower(tc))
+			}
+		}
+		return db
+	}
+
+	var rows []struct {
+		Move          string
+		SAN           string
+		Games         int
+		White         int
+		Draws         int
+		Black         int
+		AverageRating float64
+	}
+	err := filtered().
+		Select(`move, san, COUNT(*) AS games,
+			SUM(CASE WHEN result = ? THEN 1 ELSE 0 END) AS white,
+			SUM(CASE WHEN result = ? THEN 1 ELSE 0 END) AS draws,
+			SUM(CASE WHEN result = ? THEN 1 ELSE 0 END) AS black,
+			AVG(average_rating) AS average_rating`,
+			models.GameResultWhiteWins, models.GameResultDraw, models.GameResultBlackWins).
+		Group("move, san").
+		Order("games DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Position{FEN: key, Moves: make([]MoveStats, 0, len(rows)), TopGames: []TopGame{}}
+	for _, row := range rows {
+		result.Games += row.Games
+		result.White += row.White
+		result.Draws += row.Draws
+		result.Black += row.Black
+		result.Moves = append(result.Moves, MoveStats{
+			UCI:           row.Move,
+			SAN:           row.SAN,
+			Games:         row.Games,
+			WhitePercent:  percent(row.White, row.Games),
+			DrawPercent:   percent(row.Draws, row.Games),
+			BlackPercent:  percent(row.Black, row.Games),
+			AverageRating: int(math.Round(row.AverageRating)),
+		})
+	}
+	if result.Games == 0 {
+		return result, nil
+	}
+
+	var top []models.ExplorerMove
+	if err := filtered().Order("average_rating DESC, played_at DESC").Limit(topGamesLimit).Find(&top).Error; err != nil {
+		return nil, err
+	}
+	if err := s.attachTopGames(result, top); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *Service) attachTopGames(result *Position, top []models.ExplorerMove) error {
+	gameIDs := make([]uint, len(top))
+	for i, entry := range top {
+		gameIDs[i] = entry.GameID
+	}
+
+	var games []models.Game
+	if err := s.db.Preload("WhitePlayer").Preload("BlackPlayer").Find(&games, gameIDs).Error; err != nil {
+		return err
+	}
+	byID := make(map[uint]*models.Game, len(games))
+	for i := range games {
+		byID[games[i].ID] = &games[i]
+	}
+
+	for _, entry := range top {
+		game, ok := byID[entry.GameID]
+		if !ok {
+			continue
+		}
+		result.TopGames = append(result.TopGames, TopGame{
+			GameID:      entry.GameID,
+			Move:        entry.SAN,
+			White:       PlayerSummary{ID: game.WhitePlayerID, Username: username(game.WhitePlayer), Rating: entry.WhiteRating},
+			Black:       PlayerSummary{ID: game.BlackPlayerID, Username: username(game.BlackPlayer), Rating: entry.BlackRating},
+			Result:      entry.Result,
+			TimeControl: entry.TimeControl,
+			PlayedAt:    entry.PlayedAt.Format("2006-01-02"),
+		})
+	}
+	return nil
+}
+
+// OnGameFinished indexes a game as soon as it ends. It is registered as a
+// game.Service listener; the ratings on the preloaded players are still
+// the ones the game was played at.
+func (s *Service) OnGameFinished(game *models.Game) {
+	whiteRating, blackRating := 0, 0
+	if game.WhitePlayer != nil {
+		whiteRating = game.WhitePlayer.ELORating
+	}
+	if game.BlackPlayer != nil {
+		blackRating = game.BlackPlayer.ELORating
+	}
+
+	gameCopy := *game
+	go func() {
+		if err := s.IndexGame(&gameCopy, whiteRating, blackRating); err != nil {
+			log.Printf("explorer: failed to index game %d: %v", gameCopy.ID, err)
+		}
+	}()
+}
+
+// OnGameAnnulled removes an annulled game from the explorer. It is
+// registered as a game.Service listener.
+func (s *Service) OnGameAnnulled(game *models.Game) {
+	gameID := game.ID
+	go func() {
+		if err := s.db.Where("game_id = ?", gameID).Delete(&models.ExplorerMove{}).Error; err != nil {
+			log.Printf("explorer: failed to remove annulled game %d: %v", gameID, err)
+		}
+	}()
+}
+
+// IndexGame (re)builds the explorer rows of a finished game
+func (s *Service) IndexGame(game *models.Game, whiteRating, blackRating int) error {
+	if game.Status != models.GameStatusFinished || game.Annulled {
+		return nil
+	}
+
+	var moves []models.Move
+	if err := s.db.Where("game_id = ?", game.ID).Order("ply_number ASC").Find(&moves).Error; err != nil {
+		return err
+	}
+
+	entries := make([]models.ExplorerMove, 0, len(moves))
+	pos := notnil.StartingPosition()
+	for _, move := range moves {
+		m, err := notnil.UCINotation{}.Decode(pos, move.MoveNotation)
+		if err != nil {
+			return fmt.Errorf("ply %d: %w", move.PlyNumber, err)
+		}
+		entries = append(entries, models.ExplorerMove{
+			PositionKey:   chess.NormalizeFEN(pos.String()),
+			GameID:        game.ID,
+			PlyNumber:     move.PlyNumber,
+			Move:          move.MoveNotation,
+			SAN:           notnil.AlgebraicNotation{}.Encode(pos, m),
+			Result:        game.Result,
+			WhiteRating:   whiteRating,
+			BlackRating:   blackRating,
+			AverageRating: (whiteRating + blackRating) / 2,
+			TimeControl:   game.TimeControl,
+			Speed:         Speed(game.TimeControl),
+			PlayedAt:      game.CreatedAt,
+		})
+
+		// The stored board state is the position the next move is played from
+		next, err := notnil.FEN(move.BoardState)
+		if err != nil {
+			return fmt.Errorf("ply %d: %w", move.PlyNumber, err)
+		}
+		pos = notnil.NewGame(next).Position()
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// The share lock holds off an annulment until the rows are written,
+		// so that its cleanup cannot run in between
+		var current models.Game
+		if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+			Select("id", "annulled").First(&current, game.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("game_id = ?", game.ID).Delete(&models.ExplorerMove{}).Error; err != nil {
+			return err
+		}
+		if current.Annulled || len(entries) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(entries, 500).Error
+	})
+}
+
+// Backfill indexes finished games that have no explorer rows yet, e.g.
+// games that ended before the explorer existed. Current ratings stand in
+// for the ratings at the time.
+func (s *Service) Backfill() error {
+	var games []models.Game
+	if err := s.db.Preload("WhitePlayer").Preload("BlackPlayer").
+		Where("status = ? AND NOT annulled", models.GameStatusFinished).
+		Where("NOT EXISTS (SELECT 1 FROM explorer_moves WHERE explorer_moves.game_id = games.id)").
+		Find(&games).Error; err != nil {
+		return err
+	}
+
+	for i := range games {
+		game := &games[i]
+		whiteRating, blackRating := 0, 0
+		if game.WhitePlayer != nil {
+			whiteRating = game.WhitePlayer.ELORating
+		}
+		if game.BlackPlayer != nil {
+			blackRating = game.BlackPlayer.ELORating
+		}
+		if err := s.IndexGame(game, whiteRating, blackRating); err != nil {
+			log.Printf("explorer: failed to index game %d: %v", game.ID, err)
+		}
+	}
+	if len(games) > 0 {
+		log.Printf("explorer: indexed %d games", len(games))
+	}
+	return nil
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)*1000/float64(total)) / 10
+}
+
+func username(user *models.User) string {
+	if user == nil {
+		return "?"
+	}
+	return user.Username
+}
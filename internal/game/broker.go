@@ -0,0 +1,47 @@
+package game
+
+import (
+	"sync"
+)
+
+// Broker carries hub messages to every hub subscribed to it, which may run
+// in other server instances. This lets moves, tournament updates,
+// notifications and presence reach clients connected to any instance.
+type Broker interface {
+	// Publish delivers a message to every subscriber, including those in
+	// this process
+	Publish(msg *BroadcastMessage) error
+	// Subscribe registers a handler called for every published message.
+	// Handlers may block until the hub has taken the message.
+	Subscribe(handler func(msg *BroadcastMessage))
+}
+
+// MemoryBroker is a Broker for hubs running in a single process
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []func(msg *BroadcastMessage)
+}
+
+// NewMemoryBroker creates an in-process broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+// Publish hands the message to each subscriber in turn
+func (b *MemoryBroker) Publish(msg *BroadcastMessage) error {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	return nil
+}
+
+// Subscribe registers a handler for every published message
+func (b *MemoryBroker) Subscribe(handler func(msg *BroadcastMessage)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
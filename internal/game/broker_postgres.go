@@ -0,0 +1,212 @@
+package game
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// PostgresChannel is the LISTEN/NOTIFY channel shared by all instances
+const PostgresChannel = "chess_hub"
+
+// Postgres refuses NOTIFY payloads of 8000 bytes or more
+const maxNotifyPayload = 7999
+
+const (
+	listenRetryMin = time.Second
+	listenRetryMax = 30 * time.Second
+)
+
+var ErrMessageTooLarge = errors.New("hub message too large for NOTIFY")
+
+// PostgresBroker fans hub messages out to every server instance with
+// Postgres LISTEN/NOTIFY. Messages are delivered to local subscribers
+// directly and to other instances through the database. Messages sent while
+// an instance's listener is reconnecting are lost for that instance.
+type PostgresBroker struct {
+	db       *gorm.DB
+	dsn      string
+	instance string
+
+	mu       sync.RWMutex
+	handlers []func(msg *BroadcastMessage)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// envelope is a message as sent over NOTIFY
+type envelope struct {
+	Origin       string          `json:"o"`
+	GameID       uint            `json:"g,omitempty"`
+	TournamentID uint            `json:"t,omitempty"`
+	UserID       uint            `json:"u,omitempty"`
+	Data         json.RawMessage `json:"d,omitempty"`
+	Presence     *PresenceUpdate `json:"p,omitempty"`
+	Disconnect   bool            `json:"x,omitempty"`
+}
+
+// NewPostgresBroker starts listening on PostgresChannel with a dedicated
+// connection to dsn. Notifications are sent through db.
+func NewPostgresBroker(db *gorm.DB, dsn string) (*PostgresBroker, error) {
+	instance := make([]byte, 8)
+	if _, err := rand.Read(instance); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &PostgresBroker{
+		db:       db,
+		dsn:      dsn,
+		instance: hex.EncodeToString(instance),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	// Fail early if the database cannot be reached at all
+	conn, err := b.connect(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go b.listen(ctx, conn)
+	return b, nil
+}
+
+// Publish delivers the message locally, then notifies the other instances
+func (b *PostgresBroker) Publish(msg *BroadcastMessage) error {
+	b.deliver(msg)
+
+	env := envelope{
+		Origin:       b.instance,
+		GameID:       msg.GameID,
+		TournamentID: msg.TournamentID,
+		UserID:       msg.UserID,
+		Presence:     msg.Presence,
+		Disconnect:   msg.Disconnect,
+	}
+	if msg.Data != nil {
+		data, err := json.Marshal(msg.Data)
+		if err != nil {
+			return err
+		}
+		env.Data = data
+	}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		return ErrMessageTooLarge
+	}
+	return b.db.Exec("SELECT pg_notify(?, ?)", PostgresChannel, string(payload)).Error
+}
+
+// Subscribe registers a handler for every published message
+func (b *PostgresBroker) Subscribe(handler func(msg *BroadcastMessage)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// Close stops listening
+func (b *PostgresBroker) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+func (b *PostgresBroker) deliver(msg *BroadcastMessage) {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+}
+
+func (b *PostgresBroker) connect(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{PostgresChannel}.Sanitize()); err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
+// listen receives notifications until ctx is cancelled, reconnecting with
+// exponential backoff when the connection drops
+func (b *PostgresBroker) listen(ctx context.Context, conn *pgx.Conn) {
+	defer close(b.done)
+
+	retry := listenRetryMin
+	for {
+		if conn != nil {
+			retry = listenRetryMin
+			err := b.receive(ctx, conn)
+			conn.Close(context.Background())
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("hub broker: listener stopped: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry *= 2
+		if retry > listenRetryMax {
+			retry = listenRetryMax
+		}
+
+		var err error
+		if conn, err = b.connect(ctx); err != nil {
+			log.Printf("hub broker: failed to reconnect: %v", err)
+		}
+	}
+}
+
+// receive hands notifications from other instances to the subscribers
+func (b *PostgresBroker) receive(ctx context.Context, conn *pgx.Conn) error {
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var env envelope
+		if err := json.Unmarshal([]byte(n.Payload), &env); err != nil {
+			log.Printf("hub broker: invalid notification: %v", err)
+			continue
+		}
+		if env.Origin == b.instance {
+			continue
+		}
+
+		msg := &BroadcastMessage{
+			GameID:       env.GameID,
+			TournamentID: env.TournamentID,
+			UserID:       env.UserID,
+			Presence:     env.Presence,
+			Disconnect:   env.Disconnect,
+		}
+		if env.Data != nil {
+			msg.Data = env.Data
+		}
+		b.deliver(msg)
+	}
+}
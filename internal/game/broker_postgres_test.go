@@ -0,0 +1,30 @@
+//go:build postgres
+
+package game
+
+import (
+	"testing"
+
+	"chess-app/internal/models"
+)
+
+// Run with a database: DATABASE_URL=... go test -tags postgres ./internal/game
+func TestHubsSharePostgresBroker(t *testing.T) {
+	db, err := models.ConnectDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsn, err := models.DatabaseDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	brokers := make([]*PostgresBroker, 2)
+	for i := range brokers {
+		if brokers[i], err = NewPostgresBroker(db, dsn); err != nil {
+			t.Fatal(err)
+		}
+		defer brokers[i].Close()
+	}
+	testSharedBroker(t, brokers[0], brokers[1])
+}
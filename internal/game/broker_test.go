@@ -0,0 +1,85 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// receiveJSON waits for a message on a client and compares it with want as
+// JSON, since messages from other instances arrive as raw JSON
+func receiveJSON(t *testing.T, c *Client, want interface{}) {
+	t.Helper()
+	select {
+	case got := <-c.Send:
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("got %s, want %s", gotJSON, wantJSON)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message, want %v", want)
+	}
+}
+
+// waitFor polls cond until it holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testSharedBroker checks that two hubs, as run by two server instances,
+// reach each other's clients through their brokers
+func testSharedBroker(t *testing.T, brokerA, brokerB Broker) {
+	a := startHub(t, brokerA)
+	b := startHub(t, brokerB)
+
+	player := b.Subscribe(1, 0, 10)
+	defer b.Unsubscribe(player)
+	fan := b.Subscribe(0, 3, 11)
+	defer b.Unsubscribe(fan)
+	stream := b.Subscribe(0, 0, 12)
+	defer b.Unsubscribe(stream)
+
+	type move struct {
+		Type string `json:"type"`
+		UCI  string `json:"uci"`
+	}
+	a.Broadcast(1, move{Type: "move", UCI: "e2e4"})
+	receiveJSON(t, player, move{Type: "move", UCI: "e2e4"})
+
+	a.BroadcastTournament(3, map[string]interface{}{"type": "standings"})
+	receiveJSON(t, fan, map[string]interface{}{"type": "standings"})
+
+	a.SendToUser(12, map[string]interface{}{"type": "notification", "id": 5})
+	receiveJSON(t, stream, map[string]interface{}{"type": "notification", "id": 5})
+
+	// Presence of a user connected to A is seen from B
+	watcher := a.Subscribe(2, 0, 20)
+	waitFor(t, "remote presence", func() bool {
+		p := b.Presence(20)
+		return p.Online && p.GameID == 2 && b.Watching(20, 2)
+	})
+	a.Unsubscribe(watcher)
+	waitFor(t, "remote disconnection", func() bool { return !b.Presence(20).Online })
+
+	// Disconnecting from A closes the connections on B
+	a.DisconnectUser(12)
+	waitClosed(t, stream)
+	select {
+	case <-player.Done():
+		t.Error("another user was disconnected")
+	default:
+	}
+}
+
+func TestHubsShareMemoryBroker(t *testing.T) {
+	broker := NewMemoryBroker()
+	testSharedBroker(t, broker, broker)
+}
@@ -0,0 +1,105 @@
+package game
+
+import (
+	"encoding/json"
+	"sync"
+
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventListener is notified after an event has been numbered and stored
+type EventListener func(gameID uint, event GameEvent)
+
+// OnEvent registers a listener called after every stored game event.
+// Listeners run on the caller's goroutine and must not block.
+func (s *Service) OnEvent(listener EventListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventListeners = append(s.eventListeners, listener)
+}
+
+func (s *Service) notifyEvent(gameID uint, event GameEvent) {
+	s.mu.RLock()
+	listeners := s.eventListeners
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(gameID, event)
+	}
+}
+
+// AppendEvent gives an event the game's next sequence number, stores it and
+// hands it to the event listeners. The game row is locked while numbering
+// so that concurrent events of a game get distinct numbers.
+func (s *Service) AppendEvent(gameID uint, event GameEvent) error {
+	unlock := s.lockEvents(gameID)
+	defer unlock()
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").First(&models.Game{}, gameID).Error; err != nil {
+			return err
+		}
+		return appendEvent(tx, gameID, event)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyEvent(gameID, event)
+	return nil
+}
+
+// appendEvent numbers and stores an event in a transaction that holds the
+// game row lock
+func appendEvent(tx *gorm.DB, gameID uint, event GameEvent) error {
+	var last uint64
+	if err := tx.Model(&models.GameEvent{}).Where("game_id = ?", gameID).
+		Select("COALESCE(MAX(seq), 0)").Scan(&last).Error; err != nil {
+		return err
+	}
+
+	header := event.header()
+	header.Seq = last + 1
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.GameEvent{
+		GameID:  gameID,
+		Seq:     header.Seq,
+		Type:    header.Type,
+		Payload: string(payload),
+	}).Error
+}
+
+// lockEvents makes the events of a game go from numbering to the listeners
+// one at a time in this process, so that listeners get them in sequence
+// order. The row lock alone orders the commits but not what follows them.
+// The returned function may be called more than once.
+func (s *Service) lockEvents(gameID uint) (unlock func()) {
+	mu := &s.eventMu[gameID%uint(len(s.eventMu))]
+	mu.Lock()
+	var once sync.Once
+	return func() { once.Do(mu.Unlock) }
+}
+
+// LastEventSeq returns the sequence number of a game's latest event, zero if
+// it has none
+func (s *Service) LastEventSeq(gameID uint) (uint64, error) {
+	var last uint64
+	err := s.db.Model(&models.GameEvent{}).Where("game_id = ?", gameID).
+		Select("COALESCE(MAX(seq), 0)").Scan(&last).Error
+	return last, err
+}
+
+// EventsSince returns up to limit events of a game after seq, in order
+func (s *Service) EventsSince(gameID uint, seq uint64, limit int) ([]models.GameEvent, error) {
+	var events []models.GameEvent
+	err := s.db.Where("game_id = ? AND seq > ?", gameID, seq).
+		Order("seq").Limit(limit).Find(&events).Error
+	return events, err
+}
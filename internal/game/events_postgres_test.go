@@ -0,0 +1,81 @@
+//go:build postgres
+
+package game
+
+import (
+	"sync"
+	"testing"
+
+	"chess-app/internal/models"
+)
+
+func TestEventsReachListenersInOrder(t *testing.T) {
+	db := testDB(t)
+	s := NewService(db)
+	game := activeGame(t, db)
+
+	var mu sync.Mutex
+	var seqs []uint64
+	s.OnEvent(func(gameID uint, event GameEvent) {
+		mu.Lock()
+		seqs = append(seqs, event.header().Seq)
+		mu.Unlock()
+	})
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.AppendEvent(game.ID, &BerserkEvent{EventHeader: EventHeader{Type: MsgBerserk}}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seqs) != n {
+		t.Fatalf("%d events published, want %d", len(seqs), n)
+	}
+	for i, seq := range seqs {
+		if seq != uint64(i+1) {
+			t.Fatalf("published in order %v", seqs)
+		}
+	}
+}
+
+func TestMoveStoresItsEvent(t *testing.T) {
+	db := testDB(t)
+	s := NewService(db)
+	game := activeGame(t, db)
+
+	var published []GameEvent
+	s.OnEvent(func(gameID uint, event GameEvent) { published = append(published, event) })
+
+	if _, err := s.MakeMove(game.ID, *game.WhitePlayerID, "e2e4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MakeMove(game.ID, *game.BlackPlayerID, "e7e5"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := s.EventsSince(game.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || len(published) != 2 {
+		t.Fatalf("%d events stored and %d published, want 2", len(events), len(published))
+	}
+	for i, event := range events {
+		if event.Type != MsgMove || event.Seq != uint64(i+1) || published[i].header().Seq != event.Seq {
+			t.Errorf("event %d: %+v", i, event)
+		}
+	}
+
+	var moves int64
+	db.Model(&models.Move{}).Where("game_id = ?", game.ID).Count(&moves)
+	if moves != 2 {
+		t.Errorf("%d moves stored, want 2", moves)
+	}
+}
@@ -27,6 +27,8 @@ func NewHandlerWithMatchmaking(service *Service, matchmakingService *Matchmaking
 
 type CreateGameRequest struct {
 	TimeControl int `json:"timeControl"` // Time in seconds per player (default: 600 = 10 minutes)
+	Increment   int `json:"increment"`   // Seconds added after each move (default: 0)
+	OpponentID  *uint `json:"opponentId"` // Challenge this user; otherwise anyone may join
 }
 
 // CreateGame creates a new game
@@ -39,7 +41,21 @@ func (h *Handler) CreateGame(c *gin.Context) {
 		req.TimeControl = 600
 	}
 
-	game, err := h.service.CreateGame(userID, req.TimeControl)
+	if req.OpponentID != nil {
+		game, err := h.service.CreateChallenge(userID, *req.OpponentID, req.TimeControl, req.Increment)
+		if err == ErrBlocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, game)
+		return
+	}
+
+	game, err := h.service.CreateGame(userID, req.TimeControl, req.Increment)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -77,7 +93,11 @@ func (h *Handler) JoinGame(c *gin.Context) {
 	
 	userID := c.MustGet("userID").(uint)
 
-	game, err := h.service.JoinGame(uint(gameID), userID)
+	game, err := h.service.AcceptChallenge(uint(gameID), userID)
+	if err == ErrBlocked || err == ErrNotInvited {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -104,11 +124,12 @@ func (h *Handler) GetGameHistory(c *gin.Context) {
 	c.JSON(http.StatusOK, moves)
 }
 
-// GetUserGames returns all games for the current user
+// GetUserGames returns all games for the current user, optionally filtered
+// with ?opening=<ECO code or name>
 func (h *Handler) GetUserGames(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	games, err := h.service.GetUserGames(userID)
+	games, err := h.service.GetUserGames(userID, GameFilter{Opening: c.Query("opening")})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -117,6 +138,19 @@ func (h *Handler) GetUserGames(c *gin.Context) {
 	c.JSON(http.StatusOK, games)
 }
 
+// GetOpeningStats returns the current user's results per opening
+func (h *Handler) GetOpeningStats(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	stats, err := h.service.GetUserOpeningStats(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
 // FindMatch starts matchmaking for the current user
 func (h *Handler) FindMatch(c *gin.Context) {
 	if h.matchmakingService == nil {
@@ -133,6 +167,18 @@ func (h *Handler) FindMatch(c *gin.Context) {
 		return
 	}
 
+	// Bot accounts only play the games they are challenged to
+	if userModel.IsBot {
+		c.JSON(http.StatusForbidden, gin.H{"error": "bot accounts cannot use matchmaking"})
+		return
+	}
+
+	// The matchmaking pool is rated
+	if !CanPlayRated(&userModel) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "verify your email to play rated games"})
+		return
+	}
+
 	// Try to find a match
 	matchedGame, err := h.matchmakingService.JoinQueue(userID, userModel.ELORating)
 	if err != nil {
@@ -181,6 +227,10 @@ func (h *Handler) GetQueueStatus(c *gin.Context) {
 	position := h.matchmakingService.GetQueuePosition(userID)
 
 	if position == -1 {
+		if game := h.matchmakingService.TakeMatch(userID); game != nil {
+			c.JSON(http.StatusOK, gin.H{"inQueue": false, "matched": true, "game": game})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"inQueue": false})
 	} else {
 		c.JSON(http.StatusOK, gin.H{
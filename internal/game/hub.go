@@ -1,94 +1,520 @@
 package game
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How often a hub tells the other instances it is alive
+	presenceHeartbeat = 30 * time.Second
+	// Presence from an instance not heard from for this long is dropped
+	presenceTimeout = 3 * presenceHeartbeat
+	// Activity is shared with other instances at most this often per user
+	presenceTouchInterval = time.Minute
+	// Messages waiting for a client's write pump. A client that falls this
+	// far behind is resynced or dropped.
+	clientQueueSize = 256
 )
 
 // Hub manages WebSocket connections for games
 type Hub struct {
-	mu      sync.RWMutex
-	clients map[uint]map[*Client]bool // gameID -> clients
-	broadcast chan *BroadcastMessage
+	mu                sync.RWMutex
+	clients           map[uint]map[*Client]bool  // gameID -> clients
+	tournamentClients map[uint]map[*Client]bool  // tournamentID -> clients
+	users             map[uint]map[*Client]bool  // userID -> clients, for presence
+	userStreams       map[uint]map[*Client]bool  // userID -> notification stream clients
+	lastActive        map[uint]time.Time         // userID -> last connection or message
+	remote            map[string]*remotePresence // instance -> presence on another server
+
+	instance string
+	broker   Broker
+
+	// Users whose presence changed since it was last published. Changes are
+	// coalesced so that a burst of connections or a slow broker never blocks
+	// registrations; publishPresence sends each user's latest state.
+	presenceMu    sync.Mutex
+	presenceDirty map[uint]bool
+	presenceReady chan struct{}
+
 	register   chan *Client
 	unregister chan *Client
 }
 
-// BroadcastMessage represents a message to broadcast to all clients in a game
+// BroadcastMessage represents a message to broadcast to all clients in a
+// game, in a tournament if TournamentID is set, or on a user's notification
+// stream if UserID is set. Messages carrying Presence or Disconnect are for
+// the hubs themselves.
 type BroadcastMessage struct {
-	GameID uint
-	Data   interface{}
+	GameID       uint
+	TournamentID uint
+	UserID       uint
+	Data         interface{}
+	Presence     *PresenceUpdate
+	Disconnect   bool // Close every connection of UserID
+}
+
+// PresenceUpdate is a user's presence on one server instance, shared with
+// the others through the broker. An update without a user is a heartbeat.
+type PresenceUpdate struct {
+	Instance   string    `json:"instance"`
+	UserID     uint      `json:"userId,omitempty"`
+	Online     bool      `json:"online,omitempty"`
+	GameIDs    []uint    `json:"gameIds,omitempty"` // Games the user has open
+	LastActive time.Time `json:"lastActive"`
+}
+
+// remotePresence is what another instance reported about its users
+type remotePresence struct {
+	seen  time.Time
+	users map[uint]PresenceUpdate
 }
 
-// Client represents a WebSocket client
+// Client represents a WebSocket client. It follows a game, a tournament if
+// TournamentID is set, or its user's notifications if neither is set.
+//
+// Send is never closed, so any goroutine may queue messages with trySend.
+// The connection ends when done is closed, which only close does.
 type Client struct {
-	GameID uint
-	UserID uint
-	Send   chan interface{}
-	Hub    *Hub
+	GameID       uint
+	TournamentID uint
+	UserID       uint
+	Send         chan interface{}
+	Hub          *Hub
+
+	done      chan struct{}
+	closeOnce sync.Once
+	// resync is signalled when messages were dropped for a client that can
+	// catch up with a sync
+	resync     chan struct{}
+	resyncable atomic.Bool
+}
+
+func newClient(hub *Hub, gameID, tournamentID, userID uint) *Client {
+	return &Client{
+		GameID:       gameID,
+		TournamentID: tournamentID,
+		UserID:       userID,
+		Send:         make(chan interface{}, clientQueueSize),
+		Hub:          hub,
+		done:         make(chan struct{}),
+		resync:       make(chan struct{}, 1),
+	}
 }
 
-// NewHub creates a new hub
+// close ends the client's connection. It is safe to call more than once.
+func (c *Client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// trySend queues a message without blocking. A client whose queue is full
+// is told to resync if it speaks protocol version 2 on a game socket, and
+// dropped otherwise.
+func (c *Client) trySend(message interface{}) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.Send <- message:
+		return true
+	default:
+	}
+
+	if c.resyncable.Load() {
+		select {
+		case c.resync <- struct{}{}:
+		default: // Already pending
+		}
+		return false
+	}
+	log.Printf("hub: dropping slow client of user %d", c.UserID)
+	c.close()
+	return false
+}
+
+// NewHub creates a hub for a single server instance
 func NewHub() *Hub {
-	return &Hub{
-		clients:   make(map[uint]map[*Client]bool),
-		broadcast: make(chan *BroadcastMessage),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+	return NewHubWithBroker(NewMemoryBroker())
+}
+
+// NewHubWithBroker creates a hub whose broadcasts go through broker, so that
+// hubs of other instances subscribed to it reach their clients as well
+func NewHubWithBroker(broker Broker) *Hub {
+	instance := make([]byte, 8)
+	rand.Read(instance)
+
+	h := &Hub{
+		clients:           make(map[uint]map[*Client]bool),
+		tournamentClients: make(map[uint]map[*Client]bool),
+		users:             make(map[uint]map[*Client]bool),
+		userStreams:       make(map[uint]map[*Client]bool),
+		lastActive:        make(map[uint]time.Time),
+		remote:            make(map[string]*remotePresence),
+		instance:          hex.EncodeToString(instance),
+		broker:            broker,
+		presenceDirty:     make(map[uint]bool),
+		presenceReady:     make(chan struct{}, 1),
+		register:          make(chan *Client),
+		unregister:        make(chan *Client),
 	}
+	broker.Subscribe(h.receive)
+	return h
 }
 
-// Run starts the hub
+// Run starts the hub. It owns the client maps' membership: clients join and
+// leave only through it. Broadcasts do not go through Run; they are fanned
+// out on the publisher's goroutine without blocking.
 func (h *Hub) Run() {
+	go h.publishPresence()
+
 	for {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
-			if h.clients[client.GameID] == nil {
-				h.clients[client.GameID] = make(map[*Client]bool)
+			topics, id := h.topicOf(client.GameID, client.TournamentID, client.UserID)
+			if topics[id] == nil {
+				topics[id] = make(map[*Client]bool)
+			}
+			topics[id][client] = true
+			if h.users[client.UserID] == nil {
+				h.users[client.UserID] = make(map[*Client]bool)
 			}
-			h.clients[client.GameID][client] = true
+			h.users[client.UserID][client] = true
+			h.lastActive[client.UserID] = time.Now()
 			h.mu.Unlock()
+			h.presenceChanged(client.UserID)
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if clients, ok := h.clients[client.GameID]; ok {
-				if _, ok := clients[client]; ok {
-					delete(clients, client)
-					close(client.Send)
-					if len(clients) == 0 {
-						delete(h.clients, client.GameID)
-					}
+			topics, id := h.topicOf(client.GameID, client.TournamentID, client.UserID)
+			if clients, ok := topics[id]; ok {
+				delete(clients, client)
+				if len(clients) == 0 {
+					delete(topics, id)
 				}
 			}
+			client.close()
+			h.removeUserClient(client)
 			h.mu.Unlock()
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			clients := h.clients[message.GameID]
-			clientsCopy := make([]*Client, 0, len(clients))
-			for client := range clients {
-				clientsCopy = append(clientsCopy, client)
-			}
-			h.mu.RUnlock()
-
-			for _, client := range clientsCopy {
-				select {
-				case client.Send <- message.Data:
-				default:
-					close(client.Send)
-					h.mu.Lock()
-					delete(h.clients[client.GameID], client)
-					h.mu.Unlock()
-				}
-			}
+			h.presenceChanged(client.UserID)
 		}
 	}
 }
 
+// Subscribe registers a client that is not a WebSocket, such as an HTTP
+// stream. The caller reads Send until Done is closed, then unsubscribes.
+func (h *Hub) Subscribe(gameID, tournamentID, userID uint) *Client {
+	client := newClient(h, gameID, tournamentID, userID)
+	h.register <- client
+	return client
+}
+
+// Unsubscribe removes a client added with Subscribe
+func (h *Hub) Unsubscribe(client *Client) {
+	h.unregister <- client
+}
+
+// Done is closed when the client's connection should end
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
 // Broadcast sends a message to all clients in a game
 func (h *Hub) Broadcast(gameID uint, data interface{}) {
-	h.broadcast <- &BroadcastMessage{
+	h.publish(&BroadcastMessage{
 		GameID: gameID,
 		Data:   data,
+	})
+}
+
+// BroadcastTournament sends a message to all clients following a tournament
+func (h *Hub) BroadcastTournament(tournamentID uint, data interface{}) {
+	h.publish(&BroadcastMessage{
+		TournamentID: tournamentID,
+		Data:         data,
+	})
+}
+
+// SendToUser sends a message to a user's notification streams
+func (h *Hub) SendToUser(userID uint, data interface{}) {
+	h.publish(&BroadcastMessage{
+		UserID: userID,
+		Data:   data,
+	})
+}
+
+// DisconnectUser closes every connection of a user, on all instances, such
+// as when they are banned
+func (h *Hub) DisconnectUser(userID uint) {
+	h.publish(&BroadcastMessage{
+		UserID:     userID,
+		Disconnect: true,
+	})
+}
+
+func (h *Hub) publish(msg *BroadcastMessage) {
+	if err := h.broker.Publish(msg); err != nil {
+		log.Printf("hub: failed to publish message: %v", err)
+	}
+}
+
+// receive takes a message from the broker, from this hub or another one,
+// and queues it for the local clients of its topic
+func (h *Hub) receive(msg *BroadcastMessage) {
+	if msg.Presence != nil {
+		h.applyPresence(*msg.Presence)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if msg.Disconnect {
+		for client := range h.users[msg.UserID] {
+			client.close()
+		}
+		return
+	}
+
+	topics, id := h.topicOf(msg.GameID, msg.TournamentID, msg.UserID)
+	for client := range topics[id] {
+		client.trySend(msg.Data)
+	}
+}
+
+// topicOf returns the client map and key for a game, a tournament or a
+// user's notification stream
+func (h *Hub) topicOf(gameID, tournamentID, userID uint) (map[uint]map[*Client]bool, uint) {
+	switch {
+	case gameID != 0:
+		return h.clients, gameID
+	case tournamentID != 0:
+		return h.tournamentClients, tournamentID
+	}
+	return h.userStreams, userID
+}
+
+// removeUserClient forgets a client's connection for presence. The caller
+// holds h.mu.
+func (h *Hub) removeUserClient(client *Client) {
+	clients := h.users[client.UserID]
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(h.users, client.UserID)
+	}
+}
+
+// localPresence describes a user's connections to this instance. The caller
+// holds h.mu.
+func (h *Hub) localPresence(userID uint) PresenceUpdate {
+	update := PresenceUpdate{Instance: h.instance, UserID: userID, LastActive: h.lastActive[userID]}
+	for client := range h.users[userID] {
+		update.Online = true
+		if client.GameID != 0 {
+			update.GameIDs = append(update.GameIDs, client.GameID)
+		}
 	}
+	return update
+}
+
+// presenceChanged marks a user's presence for publishing. It never blocks.
+func (h *Hub) presenceChanged(userIDs ...uint) {
+	h.presenceMu.Lock()
+	for _, userID := range userIDs {
+		h.presenceDirty[userID] = true
+	}
+	h.presenceMu.Unlock()
+
+	select {
+	case h.presenceReady <- struct{}{}:
+	default: // A flush is already pending
+	}
+}
+
+// publishPresence shares local presence changes with the other instances,
+// along with a regular heartbeat
+func (h *Hub) publishPresence() {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+
+	h.publish(&BroadcastMessage{Presence: &PresenceUpdate{Instance: h.instance}})
+	for {
+		select {
+		case <-h.presenceReady:
+			h.flushPresence()
+		case <-ticker.C:
+			h.publish(&BroadcastMessage{Presence: &PresenceUpdate{Instance: h.instance}})
+		}
+	}
+}
+
+// flushPresence publishes the current presence of every user marked by
+// presenceChanged. Their state is read now, so it is never older than the
+// change that marked them.
+func (h *Hub) flushPresence() {
+	h.presenceMu.Lock()
+	dirty := h.presenceDirty
+	h.presenceDirty = make(map[uint]bool)
+	h.presenceMu.Unlock()
+	if len(dirty) == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	updates := make([]PresenceUpdate, 0, len(dirty))
+	for userID := range dirty {
+		updates = append(updates, h.localPresence(userID))
+	}
+	h.mu.RUnlock()
+
+	for i := range updates {
+		h.publish(&BroadcastMessage{Presence: &updates[i]})
+	}
+}
+
+// applyPresence records presence reported by another instance. The first
+// message from a new instance makes this hub announce its own users to it.
+func (h *Hub) applyPresence(update PresenceUpdate) {
+	if update.Instance == h.instance {
+		return
+	}
+
+	h.mu.Lock()
+	r, known := h.remote[update.Instance]
+	if !known {
+		r = &remotePresence{users: make(map[uint]PresenceUpdate)}
+		h.remote[update.Instance] = r
+	}
+	r.seen = time.Now()
+	if update.UserID != 0 {
+		if update.Online {
+			r.users[update.UserID] = update
+		} else {
+			delete(r.users, update.UserID)
+		}
+	}
+	for instance, other := range h.remote {
+		if time.Since(other.seen) > presenceTimeout {
+			delete(h.remote, instance)
+		}
+	}
+	h.mu.Unlock()
+
+	if !known {
+		go h.announce()
+	}
+}
+
+// announce publishes the presence of every user connected to this instance
+func (h *Hub) announce() {
+	h.mu.RLock()
+	userIDs := make([]uint, 0, len(h.users))
+	for userID := range h.users {
+		userIDs = append(userIDs, userID)
+	}
+	h.mu.RUnlock()
+
+	h.presenceChanged(userIDs...)
+}
+
+// Presence describes a user's live WebSocket connections
+type Presence struct {
+	Online     bool      // At least one connection is open
+	GameID     uint      // Game the user is connected to as a player, if any
+	LastActive time.Time // Last connection or message received
+}
+
+// Presence returns what the hubs of all instances know of a user's
+// connections
+func (h *Hub) Presence(userID uint) Presence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.presenceLocked(userID)
+}
+
+// Presences returns the presence of several users at once, keyed by user ID
+func (h *Hub) Presences(userIDs []uint) map[uint]Presence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	presences := make(map[uint]Presence, len(userIDs))
+	for _, userID := range userIDs {
+		presences[userID] = h.presenceLocked(userID)
+	}
+	return presences
+}
+
+// presenceLocked builds a user's presence. The caller holds h.mu.
+func (h *Hub) presenceLocked(userID uint) Presence {
+	p := Presence{LastActive: h.lastActive[userID]}
+	for client := range h.users[userID] {
+		p.Online = true
+		if client.GameID > p.GameID {
+			p.GameID = client.GameID
+		}
+	}
+	for _, r := range h.remote {
+		update, ok := r.users[userID]
+		if !ok || time.Since(r.seen) > presenceTimeout {
+			continue
+		}
+		p.Online = true
+		for _, gameID := range update.GameIDs {
+			if gameID > p.GameID {
+				p.GameID = gameID
+			}
+		}
+		if update.LastActive.After(p.LastActive) {
+			p.LastActive = update.LastActive
+		}
+	}
+	return p
+}
+
+// Watching reports whether a user has a game's socket open on any instance
+func (h *Hub) Watching(userID, gameID uint) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients[gameID] {
+		if client.UserID == userID {
+			return true
+		}
+	}
+	for _, r := range h.remote {
+		if time.Since(r.seen) > presenceTimeout {
+			continue
+		}
+		for _, id := range r.users[userID].GameIDs {
+			if id == gameID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Touch records activity from a user
+func (h *Hub) Touch(userID uint) {
+	h.mu.Lock()
+	now := time.Now()
+	share := now.Sub(h.lastActive[userID]) > presenceTouchInterval
+	h.lastActive[userID] = now
+	h.mu.Unlock()
+
+	if share {
+		h.presenceChanged(userID)
+	}
+}
+
+// BroadcastEvent sends a numbered game event to all clients in the game. It
+// is registered as a Service event listener.
+func (h *Hub) BroadcastEvent(gameID uint, event GameEvent) {
+	h.Broadcast(gameID, event)
 }
@@ -0,0 +1,233 @@
+package game
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingBroker delivers hub messages like MemoryBroker but holds presence
+// messages until released, like a database that stopped answering
+type blockingBroker struct {
+	MemoryBroker
+	release chan struct{}
+}
+
+func (b *blockingBroker) Publish(msg *BroadcastMessage) error {
+	if msg.Presence != nil {
+		<-b.release
+	}
+	return b.MemoryBroker.Publish(msg)
+}
+
+func startHub(t *testing.T, broker Broker) *Hub {
+	t.Helper()
+	h := NewHubWithBroker(broker)
+	go h.Run()
+	return h
+}
+
+func waitClosed(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client was not closed")
+	}
+}
+
+func TestHubConcurrentClients(t *testing.T) {
+	const (
+		clients = 2000
+		games   = 20
+	)
+	h := startHub(t, NewMemoryBroker())
+
+	var wg sync.WaitGroup
+	var received atomic.Int64
+	start := make(chan struct{})
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			c := h.Subscribe(uint(i%games)+1, 0, uint(i)+1)
+			c.resyncable.Store(i%2 == 0)
+			stalled := i%10 == 0 // Never reads its queue
+			deadline := time.After(50 * time.Millisecond)
+		read:
+			for !stalled {
+				select {
+				case <-c.Send:
+					received.Add(1)
+				case <-c.resync:
+				case <-c.Done():
+					break read
+				case <-deadline:
+					break read
+				}
+			}
+			if stalled {
+				<-deadline
+			}
+			// Close concurrently with the hub, which closes again on unregister
+			go c.close()
+			h.Unsubscribe(c)
+		}(i)
+	}
+	// Broadcast until every client has left
+	done := make(chan struct{})
+	var broadcasters sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		broadcasters.Add(1)
+		go func() {
+			defer broadcasters.Done()
+			<-start
+			for n := 0; ; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				h.Broadcast(uint(n%games)+1, n)
+				h.SendToUser(uint(n%clients)+1, n)
+				h.Touch(uint(n%clients) + 1)
+			}
+		}()
+	}
+	close(start)
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("clients did not finish")
+	}
+	broadcasters.Wait()
+
+	if received.Load() == 0 {
+		t.Error("no client received a broadcast")
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.clients) != 0 || len(h.users) != 0 {
+		t.Errorf("clients left after unregistering: %d games, %d users", len(h.clients), len(h.users))
+	}
+}
+
+func TestHubDropsStalledClient(t *testing.T) {
+	h := startHub(t, NewMemoryBroker())
+	c := h.Subscribe(1, 0, 1)
+	defer h.Unsubscribe(c)
+
+	for i := 0; i < clientQueueSize; i++ {
+		if !c.trySend(i) {
+			t.Fatalf("message %d refused before the queue was full", i)
+		}
+	}
+	h.Broadcast(1, "overflow")
+	waitClosed(t, c)
+
+	// Later messages and closes are harmless
+	h.Broadcast(1, "after")
+	c.close()
+	if c.trySend("after") {
+		t.Error("closed client accepted a message")
+	}
+}
+
+func TestHubResyncsStalledV2Client(t *testing.T) {
+	h := startHub(t, NewMemoryBroker())
+	c := h.Subscribe(1, 0, 1)
+	defer h.Unsubscribe(c)
+	c.resyncable.Store(true)
+
+	for i := 0; i < clientQueueSize+10; i++ {
+		h.Broadcast(1, i)
+	}
+	select {
+	case <-c.resync:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no resync signal")
+	}
+	select {
+	case <-c.Done():
+		t.Fatal("resyncable client was dropped")
+	default:
+	}
+	if len(c.resync) != 0 {
+		t.Error("resync signalled more than once")
+	}
+}
+
+func TestHubRegistersWhilePresenceStalls(t *testing.T) {
+	broker := &blockingBroker{release: make(chan struct{})}
+	h := startHub(t, broker)
+	defer close(broker.release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5000; i++ {
+			c := h.Subscribe(0, 0, uint(i)+1)
+			h.Touch(uint(i) + 1)
+			h.Unsubscribe(c)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("registrations blocked behind presence publishing")
+	}
+}
+
+func TestHubDisconnectUser(t *testing.T) {
+	h := startHub(t, NewMemoryBroker())
+	game := h.Subscribe(1, 0, 7)
+	stream := h.Subscribe(0, 0, 7)
+	other := h.Subscribe(1, 0, 8)
+	defer h.Unsubscribe(other)
+
+	h.DisconnectUser(7)
+	waitClosed(t, game)
+	waitClosed(t, stream)
+	h.Unsubscribe(game)
+	h.Unsubscribe(stream)
+	select {
+	case <-other.Done():
+		t.Error("another user was disconnected")
+	default:
+	}
+}
+
+func TestHubPresences(t *testing.T) {
+	h := startHub(t, NewMemoryBroker())
+	playing := h.Subscribe(3, 0, 7)
+	stream := h.Subscribe(0, 0, 8)
+	defer h.Unsubscribe(playing)
+	defer h.Unsubscribe(stream)
+	waitFor(t, "registration", func() bool { return h.Presence(7).Online && h.Presence(8).Online })
+
+	presences := h.Presences([]uint{7, 8, 9})
+	if len(presences) != 3 {
+		t.Fatalf("%d presences, want 3", len(presences))
+	}
+	if p := presences[7]; !p.Online || p.GameID != 3 {
+		t.Errorf("user 7: %+v", p)
+	}
+	if p := presences[8]; !p.Online || p.GameID != 0 {
+		t.Errorf("user 8: %+v", p)
+	}
+	if presences[9].Online {
+		t.Error("user 9 online without a connection")
+	}
+	for _, id := range []uint{7, 8, 9} {
+		if presences[id] != h.Presence(id) {
+			t.Errorf("user %d: Presences and Presence disagree", id)
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package game
 
 import (
+	"log"
+	"math"
 	"sync"
 	"time"
 
 	"chess-app/internal/models"
-
-	"gorm.io/gorm"
 )
 
 const (
@@ -16,8 +16,20 @@ const (
 	MaxWaitTime = 30 * time.Second
 	// Expanded ELO range after max wait time
 	ExpandedELORange = 200
+	// How often the queue is checked for players waiting on a bot fallback
+	fallbackInterval = 2 * time.Second
 )
 
+// FallbackFunc creates a game for a player nobody was matched with
+type FallbackFunc func(userID uint, elo int) (*models.Game, error)
+
+// MatchFunc creates and starts the game between two matched players
+type MatchFunc func(whitePlayerID, blackPlayerID uint) (*models.Game, error)
+
+// MatchListener is notified when a game is found for a player who was
+// waiting in the queue
+type MatchListener func(userID uint, game *models.Game)
+
 // QueueEntry represents a player waiting in the matchmaking queue
 type QueueEntry struct {
 	UserID    uint
@@ -27,16 +39,100 @@ type QueueEntry struct {
 
 // MatchmakingService handles player matchmaking
 type MatchmakingService struct {
-	mu    sync.RWMutex
-	queue []QueueEntry
-	db    *gorm.DB
+	mu      sync.RWMutex
+	queue   []QueueEntry
+	matched map[uint]*models.Game // userID -> game found while the user was waiting
+	service *Service
+
+	// Rating ranges; pools that pair by arrival order set them to the maximum
+	matchRange    int
+	expandedRange int
+	createMatch   MatchFunc
+
+	listeners []MatchListener
 }
 
 // NewMatchmakingService creates a new matchmaking service
-func NewMatchmakingService(db *gorm.DB) *MatchmakingService {
+func NewMatchmakingService(service *Service) *MatchmakingService {
+	m := &MatchmakingService{
+		queue:         make([]QueueEntry, 0),
+		matched:       make(map[uint]*models.Game),
+		service:       service,
+		matchRange:    ELOMatchRange,
+		expandedRange: ExpandedELORange,
+	}
+	m.createMatch = m.createDefaultMatch
+	return m
+}
+
+// NewPool creates a queue separate from the main one that pairs waiting
+// players regardless of rating, e.g. the pairing pool of an arena
+// tournament. createMatch creates and starts each game.
+func NewPool(createMatch MatchFunc) *MatchmakingService {
 	return &MatchmakingService{
-		queue: make([]QueueEntry, 0),
-		db:    db,
+		queue:         make([]QueueEntry, 0),
+		matched:       make(map[uint]*models.Game),
+		matchRange:    math.MaxInt32,
+		expandedRange: math.MaxInt32,
+		createMatch:   createMatch,
+	}
+}
+
+// OnMatch registers a listener called when a waiting player gets a game,
+// either an opponent or the fallback. Listeners must not block.
+func (m *MatchmakingService) OnMatch(listener MatchListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// notifyMatch calls the match listeners. The caller holds m.mu.
+func (m *MatchmakingService) notifyMatch(userID uint, game *models.Game) {
+	for _, listener := range m.listeners {
+		listener(userID, game)
+	}
+}
+
+// createDefaultMatch creates a game with the default time control (10 minutes)
+func (m *MatchmakingService) createDefaultMatch(whitePlayerID, blackPlayerID uint) (*models.Game, error) {
+	game, err := m.service.CreateGame(whitePlayerID, 600, 0)
+	if err != nil {
+		return nil, err
+	}
+	return m.service.JoinGame(game.ID, blackPlayerID)
+}
+
+// StartFallback pairs players who have waited longer than wait using fallback
+// (typically a game against the bot). It blocks, so run it in a goroutine.
+func (m *MatchmakingService) StartFallback(wait time.Duration, fallback FallbackFunc) {
+	ticker := time.NewTicker(fallbackInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.mu.Lock()
+		remaining := m.queue[:0]
+		var expired []QueueEntry
+		for _, entry := range m.queue {
+			if time.Since(entry.EnteredAt) > wait {
+				expired = append(expired, entry)
+			} else {
+				remaining = append(remaining, entry)
+			}
+		}
+		m.queue = remaining
+		m.mu.Unlock()
+
+		for _, entry := range expired {
+			game, err := fallback(entry.UserID, entry.ELO)
+			if err != nil {
+				log.Printf("matchmaking fallback failed for user %d: %v", entry.UserID, err)
+				continue
+			}
+			m.mu.Lock()
+			m.matched[entry.UserID] = game
+			m.notifyMatch(entry.UserID, game)
+			m.mu.Unlock()
+		}
 	}
 }
 
@@ -56,32 +152,39 @@ func (m *MatchmakingService) JoinQueue(userID uint, elo int) (*models.Game, erro
 
 	// Try to find a match
 	for i, entry := range m.queue {
+		// Never pair users who blocked each other
+		if m.service != nil {
+			blocked, err := m.service.Blocked(userID, entry.UserID)
+			if err != nil {
+				return nil, err
+			}
+			if blocked {
+				continue
+			}
+		}
+
 		// Check ELO compatibility
 		eloDiff := abs(elo - entry.ELO)
 		waitTime := time.Since(entry.EnteredAt)
 
 		// Use expanded range if waited too long
-		maxDiff := ELOMatchRange
+		maxDiff := m.matchRange
 		if waitTime > MaxWaitTime {
-			maxDiff = ExpandedELORange
+			maxDiff = m.expandedRange
 		}
 
 		if eloDiff <= maxDiff {
 			// Found a match! Remove from queue and create game
 			m.queue = append(m.queue[:i], m.queue[i+1:]...)
 
-			// Create game with default time control (10 minutes)
-			gameService := NewService(m.db)
-			game, err := gameService.CreateGame(userID, 600)
+			game, err := m.createMatch(userID, entry.UserID)
 			if err != nil {
 				return nil, err
 			}
 
-			// Second player joins
-			game, err = gameService.JoinGame(game.ID, entry.UserID)
-			if err != nil {
-				return nil, err
-			}
+			// Let the waiting player pick the game up on their next status check
+			m.matched[entry.UserID] = game
+			m.notifyMatch(entry.UserID, game)
 
 			return game, nil
 		}
@@ -123,6 +226,17 @@ func (m *MatchmakingService) GetQueuePosition(userID uint) int {
 	return -1
 }
 
+// TakeMatch returns and forgets the game found for a player while they were
+// waiting in the queue, or nil if there is none
+func (m *MatchmakingService) TakeMatch(userID uint) *models.Game {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	game := m.matched[userID]
+	delete(m.matched, userID)
+	return game
+}
+
 func abs(x int) int {
 	if x < 0 {
 		return -x
@@ -0,0 +1,244 @@
+package game
+
+import (
+	"errors"
+
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrGameNotActive   = errors.New("game is not in progress")
+	ErrGameNotFinished = errors.New("game is not finished")
+	ErrAlreadyAnnulled = errors.New("game is already annulled")
+	ErrInvalidResult   = errors.New("result must be white_wins, black_wins or draw")
+)
+
+// TxHook runs inside the transaction of a change, so that what it writes,
+// such as an audit entry, is saved along with the change or not at all
+type TxHook func(tx *gorm.DB, game *models.Game) error
+
+// ForceEnd ends a game in progress with the given result, updating ratings
+// and stats as if it had been played out. It backs resignations as well as
+// moderators ending games.
+func (s *Service) ForceEnd(gameID uint, result models.GameResult, hook TxHook) (*models.Game, error) {
+	switch result {
+	case models.GameResultWhiteWins, models.GameResultBlackWins, models.GameResultDraw:
+	default:
+		return nil, ErrInvalidResult
+	}
+
+	unlock := s.lockEvents(gameID)
+	defer unlock()
+
+	var game models.Game
+	var event GameEvent
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockGame(tx, gameID, &game); err != nil {
+			return err
+		}
+		if game.Status != models.GameStatusActive {
+			return ErrGameNotActive
+		}
+		// Listeners expect the players, with the ratings the game was played at
+		if err := loadPlayers(tx, &game); err != nil {
+			return err
+		}
+
+		game.Status = models.GameStatusFinished
+		game.Result = result
+		if err := applyResult(tx, &game); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Save(&game).Error; err != nil {
+			return err
+		}
+		if err := runHook(hook, tx, &game); err != nil {
+			return err
+		}
+		event = NewGameEndEvent(&game)
+		return appendEvent(tx, game.ID, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyEvent(game.ID, event)
+	unlock()
+	s.notifyGameFinished(&game)
+	return &game, nil
+}
+
+// Annul cancels a finished game: the rating changes it caused are rolled
+// back and it no longer counts in either player's stats
+func (s *Service) Annul(gameID uint, hook TxHook) (*models.Game, error) {
+	unlock := s.lockEvents(gameID)
+	defer unlock()
+
+	var game models.Game
+	var event GameEvent
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockGame(tx, gameID, &game); err != nil {
+			return err
+		}
+		if game.Status != models.GameStatusFinished {
+			return ErrGameNotFinished
+		}
+		if game.Annulled {
+			return ErrAlreadyAnnulled
+		}
+		if err := loadPlayers(tx, &game); err != nil {
+			return err
+		}
+
+		if err := rollbackResult(tx, &game); err != nil {
+			return err
+		}
+		game.Annulled = true
+		game.Rated = false
+		if err := tx.Omit(clause.Associations).Save(&game).Error; err != nil {
+			return err
+		}
+		if err := runHook(hook, tx, &game); err != nil {
+			return err
+		}
+		event = NewGameEndEvent(&game)
+		return appendEvent(tx, game.ID, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyEvent(game.ID, event)
+	unlock()
+	s.notifyGameAnnulled(&game)
+	return &game, nil
+}
+
+// lockGame loads a game and locks its row until the transaction ends
+func lockGame(tx *gorm.DB, gameID uint, game *models.Game) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(game, gameID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrGameNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// loadPlayers fills in a game's players as they are now
+func loadPlayers(tx *gorm.DB, game *models.Game) error {
+	if game.WhitePlayerID != nil {
+		game.WhitePlayer = &models.User{}
+		if err := tx.First(game.WhitePlayer, *game.WhitePlayerID).Error; err != nil {
+			return err
+		}
+	}
+	if game.BlackPlayerID != nil {
+		game.BlackPlayer = &models.User{}
+		if err := tx.First(game.BlackPlayer, *game.BlackPlayerID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runHook(hook TxHook, tx *gorm.DB, game *models.Game) error {
+	if hook == nil {
+		return nil
+	}
+	return hook(tx, game)
+}
+
+// applyResult updates both players' ratings and stats for a game that just
+// finished, and records the rating changes on the game so that they can be
+// rolled back. Unrated games only count in stats.
+func applyResult(tx *gorm.DB, game *models.Game) error {
+	if game.WhitePlayerID == nil || game.BlackPlayerID == nil {
+		return nil
+	}
+	var whitePlayer, blackPlayer models.User
+	if err := tx.First(&whitePlayer, *game.WhitePlayerID).Error; err != nil {
+		return err
+	}
+	if err := tx.First(&blackPlayer, *game.BlackPlayerID).Error; err != nil {
+		return err
+	}
+
+	newWhiteELO, newBlackELO := CalculateELO(whitePlayer.ELORating, blackPlayer.ELORating, string(game.Result))
+	if !game.Rated {
+		newWhiteELO, newBlackELO = whitePlayer.ELORating, blackPlayer.ELORating
+	}
+	game.WhiteRatingDiff = newWhiteELO - whitePlayer.ELORating
+	game.BlackRatingDiff = newBlackELO - blackPlayer.ELORating
+
+	whiteUpdates := map[string]interface{}{
+		"elo_rating":   newWhiteELO,
+		"games_played": gorm.Expr("games_played + 1"),
+	}
+	if column := statColumn(game.Result, true); column != "" {
+		whiteUpdates[column] = gorm.Expr(column + " + 1")
+	}
+	if err := tx.Model(&whitePlayer).Updates(whiteUpdates).Error; err != nil {
+		return err
+	}
+
+	blackUpdates := map[string]interface{}{
+		"elo_rating":   newBlackELO,
+		"games_played": gorm.Expr("games_played + 1"),
+	}
+	if column := statColumn(game.Result, false); column != "" {
+		blackUpdates[column] = gorm.Expr(column + " + 1")
+	}
+	return tx.Model(&blackPlayer).Updates(blackUpdates).Error
+}
+
+// rollbackResult undoes applyResult. Ratings move back by the recorded
+// change, so that later games keep their effect.
+func rollbackResult(tx *gorm.DB, game *models.Game) error {
+	if game.WhitePlayerID == nil || game.BlackPlayerID == nil {
+		return nil
+	}
+	sides := []struct {
+		playerID uint
+		diff     int
+		white    bool
+	}{
+		{*game.WhitePlayerID, game.WhiteRatingDiff, true},
+		{*game.BlackPlayerID, game.BlackRatingDiff, false},
+	}
+	for _, side := range sides {
+		updates := map[string]interface{}{
+			"elo_rating":   gorm.Expr("GREATEST(elo_rating - ?, 0)", side.diff),
+			"games_played": gorm.Expr("GREATEST(games_played - 1, 0)"),
+		}
+		if column := statColumn(game.Result, side.white); column != "" {
+			updates[column] = gorm.Expr("GREATEST(" + column + " - 1, 0)")
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", side.playerID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statColumn returns the users column a result counts in for one side
+func statColumn(result models.GameResult, white bool) string {
+	switch result {
+	case models.GameResultDraw:
+		return "draws"
+	case models.GameResultWhiteWins:
+		if white {
+			return "wins"
+		}
+		return "losses"
+	case models.GameResultBlackWins:
+		if white {
+			return "losses"
+		}
+		return "wins"
+	}
+	return ""
+}
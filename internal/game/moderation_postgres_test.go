@@ -0,0 +1,144 @@
+//go:build postgres
+
+package game
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"chess-app/internal/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testDB migrates a schema of its own, dropped after the test, so that the
+// database in DATABASE_URL is left as it was.
+// Run with a database: DATABASE_URL=... go test -tags postgres ./internal/game
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn, err := models.DatabaseDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := models.ConnectDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("game_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+schema), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := models.AutoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// activeGame starts a rated game between two new players rated 1500
+func activeGame(t *testing.T, db *gorm.DB) *models.Game {
+	t.Helper()
+	var ids [2]uint
+	for i, name := range []string{"white", "black"} {
+		user := models.User{Username: name, Email: name + "@example.com", PasswordHash: "x", ELORating: 1500, EmailVerified: true}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = user.ID
+	}
+	game := models.Game{
+		WhitePlayerID: &ids[0],
+		BlackPlayerID: &ids[1],
+		Status:        models.GameStatusActive,
+		Rated:         true,
+		CurrentFEN:    "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		TimeControl:   300,
+	}
+	if err := db.Create(&game).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &game
+}
+
+func rating(t *testing.T, db *gorm.DB, userID uint) int {
+	t.Helper()
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.ELORating
+}
+
+func TestForceEndNotifiesWithPlayers(t *testing.T) {
+	db := testDB(t)
+	s := NewService(db)
+	game := activeGame(t, db)
+
+	var finished []*models.Game
+	s.OnGameFinished(func(g *models.Game) { finished = append(finished, g) })
+
+	if _, err := s.ForceEnd(game.ID, models.GameResultWhiteWins, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(finished) != 1 {
+		t.Fatalf("%d finish notifications, want 1", len(finished))
+	}
+	g := finished[0]
+	if g.WhitePlayer == nil || g.BlackPlayer == nil {
+		t.Fatal("listener got a game without its players")
+	}
+	// Listeners see the ratings the game was played at
+	if g.WhitePlayer.ELORating != 1500 || g.BlackPlayer.ELORating != 1500 {
+		t.Errorf("ratings %d and %d, want 1500", g.WhitePlayer.ELORating, g.BlackPlayer.ELORating)
+	}
+	if r := rating(t, db, *g.WhitePlayerID); r <= 1500 {
+		t.Errorf("winner rated %d after the game", r)
+	}
+}
+
+func TestAnnulNotifiesListeners(t *testing.T) {
+	db := testDB(t)
+	s := NewService(db)
+	game := activeGame(t, db)
+	if _, err := s.ForceEnd(game.ID, models.GameResultBlackWins, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var annulled []*models.Game
+	s.OnGameAnnulled(func(g *models.Game) { annulled = append(annulled, g) })
+
+	if _, err := s.Annul(game.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Annul(game.ID, nil); err != ErrAlreadyAnnulled {
+		t.Errorf("second annulment: got %v, want ErrAlreadyAnnulled", err)
+	}
+	if len(annulled) != 1 {
+		t.Fatalf("%d annulment notifications, want 1", len(annulled))
+	}
+	if g := annulled[0]; !g.Annulled || g.WhitePlayer == nil || g.BlackPlayer == nil {
+		t.Errorf("listener got %+v", g)
+	}
+	for _, id := range []uint{*game.WhitePlayerID, *game.BlackPlayerID} {
+		if r := rating(t, db, id); r != 1500 {
+			t.Errorf("player %d rated %d after the annulment, want 1500", id, r)
+		}
+	}
+}
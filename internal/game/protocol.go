@@ -0,0 +1,255 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+
+	"chess-app/internal/models"
+)
+
+// WebSocket protocol versions. Version 1 clients never say hello; version 2
+// clients open with a hello and use sequence numbers to resync.
+const (
+	ProtocolV1      = 1
+	ProtocolV2      = 2
+	ProtocolVersion = ProtocolV2
+)
+
+// Inbound message types
+const (
+	MsgHello = "hello"
+	MsgMove  = "move"
+	MsgSync  = "sync"
+	MsgPing  = "ping"
+)
+
+// Outbound message types
+const (
+	MsgWelcome   = "welcome"
+	MsgGameState = "game_state"
+	MsgBerserk   = "berserk"
+	MsgGameEnd   = "game_end"
+	MsgPong      = "pong"
+	MsgError     = "error"
+	MsgUnread    = "unread"
+	MsgResync    = "resync"
+)
+
+// Error codes sent in ErrorMessage
+const (
+	ErrCodeBadMessage         = "bad_message"         // Not JSON, or a required field is missing
+	ErrCodeUnknownType        = "unknown_type"        // No such message type
+	ErrCodeUnsupportedVersion = "unsupported_version" // The hello asked for a version the server does not speak
+	ErrCodeNotSupported       = "not_supported"       // The message makes no sense on this stream, e.g. a move on a tournament feed
+	ErrCodeNotInGame          = "not_in_game"
+	ErrCodeNotYourTurn        = "not_your_turn"
+	ErrCodeInvalidMove        = "invalid_move"
+	ErrCodeIllegalMove        = "illegal_move"
+	ErrCodeGameFinished       = "game_finished"
+	ErrCodeInternal           = "internal_error"
+)
+
+// maxSyncEvents bounds the events replayed by a sync; a client further
+// behind gets a snapshot instead
+const maxSyncEvents = 500
+
+// Envelope is the part common to every inbound message, read first to find
+// out its type
+type Envelope struct {
+	Type string `json:"type"`
+}
+
+// HelloMessage opens a version 2 session
+type HelloMessage struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+}
+
+// MoveMessage plays a move in UCI notation
+type MoveMessage struct {
+	Type string `json:"type"`
+	UCI  string `json:"uci"`
+}
+
+// SyncMessage asks for the events after Since, the last sequence number the
+// client has seen. Zero asks for a snapshot.
+type SyncMessage struct {
+	Type  string `json:"type"`
+	Since uint64 `json:"since"`
+}
+
+// PingMessage is an application-level keepalive, answered with a pong
+type PingMessage struct {
+	Type string `json:"type"`
+}
+
+// WelcomeMessage answers a hello with the version the session will use
+type WelcomeMessage struct {
+	Type     string `json:"type"`
+	Version  int    `json:"version"`
+	Versions []int  `json:"versions"` // Versions the server speaks
+}
+
+// PongMessage answers a ping
+type PongMessage struct {
+	Type string `json:"type"`
+}
+
+// ErrorMessage reports a request the server could not handle. Error is
+// the human-readable text, kept for version 1 clients.
+type ErrorMessage struct {
+	Type  string `json:"type"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// ResyncMessage tells a client that messages were dropped because it fell
+// behind, and that it should sync from the last sequence number it has
+type ResyncMessage struct {
+	Type string `json:"type"`
+}
+
+// UnreadMessage opens a notification stream with the unread count
+type UnreadMessage struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+// GameEvent is an outbound message about a game. The hub numbers each
+// game's events in order so that clients can detect gaps.
+type GameEvent interface {
+	header() *EventHeader
+}
+
+// EventHeader starts every game event
+type EventHeader struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+}
+
+func (h *EventHeader) header() *EventHeader { return h }
+
+// GameStateMessage is a snapshot of a game, sent on connection and in
+// answer to a sync that cannot be served from events. Seq is the last event
+// it includes.
+type GameStateMessage struct {
+	EventHeader
+	GameID        uint              `json:"gameId"`
+	FEN           string            `json:"fen"`
+	PGN           string            `json:"pgn"`
+	Status        models.GameStatus `json:"status"`
+	Result        models.GameResult `json:"result"`
+	WhitePlayerID *uint             `json:"whitePlayerId"`
+	BlackPlayerID *uint             `json:"blackPlayerId"`
+	TimeControl   int               `json:"timeControl"`
+	WhiteTimeLeft int               `json:"whiteTimeLeft"`
+	BlackTimeLeft int               `json:"blackTimeLeft"`
+	IsWhite       bool              `json:"isWhite"`
+}
+
+// MoveEvent is a committed move and the game state after it
+type MoveEvent struct {
+	EventHeader
+	Move          *models.Move      `json:"move"`
+	FEN           string            `json:"fen"`
+	PGN           string            `json:"pgn"`
+	Status        models.GameStatus `json:"status"`
+	Result        models.GameResult `json:"result"`
+	WhiteTimeLeft int               `json:"whiteTimeLeft"`
+	BlackTimeLeft int               `json:"blackTimeLeft"`
+}
+
+// BerserkEvent tells that a player halved their clock in an arena game
+type BerserkEvent struct {
+	EventHeader
+	PlayerID      uint `json:"playerId"`
+	WhiteTimeLeft int  `json:"whiteTimeLeft"`
+	BlackTimeLeft int  `json:"blackTimeLeft"`
+}
+
+// GameEndEvent tells that a game was resigned, ended by a moderator or
+// annulled
+type GameEndEvent struct {
+	EventHeader
+	Status          models.GameStatus `json:"status"`
+	Result          models.GameResult `json:"result"`
+	Annulled        bool              `json:"annulled"`
+	WhiteRatingDiff int               `json:"whiteRatingDiff"`
+	BlackRatingDiff int               `json:"blackRatingDiff"`
+}
+
+// NewGameEndEvent builds the event broadcast after a game was ended early
+// or annulled
+func NewGameEndEvent(game *models.Game) *GameEndEvent {
+	return &GameEndEvent{
+		EventHeader:     EventHeader{Type: MsgGameEnd},
+		Status:          game.Status,
+		Result:          game.Result,
+		Annulled:        game.Annulled,
+		WhiteRatingDiff: game.WhiteRatingDiff,
+		BlackRatingDiff: game.BlackRatingDiff,
+	}
+}
+
+// SyncResponse answers a sync with either the missed events, in order, or
+// a snapshot. Seq is the last event sent.
+type SyncResponse struct {
+	Type     string            `json:"type"`
+	Seq      uint64            `json:"seq"`
+	Events   []json.RawMessage `json:"events,omitempty"`
+	Snapshot *GameStateMessage `json:"snapshot,omitempty"`
+}
+
+// NewMoveEvent builds the event broadcast after a move
+func NewMoveEvent(game *models.Game, move *models.Move) *MoveEvent {
+	return &MoveEvent{
+		EventHeader:   EventHeader{Type: MsgMove},
+		Move:          move,
+		FEN:           game.CurrentFEN,
+		PGN:           game.PGN,
+		Status:        game.Status,
+		Result:        game.Result,
+		WhiteTimeLeft: game.WhiteTimeLeft,
+		BlackTimeLeft: game.BlackTimeLeft,
+	}
+}
+
+// newGameState builds a snapshot of a game for one of its players
+func newGameState(game *models.Game, seq uint64, userID uint) *GameStateMessage {
+	return &GameStateMessage{
+		EventHeader:   EventHeader{Type: MsgGameState, Seq: seq},
+		GameID:        game.ID,
+		FEN:           game.CurrentFEN,
+		PGN:           game.PGN,
+		Status:        game.Status,
+		Result:        game.Result,
+		WhitePlayerID: game.WhitePlayerID,
+		BlackPlayerID: game.BlackPlayerID,
+		TimeControl:   game.TimeControl,
+		WhiteTimeLeft: game.WhiteTimeLeft,
+		BlackTimeLeft: game.BlackTimeLeft,
+		IsWhite:       game.WhitePlayerID != nil && *game.WhitePlayerID == userID,
+	}
+}
+
+// newError builds an error message
+func newError(code string, err error) *ErrorMessage {
+	return &ErrorMessage{Type: MsgError, Code: code, Error: err.Error()}
+}
+
+// moveErrorCode maps a MakeMove error to its protocol code
+func moveErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrNotInGame):
+		return ErrCodeNotInGame
+	case errors.Is(err, ErrNotYourTurn):
+		return ErrCodeNotYourTurn
+	case errors.Is(err, ErrInvalidMove):
+		return ErrCodeInvalidMove
+	case errors.Is(err, ErrIllegalMove):
+		return ErrCodeIllegalMove
+	case errors.Is(err, ErrGameFinished):
+		return ErrCodeGameFinished
+	}
+	return ErrCodeInternal
+}
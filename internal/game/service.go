@@ -2,6 +2,9 @@ package game
 
 import (
 	"errors"
+	"regexp"
+	"strings"
+	"sync"
 
 	"chess-app/internal/chess"
 	"chess-app/internal/models"
@@ -17,10 +20,39 @@ var (
 	ErrIllegalMove    = errors.New("illegal move")
 	ErrNotYourTurn    = errors.New("not your turn")
 	ErrGameFinished   = errors.New("game is finished")
+	ErrBlocked        = errors.New("one of the players has blocked the other")
+	ErrNotInvited     = errors.New("this challenge is for another player")
+	ErrGameNotWaiting = errors.New("game is not waiting for players")
+)
+
+// MaxIncrement is the largest increment a time control may add per move,
+// in seconds
+const MaxIncrement = 180
+
+// GameListener is notified when a game changes state
+type GameListener func(game *models.Game)
+
+// MoveListener is notified after a move has been committed
+type MoveListener func(game *models.Game, move *models.Move)
+
+var (
+	ecoCodePattern = regexp.MustCompile(`^[A-Ea-e][0-9]{2}$`)
+	likeEscaper    = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 )
 
 type Service struct {
 	db *gorm.DB
+
+	mu              sync.RWMutex
+	challengeListeners []GameListener
+	startListeners  []GameListener
+	moveListeners   []MoveListener
+	finishListeners []GameListener
+	annulListeners  []GameListener
+	eventListeners  []EventListener
+
+	// Striped by game ID, see lockEvents
+	eventMu [64]sync.Mutex
 }
 
 // GetDB returns the database instance (for matchmaking service)
@@ -32,29 +64,161 @@ func NewService(db *gorm.DB) *Service {
 	return &Service{db: db}
 }
 
+// OnChallenge registers a listener called when a user challenges another
+// to a game
+func (s *Service) OnChallenge(listener GameListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.challengeListeners = append(s.challengeListeners, listener)
+}
+
+// OnGameStarted registers a listener called when a second player joins a game
+func (s *Service) OnGameStarted(listener GameListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startListeners = append(s.startListeners, listener)
+}
+
+// OnMove registers a listener called after every committed move.
+// Listeners run on the caller's goroutine and must not block.
+func (s *Service) OnMove(listener MoveListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moveListeners = append(s.moveListeners, listener)
+}
+
+// OnGameFinished registers a listener called once a game has a result
+func (s *Service) OnGameFinished(listener GameListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishListeners = append(s.finishListeners, listener)
+}
+
+// OnGameAnnulled registers a listener called once a finished game has been
+// annulled, so that whatever was derived from it can be dropped
+func (s *Service) OnGameAnnulled(listener GameListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.annulListeners = append(s.annulListeners, listener)
+}
+
+func (s *Service) notifyChallenge(game *models.Game) {
+	s.mu.RLock()
+	listeners := s.challengeListeners
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(game)
+	}
+}
+
+func (s *Service) notifyGameStarted(game *models.Game) {
+	s.mu.RLock()
+	listeners := s.startListeners
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(game)
+	}
+}
+
+func (s *Service) notifyMove(game *models.Game, move *models.Move) {
+	s.mu.RLock()
+	listeners := s.moveListeners
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(game, move)
+	}
+}
+
+func (s *Service) notifyGameFinished(game *models.Game) {
+	s.mu.RLock()
+	listeners := s.finishListeners
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(game)
+	}
+}
+
+func (s *Service) notifyGameAnnulled(game *models.Game) {
+	s.mu.RLock()
+	listeners := s.annulListeners
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(game)
+	}
+}
+
 // CreateGame creates a new game
-func (s *Service) CreateGame(whitePlayerID uint, timeControl int) (*models.Game, error) {
+func (s *Service) CreateGame(whitePlayerID uint, timeControl, increment int) (*models.Game, error) {
+	game := newGame(whitePlayerID, timeControl, increment)
+	if err := s.db.Create(game).Error; err != nil {
+		return nil, err
+	}
+
+	return game, nil
+}
+
+// StartGame creates a game with both players already seated, so that nobody
+// else can join it in between. botLevel is the strength of a bot opponent,
+// 0 if there is none.
+func (s *Service) StartGame(whitePlayerID, blackPlayerID uint, timeControl, increment, botLevel int) (*models.Game, error) {
+	if whitePlayerID == blackPlayerID {
+		return nil, errors.New("cannot join as both players")
+	}
+
+	var whitePlayer, blackPlayer models.User
+	if err := s.db.First(&whitePlayer, whitePlayerID).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.First(&blackPlayer, blackPlayerID).Error; err != nil {
+		return nil, err
+	}
+
+	game := newGame(whitePlayerID, timeControl, increment)
+	game.BlackPlayerID = &blackPlayerID
+	game.Status = models.GameStatusActive
+	game.BotLevel = botLevel
+	game.Rated = CanPlayRated(&whitePlayer) && CanPlayRated(&blackPlayer)
+	if err := s.db.Create(game).Error; err != nil {
+		return nil, err
+	}
+	game.WhitePlayer = &whitePlayer
+	game.BlackPlayer = &blackPlayer
+
+	s.notifyGameStarted(game)
+
+	return game, nil
+}
+
+// newGame returns a game at the starting position waiting for black
+func newGame(whitePlayerID uint, timeControl, increment int) *models.Game {
 	engine := chess.NewEngine()
 	
 	if timeControl <= 0 {
 		timeControl = 600 // Default 10 minutes
 	}
+	if increment < 0 {
+		increment = 0
+	}
+	if increment > MaxIncrement {
+		increment = MaxIncrement
+	}
 	
 	game := &models.Game{
 		WhitePlayerID: &whitePlayerID,
 		Status:        models.GameStatusWaiting,
 		CurrentFEN:    engine.GetFEN(),
 		TimeControl:   timeControl,
+		Increment:     increment,
 		WhiteTimeLeft: timeControl,
 		BlackTimeLeft: timeControl,
 		PGN:           "",
 	}
-
-	if err := s.db.Create(game).Error; err != nil {
-		return nil, err
-	}
-
-	return game, nil
+	return game
 }
 
 // GetGame retrieves a game by ID
@@ -77,23 +241,164 @@ func (s *Service) JoinGame(gameID uint, blackPlayerID uint) (*models.Game, error
 	}
 
 	if game.Status != models.GameStatusWaiting {
-		return nil, errors.New("game is not waiting for players")
+		return nil, ErrGameNotWaiting
 	}
 
 	if game.WhitePlayerID != nil && *game.WhitePlayerID == blackPlayerID {
 		return nil, errors.New("cannot join as both players")
 	}
 
+	var blackPlayer models.User
+	if err := s.db.First(&blackPlayer, blackPlayerID).Error; err != nil {
+		return nil, err
+	}
+
 	game.BlackPlayerID = &blackPlayerID
+	game.BlackPlayer = &blackPlayer
 	game.Status = models.GameStatusActive
+	game.Rated = CanPlayRated(game.WhitePlayer) && CanPlayRated(&blackPlayer)
 
 	if err := s.db.Save(game).Error; err != nil {
 		return nil, err
 	}
 
+	s.notifyGameStarted(game)
+
+	return game, nil
+}
+
+// CanPlayRated reports whether a user's games may change ratings: bots and
+// users with a verified email
+func CanPlayRated(user *models.User) bool {
+	return user != nil && (user.IsBot || user.EmailVerified)
+}
+
+// Blocked reports whether either user has blocked the other
+func (s *Service) Blocked(userA, userB uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Block{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userA, userB, userB, userA).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CreateChallenge creates a game that only opponentID may join
+func (s *Service) CreateChallenge(whitePlayerID, opponentID uint, timeControl, increment int) (*models.Game, error) {
+	if whitePlayerID == opponentID {
+		return nil, errors.New("cannot challenge yourself")
+	}
+	if err := s.db.First(&models.User{}, opponentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("opponent not found")
+		}
+		return nil, err
+	}
+	blocked, err := s.Blocked(whitePlayerID, opponentID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrBlocked
+	}
+
+	game, err := s.CreateGame(whitePlayerID, timeControl, increment)
+	if err != nil {
+		return nil, err
+	}
+	game.InvitedPlayerID = &opponentID
+	if err := s.db.Model(game).Update("invited_player_id", opponentID).Error; err != nil {
+		return nil, err
+	}
+
+	s.notifyChallenge(game)
+
 	return game, nil
 }
 
+// AcceptChallenge joins a waiting game created by another user, unless one
+// of them has blocked the other or the game is meant for someone else
+func (s *Service) AcceptChallenge(gameID uint, userID uint) (*models.Game, error) {
+	game, err := s.GetGame(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if game.InvitedPlayerID != nil && *game.InvitedPlayerID != userID {
+		return nil, ErrNotInvited
+	}
+	if game.WhitePlayerID != nil {
+		blocked, err := s.Blocked(*game.WhitePlayerID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, ErrBlocked
+		}
+	}
+	return s.JoinGame(gameID, userID)
+}
+
+// DeclineChallenge lets the invited player turn down a challenge. The
+// waiting game is deleted along with the notifications about it.
+func (s *Service) DeclineChallenge(gameID uint, userID uint) error {
+	game, err := s.GetGame(gameID)
+	if err != nil {
+		return err
+	}
+	if game.InvitedPlayerID == nil || *game.InvitedPlayerID != userID {
+		return ErrNotInvited
+	}
+	return s.deleteWaitingGame(game)
+}
+
+// CancelGame lets the creator of a game withdraw it while it is still
+// waiting for an opponent
+func (s *Service) CancelGame(gameID uint, userID uint) error {
+	game, err := s.GetGame(gameID)
+	if err != nil {
+		return err
+	}
+	if game.WhitePlayerID == nil || *game.WhitePlayerID != userID {
+		return ErrNotInGame
+	}
+	return s.deleteWaitingGame(game)
+}
+
+// deleteWaitingGame deletes a game nobody has joined, along with the
+// notifications about it
+func (s *Service) deleteWaitingGame(game *models.Game) error {
+	if game.Status != models.GameStatusWaiting {
+		return ErrGameNotWaiting
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND status = ?", game.ID, models.GameStatusWaiting).Delete(&models.Game{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrGameNotWaiting
+		}
+		return tx.Where("game_id = ?", game.ID).Delete(&models.Notification{}).Error
+	})
+}
+
+// Resign ends a game in progress with a win for the other player
+func (s *Service) Resign(gameID uint, userID uint) (*models.Game, error) {
+	game, err := s.GetGame(gameID)
+	if err != nil {
+		return nil, err
+	}
+	var result models.GameResult
+	switch {
+	case game.WhitePlayerID != nil && *game.WhitePlayerID == userID:
+		result = models.GameResultBlackWins
+	case game.BlackPlayerID != nil && *game.BlackPlayerID == userID:
+		result = models.GameResultWhiteWins
+	default:
+		return nil, ErrNotInGame
+	}
+	return s.ForceEnd(gameID, result, nil)
+}
+
 // MakeMove validates and applies a move
 func (s *Service) MakeMove(gameID uint, playerID uint, uci string) (*models.Move, error) {
 	// Get game
@@ -102,6 +407,11 @@ func (s *Service) MakeMove(gameID uint, playerID uint, uci string) (*models.Move
 		return nil, err
 	}
 
+	// Games can end before mate, by resignation or a moderator
+	if game.Status == models.GameStatusFinished {
+		return nil, ErrGameFinished
+	}
+
 	// Check if user is in the game
 	isWhite := game.WhitePlayerID != nil && *game.WhitePlayerID == playerID
 	isBlack := game.BlackPlayerID != nil && *game.BlackPlayerID == playerID
@@ -142,9 +452,9 @@ func (s *Service) MakeMove(gameID uint, playerID uint, uci string) (*models.Move
 		return nil, err
 	}
 
-	// Count existing moves for ply number
-	var moveCount int64
-	s.db.Model(&models.Move{}).Where("game_id = ?", gameID).Count(&moveCount)
+	// Events of a game are numbered and published one at a time
+	unlock := s.lockEvents(gameID)
+	defer unlock()
 
 	// Use transaction for atomicity
 	tx := s.db.Begin()
@@ -154,6 +464,28 @@ func (s *Service) MakeMove(gameID uint, playerID uint, uci string) (*models.Move
 		}
 	}()
 
+	// Lock the game so that a move made meanwhile is not overwritten
+	var current models.Game
+	if err := lockGame(tx, gameID, &current); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if current.Status == models.GameStatusFinished {
+		tx.Rollback()
+		return nil, ErrGameFinished
+	}
+	if current.CurrentFEN != game.CurrentFEN {
+		tx.Rollback()
+		return nil, ErrNotYourTurn
+	}
+
+	// Count existing moves for ply number
+	var moveCount int64
+	if err := tx.Model(&models.Move{}).Where("game_id = ?", gameID).Count(&moveCount).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	// Create move record
 	move := &models.Move{
 		GameID:       gameID,
@@ -170,6 +502,16 @@ func (s *Service) MakeMove(gameID uint, playerID uint, uci string) (*models.Move
 
 	// Update game state
 	game.CurrentFEN = engine.GetFEN()
+
+	// Classify the opening; each named position reached is deeper than the last
+	if opening := chess.ClassifyFEN(game.CurrentFEN); opening != nil {
+		game.ECO = opening.ECO
+		game.Opening = opening.Name
+	}
+	if game.ECO != "" {
+		engine.SetTag("ECO", game.ECO)
+		engine.SetTag("Opening", game.Opening)
+	}
 	game.PGN = engine.GetPGN() // Update PGN notation
 	outcomeStr := engine.GetOutcome()
 	if outcomeStr != "" {
@@ -177,48 +519,9 @@ func (s *Service) MakeMove(gameID uint, playerID uint, uci string) (*models.Move
 		game.Result = models.GameResult(outcomeStr)
 
 		// Update ELO ratings and stats
-		if game.WhitePlayerID != nil && game.BlackPlayerID != nil {
-			var whitePlayer, blackPlayer models.User
-			if err := tx.First(&whitePlayer, *game.WhitePlayerID).Error; err == nil {
-				if err := tx.First(&blackPlayer, *game.BlackPlayerID).Error; err == nil {
-					// Calculate new ELO ratings
-					newWhiteELO, newBlackELO := CalculateELO(
-						whitePlayer.ELORating,
-						blackPlayer.ELORating,
-						outcomeStr,
-					)
-
-					// Update white player
-					whiteUpdates := map[string]interface{}{
-						"elo_rating":   newWhiteELO,
-						"games_played": whitePlayer.GamesPlayed + 1,
-					}
-					switch models.GameResult(outcomeStr) {
-					case models.GameResultWhiteWins:
-						whiteUpdates["wins"] = whitePlayer.Wins + 1
-					case models.GameResultBlackWins:
-						whiteUpdates["losses"] = whitePlayer.Losses + 1
-					case models.GameResultDraw:
-						whiteUpdates["draws"] = whitePlayer.Draws + 1
-					}
-					tx.Model(&whitePlayer).Updates(whiteUpdates)
-
-					// Update black player
-					blackUpdates := map[string]interface{}{
-						"elo_rating":   newBlackELO,
-						"games_played": blackPlayer.GamesPlayed + 1,
-					}
-					switch models.GameResult(outcomeStr) {
-					case models.GameResultWhiteWins:
-						blackUpdates["losses"] = blackPlayer.Losses + 1
-					case models.GameResultBlackWins:
-						blackUpdates["wins"] = blackPlayer.Wins + 1
-					case models.GameResultDraw:
-						blackUpdates["draws"] = blackPlayer.Draws + 1
-					}
-					tx.Model(&blackPlayer).Updates(blackUpdates)
-				}
-			}
+		if err := applyResult(tx, game); err != nil {
+			tx.Rollback()
+			return nil, err
 		}
 	}
 
@@ -227,10 +530,24 @@ func (s *Service) MakeMove(gameID uint, playerID uint, uci string) (*models.Move
 		return nil, err
 	}
 
+	// The event is stored with the move, so that the log never lags behind
+	event := NewMoveEvent(game, move)
+	if err := appendEvent(tx, game.ID, event); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		return nil, err
 	}
 
+	s.notifyEvent(game.ID, event)
+	unlock()
+	s.notifyMove(game, move)
+	if game.Status == models.GameStatusFinished {
+		s.notifyGameFinished(game)
+	}
+
 	return move, nil
 }
 
@@ -243,10 +560,24 @@ func (s *Service) GetGameHistory(gameID uint) ([]models.Move, error) {
 	return moves, nil
 }
 
+// GameFilter narrows down a game listing
+type GameFilter struct {
+	Opening string // ECO code (e.g. "C50") or part of an opening name
+}
+
 // GetUserGames returns all games for a user
-func (s *Service) GetUserGames(userID uint) ([]models.Game, error) {
+func (s *Service) GetUserGames(userID uint, filter GameFilter) ([]models.Game, error) {
+	query := s.db.Where("white_player_id = ? OR black_player_id = ?", userID, userID)
+	if opening := strings.TrimSpace(filter.Opening); opening != "" {
+		if ecoCodePattern.MatchString(opening) {
+			query = query.Where("eco = ?", strings.ToUpper(opening))
+		} else {
+			query = query.Where("opening ILIKE ?", "%"+likeEscaper.Replace(opening)+"%")
+		}
+	}
+
 	var games []models.Game
-	if err := s.db.Where("white_player_id = ? OR black_player_id = ?", userID, userID).
+	if err := query.
 		Preload("WhitePlayer").
 		Preload("BlackPlayer").
 		Order("created_at DESC").
@@ -255,3 +586,38 @@ func (s *Service) GetUserGames(userID uint) ([]models.Game, error) {
 	}
 	return games, nil
 }
+
+// OpeningStats summarises a user's results with one opening
+type OpeningStats struct {
+	ECO     string `json:"eco"`
+	Opening string `json:"opening"`
+	Games   int    `json:"games"`
+	Wins    int    `json:"wins"`
+	Draws   int    `json:"draws"`
+	Losses  int    `json:"losses"`
+}
+
+// GetUserOpeningStats returns a user's results per opening over finished games
+func (s *Service) GetUserOpeningStats(userID uint) ([]OpeningStats, error) {
+	var stats []OpeningStats
+	err := s.db.Model(&models.Game{}).
+		Select(`eco, opening, COUNT(*) AS games,
+			SUM(CASE WHEN (white_player_id = @user AND result = @white) OR (black_player_id = @user AND result = @black) THEN 1 ELSE 0 END) AS wins,
+			SUM(CASE WHEN result = @draw THEN 1 ELSE 0 END) AS draws,
+			SUM(CASE WHEN (white_player_id = @user AND result = @black) OR (black_player_id = @user AND result = @white) THEN 1 ELSE 0 END) AS losses`,
+			map[string]interface{}{
+				"user":  userID,
+				"white": models.GameResultWhiteWins,
+				"black": models.GameResultBlackWins,
+				"draw":  models.GameResultDraw,
+			}).
+		Where("status = ? AND eco <> ''", models.GameStatusFinished).
+		Where("white_player_id = ? OR black_player_id = ?", userID, userID).
+		Group("eco, opening").
+		Order("games DESC").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
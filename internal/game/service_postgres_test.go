@@ -0,0 +1,45 @@
+//go:build postgres
+
+package game
+
+import (
+	"errors"
+	"testing"
+
+	"chess-app/internal/models"
+)
+
+func TestStartGameSeatsBothPlayers(t *testing.T) {
+	db := testDB(t)
+	s := NewService(db)
+	var ids [3]uint
+	for i, name := range []string{"white", "black", "other"} {
+		user := models.User{Username: name, Email: name + "@example.com", PasswordHash: "x", EmailVerified: true}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = user.ID
+	}
+
+	var started []*models.Game
+	s.OnGameStarted(func(g *models.Game) { started = append(started, g) })
+
+	game, err := s.StartGame(ids[0], ids[1], 300, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 1 || started[0].BlackPlayer == nil {
+		t.Fatalf("start notified %d times, or without players", len(started))
+	}
+
+	stored, err := s.GetGame(game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.GameStatusActive || *stored.BlackPlayerID != ids[1] || stored.BotLevel != 3 || !stored.Rated {
+		t.Errorf("stored %+v", stored)
+	}
+	if _, err := s.JoinGame(game.ID, ids[2]); !errors.Is(err, ErrGameNotWaiting) {
+		t.Errorf("third player joining got %v, want ErrGameNotWaiting", err)
+	}
+}
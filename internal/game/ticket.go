@@ -0,0 +1,103 @@
+package game
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"chess-app/internal/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// TicketTTL is how long a WebSocket ticket can be redeemed
+const TicketTTL = 30 * time.Second
+
+var (
+	ErrInvalidTicket = errors.New("invalid or expired ticket")
+	ErrSuspended     = errors.New("account suspended")
+)
+
+// IssueTicket creates a single-use ticket for userID to open the socket of
+// a game, of a tournament, or of their notification stream if both IDs are
+// zero. Game sockets are for the game's players only, and banned or
+// suspended users get no socket at all.
+func (s *Service) IssueTicket(userID, gameID, tournamentID uint) (string, time.Time, error) {
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	if user.Suspended(time.Now()) {
+		return "", time.Time{}, ErrSuspended
+	}
+
+	ticket := models.WSTicket{UserID: userID, ExpiresAt: time.Now().Add(TicketTTL)}
+	switch {
+	case gameID != 0:
+		game, err := s.GetGame(gameID)
+		if err != nil {
+			return "", time.Time{}, ErrGameNotFound
+		}
+		isWhite := game.WhitePlayerID != nil && *game.WhitePlayerID == userID
+		isBlack := game.BlackPlayerID != nil && *game.BlackPlayerID == userID
+		if !isWhite && !isBlack {
+			return "", time.Time{}, ErrNotInGame
+		}
+		ticket.GameID = &gameID
+	case tournamentID != 0:
+		if err := s.db.First(&models.Tournament{}, tournamentID).Error; err != nil {
+			return "", time.Time{}, err
+		}
+		ticket.TournamentID = &tournamentID
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	value := base64.RawURLEncoding.EncodeToString(raw)
+	ticket.TicketHash = hashTicket(value)
+
+	// Expired tickets are only useful until they expire
+	s.db.Where("expires_at < ?", time.Now()).Delete(&models.WSTicket{})
+
+	if err := s.db.Create(&ticket).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return value, ticket.ExpiresAt, nil
+}
+
+// RedeemTicket consumes a ticket for the given socket and returns its user.
+// A ticket is deleted by its first use, even one for another socket.
+func (s *Service) RedeemTicket(value string, gameID, tournamentID uint) (uint, error) {
+	var ticket models.WSTicket
+	result := s.db.Clauses(clause.Returning{}).
+		Where("ticket_hash = ?", hashTicket(value)).
+		Delete(&ticket)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(ticket.ExpiresAt) {
+		return 0, ErrInvalidTicket
+	}
+	if !sameID(ticket.GameID, gameID) || !sameID(ticket.TournamentID, tournamentID) {
+		return 0, ErrInvalidTicket
+	}
+	return ticket.UserID, nil
+}
+
+func hashTicket(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// sameID reports whether an optional ID matches id, zero meaning unset
+func sameID(stored *uint, id uint) bool {
+	if stored == nil {
+		return id == 0
+	}
+	return *stored == id
+}
@@ -2,37 +2,139 @@ package game
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
-	"chess-app/internal/auth"
 	"chess-app/internal/config"
+	"chess-app/internal/models"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins in development
-	},
-}
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+	// Time allowed between two messages or pongs from the peer
+	pongWait = 60 * time.Second
+	// Pings are sent often enough to keep the read deadline from expiring
+	pingPeriod = pongWait * 9 / 10
+	// Largest message accepted from the peer
+	maxMessageSize = 4096
+)
 
 // WSHandler handles WebSocket connections
 type WSHandler struct {
-	hub     *Hub
-	service *Service
-	config  *config.Config
+	hub      *Hub
+	service  *Service
+	config   *config.Config
+	upgrader websocket.Upgrader
 }
 
 func NewWSHandler(hub *Hub, service *Service, cfg *config.Config) *WSHandler {
-	return &WSHandler{
+	h := &WSHandler{
 		hub:     hub,
 		service: service,
 		config:  cfg,
 	}
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     h.checkOrigin,
+	}
+	return h
+}
+
+// checkOrigin accepts connections from the server's own origin, from the
+// configured origins, and from non-browser clients that send no Origin
+func (h *WSHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range h.config.AllowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// TicketRequest names the socket a ticket is for: a game, a tournament, or
+// the user's notification stream if neither is set
+type TicketRequest struct {
+	GameID       uint `json:"gameId"`
+	TournamentID uint `json:"tournamentId"`
+}
+
+// IssueTicket returns a single-use ticket to pass as ?ticket= when opening a
+// WebSocket, so that tokens stay out of URLs and logs
+func (h *WSHandler) IssueTicket(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req TicketRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.GameID != 0 && req.TournamentID != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a ticket is for a game or a tournament, not both"})
+		return
+	}
+
+	ticket, expiresAt, err := h.service.IssueTicket(userID, req.GameID, req.TournamentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrGameNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "tournament not found"})
+		case errors.Is(err, ErrNotInGame):
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a player in this game"})
+		case errors.Is(err, ErrSuspended):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expiresAt": expiresAt})
+}
+
+// redeemTicket consumes the request's ticket for a socket and returns its
+// user. It writes the error response itself.
+func (h *WSHandler) redeemTicket(c *gin.Context, gameID, tournamentID uint) (uint, bool) {
+	ticket := c.Query("ticket")
+	if ticket == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "ticket required"})
+		return 0, false
+	}
+
+	userID, err := h.service.RedeemTicket(ticket, gameID, tournamentID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidTicket) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return 0, false
+	}
+	return userID, true
 }
 
 // HandleWebSocket handles WebSocket connections for a game
@@ -44,21 +146,19 @@ func (h *WSHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	// Get user ID from token (passed as query parameter for WebSocket)
-	token := c.Query("token")
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "token required"})
+	userID, ok := h.redeemTicket(c, uint(gameID), 0)
+	if !ok {
 		return
 	}
 
-	claims, err := auth.ValidateToken(token, []byte(h.config.JWTSecret))
+	// Read the sequence number first so that the snapshot includes at
+	// least every event up to it
+	seq, err := h.service.LastEventSeq(uint(gameID))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	userID := claims.UserID
-
 	// Verify user is in the game
 	game, err := h.service.GetGame(uint(gameID))
 	if err != nil {
@@ -74,40 +174,80 @@ func (h *WSHandler) HandleWebSocket(c *gin.Context) {
 	}
 
 	// Upgrade to WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		return
 	}
 
 	// Create client
-	client := &Client{
-		GameID: uint(gameID),
-		UserID: userID,
-		Send:   make(chan interface{}, 256),
-		Hub:    h.hub,
+	client := newClient(h.hub, uint(gameID), 0, userID)
+
+	// Queue the initial game state before registering, so that it comes
+	// before any event broadcast in the meantime
+	client.Send <- newGameState(game, seq, userID)
+	h.hub.register <- client
+
+	// Start goroutines
+	go client.writePump(conn)
+	go client.readPump(conn, h.service, h.hub)
+}
+
+// HandleTournamentWebSocket streams a tournament's live updates (pairings
+// and standings) to any logged-in user
+func (h *WSHandler) HandleTournamentWebSocket(c *gin.Context) {
+	tournamentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tournament ID"})
+		return
 	}
 
-	// Register client
+	userID, ok := h.redeemTicket(c, 0, uint(tournamentID))
+	if !ok {
+		return
+	}
+
+	if err := h.service.GetDB().First(&models.Tournament{}, tournamentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tournament not found"})
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+
+	client := newClient(h.hub, 0, uint(tournamentID), userID)
 	h.hub.register <- client
 
-	// Send initial game state
-	initialState := gin.H{
-		"type":          "game_state",
-		"gameId":        game.ID,
-		"fen":           game.CurrentFEN,
-		"pgn":           game.PGN,
-		"status":        string(game.Status),
-		"result":        string(game.Result),
-		"whitePlayerId": game.WhitePlayerID,
-		"blackPlayerId": game.BlackPlayerID,
-		"timeControl":   game.TimeControl,
-		"whiteTimeLeft": game.WhiteTimeLeft,
-		"blackTimeLeft": game.BlackTimeLeft,
-		"isWhite":       isWhite,
-	}
-	client.Send <- initialState
+	go client.writePump(conn)
+	go client.readPump(conn, h.service, h.hub)
+}
+
+// HandleUserWebSocket streams the logged-in user's notifications. Each
+// connection starts with the number of unread notifications.
+func (h *WSHandler) HandleUserWebSocket(c *gin.Context) {
+	userID, ok := h.redeemTicket(c, 0, 0)
+	if !ok {
+		return
+	}
+
+	var unread int64
+	if err := h.service.GetDB().Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Count(&unread).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+
+	client := newClient(h.hub, 0, 0, userID)
+	client.Send <- &UnreadMessage{Type: MsgUnread, Count: unread}
+	h.hub.register <- client
 
-	// Start goroutines
 	go client.writePump(conn)
 	go client.readPump(conn, h.service, h.hub)
 }
@@ -119,60 +259,184 @@ func (c *Client) readPump(conn *websocket.Conn, service *Service, hub *Hub) {
 		conn.Close()
 	}()
 
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	session := &session{client: c, service: service, version: ProtocolV1}
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		hub.Touch(c.UserID)
 
-		var msg map[string]interface{}
-		if err := json.Unmarshal(message, &msg); err != nil {
-			continue
+		if !session.handle(message) {
+			break
 		}
+	}
+}
 
-		// Handle move message
-		if msgType, ok := msg["type"].(string); ok && msgType == "move" {
-			if uci, ok := msg["uci"].(string); ok {
-				move, err := service.MakeMove(c.GameID, c.UserID, uci)
-				if err != nil {
-					errorMsg := gin.H{
-						"type":  "error",
-						"error": err.Error(),
-					}
-					c.Send <- errorMsg
-					continue
-				}
+// session is the protocol state of one connection, owned by its read pump
+type session struct {
+	client  *Client
+	service *Service
+	version int // Version 1 until the client says hello
+}
 
-				// Broadcast move to all clients
-				game, _ := service.GetGame(c.GameID)
-				moveMsg := gin.H{
-					"type":          "move",
-					"move":          move,
-					"fen":           game.CurrentFEN,
-					"pgn":           game.PGN,
-					"status":        string(game.Status),
-					"result":        string(game.Result),
-					"whiteTimeLeft": game.WhiteTimeLeft,
-					"blackTimeLeft": game.BlackTimeLeft,
-				}
-				hub.Broadcast(c.GameID, moveMsg)
-			}
+// handle answers one inbound message. It returns false when the connection
+// should be closed.
+func (s *session) handle(message []byte) bool {
+	var env Envelope
+	if err := json.Unmarshal(message, &env); err != nil || env.Type == "" {
+		s.fail(ErrCodeBadMessage, errors.New("message must be a JSON object with a type"))
+		return true
+	}
+
+	switch env.Type {
+	case MsgHello:
+		var hello HelloMessage
+		if err := json.Unmarshal(message, &hello); err != nil {
+			s.fail(ErrCodeBadMessage, err)
+			return true
+		}
+		if hello.Version != ProtocolV1 && hello.Version != ProtocolV2 {
+			s.fail(ErrCodeUnsupportedVersion, fmt.Errorf("protocol version %d is not supported", hello.Version))
+			return false
+		}
+		s.version = hello.Version
+		// Version 2 game clients can catch up after messages were dropped
+		s.client.resyncable.Store(s.version >= ProtocolV2 && s.client.GameID != 0)
+		s.send(&WelcomeMessage{Type: MsgWelcome, Version: s.version, Versions: []int{ProtocolV1, ProtocolV2}})
+
+	case MsgMove:
+		var move MoveMessage
+		if err := json.Unmarshal(message, &move); err != nil || move.UCI == "" {
+			s.fail(ErrCodeBadMessage, errors.New("move requires a uci field"))
+			return true
+		}
+		// Tournament and notification clients only listen
+		if s.client.GameID == 0 {
+			s.fail(ErrCodeNotSupported, errors.New("moves can only be sent on a game socket"))
+			return true
+		}
+		// Committed moves are broadcast by the hub's event listener
+		if _, err := s.service.MakeMove(s.client.GameID, s.client.UserID, move.UCI); err != nil {
+			s.fail(moveErrorCode(err), err)
+		}
+
+	case MsgSync:
+		if s.version < ProtocolV2 {
+			s.fail(ErrCodeUnknownType, errors.New("sync requires protocol version 2"))
+			return true
+		}
+		var sync SyncMessage
+		if err := json.Unmarshal(message, &sync); err != nil {
+			s.fail(ErrCodeBadMessage, err)
+			return true
+		}
+		if s.client.GameID == 0 {
+			s.fail(ErrCodeNotSupported, errors.New("sync is only available on a game socket"))
+			return true
+		}
+		response, err := s.sync(sync.Since)
+		if err != nil {
+			s.fail(ErrCodeInternal, err)
+			return true
 		}
+		s.send(response)
+
+	case MsgPing:
+		if s.version < ProtocolV2 {
+			s.fail(ErrCodeUnknownType, errors.New("ping requires protocol version 2"))
+			return true
+		}
+		s.send(&PongMessage{Type: MsgPong})
+
+	default:
+		s.fail(ErrCodeUnknownType, fmt.Errorf("unknown message type %q", env.Type))
+	}
+	return true
+}
+
+// sync returns the events after since, or a snapshot when since is zero,
+// ahead of the game, or too far behind
+func (s *session) sync(since uint64) (*SyncResponse, error) {
+	gameID := s.client.GameID
+	last, err := s.service.LastEventSeq(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	if since > 0 && since <= last && last-since <= maxSyncEvents {
+		events, err := s.service.EventsSince(gameID, since, maxSyncEvents)
+		if err != nil {
+			return nil, err
+		}
+		response := &SyncResponse{Type: MsgSync, Seq: since}
+		for _, event := range events {
+			response.Events = append(response.Events, json.RawMessage(event.Payload))
+			response.Seq = event.Seq
+		}
+		return response, nil
+	}
+
+	game, err := s.service.GetGame(gameID)
+	if err != nil {
+		return nil, err
 	}
+	return &SyncResponse{Type: MsgSync, Seq: last, Snapshot: newGameState(game, last, s.client.UserID)}, nil
+}
+
+func (s *session) fail(code string, err error) {
+	s.send(newError(code, err))
+}
+
+// send queues a reply to this client only
+func (s *session) send(message interface{}) {
+	s.client.trySend(message)
 }
 
 func (c *Client) writePump(conn *websocket.Conn) {
-	defer conn.Close()
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
 
 	for {
 		select {
-		case message, ok := <-c.Send:
-			if !ok {
-				conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.done:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+
+		case message := <-c.Send:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteJSON(message); err != nil {
 				return
 			}
 
-			if err := conn.WriteJSON(message); err != nil {
+		case <-c.resync:
+			// Flush what was queued before the drop first, so that the
+			// resync follows every message the client got before the gap
+			for n := len(c.Send); n > 0; n-- {
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := conn.WriteJSON(<-c.Send); err != nil {
+					return
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteJSON(&ResyncMessage{Type: MsgResync}); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
@@ -0,0 +1,48 @@
+package game
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWritePumpSendsResyncAfterQueue(t *testing.T) {
+	c := newClient(nil, 1, 0, 1)
+	defer c.close()
+	const queued = 20
+	for i := 0; i < queued; i++ {
+		c.Send <- i
+	}
+	c.resync <- struct{}{}
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c.writePump(conn)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i <= queued; i++ {
+		var message json.RawMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		resync := strings.Contains(string(message), MsgResync)
+		if resync != (i == queued) {
+			t.Fatalf("message %d is %s", i, message)
+		}
+	}
+}
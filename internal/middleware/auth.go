@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -10,8 +11,11 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// JWTAuthMiddleware validates JWT tokens
-func JWTAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+// JWTAuthMiddleware validates JWT access tokens. Given scopes, it also
+// accepts personal access tokens that have one of them; without scopes the
+// routes need a JWT, so that a token only reaches routes that declare what
+// it may do.
+func JWTAuthMiddleware(cfg *config.Config, tokens *auth.Service, scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -32,6 +36,11 @@ func JWTAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		if strings.HasPrefix(token, auth.APITokenPrefix) {
+			apiTokenAuth(c, tokens, token, scopes)
+			return
+		}
+
 		// Validate access token
 		claims, err := auth.ValidateAccessToken(token, []byte(cfg.JWTSecret))
 		if err != nil {
@@ -45,3 +54,38 @@ func JWTAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// apiTokenAuth authenticates a request made with a personal access token
+func apiTokenAuth(c *gin.Context, tokens *auth.Service, value string, scopes []string) {
+	if len(scopes) == 0 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this route does not accept personal access tokens"})
+		return
+	}
+
+	token, user, err := tokens.AuthenticateAPIToken(value)
+	if err != nil {
+		if errors.Is(err, auth.ErrAccountBanned) || errors.Is(err, auth.ErrAccountSuspended) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		}
+		return
+	}
+
+	allowed := false
+	for _, scope := range scopes {
+		allowed = allowed || token.Scopes.Has(scope)
+	}
+	if !allowed {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":  "token is missing a required scope",
+			"scopes": scopes,
+		})
+		return
+	}
+
+	c.Set("userID", user.ID)
+	c.Set("username", user.Username)
+	c.Set("apiTokenID", token.ID)
+	c.Next()
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chess-app/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedEngine serves /ping behind a limit of one request per address, with
+// the proxies trusted as the server does
+func limitedEngine(t *testing.T, trustedProxies []string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	limit := ratelimit.Limit{Requests: 1, Period: time.Hour}
+	r.Use(RateLimitMiddleware(ratelimit.NewMemoryStore(), "test", limit, ByIP))
+	r.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, ByIP(c)) })
+	return r
+}
+
+func get(r *gin.Engine, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.Header.Set("X-Real-IP", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestByIPIgnoresSpoofedForwardedFor(t *testing.T) {
+	r := limitedEngine(t, nil)
+
+	w := get(r, "203.0.113.7:40000", "198.51.100.1")
+	if w.Code != http.StatusOK || w.Body.String() != "ip:203.0.113.7" {
+		t.Fatalf("got %d %q, want the connection's address", w.Code, w.Body.String())
+	}
+	// A new forwarded address does not buy a fresh bucket
+	if w := get(r, "203.0.113.7:40001", "198.51.100.2"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For got %d, want 429", w.Code)
+	}
+}
+
+func TestByIPBelievesTrustedProxy(t *testing.T) {
+	r := limitedEngine(t, []string{"10.0.0.0/8"})
+
+	w := get(r, "10.1.2.3:40000", "198.51.100.1")
+	if w.Code != http.StatusOK || w.Body.String() != "ip:198.51.100.1" {
+		t.Fatalf("got %d %q, want the forwarded address", w.Code, w.Body.String())
+	}
+	// Clients behind the proxy have buckets of their own
+	if w := get(r, "10.1.2.3:40001", "198.51.100.2"); w.Code != http.StatusOK {
+		t.Errorf("second client behind the proxy got %d", w.Code)
+	}
+	if w := get(r, "10.1.2.3:40002", "198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("first client again got %d, want 429", w.Code)
+	}
+}
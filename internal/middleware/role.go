@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"chess-app/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole lets through users who have role or a more powerful one. It
+// must run after JWTAuthMiddleware. The role is read from the database on
+// every request, so that a demotion or a ban applies at once.
+func RequireRole(users *auth.Service, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := users.GetUserByID(c.MustGet("userID").(uint))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		if !user.HasRole(role) || user.Suspended(time.Now()) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			return
+		}
+
+		c.Set("role", user.Role)
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// ReportStatus is where a report stands
+type ReportStatus string
+
+const (
+	ReportStatusOpen      ReportStatus = "open"
+	ReportStatusResolved  ReportStatus = "resolved"  // Action was taken
+	ReportStatusDismissed ReportStatus = "dismissed" // Nothing wrong was found
+)
+
+// Report is a complaint from a user about another user, possibly about one
+// of their games
+type Report struct {
+	ID             uint         `gorm:"primaryKey" json:"id"`
+	ReporterID     uint         `gorm:"index;not null" json:"reporterId"`
+	ReportedUserID uint         `gorm:"index;not null" json:"reportedUserId"`
+	GameID         *uint        `gorm:"index" json:"gameId,omitempty"`
+	Reason         string       `gorm:"not null" json:"reason"` // e.g. "cheating", "abuse"
+	Details        string       `gorm:"type:text" json:"details"`
+	Status         ReportStatus `gorm:"index;not null;default:'open'" json:"status"`
+	ResolvedByID   *uint        `json:"resolvedById,omitempty"`
+	ResolvedAt     *time.Time   `json:"resolvedAt,omitempty"`
+	Resolution     string       `gorm:"type:text" json:"resolution,omitempty"` // Moderator's note
+	CreatedAt      time.Time    `json:"createdAt"`
+
+	// Relations
+	Reporter     *User `gorm:"foreignKey:ReporterID" json:"reporter,omitempty"`
+	ReportedUser *User `gorm:"foreignKey:ReportedUserID" json:"reportedUser,omitempty"`
+}
+
+// AuditLog records an action of a moderator or admin. Details holds the
+// action's parameters and the values it replaced.
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ActorID    uint      `gorm:"index;not null" json:"actorId"`
+	Action     string    `gorm:"index;not null" json:"action"`
+	TargetType string    `gorm:"not null" json:"targetType"` // "user", "game" or "report"
+	TargetID   uint      `gorm:"not null" json:"targetId"`
+	Reason     string    `gorm:"type:text" json:"reason"`
+	Details    JSONMap   `gorm:"type:text" json:"details"`
+	CreatedAt  time.Time `gorm:"index" json:"createdAt"`
+
+	// Relations
+	Actor *User `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+}
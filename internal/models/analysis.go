@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+)
+
+// AnalysisStatus represents the progress of a post-game analysis
+type AnalysisStatus string
+
+const (
+	AnalysisStatusPending AnalysisStatus = "pending" // Queued, not started yet
+	AnalysisStatusRunning AnalysisStatus = "running" // Being evaluated
+	AnalysisStatusDone    AnalysisStatus = "done"    // All positions evaluated
+	AnalysisStatusFailed  AnalysisStatus = "failed"  // Evaluation failed, see Error
+)
+
+// MoveClassification grades a move by how much it worsened the mover's position
+type MoveClassification string
+
+const (
+	MoveClassificationNone       MoveClassification = ""
+	MoveClassificationInaccuracy MoveClassification = "inaccuracy"
+	MoveClassificationMistake    MoveClassification = "mistake"
+	MoveClassificationBlunder    MoveClassification = "blunder"
+)
+
+// GameAnalysis holds the engine analysis summary of a finished game
+type GameAnalysis struct {
+	ID                uint           `gorm:"primaryKey" json:"id"`
+	GameID            uint           `gorm:"uniqueIndex;not null" json:"gameId"`
+	Status            AnalysisStatus `gorm:"not null;default:'pending'" json:"status"`
+	Engine            string         `json:"engine"` // Name of the engine that produced the evaluations
+	WhiteAccuracy     float64        `json:"whiteAccuracy"` // 0-100
+	BlackAccuracy     float64        `json:"blackAccuracy"` // 0-100
+	WhiteInaccuracies int            `json:"whiteInaccuracies"`
+	WhiteMistakes     int            `json:"whiteMistakes"`
+	WhiteBlunders     int            `json:"whiteBlunders"`
+	BlackInaccuracies int            `json:"blackInaccuracies"`
+	BlackMistakes     int            `json:"blackMistakes"`
+	BlackBlunders     int            `json:"blackBlunders"`
+	Error             string         `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt         time.Time      `json:"createdAt"`
+	UpdatedAt         time.Time      `json:"updatedAt"`
+
+	// Relations
+	Game  *Game          `gorm:"foreignKey:GameID" json:"-"`
+	Moves []MoveAnalysis `gorm:"foreignKey:GameID;references:GameID" json:"moves,omitempty"`
+}
+
+// MoveAnalysis holds the engine evaluation of a single ply
+type MoveAnalysis struct {
+	ID             uint               `gorm:"primaryKey" json:"id"`
+	GameID         uint               `gorm:"index:idx_move_analyses_game_ply;not null" json:"gameId"`
+	PlyNumber      int                `gorm:"index:idx_move_analyses_game_ply;not null" json:"plyNumber"`
+	Move           string             `gorm:"not null" json:"move"`     // Move played (UCI)
+	BestMove       string             `json:"bestMove"`                 // Engine's preferred move in the position before (UCI)
+	EvalCP         int                `json:"evalCp"`                   // Evaluation after the move, in centipawns from white's point of view
+	EvalMate       *int               `json:"evalMate,omitempty"`       // Mate distance after the move from white's point of view, if any
+	Accuracy       float64            `json:"accuracy"`                 // 0-100, how close the move was to the best move
+	Classification MoveClassification `gorm:"default:''" json:"classification"`
+}
@@ -13,17 +13,9 @@ import (
 
 // ConnectDatabase connects to PostgreSQL using environment variables
 func ConnectDatabase() (*gorm.DB, error) {
-	databaseURL := os.Getenv("SCALINGO_POSTGRESQL_URL")
-	if databaseURL == "" {
-		databaseURL = os.Getenv("DATABASE_URL")
-	}
-	if databaseURL == "" {
-		return nil, fmt.Errorf("DATABASE_URL or SCALINGO_POSTGRESQL_URL is required")
-	}
-
-	dsn, err := parseDatabaseURL(databaseURL)
+	dsn, err := DatabaseDSN()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse database URL: %w", err)
+		return nil, err
 	}
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
@@ -40,18 +32,134 @@ func AutoMigrate(db *gorm.DB) error {
 	// In production, use proper migrations
 	if os.Getenv("ENV") != "production" {
 		// Drop tables in reverse order of dependencies
+		db.Exec("DROP TABLE IF EXISTS audit_logs CASCADE")
+		db.Exec("DROP TABLE IF EXISTS reports CASCADE")
+		db.Exec("DROP TABLE IF EXISTS rate_limit_buckets CASCADE")
+		db.Exec("DROP TABLE IF EXISTS ws_tickets CASCADE")
+		db.Exec("DROP TABLE IF EXISTS game_events CASCADE")
+		db.Exec("DROP TABLE IF EXISTS notifications CASCADE")
+		db.Exec("DROP TABLE IF EXISTS blocks CASCADE")
+		db.Exec("DROP TABLE IF EXISTS follows CASCADE")
+		db.Exec("DROP TABLE IF EXISTS friendships CASCADE")
+		db.Exec("DROP TABLE IF EXISTS tournament_pairings CASCADE")
+		db.Exec("DROP TABLE IF EXISTS rounds CASCADE")
+		db.Exec("DROP TABLE IF EXISTS tournament_players CASCADE")
+		db.Exec("DROP TABLE IF EXISTS tournaments CASCADE")
+		db.Exec("DROP TABLE IF EXISTS puzzle_attempts CASCADE")
+		db.Exec("DROP TABLE IF EXISTS puzzles CASCADE")
+		db.Exec("DROP TABLE IF EXISTS explorer_moves CASCADE")
+		db.Exec("DROP TABLE IF EXISTS move_analyses CASCADE")
+		db.Exec("DROP TABLE IF EXISTS game_analyses CASCADE")
 		db.Exec("DROP TABLE IF EXISTS moves CASCADE")
+		db.Exec("DROP TABLE IF EXISTS api_tokens CASCADE")
+		db.Exec("DROP TABLE IF EXISTS user_identities CASCADE")
+		db.Exec("DROP TABLE IF EXISTS recovery_codes CASCADE")
 		db.Exec("DROP TABLE IF EXISTS refresh_tokens CASCADE")
+		db.Exec("DROP TABLE IF EXISTS sessions CASCADE")
 		db.Exec("DROP TABLE IF EXISTS games CASCADE")
 		db.Exec("DROP TABLE IF EXISTS users CASCADE")
 	}
 
-	return db.AutoMigrate(
+	if err := migrateEmailVerified(db); err != nil {
+		return err
+	}
+	if err := migrateRefreshTokens(db); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(
 		&User{},
+		&Session{},
 		&RefreshToken{},
+		&RecoveryCode{},
+		&UserIdentity{},
+		&APIToken{},
 		&Game{},
 		&Move{},
-	)
+		&GameAnalysis{},
+		&MoveAnalysis{},
+		&ExplorerMove{},
+		&Puzzle{},
+		&PuzzleAttempt{},
+		&Tournament{},
+		&TournamentPlayer{},
+		&Round{},
+		&TournamentPairing{},
+		&Friendship{},
+		&Follow{},
+		&Block{},
+		&Notification{},
+		&GameEvent{},
+		&WSTicket{},
+		&RateLimitBucket{},
+		&Report{},
+		&AuditLog{},
+	); err != nil {
+		return err
+	}
+	return migrateFriendshipPairs(db)
+}
+
+// migrateFriendshipPairs makes a friendship unique to a pair of users,
+// whichever of them asked. Rows left by both asking at once are merged
+// first, keeping an accepted one or else the oldest.
+func migrateFriendshipPairs(db *gorm.DB) error {
+	if err := db.Exec(`DELETE FROM friendships f USING friendships g
+		WHERE LEAST(f.requester_id, f.addressee_id) = LEAST(g.requester_id, g.addressee_id)
+		AND GREATEST(f.requester_id, f.addressee_id) = GREATEST(g.requester_id, g.addressee_id)
+		AND f.id <> g.id
+		AND ((g.status = ? AND f.status <> ?) OR (f.status = g.status AND f.id > g.id))`,
+		FriendshipStatusAccepted, FriendshipStatusAccepted).Error; err != nil {
+		return err
+	}
+	return db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_friendships_users
+		ON friendships (LEAST(requester_id, addressee_id), GREATEST(requester_id, addressee_id))`).Error
+}
+
+// migrateEmailVerified adds the email_verified column to an existing users
+// table with every account in it counted as verified: they signed up before
+// addresses were checked, and would otherwise lose rated games. Accounts
+// created from then on start unverified.
+func migrateEmailVerified(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&User{}) || db.Migrator().HasColumn(&User{}, "email_verified") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE users ADD COLUMN email_verified boolean NOT NULL DEFAULT false").Error; err != nil {
+			return err
+		}
+		return tx.Exec("UPDATE users SET email_verified = true").Error
+	})
+}
+
+// migrateRefreshTokens clears refresh tokens stored in clear before they were
+// hashed and tied to a session. They cannot be carried over, so their holders
+// sign in again, and the old column would reject every new row.
+func migrateRefreshTokens(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&RefreshToken{}, "token") {
+		return nil
+	}
+	if err := db.Exec("DELETE FROM refresh_tokens").Error; err != nil {
+		return err
+	}
+	return db.Exec("ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS token").Error
+}
+
+// DatabaseDSN returns the connection string built from the environment, for
+// clients that need their own connection such as LISTEN/NOTIFY
+func DatabaseDSN() (string, error) {
+	databaseURL := os.Getenv("SCALINGO_POSTGRESQL_URL")
+	if databaseURL == "" {
+		databaseURL = os.Getenv("DATABASE_URL")
+	}
+	if databaseURL == "" {
+		return "", fmt.Errorf("DATABASE_URL or SCALINGO_POSTGRESQL_URL is required")
+	}
+
+	dsn, err := parseDatabaseURL(databaseURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse database URL: %w", err)
+	}
+	return dsn, nil
 }
 
 func parseDatabaseURL(rawURL string) (string, error) {
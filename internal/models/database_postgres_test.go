@@ -0,0 +1,118 @@
+//go:build postgres
+
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// emptyDB opens a schema of its own, dropped after the test, without
+// migrating it, so that a test can lay out an older schema first.
+// Run with a database: DATABASE_URL=... go test -tags postgres ./internal/models
+func emptyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn, err := DatabaseDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := ConnectDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("models_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+schema), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	// Upgrades only happen in production; elsewhere the tables are dropped
+	t.Setenv("ENV", "production")
+	return db
+}
+
+func exec(t *testing.T, db *gorm.DB, sql string, values ...interface{}) {
+	t.Helper()
+	if err := db.Exec(sql, values...).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateLegacyRefreshTokens(t *testing.T) {
+	db := emptyDB(t)
+	exec(t, db, `CREATE TABLE refresh_tokens (id bigserial PRIMARY KEY, user_id bigint NOT NULL,
+		token text NOT NULL UNIQUE, expires_at timestamptz NOT NULL, created_at timestamptz)`)
+	exec(t, db, `INSERT INTO refresh_tokens (user_id, token, expires_at) VALUES (1, 'legacy', now() + interval '1 day')`)
+
+	if err := AutoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasColumn(&RefreshToken{}, "token") {
+		t.Error("legacy token column kept")
+	}
+	var count int64
+	db.Model(&RefreshToken{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d legacy tokens kept", count)
+	}
+
+	user := User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: user.ID}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := RefreshToken{UserID: user.ID, SessionID: session.ID, TokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&token).Error; err != nil {
+		t.Errorf("new refresh token rejected: %v", err)
+	}
+}
+
+func TestMigrateEmailVerifiedKeepsExistingAccountsVerified(t *testing.T) {
+	db := emptyDB(t)
+	exec(t, db, `CREATE TABLE users (id bigserial PRIMARY KEY, username text NOT NULL UNIQUE,
+		email text NOT NULL UNIQUE, password_hash text NOT NULL, created_at timestamptz)`)
+	exec(t, db, `INSERT INTO users (username, email, password_hash) VALUES ('alice', 'alice@example.com', 'x')`)
+
+	if err := AutoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+	var alice User
+	if err := db.Where("username = ?", "alice").First(&alice).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !alice.EmailVerified {
+		t.Error("existing account left unverified")
+	}
+
+	bob := User{Username: "bob", Email: "bob@example.com", PasswordHash: "x"}
+	if err := db.Create(&bob).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&bob, bob.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bob.EmailVerified {
+		t.Error("new account created verified")
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// ExplorerMove is one move of a finished game, keyed by the position it was
+// played from. The opening explorer aggregates these rows per position.
+type ExplorerMove struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	PositionKey   string     `gorm:"type:text;not null;index:idx_explorer_position_rating,priority:1" json:"positionKey"` // FEN before the move without move counters
+	GameID        uint       `gorm:"index;not null" json:"gameId"`
+	PlyNumber     int        `gorm:"not null" json:"plyNumber"`
+	Move          string     `gorm:"not null" json:"move"` // UCI notation
+	SAN           string     `gorm:"not null" json:"san"`  // Standard algebraic notation
+	Result        GameResult `gorm:"not null" json:"result"`
+	WhiteRating   int        `json:"whiteRating"` // Ratings when the game was played
+	BlackRating   int        `json:"blackRating"`
+	AverageRating int        `gorm:"index:idx_explorer_position_rating,priority:2" json:"averageRating"`
+	TimeControl   int        `json:"timeControl"` // Seconds per player
+	Speed         string     `gorm:"index" json:"speed"` // bullet, blitz, rapid or classical
+	PlayedAt      time.Time  `json:"playedAt"`
+}
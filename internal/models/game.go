@@ -28,13 +28,22 @@ type Game struct {
 	ID            uint       `gorm:"primaryKey" json:"id"`
 	WhitePlayerID *uint      `gorm:"index" json:"whitePlayerId"`
 	BlackPlayerID *uint      `gorm:"index" json:"blackPlayerId"`
+	InvitedPlayerID *uint    `gorm:"index" json:"invitedPlayerId,omitempty"` // Only this user may join a challenge
 	Status        GameStatus `gorm:"not null;default:'waiting'" json:"status"`
 	Result        GameResult `gorm:"default:''" json:"result"`
 	CurrentFEN    string     `gorm:"type:text;not null" json:"currentFEN"` // Current board state in FEN notation
 	PGN           string     `gorm:"type:text" json:"pgn"`                  // Game notation in PGN format
 	TimeControl   int        `gorm:"default:600" json:"timeControl"`       // Time per player in seconds (default: 10 minutes)
+	Increment     int        `gorm:"default:0;not null" json:"increment"`  // Seconds added to a player's clock after each of their moves
 	WhiteTimeLeft int        `gorm:"default:600" json:"whiteTimeLeft"`      // Time remaining for white in seconds
 	BlackTimeLeft int        `gorm:"default:600" json:"blackTimeLeft"`     // Time remaining for black in seconds
+	BotLevel      int        `gorm:"default:0" json:"botLevel,omitempty"`   // Strength of the bot opponent (0 = no bot)
+	Rated         bool       `gorm:"default:false;not null" json:"rated"`   // Ratings change only if both players were verified when it started
+	WhiteRatingDiff int      `gorm:"default:0;not null" json:"whiteRatingDiff"` // Rating change of each player when it finished, kept to roll it back
+	BlackRatingDiff int      `gorm:"default:0;not null" json:"blackRatingDiff"`
+	Annulled      bool       `gorm:"default:false;not null" json:"annulled"` // Cancelled by an admin; counts in no ratings or stats
+	ECO           string     `gorm:"index;default:''" json:"eco"`           // ECO code of the deepest named opening reached
+	Opening       string     `gorm:"default:''" json:"opening"`             // Name of that opening
 	CreatedAt     time.Time  `json:"createdAt"`
 	UpdatedAt     time.Time  `json:"updatedAt"`
 
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// GameEvent is a message broadcast to a game's clients, kept so that a
+// client that missed some can catch up from its last sequence number
+type GameEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	GameID    uint      `gorm:"uniqueIndex:idx_game_events_game_seq;not null" json:"gameId"`
+	Seq       uint64    `gorm:"uniqueIndex:idx_game_events_game_seq;not null" json:"seq"` // Increases by one per event of a game
+	Type      string    `gorm:"not null" json:"type"`
+	Payload   string    `gorm:"type:text;not null" json:"-"` // The event as sent, in JSON
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Relations
+	Game *Game `gorm:"foreignKey:GameID" json:"-"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links a user to their account at a single sign-on provider
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_user_identities_user_provider;not null" json:"userId"`
+	Provider  string    `gorm:"uniqueIndex:idx_user_identities_user_provider;uniqueIndex:idx_user_identities_provider_subject;not null" json:"provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;not null" json:"-"` // The provider's stable user ID
+	Email     string    `json:"email"`                                                              // As reported by the provider when linked
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Relations
+	User *User `gorm:"foreignKey:UserID" json:"-"`
+}
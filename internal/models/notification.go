@@ -0,0 +1,64 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// NotificationType identifies what a notification is about
+type NotificationType string
+
+const (
+	NotificationChallenge      NotificationType = "challenge"       // Someone challenged the user to a game
+	NotificationMatchFound     NotificationType = "match_found"     // Matchmaking found an opponent
+	NotificationYourTurn       NotificationType = "your_turn"       // The opponent moved while the user was away from the game
+	NotificationRoundStarted   NotificationType = "round_started"   // A tournament round the user plays in has been paired
+	NotificationFriendRequest  NotificationType = "friend_request"  // Someone asked to be the user's friend
+	NotificationFriendAccepted NotificationType = "friend_accepted" // A friend request the user sent was accepted
+)
+
+// JSONMap is a JSON object stored in a text column
+type JSONMap map[string]interface{}
+
+// Value implements driver.Valuer
+func (m JSONMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (m *JSONMap) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into JSONMap", value)
+	}
+	return json.Unmarshal(data, m)
+}
+
+// Notification is an event for a user, pushed live on their stream and
+// kept until read
+type Notification struct {
+	ID        uint             `gorm:"primaryKey" json:"id"`
+	UserID    uint             `gorm:"index;not null" json:"userId"`
+	Type      NotificationType `gorm:"not null" json:"type"`
+	GameID    *uint            `gorm:"index" json:"gameId,omitempty"` // Game the notification is about, if any
+	Data      JSONMap          `gorm:"type:text" json:"data"`         // Type-specific details, e.g. who sent a request
+	ReadAt    *time.Time       `json:"readAt"`
+	CreatedAt time.Time        `gorm:"index" json:"createdAt"`
+
+	// Relations
+	User *User `gorm:"foreignKey:UserID" json:"-"`
+}
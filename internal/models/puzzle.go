@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// Puzzle is a tactic taken from a game played on the server. The solver is
+// the side to move in FEN; Moves alternates the solver's moves and the
+// opponent's forced replies, starting and ending with a solver move.
+type Puzzle struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	GameID    uint      `gorm:"uniqueIndex:idx_puzzles_game_ply;not null" json:"gameId"`
+	PlyNumber int       `gorm:"uniqueIndex:idx_puzzles_game_ply;not null" json:"plyNumber"` // Ply after which the puzzle position arose
+	FEN       string    `gorm:"type:text;not null" json:"fen"`
+	Moves     string    `gorm:"type:text;not null" json:"-"` // Solution in UCI notation, space separated
+	Themes    string    `gorm:"type:text" json:"themes"`     // Space separated, e.g. "mate mateIn2 short"
+	Rating    int       `gorm:"default:1500;not null;index" json:"rating"`
+	Plays     int       `gorm:"default:0;not null" json:"plays"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Relations
+	Game *Game `gorm:"foreignKey:GameID" json:"-"`
+}
+
+// PuzzleAttempt records a user's first try at a puzzle, which is the only
+// one that affects ratings
+type PuzzleAttempt struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"uniqueIndex:idx_puzzle_attempts_user_puzzle;not null" json:"userId"`
+	PuzzleID     uint      `gorm:"uniqueIndex:idx_puzzle_attempts_user_puzzle;not null" json:"puzzleId"`
+	Solved       bool      `gorm:"not null" json:"solved"`
+	RatingBefore int       `json:"ratingBefore"`
+	RatingAfter  int       `json:"ratingAfter"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	// Relations
+	User   *User   `gorm:"foreignKey:UserID" json:"-"`
+	Puzzle *Puzzle `gorm:"foreignKey:PuzzleID" json:"-"`
+}
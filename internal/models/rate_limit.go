@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// RateLimitBucket is a token bucket shared by all server instances. Tokens
+// is the count at RefilledAt; the bucket is full again at FullAt and can
+// then be deleted.
+type RateLimitBucket struct {
+	Key        string    `gorm:"primaryKey;size:255" json:"key"`
+	Tokens     float64   `gorm:"not null" json:"tokens"`
+	RefilledAt time.Time `gorm:"not null" json:"refilledAt"`
+	FullAt     time.Time `gorm:"index;not null" json:"fullAt"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a login on one device: the family of refresh tokens issued
+// from it. Revoking it invalidates every token of the family.
+type Session struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"userId"`
+	UserAgent  string     `gorm:"type:text" json:"userAgent"`
+	IP         string     `json:"ip"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+
+	// Relations
+	User *User `gorm:"foreignKey:UserID" json:"-"`
+}
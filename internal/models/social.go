@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+)
+
+// FriendshipStatus represents the state of a friend request
+type FriendshipStatus string
+
+const (
+	FriendshipStatusPending  FriendshipStatus = "pending"  // Waiting for the addressee to answer
+	FriendshipStatusAccepted FriendshipStatus = "accepted" // Both users are friends
+)
+
+// Friendship links two users, starting as a request from RequesterID to
+// AddresseeID. There is at most one row per pair of users, whichever way
+// round, enforced by the idx_friendships_users index.
+type Friendship struct {
+	ID          uint             `gorm:"primaryKey" json:"id"`
+	RequesterID uint             `gorm:"uniqueIndex:idx_friendships_pair;not null" json:"requesterId"`
+	AddresseeID uint             `gorm:"uniqueIndex:idx_friendships_pair;index;not null" json:"addresseeId"`
+	Status      FriendshipStatus `gorm:"not null;default:'pending'" json:"status"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	AcceptedAt  *time.Time       `json:"acceptedAt"`
+
+	// Relations
+	Requester *User `gorm:"foreignKey:RequesterID" json:"requester,omitempty"`
+	Addressee *User `gorm:"foreignKey:AddresseeID" json:"addressee,omitempty"`
+}
+
+// Follow is a one-way subscription to another user's activity
+type Follow struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	FollowerID uint      `gorm:"uniqueIndex:idx_follows_pair;not null" json:"followerId"`
+	FolloweeID uint      `gorm:"uniqueIndex:idx_follows_pair;index;not null" json:"followeeId"`
+	CreatedAt  time.Time `json:"createdAt"`
+
+	// Relations
+	Follower *User `gorm:"foreignKey:FollowerID" json:"follower,omitempty"`
+	Followee *User `gorm:"foreignKey:FolloweeID" json:"followee,omitempty"`
+}
+
+// Block stops two users from interacting: no friend requests, follows,
+// challenges or matchmaking pairings in either direction
+type Block struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	BlockerID uint      `gorm:"uniqueIndex:idx_blocks_pair;not null" json:"blockerId"`
+	BlockedID uint      `gorm:"uniqueIndex:idx_blocks_pair;index;not null" json:"blockedId"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Relations
+	Blocked *User `gorm:"foreignKey:BlockedID" json:"blocked,omitempty"`
+}
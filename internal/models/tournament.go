@@ -0,0 +1,110 @@
+package models
+
+import (
+	"time"
+)
+
+// TournamentFormat selects how a tournament pairs its players
+type TournamentFormat string
+
+const (
+	TournamentFormatSwiss      TournamentFormat = "swiss"       // Dutch-system Swiss rounds
+	TournamentFormatArena      TournamentFormat = "arena"       // Continuous pairing until a set time
+	TournamentFormatRoundRobin TournamentFormat = "round_robin" // Everyone plays everyone, Berger tables
+	TournamentFormatKnockout   TournamentFormat = "knockout"    // Single elimination mini-matches
+)
+
+// TournamentStatus represents the progress of a tournament
+type TournamentStatus string
+
+const (
+	TournamentStatusRegistering TournamentStatus = "registering" // Open for registration
+	TournamentStatusInProgress  TournamentStatus = "in_progress" // Rounds being played, or arena running
+	TournamentStatusFinished    TournamentStatus = "finished"    // All rounds played, or arena time is up
+)
+
+// RoundStatus represents the progress of a tournament round
+type RoundStatus string
+
+const (
+	RoundStatusInProgress RoundStatus = "in_progress" // Games being played
+	RoundStatusFinished   RoundStatus = "finished"    // Every pairing has a result
+)
+
+// Tournament is an event whose games are paired by the server
+type Tournament struct {
+	ID           uint             `gorm:"primaryKey" json:"id"`
+	Name         string           `gorm:"not null" json:"name"`
+	Format       TournamentFormat `gorm:"not null;default:'swiss'" json:"format"`
+	Status       TournamentStatus `gorm:"not null;default:'registering';index" json:"status"`
+	TimeControl  int              `gorm:"default:600" json:"timeControl"` // Time per player in seconds
+	NumRounds    int              `gorm:"not null" json:"numRounds"`      // Number of rounds to play
+	CurrentRound int              `gorm:"default:0" json:"currentRound"`  // 0 until the first round is paired
+	Duration     int              `gorm:"default:0" json:"duration"`      // Arena length in minutes
+	Berserk      bool             `gorm:"default:false" json:"berserk"`   // Arena players may halve their clock for an extra point
+	EndsAt       *time.Time       `json:"endsAt"`                         // When an arena stops pairing
+	MatchGames   int              `gorm:"default:0" json:"matchGames"`    // Games per knockout match before an armageddon
+	CreatedByID  uint             `gorm:"not null" json:"createdById"`
+	StartedAt    *time.Time       `json:"startedAt"`
+	FinishedAt   *time.Time       `json:"finishedAt"`
+	CreatedAt    time.Time        `json:"createdAt"`
+	UpdatedAt    time.Time        `json:"updatedAt"`
+
+	// Relations
+	CreatedBy *User              `gorm:"foreignKey:CreatedByID" json:"-"`
+	Players   []TournamentPlayer `gorm:"foreignKey:TournamentID" json:"players,omitempty"`
+}
+
+// TournamentPlayer is a user registered in a tournament
+type TournamentPlayer struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	TournamentID   uint      `gorm:"uniqueIndex:idx_tournament_players_tournament_user;not null" json:"tournamentId"`
+	UserID         uint      `gorm:"uniqueIndex:idx_tournament_players_tournament_user;not null" json:"userId"`
+	StartingRating int       `gorm:"not null" json:"startingRating"` // Rating at registration, used for seeding
+	Score          float64   `gorm:"default:0;not null" json:"score"`
+	Streak         int       `gorm:"default:0;not null" json:"streak"`        // Consecutive arena wins
+	Withdrawn      bool      `gorm:"default:false;not null" json:"withdrawn"` // No longer paired
+	CreatedAt      time.Time `json:"createdAt"`
+
+	// Relations
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// Round is one round of a round-based tournament. In a knockout each round
+// is a stage of the bracket and the pairings sharing a board form a match.
+type Round struct {
+	ID           uint        `gorm:"primaryKey" json:"id"`
+	TournamentID uint        `gorm:"uniqueIndex:idx_rounds_tournament_number;not null" json:"tournamentId"`
+	Number       int         `gorm:"uniqueIndex:idx_rounds_tournament_number;not null" json:"number"`
+	Status       RoundStatus `gorm:"not null;default:'in_progress'" json:"status"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	FinishedAt   *time.Time  `json:"finishedAt"`
+
+	// Relations
+	Pairings []TournamentPairing `gorm:"foreignKey:RoundID" json:"pairings,omitempty"`
+}
+
+// TournamentPairing is a game between two tournament players, or a bye if
+// BlackPlayerID is nil. Arena pairings belong to no round.
+type TournamentPairing struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	TournamentID  uint       `gorm:"index;not null" json:"tournamentId"`
+	RoundID       *uint      `gorm:"index" json:"roundId"`
+	Board         int        `json:"board"`
+	WhitePlayerID uint       `gorm:"not null" json:"whitePlayerId"`
+	BlackPlayerID *uint      `json:"blackPlayerId"`
+	GameID        *uint      `gorm:"uniqueIndex" json:"gameId"`
+	Result        GameResult `gorm:"default:''" json:"result"`
+	WhiteBerserk  bool       `gorm:"default:false" json:"whiteBerserk"`
+	BlackBerserk  bool       `gorm:"default:false" json:"blackBerserk"`
+	Armageddon    bool       `gorm:"default:false" json:"armageddon"` // Knockout decider; a draw counts as a black win
+	WhitePoints   int        `gorm:"default:0" json:"whitePoints"`    // Arena points scored by each side
+	BlackPoints   int        `gorm:"default:0" json:"blackPoints"`
+	Annulled      bool       `gorm:"default:false" json:"annulled"` // The game was annulled and scores nothing
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+
+	// Relations
+	WhitePlayer *User `gorm:"foreignKey:WhitePlayerID" json:"whitePlayer,omitempty"`
+	BlackPlayer *User `gorm:"foreignKey:BlackPlayerID" json:"blackPlayer,omitempty"`
+}
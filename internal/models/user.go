@@ -4,6 +4,22 @@ import (
 	"time"
 )
 
+// Roles, each allowed everything the previous one is
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator" // Handles reports, suspends and bans players, ends games
+	RoleAdmin     = "admin"     // Also adjusts ratings, annuls games and grants roles
+)
+
+// DeletedUsernamePrefix starts the username of deleted accounts, which are
+// anonymised rather than removed so that their games stay intact. New
+// accounts cannot take such a name.
+const DeletedUsernamePrefix = "deleted-"
+
+// BotUsername is the name of the built-in bot account, which new accounts
+// cannot take either
+const BotUsername = "ChessBot"
+
 // User represents a user account
 type User struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
@@ -16,6 +32,20 @@ type User struct {
 	Wins        int       `gorm:"default:0;not null" json:"wins"`
 	Losses      int       `gorm:"default:0;not null" json:"losses"`
 	Draws       int       `gorm:"default:0;not null" json:"draws"`
+	IsBot       bool      `gorm:"default:false;not null" json:"isBot"` // Computer opponent account
+	EmailVerified bool    `gorm:"default:false;not null" json:"emailVerified"` // Required for rated play
+	TOTPSecret  string    `gorm:"type:text" json:"-"` // Encrypted; set during enrollment, before TOTPEnabled
+	TOTPEnabled bool      `gorm:"default:false;not null" json:"totpEnabled"` // Login asks for a second factor
+	TOTPLastStep int64    `gorm:"default:0;not null" json:"-"` // Time step of the last code used, to refuse replays
+	FailedLogins int      `gorm:"default:0;not null" json:"-"` // Consecutive failed logins, counted toward a lockout
+	LastFailedLoginAt *time.Time `json:"-"`
+	LockedUntil *time.Time `json:"-"` // Logins are refused until then
+	PuzzleRating int      `gorm:"default:1500;not null" json:"puzzleRating"` // Rating for tactics puzzles, separate from ELORating
+	Role        string    `gorm:"default:'user';not null" json:"role"`
+	BannedAt    *time.Time `json:"bannedAt,omitempty"` // Banned for good
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"` // Banned until then
+	BanReason   string    `gorm:"type:text" json:"banReason,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"` // Anonymised at its owner's request
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 
@@ -25,14 +55,54 @@ type User struct {
 	RefreshTokens []RefreshToken `gorm:"foreignKey:UserID" json:"-"`
 }
 
-// RefreshToken represents a refresh token for JWT authentication
+// RecoveryCode is a single-use second factor, for users who lost their
+// authenticator. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"userId"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// RefreshToken represents an opaque refresh token. Each use replaces it with
+// a new token of the same session (its family); only a hash is stored.
 type RefreshToken struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    uint      `gorm:"index;not null" json:"userId"`
-	Token     string    `gorm:"uniqueIndex;not null" json:"-"`
-	ExpiresAt time.Time `gorm:"not null" json:"expiresAt"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"userId"`
+	SessionID uint       `gorm:"index;not null" json:"sessionId"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"` // Set once rotated; a later use is a replay
+	Successor string     `gorm:"type:text" json:"-"` // Token issued in exchange, sealed with this one, for replays in the grace period
+	CreatedAt time.Time  `json:"createdAt"`
 
 	// Relations
-	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	User    *User    `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Session *Session `gorm:"foreignKey:SessionID" json:"-"`
+}
+
+// roleRank orders roles by the power they give
+var roleRank = map[string]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether the user has role or a more powerful one
+func (u *User) HasRole(role string) bool {
+	return roleRank[u.Role] >= roleRank[role]
+}
+
+// Outranks reports whether the user's role is more powerful than other's,
+// as needed to moderate them
+func (u *User) Outranks(other *User) bool {
+	return roleRank[u.Role] > roleRank[other.Role]
+}
+
+// Suspended reports whether the user is banned, or suspended at t
+func (u *User) Suspended(t time.Time) bool {
+	return u.BannedAt != nil || (u.SuspendedUntil != nil && t.Before(*u.SuspendedUntil))
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// WSTicket lets its holder open one WebSocket connection shortly after it
+// was issued. Only a hash of the ticket is stored.
+type WSTicket struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	TicketHash   string    `gorm:"uniqueIndex;not null" json:"-"`
+	UserID       uint      `gorm:"index;not null" json:"userId"`
+	GameID       *uint     `json:"gameId"`       // Game socket, if set
+	TournamentID *uint     `json:"tournamentId"` // Tournament socket, if set; the user's notification stream if neither is
+	ExpiresAt    time.Time `gorm:"index;not null" json:"expiresAt"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
@@ -0,0 +1,65 @@
+package notification
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// List returns the current user's notifications, newest first:
+// GET /api/notifications?unread=true&limit=50
+func (h *Handler) List(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	notifications, unread, err := h.service.List(userID, c.Query("unread") == "true", limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unread})
+}
+
+// MarkRead marks a notification as read: POST /api/notifications/:id/read
+func (h *Handler) MarkRead(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	notificationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification ID"})
+		return
+	}
+
+	if err := h.service.MarkRead(userID, uint(notificationID)); err != nil {
+		if err == ErrNotificationNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification read"})
+}
+
+// MarkAllRead marks every notification of the current user as read
+func (h *Handler) MarkAllRead(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	count, err := h.service.MarkAllRead(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"read": count})
+}
@@ -0,0 +1,169 @@
+// Package notification stores user notifications and pushes them to the
+// users' live streams.
+package notification
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// Page size of the notification list
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+type Service struct {
+	db  *gorm.DB
+	hub *game.Hub
+}
+
+// NewService creates a notification service. Notifications are saved, then
+// sent to the user's open streams through the hub.
+func NewService(db *gorm.DB, hub *game.Hub) *Service {
+	return &Service{db: db, hub: hub}
+}
+
+// Notify saves a notification and pushes it to the user's streams. gameID
+// may be 0.
+func (s *Service) Notify(userID uint, kind models.NotificationType, gameID uint, data models.JSONMap) error {
+	n := &models.Notification{UserID: userID, Type: kind, Data: data}
+	if gameID != 0 {
+		n.GameID = &gameID
+	}
+	if err := s.db.Create(n).Error; err != nil {
+		return err
+	}
+
+	if s.hub != nil {
+		s.hub.SendToUser(userID, map[string]interface{}{
+			"type":         "notification",
+			"notification": n,
+		})
+	}
+	return nil
+}
+
+// List returns a user's latest notifications and how many are unread
+func (s *Service) List(userID uint, unreadOnly bool, limit int) ([]models.Notification, int64, error) {
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	query := s.db.Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	var notifications []models.Notification
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&notifications).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var unread int64
+	if err := s.db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Count(&unread).Error; err != nil {
+		return nil, 0, err
+	}
+	return notifications, unread, nil
+}
+
+// MarkRead marks one of the user's notifications as read
+func (s *Service) MarkRead(userID, notificationID uint) error {
+	result := s.db.Model(&models.Notification{}).
+		Where("id = ? AND user_id = ?", notificationID, userID).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", time.Now()))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks every unread notification of the user as read and
+// returns how many there were
+func (s *Service) MarkAllRead(userID uint) (int64, error) {
+	result := s.db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// OnChallenge tells the challenged user about a new challenge. It is
+// registered as a game.Service listener.
+func (s *Service) OnChallenge(g *models.Game) {
+	if g.InvitedPlayerID == nil || g.WhitePlayerID == nil {
+		return
+	}
+	s.notify(*g.InvitedPlayerID, models.NotificationChallenge, g.ID, models.JSONMap{
+		"challengerId": *g.WhitePlayerID,
+		"timeControl":  g.TimeControl,
+	})
+}
+
+// OnMatch tells a player waiting in the matchmaking queue that their game
+// is ready. It is registered as a game.MatchmakingService listener.
+func (s *Service) OnMatch(userID uint, g *models.Game) {
+	s.notify(userID, models.NotificationMatchFound, g.ID, models.JSONMap{
+		"timeControl": g.TimeControl,
+	})
+}
+
+// OnMove tells the player to move that it is their turn, unless they have
+// the game open. Only one unread notification is kept per game. It is
+// registered as a game.Service move listener.
+func (s *Service) OnMove(g *models.Game, move *models.Move) {
+	if g.Status != models.GameStatusActive || g.WhitePlayerID == nil || g.BlackPlayerID == nil {
+		return
+	}
+	next := *g.WhitePlayerID
+	if move.PlayerID == next {
+		next = *g.BlackPlayerID
+	}
+	for _, player := range []*models.User{g.WhitePlayer, g.BlackPlayer} {
+		if player != nil && player.ID == next && player.IsBot {
+			return
+		}
+	}
+	if s.hub != nil && s.hub.Watching(next, g.ID) {
+		return
+	}
+
+	gameID, opponentID, uci := g.ID, move.PlayerID, move.MoveNotation
+	go func() {
+		var pending int64
+		if err := s.db.Model(&models.Notification{}).
+			Where("user_id = ? AND game_id = ? AND type = ? AND read_at IS NULL", next, gameID, models.NotificationYourTurn).
+			Count(&pending).Error; err != nil {
+			log.Printf("notification: failed to check turn notifications for user %d: %v", next, err)
+			return
+		}
+		if pending > 0 {
+			return
+		}
+		s.notify(next, models.NotificationYourTurn, gameID, models.JSONMap{
+			"opponentId": opponentID,
+			"move":       uci,
+		})
+	}()
+}
+
+// notify is Notify for listeners, which have nobody to return an error to
+func (s *Service) notify(userID uint, kind models.NotificationType, gameID uint, data models.JSONMap) {
+	if err := s.Notify(userID, kind, gameID, data); err != nil {
+		log.Printf("notification: failed to notify user %d of %s: %v", userID, kind, err)
+	}
+}
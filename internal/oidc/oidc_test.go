@@ -0,0 +1,260 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"chess-app/internal/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const redirectURL = "https://chess.example/api/auth/oidc/test/callback"
+
+var alice = oidctest.User{
+	Subject:           "alice-sub",
+	Email:             "Alice@Example.com",
+	EmailVerified:     true,
+	Name:              "Alice",
+	PreferredUsername: "alice",
+}
+
+func startProvider(t *testing.T) (*oidctest.Provider, *Provider) {
+	t.Helper()
+	mock, err := oidctest.NewProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+	return mock, NewProvider(Config{
+		Issuer:       mock.Issuer(),
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+}
+
+// login runs the authorization code flow as user and returns the code to
+// redeem along with the request it belongs to
+func login(t *testing.T, mock *oidctest.Provider, p *Provider, user oidctest.User) (string, *AuthRequest) {
+	t.Helper()
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthURL(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := mock.Authorize(authURL, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != req.State {
+		t.Fatalf("state %q came back as %q", req.State, state)
+	}
+	return code, req
+}
+
+func TestAuthURL(t *testing.T) {
+	mock, p := startProvider(t)
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.State == req.Nonce || req.Nonce == req.CodeVerifier {
+		t.Fatal("auth request values are not independent")
+	}
+
+	authURL, err := p.AuthURL(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != mock.Issuer()+"/authorize" {
+		t.Errorf("authorization endpoint %q", got)
+	}
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             mock.ClientID,
+		"redirect_uri":          redirectURL,
+		"scope":                 "openid email profile",
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := u.Query().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	// The metadata is fetched once
+	if _, err := p.AuthURL(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if n := mock.Requests("/.well-known/openid-configuration"); n != 1 {
+		t.Errorf("discovery fetched %d times", n)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	mock, _ := startProvider(t)
+	p := NewProvider(Config{Issuer: mock.Issuer() + "/", ClientID: mock.ClientID})
+	req, _ := NewAuthRequest()
+	if _, err := p.AuthURL(context.Background(), req); err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Errorf("got %v, want an issuer mismatch", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	mock, p := startProvider(t)
+	for i := 0; i < 2; i++ {
+		code, req := login(t, mock, p, alice)
+		claims, err := p.Exchange(context.Background(), code, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Subject != alice.Subject || claims.Email != alice.Email || !claims.EmailVerified ||
+			claims.Name != alice.Name || claims.PreferredUsername != alice.PreferredUsername {
+			t.Errorf("unexpected claims %+v", claims)
+		}
+	}
+	// The key set is cached
+	if n := mock.Requests("/jwks"); n != 1 {
+		t.Errorf("key set fetched %d times", n)
+	}
+}
+
+func TestExchangeCodeVerifierMismatch(t *testing.T) {
+	mock, p := startProvider(t)
+	code, req := login(t, mock, p, alice)
+	other, _ := NewAuthRequest()
+	req.CodeVerifier = other.CodeVerifier
+
+	_, err := p.Exchange(context.Background(), code, req)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("got %v, want invalid_grant", err)
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	mock, p := startProvider(t)
+	code, req := login(t, mock, p, alice)
+	req.Nonce = "replayed"
+
+	_, err := p.Exchange(context.Background(), code, req)
+	if !errors.Is(err, ErrInvalidIDToken) || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("got %v, want a nonce mismatch", err)
+	}
+}
+
+func TestExchangeRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(jwt.MapClaims)
+	}{
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, p := startProvider(t)
+			mock.Tamper = tt.tamper
+			code, req := login(t, mock, p, alice)
+			if _, err := p.Exchange(context.Background(), code, req); !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("got %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestExchangeUserinfoEmail(t *testing.T) {
+	mock, p := startProvider(t)
+	user := alice
+	user.EmailInUserinfo = true
+	code, req := login(t, mock, p, user)
+
+	claims, err := p.Exchange(context.Background(), code, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != alice.Email || !claims.EmailVerified {
+		t.Errorf("email from userinfo: %q verified=%v", claims.Email, claims.EmailVerified)
+	}
+	if mock.Requests("/userinfo") != 1 {
+		t.Error("userinfo was not asked")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	mock, p := startProvider(t)
+	code, req := login(t, mock, p, alice)
+	if _, err := p.Exchange(context.Background(), code, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown keys do not refetch the key set more than once a minute
+	code, req = login(t, mock, p, alice)
+	if _, err := p.Exchange(context.Background(), code, req); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want ErrInvalidIDToken", err)
+	}
+
+	p.mu.Lock()
+	p.keysFetch = time.Now().Add(-jwksRefreshInterval)
+	p.mu.Unlock()
+	code, req = login(t, mock, p, alice)
+	if _, err := p.Exchange(context.Background(), code, req); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if n := mock.Requests("/jwks"); n != 2 {
+		t.Errorf("key set fetched %d times", n)
+	}
+}
+
+func TestJWKPublicKeyEC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	k := jwk{Kty: "EC", Crv: "P-256", X: encode(key.X.Bytes()), Y: encode(key.Y.Bytes())}
+
+	public, err := k.publicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(public) {
+		t.Error("decoded key differs")
+	}
+
+	k.Y = encode([]byte{1})
+	if _, err := k.publicKey(); err == nil {
+		t.Error("accepted a point off the curve")
+	}
+	k.Crv = "P-521"
+	if _, err := k.publicKey(); err == nil {
+		t.Error("accepted an unsupported curve")
+	}
+}
@@ -0,0 +1,298 @@
+// Package puzzle extracts tactics puzzles from played games and serves them
+// to users.
+package puzzle
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+
+	"chess-app/internal/analysis"
+	"chess-app/internal/models"
+
+	"github.com/notnil/chess"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Advantage the solver must keep after each of their moves
+	winningCP = 300
+	// An alternative move scoring at least this much means the solution is
+	// not unique
+	alternativeCP = 200
+	// Evaluation above which a non-mating puzzle counts as crushing
+	crushingCP = 600
+	// Longest solution, counted in solver moves
+	maxSolverMoves = 4
+)
+
+// knownThemes lists every theme the generator gives puzzles
+var knownThemes = func() map[string]bool {
+	themes := map[string]bool{
+		"mate": true, "crushing": true, "advantage": true,
+		"oneMove": true, "short": true, "long": true, "veryLong": true,
+		"promotion": true,
+	}
+	for n := 1; n <= maxSolverMoves; n++ {
+		themes["mateIn"+strconv.Itoa(n)] = true
+	}
+	return themes
+}()
+
+// Generator scans analysed games for positions with a single winning
+// tactical line. The evaluator can be the built-in search or a UCI engine.
+type Generator struct {
+	db        *gorm.DB
+	evaluator analysis.Evaluator
+	analyzer  *analysis.Service
+}
+
+// NewGenerator creates a generator. If analyzer is not nil, finished games
+// without an analysis are analysed first, since candidate positions come
+// from the mistakes and blunders it finds.
+func NewGenerator(db *gorm.DB, evaluator analysis.Evaluator, analyzer *analysis.Service) *Generator {
+	return &Generator{db: db, evaluator: evaluator, analyzer: analyzer}
+}
+
+// candidate is a position right after a mistake or blunder
+type candidate struct {
+	GameID     uint
+	PlyNumber  int
+	BoardState string
+}
+
+// Run creates up to limit new puzzles and returns how many were created
+func (g *Generator) Run(ctx context.Context, limit int) (int, error) {
+	if g.analyzer != nil {
+		if err := g.analyzeGames(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	var candidates []candidate
+	err := g.db.Table("move_analyses").
+		Select("move_analyses.game_id, move_analyses.ply_number, moves.board_state").
+		Joins("JOIN moves ON moves.game_id = move_analyses.game_id AND moves.ply_number = move_analyses.ply_number").
+		Joins("JOIN games ON games.id = move_analyses.game_id AND NOT games.annulled").
+		Where("move_analyses.classification IN ?", []models.MoveClassification{
+			models.MoveClassificationMistake,
+			models.MoveClassificationBlunder,
+		}).
+		Where("NOT EXISTS (SELECT 1 FROM puzzles WHERE puzzles.game_id = move_analyses.game_id AND puzzles.ply_number = move_analyses.ply_number)").
+		Order("move_analyses.game_id, move_analyses.ply_number").
+		Scan(&candidates).Error
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, c := range candidates {
+		if created >= limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return created, err
+		}
+
+		puzzle, err := g.FromPosition(ctx, c.BoardState)
+		if err != nil {
+			log.Printf("puzzle: game %d ply %d: %v", c.GameID, c.PlyNumber, err)
+			continue
+		}
+		if puzzle == nil {
+			continue
+		}
+		puzzle.GameID = c.GameID
+		puzzle.PlyNumber = c.PlyNumber
+
+		result := g.db.Clauses(clause.OnConflict{DoNothing: true}).Create(puzzle)
+		if result.Error != nil {
+			return created, result.Error
+		}
+		created += int(result.RowsAffected)
+	}
+	return created, nil
+}
+
+func (g *Generator) analyzeGames(ctx context.Context) error {
+	var gameIDs []uint
+	if err := g.db.Model(&models.Game{}).
+		Where("status = ? AND NOT annulled", models.GameStatusFinished).
+		Where("NOT EXISTS (SELECT 1 FROM game_analyses WHERE game_analyses.game_id = games.id AND game_analyses.status = ?)", models.AnalysisStatusDone).
+		Pluck("id", &gameIDs).Error; err != nil {
+		return err
+	}
+
+	for _, id := range gameIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := g.analyzer.Run(ctx, id); err != nil {
+			log.Printf("puzzle: failed to analyse game %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// FromPosition looks for a puzzle starting at fen. It returns nil if the
+// side to move has no single winning line.
+func (g *Generator) FromPosition(ctx context.Context, fen string) (*models.Puzzle, error) {
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	pos := chess.NewGame(opt).Position()
+
+	var line []*chess.Move
+	var lastScore int
+	mate := false
+	for solverMoves := 0; solverMoves < maxSolverMoves; solverMoves++ {
+		move, score, err := g.uniqueWinningMove(ctx, pos)
+		if err != nil {
+			return nil, err
+		}
+		if move == nil {
+			break
+		}
+		line = append(line, move)
+		lastScore = score
+		pos = pos.Update(move)
+		if pos.Status() == chess.Checkmate {
+			mate = true
+			break
+		}
+
+		// The opponent's best defence; it is dropped again if the solver
+		// has no unique follow-up
+		reply, err := g.evaluator.Evaluate(ctx, pos.String())
+		if err != nil {
+			return nil, err
+		}
+		replyMove := findMove(pos, reply.BestMove)
+		if replyMove == nil {
+			break
+		}
+		line = append(line, replyMove)
+		pos = pos.Update(replyMove)
+	}
+
+	// The solution must end with a solver move
+	if len(line)%2 == 0 && len(line) > 0 {
+		line = line[:len(line)-1]
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	return buildPuzzle(fen, line, lastScore, mate), nil
+}
+
+// uniqueWinningMove returns the side to move's only winning move, or nil if
+// there is none or more than one. Any mate in one is accepted even if there
+// are several, since attempts accept every mating move.
+func (g *Generator) uniqueWinningMove(ctx context.Context, pos *chess.Position) (*chess.Move, int, error) {
+	eval, err := g.evaluator.Evaluate(ctx, pos.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	best := findMove(pos, eval.BestMove)
+	if best == nil || eval.CP < winningCP {
+		return nil, 0, nil
+	}
+	if best.HasTag(chess.Check) && pos.Update(best).Status() == chess.Checkmate {
+		return best, analysis.MateCP, nil
+	}
+
+	for _, move := range pos.ValidMoves() {
+		if move.String() == best.String() {
+			continue
+		}
+		score, err := g.scoreMove(ctx, pos, move)
+		if err != nil {
+			return nil, 0, err
+		}
+		if score >= alternativeCP {
+			return nil, 0, nil
+		}
+	}
+	return best, eval.CP, nil
+}
+
+// scoreMove evaluates a move from the mover's point of view
+func (g *Generator) scoreMove(ctx context.Context, pos *chess.Position, move *chess.Move) (int, error) {
+	next := pos.Update(move)
+	switch next.Status() {
+	case chess.Checkmate:
+		return analysis.MateCP, nil
+	case chess.Stalemate:
+		return 0, nil
+	}
+	eval, err := g.evaluator.Evaluate(ctx, next.String())
+	if err != nil {
+		return 0, err
+	}
+	return -eval.CP, nil
+}
+
+// buildPuzzle derives themes and a starting rating from the solution
+func buildPuzzle(fen string, line []*chess.Move, lastScore int, mate bool) *models.Puzzle {
+	solverMoves := (len(line) + 1) / 2
+
+	moves := make([]string, len(line))
+	promotion := false
+	for i, move := range line {
+		moves[i] = move.String()
+		if i%2 == 0 && move.Promo() != chess.NoPieceType {
+			promotion = true
+		}
+	}
+
+	var themes []string
+	if mate {
+		themes = append(themes, "mate", "mateIn"+strconv.Itoa(solverMoves))
+	} else if lastScore >= crushingCP {
+		themes = append(themes, "crushing")
+	} else {
+		themes = append(themes, "advantage")
+	}
+	switch solverMoves {
+	case 1:
+		themes = append(themes, "oneMove")
+	case 2:
+		themes = append(themes, "short")
+	case 3:
+		themes = append(themes, "long")
+	default:
+		themes = append(themes, "veryLong")
+	}
+	if promotion {
+		themes = append(themes, "promotion")
+	}
+
+	// Longer lines and quiet first moves are harder to find
+	rating := 1000 + 250*solverMoves
+	if !line[0].HasTag(chess.Capture) && !line[0].HasTag(chess.Check) {
+		rating += 150
+	}
+
+	return &models.Puzzle{
+		FEN:    fen,
+		Moves:  strings.Join(moves, " "),
+		Themes: strings.Join(themes, " "),
+		Rating: rating,
+	}
+}
+
+func findMove(pos *chess.Position, uci string) *chess.Move {
+	if uci == "" {
+		return nil
+	}
+	for _, move := range pos.ValidMoves() {
+		if move.String() == uci {
+			return move
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package puzzle
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"chess-app/internal/chess"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+type AttemptRequest struct {
+	Moves []string `json:"moves" binding:"required"` // Solver moves so far, in UCI notation
+}
+
+// Next returns a puzzle suited to the user: GET /api/puzzles/next?theme=mate
+func (h *Handler) Next(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	puzzle, err := h.service.Next(userID, c.Query("theme"))
+	if err != nil {
+		if err == ErrNoPuzzleAvailable {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else if err == ErrUnknownTheme {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, puzzle)
+}
+
+// Attempt checks the user's moves: POST /api/puzzles/:id/attempt
+func (h *Handler) Attempt(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	puzzleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid puzzle ID"})
+		return
+	}
+
+	var req AttemptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.service.Attempt(userID, uint(puzzleID), req.Moves)
+	if err != nil {
+		switch {
+		case err == ErrPuzzleNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case err == ErrNoMoves, errors.Is(err, chess.ErrInvalidMove), errors.Is(err, chess.ErrIllegalMove):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
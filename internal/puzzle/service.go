@@ -0,0 +1,202 @@
+package puzzle
+
+import (
+	"errors"
+	"strings"
+
+	"chess-app/internal/chess"
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPuzzleNotFound    = errors.New("puzzle not found")
+	ErrNoPuzzleAvailable = errors.New("no puzzle available")
+	ErrNoMoves           = errors.New("at least one move is required")
+	ErrUnknownTheme      = errors.New("unknown puzzle theme")
+)
+
+// Rating windows around the user's puzzle rating, tried in order until an
+// unplayed puzzle is found. Zero means any rating.
+var ratingWindows = []int{100, 200, 400, 0}
+
+// Attempt statuses
+const (
+	AttemptContinue = "continue"
+	AttemptSolved   = "solved"
+	AttemptFailed   = "failed"
+)
+
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// AttemptResult is the answer to the solver's moves so far. Reply is the
+// opponent's next move while the puzzle goes on; Solution is revealed once
+// it is over. Rating fields are only set by the first completed attempt.
+type AttemptResult struct {
+	Status       string   `json:"status"`
+	Reply        string   `json:"reply,omitempty"`
+	Solution     []string `json:"solution,omitempty"`
+	Rating       int      `json:"rating,omitempty"`
+	RatingChange int      `json:"ratingChange,omitempty"`
+}
+
+// Next picks a puzzle the user has not tried yet, close to their puzzle
+// rating and optionally with a given theme. Puzzles from annulled games are
+// no longer served.
+func (s *Service) Next(userID uint, theme string) (*models.Puzzle, error) {
+	// Only known themes, which contain no LIKE wildcards, reach the query
+	theme = strings.TrimSpace(theme)
+	if theme != "" && !knownThemes[theme] {
+		return nil, ErrUnknownTheme
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	for _, window := range ratingWindows {
+		query := s.db.Model(&models.Puzzle{}).
+			Where("NOT EXISTS (SELECT 1 FROM puzzle_attempts WHERE puzzle_attempts.puzzle_id = puzzles.id AND puzzle_attempts.user_id = ?)", userID).
+			Where("NOT EXISTS (SELECT 1 FROM games WHERE games.id = puzzles.game_id AND games.annulled)")
+		if window > 0 {
+			query = query.Where("rating BETWEEN ? AND ?", user.PuzzleRating-window, user.PuzzleRating+window)
+		}
+		if theme != "" {
+			query = query.Where("' ' || themes || ' ' LIKE ?", "% "+theme+" %")
+		}
+
+		var puzzle models.Puzzle
+		err := query.Order("RANDOM()").First(&puzzle).Error
+		if err == nil {
+			return &puzzle, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	return nil, ErrNoPuzzleAvailable
+}
+
+// Attempt checks the solver's moves so far against the solution. Any move
+// that gives mate is accepted, even if it differs from the stored one.
+func (s *Service) Attempt(userID, puzzleID uint, moves []string) (*AttemptResult, error) {
+	if len(moves) == 0 {
+		return nil, ErrNoMoves
+	}
+
+	var puzzle models.Puzzle
+	if err := s.db.First(&puzzle, puzzleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPuzzleNotFound
+		}
+		return nil, err
+	}
+	solution := strings.Fields(puzzle.Moves)
+
+	engine, err := chess.NewEngineFromFEN(puzzle.FEN)
+	if err != nil {
+		return nil, err
+	}
+
+	status := AttemptContinue
+	for i, move := range moves {
+		expected := 2 * i
+		if expected >= len(solution) {
+			// Moves past the end of the solution
+			status = AttemptFailed
+			break
+		}
+		if err := engine.ValidateMove(move); err != nil {
+			return nil, err
+		}
+		if err := engine.MakeMove(move); err != nil {
+			return nil, err
+		}
+		if engine.IsCheckmate() {
+			status = AttemptSolved
+			break
+		}
+		if move != solution[expected] {
+			status = AttemptFailed
+			break
+		}
+		if expected == len(solution)-1 {
+			status = AttemptSolved
+			break
+		}
+		if i < len(moves)-1 {
+			if err := engine.MakeMove(solution[expected+1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if status == AttemptContinue {
+		return &AttemptResult{Status: status, Reply: solution[2*len(moves)-1]}, nil
+	}
+
+	result := &AttemptResult{Status: status, Solution: solution}
+	if err := s.record(userID, &puzzle, status == AttemptSolved, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// record stores the user's first completed attempt and updates both ratings,
+// treating the puzzle as the user's opponent. Later attempts change nothing.
+func (s *Service) record(userID uint, puzzle *models.Puzzle, solved bool, result *AttemptResult) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(puzzle, puzzle.ID).Error; err != nil {
+			return err
+		}
+
+		outcome := "black_wins"
+		if solved {
+			outcome = "white_wins"
+		}
+		userRating, puzzleRating := game.CalculateELO(user.PuzzleRating, puzzle.Rating, outcome)
+
+		attempt := models.PuzzleAttempt{
+			UserID:       userID,
+			PuzzleID:     puzzle.ID,
+			Solved:       solved,
+			RatingBefore: user.PuzzleRating,
+			RatingAfter:  userRating,
+		}
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&attempt)
+		if created.Error != nil {
+			return created.Error
+		}
+		if created.RowsAffected == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&user).Update("puzzle_rating", userRating).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(puzzle).Updates(map[string]interface{}{
+			"rating": puzzleRating,
+			"plays":  gorm.Expr("plays + 1"),
+		}).Error; err != nil {
+			return err
+		}
+
+		result.Rating = userRating
+		result.RatingChange = userRating - attempt.RatingBefore
+		return nil
+	})
+}
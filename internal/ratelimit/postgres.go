@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore keeps buckets in the database, so that limits hold across
+// server instances. Each Take is a short transaction that locks one row.
+type PostgresStore struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	nextSweep time.Time
+}
+
+// NewPostgresStore creates a store backed by the rate_limit_buckets table
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db, nextSweep: time.Now().Add(sweepInterval)}
+}
+
+// Take removes a token from the bucket of key
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	if limit.Unlimited() {
+		return true, 0, nil
+	}
+	now := time.Now()
+	s.sweep(now)
+
+	var allowed bool
+	var wait time.Duration
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RateLimitBucket{
+			Key:        key,
+			Tokens:     float64(limit.Requests),
+			RefilledAt: now,
+			FullAt:     now,
+		}).Error; err != nil {
+			return err
+		}
+
+		var row models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("key = ?", key).
+			First(&row).Error; err != nil {
+			return err
+		}
+
+		b := bucket{tokens: row.Tokens, refilledAt: row.RefilledAt}
+		allowed, wait = b.take(now, limit)
+		return tx.Model(&row).Updates(map[string]interface{}{
+			"tokens":      b.tokens,
+			"refilled_at": b.refilledAt,
+			"full_at":     b.fullAt(limit),
+		}).Error
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed, wait, nil
+}
+
+// sweep deletes full buckets now and then, in the background
+func (s *PostgresStore) sweep(now time.Time) {
+	s.mu.Lock()
+	due := now.After(s.nextSweep)
+	if due {
+		s.nextSweep = now.Add(sweepInterval)
+	}
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+
+	go func() {
+		if err := s.db.Where("full_at < ?", now).Delete(&models.RateLimitBucket{}).Error; err != nil {
+			log.Printf("ratelimit: failed to delete full buckets: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Period on average, in bursts of up to Requests.
+// The zero Limit allows everything.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Unlimited reports whether the limit lets every request through
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// rate is the number of tokens added to a bucket per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// ParseLimit reads a limit written "<requests>/<period>", such as "10/1m",
+// or "off" for no limit
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must look like 10/1m", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q must allow a positive number of requests", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q must have a positive period", s)
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// Store keeps token buckets. A bucket starts full with limit.Requests
+// tokens and refills at limit.Requests per limit.Period.
+type Store interface {
+	// Take removes a token from the bucket of key. It reports whether there
+	// was one and, if not, how long until there will be.
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// bucket is the state of a token bucket at its last refill
+type bucket struct {
+	tokens     float64
+	refilledAt time.Time
+}
+
+// take refills the bucket up to now and removes a token if there is one
+func (b *bucket) take(now time.Time, limit Limit) (bool, time.Duration) {
+	elapsed := now.Sub(b.refilledAt)
+	if elapsed < 0 {
+		elapsed = 0 // Clocks of several instances may disagree a little
+	}
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+elapsed.Seconds()*limit.rate())
+	b.refilledAt = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.rate() * float64(time.Second))
+}
+
+// fullAt is when the bucket will have refilled completely; it can be
+// forgotten after that
+func (b *bucket) fullAt(limit Limit) time.Time {
+	missing := float64(limit.Requests) - b.tokens
+	return b.refilledAt.Add(time.Duration(missing / limit.rate() * float64(time.Second)))
+}
+
+// RetryAfter formats a wait for the Retry-After header, in whole seconds
+func RetryAfter(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
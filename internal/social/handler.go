@@ -0,0 +1,224 @@
+package social
+
+import (
+	"net/http"
+	"strconv"
+
+	"chess-app/internal/game"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// ListFriends returns the current user's friends with their status and, if
+// they are playing, their game ID
+func (h *Handler) ListFriends(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	friends, err := h.service.ListFriends(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"friends": friends})
+}
+
+// ListRequests returns the pending friend requests sent and received
+func (h *Handler) ListRequests(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	requests, err := h.service.ListRequests(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// RequestFriend sends a friend request to a user: POST /api/friends/:id
+func (h *Handler) RequestFriend(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	otherID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	friendship, err := h.service.RequestFriend(userID, otherID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, friendship)
+}
+
+// AcceptFriend accepts a user's friend request: POST /api/friends/:id/accept
+func (h *Handler) AcceptFriend(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	otherID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	friendship, err := h.service.AcceptFriend(userID, otherID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, friendship)
+}
+
+// RemoveFriend unfriends a user, or declines or cancels a request
+func (h *Handler) RemoveFriend(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	otherID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RemoveFriend(userID, otherID); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend removed"})
+}
+
+// ListFollowing returns the users the current user follows
+func (h *Handler) ListFollowing(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	follows, err := h.service.ListFollowing(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"following": follows})
+}
+
+// ListFollowers returns the users following the current user
+func (h *Handler) ListFollowers(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	follows, err := h.service.ListFollowers(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"followers": follows})
+}
+
+// Follow subscribes the current user to a user: POST /api/follows/:id
+func (h *Handler) Follow(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	otherID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	follow, err := h.service.Follow(userID, otherID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, follow)
+}
+
+// Unfollow removes the current user's subscription to a user
+func (h *Handler) Unfollow(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	otherID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Unfollow(userID, otherID); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unfollowed"})
+}
+
+// ListBlocks returns the users the current user has blocked
+func (h *Handler) ListBlocks(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	blocks, err := h.service.ListBlocks(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocks": blocks})
+}
+
+// Block blocks a user: POST /api/blocks/:id
+func (h *Handler) Block(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	otherID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	block, err := h.service.Block(userID, otherID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, block)
+}
+
+// Unblock lifts the current user's block on a user
+func (h *Handler) Unblock(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	otherID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Unblock(userID, otherID); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unblocked"})
+}
+
+func (h *Handler) respondError(c *gin.Context, err error) {
+	switch err {
+	case ErrUserNotFound, ErrRequestNotFound, ErrNotFriends, ErrNotFollowing, ErrNotBlocked:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case game.ErrBlocked:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case ErrAlreadyFriends, ErrRequestPending, ErrAlreadyFollowing, ErrAlreadyBlocked:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case ErrSelf:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func parseID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,444 @@
+// Package social manages friends, follows, blocks and online presence.
+package social
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+	"chess-app/internal/notification"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrSelf             = errors.New("cannot do this with yourself")
+	ErrAlreadyFriends   = errors.New("already friends")
+	ErrRequestNotFound  = errors.New("friend request not found")
+	ErrRequestPending   = errors.New("friend request already sent")
+	ErrNotFriends       = errors.New("not friends")
+	ErrAlreadyFollowing = errors.New("already following")
+	ErrNotFollowing     = errors.New("not following")
+	ErrAlreadyBlocked   = errors.New("already blocked")
+	ErrNotBlocked       = errors.New("not blocked")
+)
+
+// A connected user who has sent nothing for this long is idle
+const idleAfter = 5 * time.Minute
+
+// Presence statuses
+const (
+	StatusOffline = "offline"
+	StatusOnline  = "online"
+	StatusIdle    = "idle"
+	StatusPlaying = "playing"
+)
+
+type Service struct {
+	db            *gorm.DB
+	games         *game.Service
+	hub           *game.Hub
+	notifications *notification.Service
+}
+
+// NewService creates a social service. Presence is read from the hub's
+// WebSocket connections; friend requests are announced as notifications.
+func NewService(games *game.Service, hub *game.Hub, notifications *notification.Service) *Service {
+	return &Service{
+		db:            games.GetDB(),
+		games:         games,
+		hub:           hub,
+		notifications: notifications,
+	}
+}
+
+// Friend is a friend with their live status. GameID is set while they are
+// playing, so the UI can offer to watch.
+type Friend struct {
+	UserID    uint      `json:"userId"`
+	Username  string    `json:"username"`
+	AvatarURL string    `json:"avatarUrl"`
+	ELORating int       `json:"eloRating"`
+	Status    string    `json:"status"`
+	GameID    *uint     `json:"gameId"`
+	Since     time.Time `json:"since"`
+}
+
+// FriendRequests are the pending requests of a user
+type FriendRequests struct {
+	Incoming []models.Friendship `json:"incoming"`
+	Outgoing []models.Friendship `json:"outgoing"`
+}
+
+// ListFriends returns the user's friends with their presence
+func (s *Service) ListFriends(userID uint) ([]Friend, error) {
+	var friendships []models.Friendship
+	if err := s.db.Preload("Requester").Preload("Addressee").
+		Where("status = ? AND (requester_id = ? OR addressee_id = ?)", models.FriendshipStatusAccepted, userID, userID).
+		Find(&friendships).Error; err != nil {
+		return nil, err
+	}
+
+	others := make([]*models.User, 0, len(friendships))
+	otherIDs := make([]uint, 0, len(friendships))
+	for _, f := range friendships {
+		other := f.Requester
+		if f.RequesterID == userID {
+			other = f.Addressee
+		}
+		others = append(others, other)
+		if other != nil {
+			otherIDs = append(otherIDs, other.ID)
+		}
+	}
+	statuses, err := s.statuses(otherIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	friends := make([]Friend, 0, len(friendships))
+	for i, f := range friendships {
+		other := others[i]
+		if other == nil {
+			continue
+		}
+		friend := Friend{
+			UserID:    other.ID,
+			Username:  other.Username,
+			AvatarURL: other.AvatarURL,
+			ELORating: other.ELORating,
+			Since:     f.CreatedAt,
+		}
+		if f.AcceptedAt != nil {
+			friend.Since = *f.AcceptedAt
+		}
+		status := statuses[other.ID]
+		friend.Status = status.status
+		if status.gameID != 0 {
+			gameID := status.gameID
+			friend.GameID = &gameID
+		}
+		friends = append(friends, friend)
+	}
+	return friends, nil
+}
+
+// Status returns a user's presence and, while they are playing, their game.
+// A user connected to a game that has ended counts as online.
+func (s *Service) Status(userID uint) (string, uint, error) {
+	statuses, err := s.statuses([]uint{userID})
+	if err != nil {
+		return "", 0, err
+	}
+	status := statuses[userID]
+	return status.status, status.gameID, nil
+}
+
+// userStatus is a user's presence and the game they are playing, if any
+type userStatus struct {
+	status string
+	gameID uint
+}
+
+// statuses works out the presence of several users with one look at the
+// hub and one query for the games they are connected to
+func (s *Service) statuses(userIDs []uint) (map[uint]userStatus, error) {
+	statuses := make(map[uint]userStatus, len(userIDs))
+	if s.hub == nil {
+		for _, userID := range userIDs {
+			statuses[userID] = userStatus{status: StatusOffline}
+		}
+		return statuses, nil
+	}
+
+	presences := s.hub.Presences(userIDs)
+	var gameIDs []uint
+	for _, p := range presences {
+		if p.Online && p.GameID != 0 {
+			gameIDs = append(gameIDs, p.GameID)
+		}
+	}
+	active := make(map[uint]bool, len(gameIDs))
+	if len(gameIDs) > 0 {
+		var activeIDs []uint
+		if err := s.db.Model(&models.Game{}).
+			Where("id IN ? AND status = ?", gameIDs, models.GameStatusActive).
+			Pluck("id", &activeIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range activeIDs {
+			active[id] = true
+		}
+	}
+
+	for userID, p := range presences {
+		switch {
+		case !p.Online:
+			statuses[userID] = userStatus{status: StatusOffline}
+		case active[p.GameID]:
+			statuses[userID] = userStatus{status: StatusPlaying, gameID: p.GameID}
+		case time.Since(p.LastActive) > idleAfter:
+			statuses[userID] = userStatus{status: StatusIdle}
+		default:
+			statuses[userID] = userStatus{status: StatusOnline}
+		}
+	}
+	return statuses, nil
+}
+
+// ListRequests returns the user's pending friend requests, both ways
+func (s *Service) ListRequests(userID uint) (*FriendRequests, error) {
+	requests := &FriendRequests{}
+	if err := s.db.Preload("Requester").
+		Where("addressee_id = ? AND status = ?", userID, models.FriendshipStatusPending).
+		Order("created_at DESC").Find(&requests.Incoming).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Preload("Addressee").
+		Where("requester_id = ? AND status = ?", userID, models.FriendshipStatusPending).
+		Order("created_at DESC").Find(&requests.Outgoing).Error; err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// RequestFriend sends a friend request, or accepts the one the other user
+// already sent
+func (s *Service) RequestFriend(userID, otherID uint) (*models.Friendship, error) {
+	if err := s.checkTarget(userID, otherID); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.findFriendship(userID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		switch {
+		case existing.Status == models.FriendshipStatusAccepted:
+			return nil, ErrAlreadyFriends
+		case existing.RequesterID == userID:
+			return nil, ErrRequestPending
+		default:
+			return s.AcceptFriend(userID, otherID)
+		}
+	}
+
+	friendship := &models.Friendship{
+		RequesterID: userID,
+		AddresseeID: otherID,
+		Status:      models.FriendshipStatusPending,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(friendship)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// A request between the two was sent meanwhile; answer it as above
+		return s.RequestFriend(userID, otherID)
+	}
+	s.notify(otherID, models.NotificationFriendRequest, userID)
+	return friendship, nil
+}
+
+// AcceptFriend accepts the request otherID sent to userID
+func (s *Service) AcceptFriend(userID, otherID uint) (*models.Friendship, error) {
+	var friendship models.Friendship
+	err := s.db.Where("requester_id = ? AND addressee_id = ? AND status = ?", otherID, userID, models.FriendshipStatusPending).
+		First(&friendship).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRequestNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	friendship.Status = models.FriendshipStatusAccepted
+	friendship.AcceptedAt = &now
+	if err := s.db.Model(&friendship).Updates(map[string]interface{}{
+		"status":      friendship.Status,
+		"accepted_at": friendship.AcceptedAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+	s.notify(otherID, models.NotificationFriendAccepted, userID)
+	return &friendship, nil
+}
+
+// RemoveFriend ends a friendship, or declines or cancels a pending request
+func (s *Service) RemoveFriend(userID, otherID uint) error {
+	result := s.db.Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)", userID, otherID, otherID, userID).
+		Delete(&models.Friendship{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFriends
+	}
+	return nil
+}
+
+// Follow subscribes userID to otherID
+func (s *Service) Follow(userID, otherID uint) (*models.Follow, error) {
+	if err := s.checkTarget(userID, otherID); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.Follow{}).Where("follower_id = ? AND followee_id = ?", userID, otherID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrAlreadyFollowing
+	}
+
+	follow := &models.Follow{FollowerID: userID, FolloweeID: otherID}
+	if err := s.db.Create(follow).Error; err != nil {
+		return nil, err
+	}
+	return follow, nil
+}
+
+// Unfollow removes userID's subscription to otherID
+func (s *Service) Unfollow(userID, otherID uint) error {
+	result := s.db.Where("follower_id = ? AND followee_id = ?", userID, otherID).Delete(&models.Follow{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFollowing
+	}
+	return nil
+}
+
+// ListFollowing returns the users userID follows
+func (s *Service) ListFollowing(userID uint) ([]models.Follow, error) {
+	var follows []models.Follow
+	err := s.db.Preload("Followee").Where("follower_id = ?", userID).Order("created_at DESC").Find(&follows).Error
+	return follows, err
+}
+
+// ListFollowers returns the users following userID
+func (s *Service) ListFollowers(userID uint) ([]models.Follow, error) {
+	var follows []models.Follow
+	err := s.db.Preload("Follower").Where("followee_id = ?", userID).Order("created_at DESC").Find(&follows).Error
+	return follows, err
+}
+
+// Block stops otherID from interacting with userID. Any friendship, pending
+// request or follow between them is removed.
+func (s *Service) Block(userID, otherID uint) (*models.Block, error) {
+	if userID == otherID {
+		return nil, ErrSelf
+	}
+	if err := s.checkUser(otherID); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.Block{}).Where("blocker_id = ? AND blocked_id = ?", userID, otherID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrAlreadyBlocked
+	}
+
+	block := &models.Block{BlockerID: userID, BlockedID: otherID}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(block).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)", userID, otherID, otherID, userID).
+			Delete(&models.Friendship{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("(follower_id = ? AND followee_id = ?) OR (follower_id = ? AND followee_id = ?)", userID, otherID, otherID, userID).
+			Delete(&models.Follow{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Unblock lifts userID's block on otherID
+func (s *Service) Unblock(userID, otherID uint) error {
+	result := s.db.Where("blocker_id = ? AND blocked_id = ?", userID, otherID).Delete(&models.Block{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotBlocked
+	}
+	return nil
+}
+
+// ListBlocks returns the users userID has blocked
+func (s *Service) ListBlocks(userID uint) ([]models.Block, error) {
+	var blocks []models.Block
+	err := s.db.Preload("Blocked").Where("blocker_id = ?", userID).Order("created_at DESC").Find(&blocks).Error
+	return blocks, err
+}
+
+// checkTarget verifies that userID may send a request to or follow otherID
+func (s *Service) checkTarget(userID, otherID uint) error {
+	if userID == otherID {
+		return ErrSelf
+	}
+	if err := s.checkUser(otherID); err != nil {
+		return err
+	}
+	blocked, err := s.games.Blocked(userID, otherID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return game.ErrBlocked
+	}
+	return nil
+}
+
+// notify tells userID about a friend request event from fromID
+func (s *Service) notify(userID uint, kind models.NotificationType, fromID uint) {
+	if s.notifications == nil {
+		return
+	}
+	var from models.User
+	if err := s.db.Select("id", "username").First(&from, fromID).Error; err != nil {
+		log.Printf("social: failed to load user %d: %v", fromID, err)
+		return
+	}
+	data := models.JSONMap{"userId": from.ID, "username": from.Username}
+	if err := s.notifications.Notify(userID, kind, 0, data); err != nil {
+		log.Printf("social: failed to notify user %d: %v", userID, err)
+	}
+}
+
+func (s *Service) checkUser(userID uint) error {
+	var count int64
+	if err := s.db.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *Service) findFriendship(userID, otherID uint) (*models.Friendship, error) {
+	var friendship models.Friendship
+	err := s.db.Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)", userID, otherID, otherID, userID).
+		First(&friendship).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &friendship, nil
+}
@@ -0,0 +1,115 @@
+//go:build postgres
+
+package social
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testDB migrates a schema of its own, dropped after the test, so that the
+// database in DATABASE_URL is left as it was.
+// Run with a database: DATABASE_URL=... go test -tags postgres ./internal/social
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn, err := models.DatabaseDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := models.ConnectDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("social_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+schema), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := models.AutoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func createUsers(t *testing.T, db *gorm.DB, names ...string) []uint {
+	t.Helper()
+	ids := make([]uint, len(names))
+	for i, name := range names {
+		user := models.User{Username: name, Email: name + "@example.com", PasswordHash: "x"}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+func TestFriendshipUniqueWhicheverWayRound(t *testing.T) {
+	db := testDB(t)
+	ids := createUsers(t, db, "alice", "bob")
+
+	if err := db.Create(&models.Friendship{RequesterID: ids[0], AddresseeID: ids[1]}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.Friendship{RequesterID: ids[1], AddresseeID: ids[0]}).Error; err == nil {
+		t.Error("stored a second friendship for the same pair")
+	}
+}
+
+func TestCrossedFriendRequestsMakeFriends(t *testing.T) {
+	db := testDB(t)
+	s := NewService(game.NewService(db), nil, nil)
+	ids := createUsers(t, db, "alice", "bob", "carol")
+
+	if _, err := s.RequestFriend(ids[0], ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	friendship, err := s.RequestFriend(ids[1], ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if friendship.Status != models.FriendshipStatusAccepted {
+		t.Errorf("status %q, want accepted", friendship.Status)
+	}
+	if _, err := s.RequestFriend(ids[2], ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AcceptFriend(ids[0], ids[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	friends, err := s.ListFriends(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(friends) != 2 {
+		t.Fatalf("%d friends, want 2", len(friends))
+	}
+	for _, friend := range friends {
+		if friend.Status != StatusOffline || friend.GameID != nil {
+			t.Errorf("friend %+v", friend)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package tournament
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBerserkNotAllowed = errors.New("berserk is not allowed in this tournament")
+	ErrBerserkTooLate    = errors.New("berserk is only possible before your first move")
+	ErrNotTournamentGame = errors.New("game is not part of this tournament")
+)
+
+// arena is the live state of a running arena tournament
+type arena struct {
+	pool  *game.MatchmakingService
+	timer *time.Timer
+}
+
+// arenaPoints scores a finished arena game for one player: 2 for a win and
+// 1 for a draw, doubled while on a streak of two or more wins. A berserk win
+// earns one more point. It also returns the player's new streak.
+func arenaPoints(score float64, streak int, berserk bool) (int, int) {
+	points, newStreak := 0, 0
+	switch score {
+	case 1:
+		points, newStreak = 2, streak+1
+	case 0.5:
+		points = 1
+	}
+	if streak >= 2 {
+		points *= 2
+	}
+	if berserk && score == 1 {
+		points++
+	}
+	return points, newStreak
+}
+
+// openArena creates the arena's pairing pool, puts every active player in it
+// and schedules the end. The caller holds s.mu.
+func (s *Service) openArena(t *models.Tournament) error {
+	id := t.ID
+	a := &arena{
+		pool:  game.NewPool(s.arenaMatch(*t)),
+		timer: time.AfterFunc(time.Until(*t.EndsAt), func() { s.endArena(id) }),
+	}
+	s.arenas[t.ID] = a
+
+	var userIDs []uint
+	if err := s.db.Model(&models.TournamentPlayer{}).
+		Where("tournament_id = ? AND withdrawn = ?", t.ID, false).
+		Order("starting_rating DESC").
+		Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if err := s.enqueue(t, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeArena reopens an arena after a restart, or ends it if its time ran
+// out meanwhile. The caller holds s.mu.
+func (s *Service) resumeArena(t *models.Tournament) error {
+	if t.EndsAt == nil || !time.Now().Before(*t.EndsAt) {
+		return s.finish(t)
+	}
+	return s.openArena(t)
+}
+
+// endArena stops pairing once the arena's time is up. Games in progress are
+// played out and still score.
+func (s *Service) endArena(tournamentID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.arenas, tournamentID)
+	t, err := s.loadTournament(tournamentID)
+	if err != nil {
+		log.Printf("tournament %d: failed to end arena: %v", tournamentID, err)
+		return
+	}
+	if t.Status != models.TournamentStatusInProgress {
+		return
+	}
+	if err := s.finish(t); err != nil {
+		log.Printf("tournament %d: failed to end arena: %v", tournamentID, err)
+		return
+	}
+	s.publishStandings(t)
+}
+
+// enqueue puts a player in the arena's pairing pool unless they are still
+// playing. The caller holds s.mu.
+func (s *Service) enqueue(t *models.Tournament, userID uint) error {
+	a := s.arenas[t.ID]
+	if a == nil {
+		return nil
+	}
+
+	var playing int64
+	if err := s.db.Model(&models.TournamentPairing{}).
+		Where("tournament_id = ? AND result = '' AND game_id IS NOT NULL", t.ID).
+		Where("white_player_id = ? OR black_player_id = ?", userID, userID).
+		Count(&playing).Error; err != nil {
+		return err
+	}
+	if playing > 0 {
+		return nil
+	}
+
+	var player models.TournamentPlayer
+	if err := s.db.Where("tournament_id = ? AND user_id = ?", t.ID, userID).First(&player).Error; err != nil {
+		return err
+	}
+	if player.Withdrawn {
+		return nil
+	}
+
+	g, err := a.pool.JoinQueue(userID, player.StartingRating)
+	if err != nil {
+		return err
+	}
+	if g != nil {
+		// Both players learn about the game from the tournament feed, so the
+		// pool need not keep it for the one who was waiting
+		a.pool.TakeMatch(*g.WhitePlayerID)
+		a.pool.TakeMatch(*g.BlackPlayerID)
+	}
+	return nil
+}
+
+// arenaMatch returns the function the pool uses to start a game. Whoever has
+// had white less often in the arena gets white. It runs with s.mu held by
+// the caller of enqueue, so it must not lock it again.
+func (s *Service) arenaMatch(t models.Tournament) game.MatchFunc {
+	return func(whitePlayerID, blackPlayerID uint) (*models.Game, error) {
+		whiteBalance, err := s.colorBalance(t.ID, whitePlayerID)
+		if err != nil {
+			return nil, err
+		}
+		blackBalance, err := s.colorBalance(t.ID, blackPlayerID)
+		if err != nil {
+			return nil, err
+		}
+		if whiteBalance > blackBalance {
+			whitePlayerID, blackPlayerID = blackPlayerID, whitePlayerID
+		}
+
+		g, err := s.games.CreateGame(whitePlayerID, t.TimeControl, 0)
+		if err != nil {
+			return nil, err
+		}
+		pairing := models.TournamentPairing{
+			TournamentID:  t.ID,
+			WhitePlayerID: whitePlayerID,
+			BlackPlayerID: &blackPlayerID,
+			GameID:        &g.ID,
+		}
+		if err := s.db.Create(&pairing).Error; err != nil {
+			return nil, err
+		}
+		g, err = s.games.JoinGame(g.ID, blackPlayerID)
+		if err != nil {
+			return nil, err
+		}
+
+		s.publish(t.ID, map[string]interface{}{
+			"type":          "pairing",
+			"gameId":        g.ID,
+			"whitePlayerId": whitePlayerID,
+			"blackPlayerId": blackPlayerID,
+		})
+		return g, nil
+	}
+}
+
+// colorBalance returns how many more games a player has had with white than
+// with black in a tournament
+func (s *Service) colorBalance(tournamentID, userID uint) (int64, error) {
+	var balance int64
+	err := s.db.Model(&models.TournamentPairing{}).
+		Select("COALESCE(SUM(CASE WHEN white_player_id = ? THEN 1 ELSE -1 END), 0)", userID).
+		Where("tournament_id = ? AND (white_player_id = ? OR black_player_id = ?)", tournamentID, userID, userID).
+		Scan(&balance).Error
+	return balance, err
+}
+
+// recordArenaResult scores an arena game and sends both players back to
+// the pool. The caller holds s.mu.
+func (s *Service) recordArenaResult(t *models.Tournament, pairing *models.TournamentPairing, result models.GameResult) error {
+	whiteScore, blackScore, _ := points(result)
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var whitePoints, blackPoints int
+		var err error
+		if whitePoints, err = s.scoreArenaGame(tx, t.ID, pairing.WhitePlayerID, whiteScore, pairing.WhiteBerserk); err != nil {
+			return err
+		}
+		if blackPoints, err = s.scoreArenaGame(tx, t.ID, *pairing.BlackPlayerID, blackScore, pairing.BlackBerserk); err != nil {
+			return err
+		}
+		return tx.Model(pairing).Updates(map[string]interface{}{
+			"result":       result,
+			"white_points": whitePoints,
+			"black_points": blackPoints,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if t.Status != models.TournamentStatusInProgress {
+		return nil
+	}
+	if err := s.enqueue(t, pairing.WhitePlayerID); err != nil {
+		return err
+	}
+	return s.enqueue(t, *pairing.BlackPlayerID)
+}
+
+// scoreArenaGame adds a game's points to a player and updates their streak
+func (s *Service) scoreArenaGame(tx *gorm.DB, tournamentID, userID uint, score float64, berserk bool) (int, error) {
+	var player models.TournamentPlayer
+	if err := tx.Where("tournament_id = ? AND user_id = ?", tournamentID, userID).First(&player).Error; err != nil {
+		return 0, err
+	}
+	gained, streak := arenaPoints(score, player.Streak, berserk)
+	return gained, tx.Model(&player).Updates(map[string]interface{}{
+		"score":  gorm.Expr("score + ?", gained),
+		"streak": streak,
+	}).Error
+}
+
+// Berserk halves the player's clock in an arena game for an extra point if
+// they win. It must be used before the player's first move.
+func (s *Service) Berserk(tournamentID, gameID, userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.loadTournament(tournamentID)
+	if err != nil {
+		return err
+	}
+	if t.Format != models.TournamentFormatArena || !t.Berserk {
+		return ErrBerserkNotAllowed
+	}
+
+	var pairing models.TournamentPairing
+	if err := s.db.Where("tournament_id = ? AND game_id = ?", tournamentID, gameID).First(&pairing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotTournamentGame
+		}
+		return err
+	}
+
+	isWhite := pairing.WhitePlayerID == userID
+	isBlack := pairing.BlackPlayerID != nil && *pairing.BlackPlayerID == userID
+	if !isWhite && !isBlack {
+		return game.ErrNotInGame
+	}
+	if (isWhite && pairing.WhiteBerserk) || (isBlack && pairing.BlackBerserk) {
+		return nil
+	}
+
+	g, err := s.games.GetGame(gameID)
+	if err != nil {
+		return err
+	}
+	var moves int64
+	if err := s.db.Model(&models.Move{}).Where("game_id = ? AND player_id = ?", gameID, userID).Count(&moves).Error; err != nil {
+		return err
+	}
+	if g.Status != models.GameStatusActive || moves > 0 {
+		return ErrBerserkTooLate
+	}
+
+	halved := g.TimeControl / 2
+	berserkColumn, clockColumn := "white_berserk", "white_time_left"
+	if isBlack {
+		berserkColumn, clockColumn = "black_berserk", "black_time_left"
+		g.BlackTimeLeft = halved
+	} else {
+		g.WhiteTimeLeft = halved
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&pairing).Update(berserkColumn, true).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Game{}).Where("id = ?", gameID).Update(clockColumn, halved).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.games.AppendEvent(gameID, &game.BerserkEvent{
+		EventHeader:   game.EventHeader{Type: game.MsgBerserk},
+		PlayerID:      userID,
+		WhiteTimeLeft: g.WhiteTimeLeft,
+		BlackTimeLeft: g.BlackTimeLeft,
+	})
+}
+
+// computeArenaStandings ranks arena players by points, then performance
+// rating (average opponent rating plus 500 per net win per game)
+func computeArenaStandings(players []models.TournamentPlayer, pairings []models.TournamentPairing) []Standing {
+	ratings := make(map[uint]int, len(players))
+	for _, p := range players {
+		ratings[p.UserID] = p.StartingRating
+	}
+
+	opponentRatings := make(map[uint]int)
+	netWins := make(map[uint]int)
+	played := make(map[uint]int)
+	for _, p := range pairings {
+		whiteScore, blackScore, ok := points(p.Result)
+		if !ok || p.BlackPlayerID == nil || p.Annulled {
+			continue
+		}
+		white, black := p.WhitePlayerID, *p.BlackPlayerID
+		played[white]++
+		played[black]++
+		opponentRatings[white] += ratings[black]
+		opponentRatings[black] += ratings[white]
+		netWins[white] += int(whiteScore*2) - 1
+		netWins[black] += int(blackScore*2) - 1
+	}
+
+	standings := make([]Standing, len(players))
+	for i, player := range players {
+		s := Standing{
+			UserID:    player.UserID,
+			Rating:    player.StartingRating,
+			Score:     player.Score,
+			Played:    played[player.UserID],
+			Streak:    player.Streak,
+			Withdrawn: player.Withdrawn,
+		}
+		if player.User != nil {
+			s.Username = player.User.Username
+		}
+		if n := played[player.UserID]; n > 0 {
+			s.Performance = (opponentRatings[player.UserID] + 500*netWins[player.UserID]) / n
+		}
+		standings[i] = s
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Performance != b.Performance {
+			return a.Performance > b.Performance
+		}
+		return a.Rating > b.Rating
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings
+}
@@ -0,0 +1,130 @@
+package tournament
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"chess-app/internal/models"
+)
+
+// CrosstableGame is one game of a crosstable cell, seen from the row
+// player's side
+type CrosstableGame struct {
+	GameID     uint     `json:"gameId"`
+	Color      string   `json:"color"` // "white" or "black"
+	Score      *float64 `json:"score"` // nil while the game is in progress or if annulled
+	Armageddon bool     `json:"armageddon,omitempty"`
+	Annulled   bool     `json:"annulled,omitempty"`
+}
+
+// CrosstableRow is a player's line: their standing and, for each row of the
+// table, the games they played against that player
+type CrosstableRow struct {
+	Standing
+	Results [][]CrosstableGame `json:"results"`
+}
+
+// Crosstable lists the results between every pair of players, in standings
+// order
+type Crosstable struct {
+	TournamentID uint            `json:"tournamentId"`
+	Rows         []CrosstableRow `json:"rows"`
+}
+
+// GetCrosstable builds the crosstable of a tournament from its games
+func (s *Service) GetCrosstable(tournamentID uint) (*Crosstable, error) {
+	standings, err := s.GetStandings(tournamentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var games []struct {
+		GameID        uint
+		WhitePlayerID uint
+		BlackPlayerID uint
+		Armageddon    bool
+		Annulled      bool
+		Result        models.GameResult
+	}
+	if err := s.db.Table("tournament_pairings").
+		Select("tournament_pairings.game_id, tournament_pairings.white_player_id, tournament_pairings.black_player_id, tournament_pairings.armageddon, tournament_pairings.annulled, games.result").
+		Joins("JOIN games ON games.id = tournament_pairings.game_id").
+		Where("tournament_pairings.tournament_id = ?", tournamentID).
+		Order("tournament_pairings.id ASC").
+		Scan(&games).Error; err != nil {
+		return nil, err
+	}
+
+	index := make(map[uint]int, len(standings))
+	table := &Crosstable{TournamentID: tournamentID, Rows: make([]CrosstableRow, len(standings))}
+	for i, standing := range standings {
+		index[standing.UserID] = i
+		table.Rows[i] = CrosstableRow{Standing: standing, Results: make([][]CrosstableGame, len(standings))}
+	}
+
+	for _, g := range games {
+		white, okWhite := index[g.WhitePlayerID]
+		black, okBlack := index[g.BlackPlayerID]
+		if !okWhite || !okBlack {
+			continue
+		}
+		whiteGame := CrosstableGame{GameID: g.GameID, Color: "white", Armageddon: g.Armageddon, Annulled: g.Annulled}
+		blackGame := CrosstableGame{GameID: g.GameID, Color: "black", Armageddon: g.Armageddon, Annulled: g.Annulled}
+		if whitePoints, blackPoints, ok := points(g.Result); ok && !g.Annulled {
+			whiteGame.Score, blackGame.Score = &whitePoints, &blackPoints
+		}
+		table.Rows[white].Results[black] = append(table.Rows[white].Results[black], whiteGame)
+		table.Rows[black].Results[white] = append(table.Rows[black].Results[white], blackGame)
+	}
+	return table, nil
+}
+
+// Text renders the crosstable as a plain-text grid. Each cell lists the row
+// player's scores against the column player, "*" marking a game in progress.
+func (t *Crosstable) Text() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+
+	fmt.Fprint(w, "#\tPlayer\tRating")
+	for i := range t.Rows {
+		fmt.Fprintf(w, "\t%d", i+1)
+	}
+	fmt.Fprint(w, "\tScore\t\n")
+
+	for i, row := range t.Rows {
+		fmt.Fprintf(w, "%d\t%s\t%d", row.Rank, row.Username, row.Rating)
+		for j, cell := range row.Results {
+			switch {
+			case i == j:
+				fmt.Fprint(w, "\tX")
+			case len(cell) == 0:
+				fmt.Fprint(w, "\t.")
+			default:
+				fmt.Fprint(w, "\t")
+				for _, g := range cell {
+					if g.Annulled {
+						fmt.Fprint(w, "-")
+						continue
+					}
+					fmt.Fprint(w, formatScore(g.Score))
+				}
+			}
+		}
+		fmt.Fprintf(w, "\t%g\t\n", row.Score)
+	}
+
+	w.Flush()
+	return b.String()
+}
+
+func formatScore(score *float64) string {
+	switch {
+	case score == nil:
+		return "*"
+	case *score == 0.5:
+		return "½"
+	default:
+		return fmt.Sprintf("%g", *score)
+	}
+}
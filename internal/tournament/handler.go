@@ -0,0 +1,238 @@
+package tournament
+
+import (
+	"net/http"
+	"strconv"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+type CreateTournamentRequest struct {
+	Name        string                  `json:"name" binding:"required"`
+	Format      models.TournamentFormat `json:"format"`      // "swiss" (default), "arena", "round_robin" or "knockout"
+	TimeControl int                     `json:"timeControl"` // Time in seconds per player (default: 600 = 10 minutes)
+	Rounds      int                     `json:"rounds"`      // Swiss: number of rounds to play
+	Duration    int                     `json:"duration"`    // Arena: length in minutes
+	Berserk     bool                    `json:"berserk"`     // Arena: allow halving your clock for an extra point
+	MatchGames  int                     `json:"matchGames"`  // Knockout: games per match before an armageddon (default: 2)
+}
+
+// CreateTournament opens a new tournament organised by the current user
+func (h *Handler) CreateTournament(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req CreateTournamentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, err := h.service.CreateTournament(userID, Settings{
+		Name:        req.Name,
+		Format:      req.Format,
+		TimeControl: req.TimeControl,
+		Rounds:      req.Rounds,
+		Duration:    req.Duration,
+		Berserk:     req.Berserk,
+		MatchGames:  req.MatchGames,
+	})
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, t)
+}
+
+// ListTournaments returns recent tournaments: GET /api/tournaments?status=registering
+func (h *Handler) ListTournaments(c *gin.Context) {
+	tournaments, err := h.service.ListTournaments(models.TournamentStatus(c.Query("status")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tournaments": tournaments})
+}
+
+// GetTournament returns a tournament with its players
+func (h *Handler) GetTournament(c *gin.Context) {
+	tournamentID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	t, err := h.service.GetTournament(tournamentID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+// Join registers the current user
+func (h *Handler) Join(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	tournamentID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	player, err := h.service.Join(tournamentID, userID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, player)
+}
+
+// Withdraw removes the current user from the tournament
+func (h *Handler) Withdraw(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	tournamentID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Withdraw(tournamentID, userID); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Withdrawn from tournament"})
+}
+
+// Start closes registration and pairs the first round. Only the organizer
+// can start a tournament.
+func (h *Handler) Start(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	tournamentID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	t, err := h.service.Start(tournamentID, userID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+// Berserk halves the current user's clock in an arena game:
+// POST /api/tournaments/:id/games/:gameId/berserk
+func (h *Handler) Berserk(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	tournamentID, ok := parseID(c)
+	if !ok {
+		return
+	}
+	gameID, err := strconv.ParseUint(c.Param("gameId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid game ID"})
+		return
+	}
+
+	if err := h.service.Berserk(tournamentID, uint(gameID), userID); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Berserk"})
+}
+
+// GetStandings returns the table with its tie-breaks
+func (h *Handler) GetStandings(c *gin.Context) {
+	tournamentID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	standings, err := h.service.GetStandings(tournamentID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"standings": standings})
+}
+
+// GetCrosstable returns the results between every pair of players:
+// GET /api/tournaments/:id/crosstable?format=text
+func (h *Handler) GetCrosstable(c *gin.Context) {
+	tournamentID, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	table, err := h.service.GetCrosstable(tournamentID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	if c.Query("format") == "text" {
+		c.String(http.StatusOK, table.Text())
+		return
+	}
+	c.JSON(http.StatusOK, table)
+}
+
+// GetRound returns the pairings of a round: GET /api/tournaments/:id/rounds/:round
+func (h *Handler) GetRound(c *gin.Context) {
+	tournamentID, ok := parseID(c)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(c.Param("round"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid round number"})
+		return
+	}
+
+	round, err := h.service.GetRound(tournamentID, number)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, round)
+}
+
+func (h *Handler) respondError(c *gin.Context, err error) {
+	switch err {
+	case ErrTournamentNotFound, ErrRoundNotFound, ErrNotTournamentGame:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case ErrNotOrganizer, game.ErrNotInGame:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case ErrRegistrationClosed, ErrAlreadyRegistered, ErrAlreadyStarted, ErrBerserkTooLate:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case ErrInvalidFormat, ErrInvalidRounds, ErrInvalidDuration, ErrInvalidMatchGames, ErrNotRegistered, ErrNotEnoughPlayers, ErrBerserkNotAllowed:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func parseID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tournament ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
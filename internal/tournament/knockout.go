@@ -0,0 +1,304 @@
+package tournament
+
+import (
+	"fmt"
+	"sort"
+
+	"chess-app/internal/models"
+)
+
+// knockoutRounds returns the number of bracket rounds for n players
+func knockoutRounds(n int) int {
+	rounds := 0
+	for size := 1; size < n; size *= 2 {
+		rounds++
+	}
+	return rounds
+}
+
+// bracketOrder lists the seeds of a bracket of the given size (a power of
+// two) in match order, so that the top seeds can only meet in the late
+// rounds: 1-8, 4-5, 2-7, 3-6 for eight players
+func bracketOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		next := make([]int, 0, 2*len(order))
+		total := 2*len(order) + 1
+		for _, seed := range order {
+			next = append(next, seed, total-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// matchState is the progress of a knockout match
+type matchState struct {
+	winner uint                      // 0 while the match is undecided
+	next   *models.TournamentPairing // Game to play next, if any
+}
+
+// evaluateMatch decides a knockout match from its games, oldest first. The
+// first game's white is the higher seed. Colors alternate for matchGames
+// games; a tie after them is settled by an armageddon game in which the
+// higher seed has white and a draw counts as a win for black.
+func evaluateMatch(games []models.TournamentPairing, matchGames int) matchState {
+	first := games[0]
+	if first.BlackPlayerID == nil {
+		return matchState{winner: first.WhitePlayerID}
+	}
+	a, b := first.WhitePlayerID, *first.BlackPlayerID
+
+	var scoreA, scoreB float64
+	played := 0
+	for _, g := range games {
+		if g.Result == models.GameResultNone {
+			return matchState{}
+		}
+		if g.Armageddon {
+			if g.Result == models.GameResultWhiteWins {
+				return matchState{winner: g.WhitePlayerID}
+			}
+			return matchState{winner: *g.BlackPlayerID}
+		}
+		whitePoints, blackPoints, _ := points(g.Result)
+		if g.WhitePlayerID == a {
+			scoreA, scoreB = scoreA+whitePoints, scoreB+blackPoints
+		} else {
+			scoreA, scoreB = scoreA+blackPoints, scoreB+whitePoints
+		}
+		played++
+	}
+
+	remaining := float64(matchGames - played)
+	switch {
+	case scoreA > scoreB+remaining:
+		return matchState{winner: a}
+	case scoreB > scoreA+remaining:
+		return matchState{winner: b}
+	}
+
+	next := &models.TournamentPairing{Board: first.Board, WhitePlayerID: a, BlackPlayerID: &b}
+	if played < matchGames {
+		if played%2 == 1 {
+			next.WhitePlayerID, next.BlackPlayerID = b, &a
+		}
+	} else {
+		next.Armageddon = true
+	}
+	return matchState{next: next}
+}
+
+// planKnockout draws the bracket for the first round, then pairs the
+// winners of neighbouring matches
+func (s *Service) planKnockout(t *models.Tournament, number int) ([]models.TournamentPairing, error) {
+	var players []models.TournamentPlayer
+	if err := s.db.Where("tournament_id = ?", t.ID).Find(&players).Error; err != nil {
+		return nil, err
+	}
+	sort.SliceStable(players, func(i, j int) bool {
+		if players[i].StartingRating != players[j].StartingRating {
+			return players[i].StartingRating > players[j].StartingRating
+		}
+		return players[i].ID < players[j].ID
+	})
+	seeds := make(map[uint]int, len(players))
+	for i, p := range players {
+		seeds[p.UserID] = i + 1
+	}
+
+	var entrants []uint
+	if number == 1 {
+		// Seeds beyond the field are byes for their opponents
+		for _, seed := range bracketOrder(1 << t.NumRounds) {
+			if seed <= len(players) {
+				entrants = append(entrants, players[seed-1].UserID)
+			} else {
+				entrants = append(entrants, 0)
+			}
+		}
+	} else {
+		winners, err := s.knockoutWinners(t, number-1)
+		if err != nil {
+			return nil, err
+		}
+		entrants = winners
+	}
+
+	var pairings []models.TournamentPairing
+	for k := 0; k+1 < len(entrants); k += 2 {
+		a, b := entrants[k], entrants[k+1]
+		if a == 0 {
+			a, b = b, a
+		}
+		pairing := models.TournamentPairing{Board: k/2 + 1, WhitePlayerID: a}
+		if b == 0 {
+			pairing.Result = models.GameResultWhiteWins
+		} else {
+			if seeds[b] < seeds[a] {
+				pairing.WhitePlayerID, b = b, a
+			}
+			pairing.BlackPlayerID = &b
+		}
+		pairings = append(pairings, pairing)
+	}
+	return pairings, nil
+}
+
+// knockoutWinners returns the winners of a round's matches in bracket order
+func (s *Service) knockoutWinners(t *models.Tournament, number int) ([]uint, error) {
+	var round models.Round
+	if err := s.db.Where("tournament_id = ? AND number = ?", t.ID, number).First(&round).Error; err != nil {
+		return nil, err
+	}
+	var pairings []models.TournamentPairing
+	if err := s.db.Where("round_id = ?", round.ID).Order("board ASC, id ASC").Find(&pairings).Error; err != nil {
+		return nil, err
+	}
+
+	var winners []uint
+	for _, match := range groupMatches(pairings) {
+		state := evaluateMatch(match, t.MatchGames)
+		if state.winner == 0 {
+			return nil, fmt.Errorf("round %d board %d is undecided", number, match[0].Board)
+		}
+		winners = append(winners, state.winner)
+	}
+	return winners, nil
+}
+
+// recordKnockoutResult scores a match game, then schedules the match's next
+// game or, once every match of the round is decided, the next round. The
+// caller holds s.mu.
+func (s *Service) recordKnockoutResult(t *models.Tournament, pairing *models.TournamentPairing, result models.GameResult) error {
+	if err := s.db.Model(pairing).Update("result", result).Error; err != nil {
+		return err
+	}
+
+	var match []models.TournamentPairing
+	if err := s.db.Where("round_id = ? AND board = ?", pairing.RoundID, pairing.Board).
+		Order("id ASC").Find(&match).Error; err != nil {
+		return err
+	}
+
+	state := evaluateMatch(match, t.MatchGames)
+	if state.next != nil {
+		next := state.next
+		next.TournamentID = t.ID
+		next.RoundID = pairing.RoundID
+		if err := s.db.Create(next).Error; err != nil {
+			return err
+		}
+		return s.startGame(t, next)
+	}
+	return s.advance(t)
+}
+
+// groupMatches splits a round's pairings, sorted by board then ID, into
+// matches
+func groupMatches(pairings []models.TournamentPairing) [][]models.TournamentPairing {
+	var matches [][]models.TournamentPairing
+	for i := 0; i < len(pairings); {
+		j := i
+		for j < len(pairings) && pairings[j].Board == pairings[i].Board {
+			j++
+		}
+		matches = append(matches, pairings[i:j])
+		i = j
+	}
+	return matches
+}
+
+// computeKnockoutStandings ranks players by how far they got: the champion
+// first, then by the round in which they were knocked out, then by game
+// points and starting rating
+func computeKnockoutStandings(t *models.Tournament, players []models.TournamentPlayer, rounds []models.Round, pairings []models.TournamentPairing) []Standing {
+	roundNumbers := make(map[uint]int, len(rounds))
+	for _, r := range rounds {
+		roundNumbers[r.ID] = r.Number
+	}
+	sort.SliceStable(pairings, func(i, j int) bool {
+		ri, rj := roundNumbers[*pairings[i].RoundID], roundNumbers[*pairings[j].RoundID]
+		if ri != rj {
+			return ri < rj
+		}
+		if pairings[i].Board != pairings[j].Board {
+			return pairings[i].Board < pairings[j].Board
+		}
+		return pairings[i].ID < pairings[j].ID
+	})
+
+	reached := make(map[uint]int, len(players))
+	eliminated := make(map[uint]bool, len(players))
+	scores := make(map[uint]float64, len(players))
+	played := make(map[uint]int, len(players))
+	var matches [][]models.TournamentPairing
+	for i := 0; i < len(pairings); {
+		j := i
+		for j < len(pairings) && *pairings[j].RoundID == *pairings[i].RoundID && pairings[j].Board == pairings[i].Board {
+			j++
+		}
+		matches = append(matches, pairings[i:j])
+		i = j
+	}
+	for _, match := range matches {
+		number := roundNumbers[*match[0].RoundID]
+		a := match[0].WhitePlayerID
+		reached[a] = number
+		if match[0].BlackPlayerID == nil {
+			continue
+		}
+		b := *match[0].BlackPlayerID
+		reached[b] = number
+		for _, g := range match {
+			whitePoints, blackPoints, ok := points(g.Result)
+			if !ok || g.Annulled {
+				continue
+			}
+			scores[g.WhitePlayerID] += whitePoints
+			scores[*g.BlackPlayerID] += blackPoints
+			played[g.WhitePlayerID]++
+			played[*g.BlackPlayerID]++
+		}
+		switch evaluateMatch(match, t.MatchGames).winner {
+		case a:
+			eliminated[b] = true
+		case b:
+			eliminated[a] = true
+		}
+	}
+
+	standings := make([]Standing, len(players))
+	for i, player := range players {
+		s := Standing{
+			UserID:       player.UserID,
+			Rating:       player.StartingRating,
+			Score:        scores[player.UserID],
+			Played:       played[player.UserID],
+			RoundReached: reached[player.UserID],
+			Withdrawn:    player.Withdrawn,
+		}
+		if player.User != nil {
+			s.Username = player.User.Username
+		}
+		standings[i] = s
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if eliminated[a.UserID] != eliminated[b.UserID] {
+			return !eliminated[a.UserID]
+		}
+		if a.RoundReached != b.RoundReached {
+			return a.RoundReached > b.RoundReached
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Rating > b.Rating
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings
+}
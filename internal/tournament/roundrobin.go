@@ -0,0 +1,76 @@
+package tournament
+
+import (
+	"sort"
+
+	"chess-app/internal/models"
+)
+
+// roundRobinRounds returns the number of rounds for n players. With an odd
+// count one player sits out each round.
+func roundRobinRounds(n int) int {
+	if n%2 == 1 {
+		return n
+	}
+	return n - 1
+}
+
+// bergerRound returns the games of a round from the Berger tables as
+// (white, black) pairs of seeds, numbered from 1. n must be even; the last
+// seed stays in place while the others rotate by n/2 each round.
+func bergerRound(n, round int) [][2]int {
+	m := n - 1
+	seat := func(i int) int {
+		return ((round-1)*(n/2)+i)%m + 1
+	}
+
+	games := make([][2]int, 0, n/2)
+	if round%2 == 1 {
+		games = append(games, [2]int{seat(0), n})
+	} else {
+		games = append(games, [2]int{n, seat(0)})
+	}
+	for k := 1; k < n/2; k++ {
+		games = append(games, [2]int{seat(k), seat(m - k)})
+	}
+	return games
+}
+
+// planRoundRobin looks up a round in the Berger tables. Seeds follow the
+// starting ratings; a player meeting the missing seed of an odd field, or a
+// withdrawn player, has no game that round.
+func (s *Service) planRoundRobin(t *models.Tournament, number int) ([]models.TournamentPairing, error) {
+	var players []models.TournamentPlayer
+	if err := s.db.Where("tournament_id = ?", t.ID).Find(&players).Error; err != nil {
+		return nil, err
+	}
+	sort.SliceStable(players, func(i, j int) bool {
+		if players[i].StartingRating != players[j].StartingRating {
+			return players[i].StartingRating > players[j].StartingRating
+		}
+		return players[i].ID < players[j].ID
+	})
+
+	n := len(players)
+	if n%2 == 1 {
+		n++
+	}
+
+	var pairings []models.TournamentPairing
+	for _, g := range bergerRound(n, number) {
+		if g[0] > len(players) || g[1] > len(players) {
+			continue
+		}
+		white, black := players[g[0]-1], players[g[1]-1]
+		if white.Withdrawn || black.Withdrawn {
+			continue
+		}
+		blackID := black.UserID
+		pairings = append(pairings, models.TournamentPairing{
+			Board:         len(pairings) + 1,
+			WhitePlayerID: white.UserID,
+			BlackPlayerID: &blackID,
+		})
+	}
+	return pairings, nil
+}
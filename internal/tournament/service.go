@@ -0,0 +1,879 @@
+// Package tournament organises events whose games are paired by the server.
+package tournament
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+	"chess-app/internal/notification"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTournamentNotFound = errors.New("tournament not found")
+	ErrRoundNotFound      = errors.New("round not found")
+	ErrInvalidFormat      = errors.New("unsupported tournament format")
+	ErrInvalidRounds      = errors.New("number of rounds must be between 1 and 20")
+	ErrNotOrganizer       = errors.New("only the organizer can do this")
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrAlreadyRegistered  = errors.New("already registered")
+	ErrNotRegistered      = errors.New("not registered in this tournament")
+	ErrAlreadyStarted     = errors.New("tournament has already started")
+	ErrNotEnoughPlayers   = errors.New("at least two players are required")
+	ErrInvalidDuration    = errors.New("arena duration must be between 1 and 720 minutes")
+	ErrInvalidMatchGames  = errors.New("knockout matches must have between 1 and 6 games")
+)
+
+const (
+	maxRounds        = 20
+	maxArenaDuration = 720 // Minutes
+)
+
+// Knockout matches
+const (
+	defaultMatchGames = 2
+	maxMatchGames     = 6
+	// Black's clock in an armageddon game, in percent of the time control
+	armageddonBlackTime = 80
+)
+
+type Service struct {
+	db            *gorm.DB
+	games         *game.Service
+	hub           *game.Hub
+	notifications *notification.Service
+
+	// Serialises result recording and pairing, so two games finishing at
+	// once cannot both pair the next round
+	mu     sync.Mutex
+	arenas map[uint]*arena // tournamentID -> running arena
+}
+
+// NewService creates a tournament service. Tournament games are created and
+// played through the game service like any other game; live updates are
+// pushed to the tournament's followers through the hub, and players are
+// notified when their round starts.
+func NewService(games *game.Service, hub *game.Hub, notifications *notification.Service) *Service {
+	return &Service{
+		db:            games.GetDB(),
+		games:         games,
+		hub:           hub,
+		notifications: notifications,
+		arenas:        make(map[uint]*arena),
+	}
+}
+
+// Settings describes a new tournament
+type Settings struct {
+	Name        string
+	Format      models.TournamentFormat
+	TimeControl int
+	Rounds      int  // Swiss only; round robins and knockouts derive it
+	Duration    int  // Arena only, in minutes
+	Berserk     bool // Arena only
+	MatchGames  int  // Knockout only, games per match before an armageddon
+}
+
+// CreateTournament opens a tournament for registration
+func (s *Service) CreateTournament(organizerID uint, settings Settings) (*models.Tournament, error) {
+	t := &models.Tournament{
+		Name:        settings.Name,
+		Format:      settings.Format,
+		Status:      models.TournamentStatusRegistering,
+		TimeControl: settings.TimeControl,
+		CreatedByID: organizerID,
+	}
+	if t.Format == "" {
+		t.Format = models.TournamentFormatSwiss
+	}
+	if t.TimeControl <= 0 {
+		t.TimeControl = 600
+	}
+
+	switch t.Format {
+	case models.TournamentFormatSwiss:
+		if settings.Rounds < 1 || settings.Rounds > maxRounds {
+			return nil, ErrInvalidRounds
+		}
+		t.NumRounds = settings.Rounds
+	case models.TournamentFormatArena:
+		if settings.Duration < 1 || settings.Duration > maxArenaDuration {
+			return nil, ErrInvalidDuration
+		}
+		t.Duration = settings.Duration
+		t.Berserk = settings.Berserk
+	case models.TournamentFormatRoundRobin:
+	case models.TournamentFormatKnockout:
+		t.MatchGames = settings.MatchGames
+		if t.MatchGames == 0 {
+			t.MatchGames = defaultMatchGames
+		}
+		if t.MatchGames < 1 || t.MatchGames > maxMatchGames {
+			return nil, ErrInvalidMatchGames
+		}
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	if err := s.db.Create(t).Error; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetTournament returns a tournament with its registered players
+func (s *Service) GetTournament(tournamentID uint) (*models.Tournament, error) {
+	var t models.Tournament
+	if err := s.db.Preload("Players", func(db *gorm.DB) *gorm.DB {
+		return db.Order("starting_rating DESC, id ASC")
+	}).Preload("Players.User").First(&t, tournamentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTournamentNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListTournaments returns tournaments, newest first, optionally filtered by
+// status
+func (s *Service) ListTournaments(status models.TournamentStatus) ([]models.Tournament, error) {
+	query := s.db.Order("created_at DESC").Limit(50)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var tournaments []models.Tournament
+	if err := query.Find(&tournaments).Error; err != nil {
+		return nil, err
+	}
+	return tournaments, nil
+}
+
+// Join registers a user, seeded by their current rating. A running arena
+// can be joined at any time, including by players coming back after
+// withdrawing.
+func (s *Service) Join(tournamentID, userID uint) (*models.TournamentPlayer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.loadTournament(tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	lateEntry := t.Format == models.TournamentFormatArena && t.Status == models.TournamentStatusInProgress
+	if t.Status != models.TournamentStatusRegistering && !lateEntry {
+		return nil, ErrRegistrationClosed
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	var player models.TournamentPlayer
+	err = s.db.Where("tournament_id = ? AND user_id = ?", tournamentID, userID).First(&player).Error
+	switch {
+	case err == nil && lateEntry && player.Withdrawn:
+		if err := s.db.Model(&player).Update("withdrawn", false).Error; err != nil {
+			return nil, err
+		}
+	case err == nil:
+		return nil, ErrAlreadyRegistered
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		player = models.TournamentPlayer{
+			TournamentID:   tournamentID,
+			UserID:         userID,
+			StartingRating: user.ELORating,
+		}
+		if err := s.db.Create(&player).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if lateEntry {
+		if err := s.enqueue(t, userID); err != nil {
+			return nil, err
+		}
+	}
+	return &player, nil
+}
+
+// Withdraw removes a user from a tournament. Once it has started they are
+// kept in the standings but no longer paired; a game in progress is played
+// out normally. Knockout players cannot withdraw once the bracket is drawn.
+func (s *Service) Withdraw(tournamentID, userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.loadTournament(tournamentID)
+	if err != nil {
+		return err
+	}
+
+	query := s.db.Where("tournament_id = ? AND user_id = ?", tournamentID, userID)
+	var result *gorm.DB
+	switch t.Status {
+	case models.TournamentStatusRegistering:
+		result = query.Delete(&models.TournamentPlayer{})
+	case models.TournamentStatusInProgress:
+		if t.Format == models.TournamentFormatKnockout {
+			return ErrAlreadyStarted
+		}
+		result = query.Model(&models.TournamentPlayer{}).Update("withdrawn", true)
+	default:
+		return ErrRegistrationClosed
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotRegistered
+	}
+	if a := s.arenas[t.ID]; a != nil {
+		a.pool.LeaveQueue(userID)
+	}
+	return nil
+}
+
+// Start closes registration and pairs the first round, or opens the
+// pairing pool of an arena
+func (s *Service) Start(tournamentID, userID uint) (*models.Tournament, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.loadTournament(tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	if t.CreatedByID != userID {
+		return nil, ErrNotOrganizer
+	}
+	if t.Status != models.TournamentStatusRegistering {
+		return nil, ErrAlreadyStarted
+	}
+
+	var count int64
+	if err := s.db.Model(&models.TournamentPlayer{}).Where("tournament_id = ?", t.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count < 2 {
+		return nil, ErrNotEnoughPlayers
+	}
+
+	now := time.Now()
+	t.Status = models.TournamentStatusInProgress
+	t.StartedAt = &now
+	updates := map[string]interface{}{
+		"status":     t.Status,
+		"started_at": t.StartedAt,
+	}
+	switch t.Format {
+	case models.TournamentFormatArena:
+		endsAt := now.Add(time.Duration(t.Duration) * time.Minute)
+		t.EndsAt = &endsAt
+		updates["ends_at"] = t.EndsAt
+	case models.TournamentFormatRoundRobin:
+		t.NumRounds = roundRobinRounds(int(count))
+		updates["num_rounds"] = t.NumRounds
+	case models.TournamentFormatKnockout:
+		t.NumRounds = knockoutRounds(int(count))
+		updates["num_rounds"] = t.NumRounds
+	}
+	if err := s.db.Model(t).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	if t.Format == models.TournamentFormatArena {
+		err = s.openArena(t)
+	} else {
+		err = s.pairNextRound(t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetStandings returns the current table with tie-breaks
+func (s *Service) GetStandings(tournamentID uint) ([]Standing, error) {
+	t, err := s.loadTournament(tournamentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var players []models.TournamentPlayer
+	if err := s.db.Preload("User").Where("tournament_id = ?", tournamentID).Find(&players).Error; err != nil {
+		return nil, err
+	}
+	var pairings []models.TournamentPairing
+	if err := s.db.Where("tournament_id = ?", tournamentID).Find(&pairings).Error; err != nil {
+		return nil, err
+	}
+	switch t.Format {
+	case models.TournamentFormatArena:
+		return computeArenaStandings(players, pairings), nil
+	case models.TournamentFormatKnockout:
+		var rounds []models.Round
+		if err := s.db.Where("tournament_id = ?", tournamentID).Find(&rounds).Error; err != nil {
+			return nil, err
+		}
+		return computeKnockoutStandings(t, players, rounds, pairings), nil
+	}
+	return computeStandings(players, pairings), nil
+}
+
+// GetRound returns a round with its pairings, top board first
+func (s *Service) GetRound(tournamentID uint, number int) (*models.Round, error) {
+	var round models.Round
+	err := s.db.Where("tournament_id = ? AND number = ?", tournamentID, number).
+		Preload("Pairings", func(db *gorm.DB) *gorm.DB { return db.Order("board ASC") }).
+		Preload("Pairings.WhitePlayer").
+		Preload("Pairings.BlackPlayer").
+		First(&round).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoundNotFound
+		}
+		return nil, err
+	}
+	return &round, nil
+}
+
+// OnGameFinished records the result of a tournament game. It is registered
+// as a game.Service listener; the work runs in the background since it may
+// pair the next round.
+func (s *Service) OnGameFinished(g *models.Game) {
+	gameID, result := g.ID, g.Result
+	go func() {
+		if err := s.recordResult(gameID, result); err != nil {
+			log.Printf("tournament: failed to record result of game %d: %v", gameID, err)
+		}
+	}()
+}
+
+// OnGameAnnulled takes the points of an annulled tournament game back. It
+// is registered as a game.Service listener. Rounds and brackets the game
+// already decided are left as they are.
+func (s *Service) OnGameAnnulled(g *models.Game) {
+	gameID := g.ID
+	go func() {
+		if err := s.annulResult(gameID); err != nil {
+			log.Printf("tournament: failed to annul game %d: %v", gameID, err)
+		}
+	}()
+}
+
+// Resume records results of games that finished or were annulled while the
+// server was down, pairs rounds that were left unpaired and reopens running
+// arenas
+func (s *Service) Resume() error {
+	var pending []struct {
+		GameID uint
+		Result models.GameResult
+	}
+	if err := s.db.Table("tournament_pairings").
+		Select("games.id AS game_id, games.result").
+		Joins("JOIN games ON games.id = tournament_pairings.game_id").
+		Where("tournament_pairings.result = '' AND games.status = ?", models.GameStatusFinished).
+		Scan(&pending).Error; err != nil {
+		return err
+	}
+	for _, p := range pending {
+		if err := s.recordResult(p.GameID, p.Result); err != nil {
+			return err
+		}
+	}
+
+	var annulled []uint
+	if err := s.db.Table("tournament_pairings").
+		Joins("JOIN games ON games.id = tournament_pairings.game_id").
+		Where("NOT tournament_pairings.annulled AND games.annulled").
+		Pluck("games.id", &annulled).Error; err != nil {
+		return err
+	}
+	for _, gameID := range annulled {
+		if err := s.annulResult(gameID); err != nil {
+			return err
+		}
+	}
+
+	var tournaments []models.Tournament
+	if err := s.db.Where("status = ?", models.TournamentStatusInProgress).Find(&tournaments).Error; err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range tournaments {
+		t := &tournaments[i]
+		var err error
+		if t.Format == models.TournamentFormatArena {
+			err = s.resumeArena(t)
+		} else {
+			err = s.advance(t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) recordResult(gameID uint, result models.GameResult) error {
+	if _, _, ok := points(result); !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pairing models.TournamentPairing
+	if err := s.db.Where("game_id = ?", gameID).First(&pairing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // Not a tournament game
+		}
+		return err
+	}
+	if pairing.Result != models.GameResultNone {
+		return nil
+	}
+
+	t, err := s.loadTournament(pairing.TournamentID)
+	if err != nil {
+		return err
+	}
+	switch t.Format {
+	case models.TournamentFormatArena:
+		err = s.recordArenaResult(t, &pairing, result)
+	case models.TournamentFormatKnockout:
+		err = s.recordKnockoutResult(t, &pairing, result)
+	default:
+		err = s.recordRoundResult(t, &pairing, result)
+	}
+	if err != nil {
+		return err
+	}
+	// Annulled before its result came in
+	if pairing.Annulled {
+		if err := s.recomputeScores(s.db, t, &pairing); err != nil {
+			return err
+		}
+	}
+	s.publishStandings(t)
+	return nil
+}
+
+// annulResult marks a game's pairing as annulled and scores its players
+// again without it
+func (s *Service) annulResult(gameID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pairing models.TournamentPairing
+	if err := s.db.Where("game_id = ?", gameID).First(&pairing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // Not a tournament game
+		}
+		return err
+	}
+	if pairing.Annulled {
+		return nil
+	}
+
+	t, err := s.loadTournament(pairing.TournamentID)
+	if err != nil {
+		return err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&pairing).Updates(map[string]interface{}{
+			"annulled":     true,
+			"white_points": 0,
+			"black_points": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return s.recomputeScores(tx, t, &pairing)
+	})
+	if err != nil {
+		return err
+	}
+	s.publishStandings(t)
+	return nil
+}
+
+// recomputeScores sums the scores of a pairing's players again from their
+// pairings that were not annulled. Arena games count the points they were
+// awarded, other games their result. Knockout standings are worked out
+// from the bracket, so they keep no score.
+func (s *Service) recomputeScores(tx *gorm.DB, t *models.Tournament, pairing *models.TournamentPairing) error {
+	if t.Format == models.TournamentFormatKnockout {
+		return nil
+	}
+	players := []uint{pairing.WhitePlayerID}
+	if pairing.BlackPlayerID != nil {
+		players = append(players, *pairing.BlackPlayerID)
+	}
+	for _, userID := range players {
+		var pairings []models.TournamentPairing
+		if err := tx.Where("tournament_id = ? AND NOT annulled AND (white_player_id = ? OR black_player_id = ?)",
+			pairing.TournamentID, userID, userID).Find(&pairings).Error; err != nil {
+			return err
+		}
+		score := 0.0
+		for _, p := range pairings {
+			white := p.WhitePlayerID == userID
+			whitePoints, blackPoints, _ := points(p.Result)
+			arena := t.Format == models.TournamentFormatArena
+			switch {
+			case arena && white:
+				score += float64(p.WhitePoints)
+			case arena:
+				score += float64(p.BlackPoints)
+			case white:
+				score += whitePoints
+			default:
+				score += blackPoints
+			}
+		}
+		if err := tx.Model(&models.TournamentPlayer{}).
+			Where("tournament_id = ? AND user_id = ?", pairing.TournamentID, userID).
+			Update("score", score).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRoundResult scores a Swiss or round-robin game and moves the
+// tournament on once the round is complete. The caller holds s.mu.
+func (s *Service) recordRoundResult(t *models.Tournament, pairing *models.TournamentPairing, result models.GameResult) error {
+	whitePoints, blackPoints, _ := points(result)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(pairing).Update("result", result).Error; err != nil {
+			return err
+		}
+		if err := s.addScore(tx, pairing.TournamentID, pairing.WhitePlayerID, whitePoints); err != nil {
+			return err
+		}
+		if pairing.BlackPlayerID != nil {
+			return s.addScore(tx, pairing.TournamentID, *pairing.BlackPlayerID, blackPoints)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.advance(t)
+}
+
+// advance finishes the current round once every pairing has a result, then
+// pairs the next one or ends the tournament. The caller holds s.mu.
+func (s *Service) advance(t *models.Tournament) error {
+	if t.Status != models.TournamentStatusInProgress || t.Format == models.TournamentFormatArena {
+		return nil
+	}
+
+	var round models.Round
+	err := s.db.Where("tournament_id = ? AND number = ?", t.ID, t.CurrentRound).First(&round).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Started, but the first round was never paired
+		return s.pairNextRound(t)
+	}
+	if err != nil {
+		return err
+	}
+
+	var unfinished int64
+	if err := s.db.Model(&models.TournamentPairing{}).
+		Where("round_id = ? AND result = ''", round.ID).
+		Count(&unfinished).Error; err != nil {
+		return err
+	}
+	if unfinished > 0 {
+		return nil
+	}
+
+	if round.Status != models.RoundStatusFinished {
+		now := time.Now()
+		if err := s.db.Model(&round).Updates(map[string]interface{}{
+			"status":      models.RoundStatusFinished,
+			"finished_at": now,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	var active int64
+	if err := s.db.Model(&models.TournamentPlayer{}).
+		Where("tournament_id = ? AND withdrawn = ?", t.ID, false).
+		Count(&active).Error; err != nil {
+		return err
+	}
+	if t.CurrentRound >= t.NumRounds || (active < 2 && t.Format != models.TournamentFormatKnockout) {
+		return s.finish(t)
+	}
+	err = s.pairNextRound(t)
+	if errors.Is(err, ErrNoPairing) {
+		// Everyone left has already met everyone they could be paired with
+		log.Printf("tournament %d: ending after round %d: %v", t.ID, t.CurrentRound, err)
+		return s.finish(t)
+	}
+	return err
+}
+
+func (s *Service) finish(t *models.Tournament) error {
+	now := time.Now()
+	t.Status = models.TournamentStatusFinished
+	t.FinishedAt = &now
+	if err := s.db.Model(t).Updates(map[string]interface{}{
+		"status":      t.Status,
+		"finished_at": t.FinishedAt,
+	}).Error; err != nil {
+		return err
+	}
+	s.publish(t.ID, map[string]interface{}{"type": "finished"})
+	return nil
+}
+
+// pairNextRound plans the next round for the tournament's format, stores
+// it and creates its games. The caller holds s.mu.
+func (s *Service) pairNextRound(t *models.Tournament) error {
+	number := t.CurrentRound + 1
+	var pairings []models.TournamentPairing
+	var err error
+	switch t.Format {
+	case models.TournamentFormatRoundRobin:
+		pairings, err = s.planRoundRobin(t, number)
+	case models.TournamentFormatKnockout:
+		pairings, err = s.planKnockout(t, number)
+	default:
+		pairings, err = s.planSwiss(t, number)
+	}
+	if err != nil {
+		return err
+	}
+
+	round := models.Round{TournamentID: t.ID, Number: number, Status: models.RoundStatusInProgress}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&round).Error; err != nil {
+			return err
+		}
+		for i := range pairings {
+			pairings[i].TournamentID = t.ID
+			pairings[i].RoundID = &round.ID
+		}
+		if len(pairings) > 0 {
+			if err := tx.Create(&pairings).Error; err != nil {
+				return err
+			}
+		}
+		if t.Format == models.TournamentFormatSwiss {
+			// A Swiss bye scores a full point
+			for _, p := range pairings {
+				if p.BlackPlayerID != nil {
+					continue
+				}
+				if err := s.addScore(tx, t.ID, p.WhitePlayerID, 1); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Model(t).Update("current_round", number).Error
+	})
+	if err != nil {
+		return err
+	}
+	t.CurrentRound = number
+	s.publish(t.ID, map[string]interface{}{"type": "round", "round": number})
+
+	started := 0
+	for i := range pairings {
+		if pairings[i].BlackPlayerID == nil {
+			continue
+		}
+		if err := s.startGame(t, &pairings[i]); err != nil {
+			return fmt.Errorf("round %d board %d: %w", number, pairings[i].Board, err)
+		}
+		s.notifyRoundStarted(t, &pairings[i])
+		started++
+	}
+
+	// A round made only of byes is already over
+	if started == 0 {
+		return s.advance(t)
+	}
+	return nil
+}
+
+// planSwiss pairs a Swiss round with the Dutch system
+func (s *Service) planSwiss(t *models.Tournament, number int) ([]models.TournamentPairing, error) {
+	entrants, err := s.loadEntrants(t.ID)
+	if err != nil {
+		return nil, err
+	}
+	pairs, bye, err := pairSwiss(entrants, number)
+	if err != nil {
+		return nil, err
+	}
+
+	pairings := make([]models.TournamentPairing, 0, len(pairs)+1)
+	for i, p := range pairs {
+		blackID := p.black.id
+		pairings = append(pairings, models.TournamentPairing{
+			Board:         i + 1,
+			WhitePlayerID: p.white.id,
+			BlackPlayerID: &blackID,
+		})
+	}
+	if bye != nil {
+		pairings = append(pairings, models.TournamentPairing{
+			Board:         len(pairs) + 1,
+			WhitePlayerID: bye.id,
+			Result:        models.GameResultWhiteWins,
+		})
+	}
+	return pairings, nil
+}
+
+// startGame creates the game of a pairing through the game service. The
+// game ID is stored before the game starts so that its result can always be
+// matched to the pairing.
+func (s *Service) startGame(t *models.Tournament, pairing *models.TournamentPairing) error {
+	g, err := s.games.CreateGame(pairing.WhitePlayerID, t.TimeControl, 0)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(pairing).Update("game_id", g.ID).Error; err != nil {
+		return err
+	}
+	pairing.GameID = &g.ID
+	if pairing.Armageddon {
+		// Black has less time in exchange for draw odds
+		if err := s.db.Model(g).Update("black_time_left", t.TimeControl*armageddonBlackTime/100).Error; err != nil {
+			return err
+		}
+	}
+	_, err = s.games.JoinGame(g.ID, *pairing.BlackPlayerID)
+	return err
+}
+
+// notifyRoundStarted tells both players of a pairing that their game in
+// the new round has started
+func (s *Service) notifyRoundStarted(t *models.Tournament, pairing *models.TournamentPairing) {
+	if s.notifications == nil {
+		return
+	}
+	for _, userID := range []uint{pairing.WhitePlayerID, *pairing.BlackPlayerID} {
+		err := s.notifications.Notify(userID, models.NotificationRoundStarted, *pairing.GameID, models.JSONMap{
+			"tournamentId":   t.ID,
+			"tournamentName": t.Name,
+			"round":          t.CurrentRound,
+			"board":          pairing.Board,
+		})
+		if err != nil {
+			log.Printf("tournament %d: failed to notify user %d: %v", t.ID, userID, err)
+		}
+	}
+}
+
+// loadEntrants builds the pairing engine's view of the active players
+func (s *Service) loadEntrants(tournamentID uint) ([]*entrant, error) {
+	var players []models.TournamentPlayer
+	if err := s.db.Where("tournament_id = ?", tournamentID).Find(&players).Error; err != nil {
+		return nil, err
+	}
+	var pairings []models.TournamentPairing
+	if err := s.db.Where("tournament_id = ?", tournamentID).Find(&pairings).Error; err != nil {
+		return nil, err
+	}
+
+	// Pairing numbers follow the starting ratings
+	sort.SliceStable(players, func(i, j int) bool {
+		if players[i].StartingRating != players[j].StartingRating {
+			return players[i].StartingRating > players[j].StartingRating
+		}
+		return players[i].ID < players[j].ID
+	})
+
+	byUser := make(map[uint]*entrant, len(players))
+	entrants := make([]*entrant, 0, len(players))
+	for i, p := range players {
+		e := &entrant{
+			id:        p.UserID,
+			rank:      i + 1,
+			score:     p.Score,
+			opponents: make(map[uint]bool),
+		}
+		byUser[p.UserID] = e
+		if !p.Withdrawn {
+			entrants = append(entrants, e)
+		}
+	}
+
+	sort.SliceStable(pairings, func(i, j int) bool { return pairings[i].ID < pairings[j].ID })
+	for _, p := range pairings {
+		w := byUser[p.WhitePlayerID]
+		if w == nil {
+			continue
+		}
+		if p.BlackPlayerID == nil {
+			w.hadBye = true
+			continue
+		}
+		b := byUser[*p.BlackPlayerID]
+		if b == nil {
+			continue
+		}
+		w.colors = append(w.colors, white)
+		b.colors = append(b.colors, black)
+		w.opponents[b.id] = true
+		b.opponents[w.id] = true
+	}
+	return entrants, nil
+}
+
+func (s *Service) addScore(tx *gorm.DB, tournamentID, userID uint, points float64) error {
+	if points == 0 {
+		return nil
+	}
+	return tx.Model(&models.TournamentPlayer{}).
+		Where("tournament_id = ? AND user_id = ?", tournamentID, userID).
+		Update("score", gorm.Expr("score + ?", points)).Error
+}
+
+// publishStandings pushes the current table to the tournament's followers
+func (s *Service) publishStandings(t *models.Tournament) {
+	standings, err := s.GetStandings(t.ID)
+	if err != nil {
+		log.Printf("tournament %d: failed to compute standings: %v", t.ID, err)
+		return
+	}
+	s.publish(t.ID, map[string]interface{}{"type": "standings", "standings": standings})
+}
+
+func (s *Service) publish(tournamentID uint, data map[string]interface{}) {
+	if s.hub == nil {
+		return
+	}
+	data["tournamentId"] = tournamentID
+	s.hub.BroadcastTournament(tournamentID, data)
+}
+
+func (s *Service) loadTournament(tournamentID uint) (*models.Tournament, error) {
+	var t models.Tournament
+	if err := s.db.First(&t, tournamentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTournamentNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
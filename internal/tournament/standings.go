@@ -0,0 +1,103 @@
+package tournament
+
+import (
+	"sort"
+
+	"chess-app/internal/models"
+)
+
+// Standing is a player's line in the tournament table
+type Standing struct {
+	Rank            int     `json:"rank"`
+	UserID          uint    `json:"userId"`
+	Username        string  `json:"username"`
+	Rating          int     `json:"rating"` // Starting rating
+	Score           float64 `json:"score"`
+	Played          int     `json:"played"`
+	Buchholz        float64 `json:"buchholz"`
+	SonnebornBerger float64 `json:"sonnebornBerger"`
+	Streak          int     `json:"streak,omitempty"`       // Arena only
+	Performance     int     `json:"performance,omitempty"`  // Arena only
+	RoundReached    int     `json:"roundReached,omitempty"` // Knockout only
+	Withdrawn       bool    `json:"withdrawn"`
+}
+
+// points returns what each side scored in a finished pairing
+func points(result models.GameResult) (whitePoints, blackPoints float64, ok bool) {
+	switch result {
+	case models.GameResultWhiteWins:
+		return 1, 0, true
+	case models.GameResultBlackWins:
+		return 0, 1, true
+	case models.GameResultDraw:
+		return 0.5, 0.5, true
+	}
+	return 0, 0, false
+}
+
+// computeStandings ranks players by score, then Buchholz (sum of the
+// opponents' scores), then Sonneborn-Berger (scores of the opponents beaten
+// plus half those drawn with), then starting rating. Byes count toward the
+// score but not the tie-breaks; annulled games count for neither.
+func computeStandings(players []models.TournamentPlayer, pairings []models.TournamentPairing) []Standing {
+	type opponentResult struct {
+		opponent uint
+		points   float64
+	}
+
+	scores := make(map[uint]float64, len(players))
+	played := make(map[uint]int, len(players))
+	results := make(map[uint][]opponentResult, len(players))
+	for _, p := range pairings {
+		whitePoints, blackPoints, ok := points(p.Result)
+		if !ok || p.Annulled {
+			continue
+		}
+		scores[p.WhitePlayerID] += whitePoints
+		if p.BlackPlayerID == nil {
+			continue
+		}
+		scores[*p.BlackPlayerID] += blackPoints
+		played[p.WhitePlayerID]++
+		played[*p.BlackPlayerID]++
+		results[p.WhitePlayerID] = append(results[p.WhitePlayerID], opponentResult{*p.BlackPlayerID, whitePoints})
+		results[*p.BlackPlayerID] = append(results[*p.BlackPlayerID], opponentResult{p.WhitePlayerID, blackPoints})
+	}
+
+	standings := make([]Standing, len(players))
+	for i, player := range players {
+		s := Standing{
+			UserID:    player.UserID,
+			Rating:    player.StartingRating,
+			Score:     scores[player.UserID],
+			Played:    played[player.UserID],
+			Withdrawn: player.Withdrawn,
+		}
+		if player.User != nil {
+			s.Username = player.User.Username
+		}
+		for _, r := range results[player.UserID] {
+			s.Buchholz += scores[r.opponent]
+			s.SonnebornBerger += r.points * scores[r.opponent]
+		}
+		standings[i] = s
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		if a.SonnebornBerger != b.SonnebornBerger {
+			return a.SonnebornBerger > b.SonnebornBerger
+		}
+		return a.Rating > b.Rating
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings
+}
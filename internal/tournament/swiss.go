@@ -0,0 +1,407 @@
+package tournament
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrNoPairing = errors.New("no valid pairing exists for this round")
+
+const (
+	// Caps on the search in a score group; club-sized groups stay well below
+	maxTranspositions = 5000
+	maxBacktrackSteps = 200000
+)
+
+type color int
+
+const (
+	noColor color = iota
+	white
+	black
+)
+
+func (c color) opposite() color {
+	switch c {
+	case white:
+		return black
+	case black:
+		return white
+	}
+	return noColor
+}
+
+// Strength of a color preference, as in the FIDE Dutch rules
+const (
+	preferenceNone = iota
+	preferenceMild
+	preferenceStrong
+	preferenceAbsolute
+)
+
+// entrant is a player as seen by the pairing engine
+type entrant struct {
+	id        uint
+	rank      int // Pairing number, 1 for the highest seed
+	score     float64
+	colors    []color // Colors of the games played, in round order
+	opponents map[uint]bool
+	hadBye    bool
+}
+
+// colorPreference returns the color the player should get next and how
+// strongly
+func (e *entrant) colorPreference() (color, int) {
+	n := len(e.colors)
+	if n == 0 {
+		return noColor, preferenceNone
+	}
+	diff := 0
+	for _, c := range e.colors {
+		if c == white {
+			diff++
+		} else {
+			diff--
+		}
+	}
+	last := e.colors[n-1]
+	sameTwice := n >= 2 && e.colors[n-2] == last
+
+	switch {
+	case diff > 1 || (sameTwice && last == white):
+		return black, preferenceAbsolute
+	case diff < -1 || (sameTwice && last == black):
+		return white, preferenceAbsolute
+	case diff == 1:
+		return black, preferenceStrong
+	case diff == -1:
+		return white, preferenceStrong
+	}
+	return last.opposite(), preferenceMild
+}
+
+// compatible reports whether two players may meet: they must not have met
+// before, and with strictColors they must not both need the same color
+func compatible(a, b *entrant, strictColors bool) bool {
+	if a.opponents[b.id] {
+		return false
+	}
+	if strictColors {
+		ca, sa := a.colorPreference()
+		cb, sb := b.colorPreference()
+		if sa == preferenceAbsolute && sb == preferenceAbsolute && ca == cb {
+			return false
+		}
+	}
+	return true
+}
+
+// allocateColors returns the pair as (white, black). higher is the player
+// ranked higher in the score group; topWhite decides colors for two players
+// without history.
+func allocateColors(higher, lower *entrant, topWhite bool) (*entrant, *entrant) {
+	ch, sh := higher.colorPreference()
+	cl, sl := lower.colorPreference()
+
+	switch {
+	case sh == preferenceNone && sl == preferenceNone:
+		if topWhite {
+			return higher, lower
+		}
+		return lower, higher
+	case ch != cl && ch != noColor && cl != noColor:
+		if ch == white {
+			return higher, lower
+		}
+		return lower, higher
+	case sl > sh:
+		if cl == white {
+			return lower, higher
+		}
+		return higher, lower
+	}
+	// The higher ranked player gets their preference
+	if ch == white {
+		return higher, lower
+	}
+	return lower, higher
+}
+
+// pair is a game to be played, colors already allocated
+type pair struct {
+	white, black *entrant
+}
+
+// pairSwiss pairs a round with the Dutch system: players are split into
+// score groups, each group's top half meets its bottom half in order, and
+// transpositions and exchanges are tried until no one meets a previous
+// opponent. Players who cannot be paired in their group float down to the
+// next one. The lowest ranked player without a bye gets one if the count is
+// odd.
+func pairSwiss(players []*entrant, round int) ([]pair, *entrant, error) {
+	sorted := make([]*entrant, len(players))
+	copy(sorted, players)
+	sortByStanding(sorted)
+
+	var bye *entrant
+	if len(sorted)%2 == 1 {
+		bye = sorted[len(sorted)-1]
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if !sorted[i].hadBye {
+				bye = sorted[i]
+				break
+			}
+		}
+		sorted = remove(sorted, bye)
+	}
+
+	games, ok := pairByScoreGroups(sorted)
+	if !ok {
+		// The score groups could not be completed; pair the whole field,
+		// still preferring opponents with close scores
+		games, ok = pairAny(sorted, true)
+		if !ok {
+			games, ok = pairAny(sorted, false)
+		}
+		if !ok {
+			return nil, nil, ErrNoPairing
+		}
+	}
+
+	pairs := make([]pair, len(games))
+	for i, g := range games {
+		// In the first round the top half alternates colors board by board
+		w, b := allocateColors(g[0], g[1], round > 1 || i%2 == 0)
+		pairs[i] = pair{white: w, black: b}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return boardKey(pairs[i]).less(boardKey(pairs[j]))
+	})
+	return pairs, bye, nil
+}
+
+// pairByScoreGroups pairs the score groups from the top down, carrying
+// unpaired players into the next group
+func pairByScoreGroups(sorted []*entrant) ([][2]*entrant, bool) {
+	var groups [][]*entrant
+	for i := 0; i < len(sorted); {
+		j := i
+		for j < len(sorted) && sorted[j].score == sorted[i].score {
+			j++
+		}
+		groups = append(groups, sorted[i:j])
+		i = j
+	}
+
+	var result [][2]*entrant
+	var floaters []*entrant
+	for i, group := range groups {
+		bracket := append(append([]*entrant{}, floaters...), group...)
+		last := i == len(groups)-1
+		pairs, rest, ok := pairBracket(bracket, last)
+		if !ok {
+			return nil, false
+		}
+		result = append(result, pairs...)
+		floaters = rest
+	}
+	return result, len(floaters) == 0
+}
+
+// pairBracket pairs as many players of a score group as possible. Players
+// left over float down, lowest ranked first. The last group must be paired
+// completely.
+func pairBracket(bracket []*entrant, last bool) ([][2]*entrant, []*entrant, bool) {
+	for floatCount := len(bracket) % 2; floatCount <= len(bracket); floatCount += 2 {
+		if last && floatCount > 0 {
+			return nil, nil, false
+		}
+		for _, floaters := range floaterChoices(bracket, floatCount) {
+			rest := bracket
+			for _, f := range floaters {
+				rest = remove(rest, f)
+			}
+			if pairs, ok := pairHomogeneous(rest); ok {
+				return pairs, floaters, true
+			}
+		}
+	}
+	return nil, bracket, !last
+}
+
+// floaterChoices lists the sets of players to move down, most preferred
+// first. A single floater is tried from the bottom up; larger sets are just
+// the lowest ranked players.
+func floaterChoices(bracket []*entrant, count int) [][]*entrant {
+	if count == 0 {
+		return [][]*entrant{nil}
+	}
+	if count == 1 {
+		choices := make([][]*entrant, 0, len(bracket))
+		for i := len(bracket) - 1; i >= 0; i-- {
+			choices = append(choices, []*entrant{bracket[i]})
+		}
+		return choices
+	}
+	return [][]*entrant{append([]*entrant{}, bracket[len(bracket)-count:]...)}
+}
+
+// pairHomogeneous pairs an even group: S1 (top half) against S2 (bottom
+// half), then transpositions of S2, then exchanges between the halves
+func pairHomogeneous(players []*entrant) ([][2]*entrant, bool) {
+	if len(players) == 0 {
+		return nil, true
+	}
+	half := len(players) / 2
+	s1 := append([]*entrant{}, players[:half]...)
+	s2 := append([]*entrant{}, players[half:]...)
+
+	if pairs, ok := tryTranspositions(s1, s2); ok {
+		return pairs, true
+	}
+
+	for i := len(s1) - 1; i >= 0; i-- {
+		for j := 0; j < len(s2); j++ {
+			x1 := append([]*entrant{}, s1...)
+			x2 := append([]*entrant{}, s2...)
+			x1[i], x2[j] = x2[j], x1[i]
+			sortByStanding(x1)
+			sortByStanding(x2)
+			if pairs, ok := tryTranspositions(x1, x2); ok {
+				return pairs, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// tryTranspositions walks the permutations of s2 in lexicographic order and
+// returns the first one giving valid pairs
+func tryTranspositions(s1, s2 []*entrant) ([][2]*entrant, bool) {
+	perm := make([]int, len(s2))
+	for i := range perm {
+		perm[i] = i
+	}
+	for tries := 0; tries < maxTranspositions; tries++ {
+		valid := true
+		for i := range s1 {
+			if !compatible(s1[i], s2[perm[i]], true) {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			pairs := make([][2]*entrant, len(s1))
+			for i := range s1 {
+				pairs[i] = [2]*entrant{s1[i], s2[perm[i]]}
+			}
+			return pairs, true
+		}
+		if !nextPermutation(perm) {
+			break
+		}
+	}
+	return nil, false
+}
+
+// pairAny pairs the whole field by backtracking, trying opponents with the
+// closest score first
+func pairAny(players []*entrant, strictColors bool) ([][2]*entrant, bool) {
+	steps := 0
+	var solve func(rest []*entrant) ([][2]*entrant, bool)
+	solve = func(rest []*entrant) ([][2]*entrant, bool) {
+		if len(rest) == 0 {
+			return nil, true
+		}
+		steps++
+		if steps > maxBacktrackSteps {
+			return nil, false
+		}
+		top := rest[0]
+		candidates := append([]*entrant{}, rest[1:]...)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			di, dj := abs(top.score-candidates[i].score), abs(top.score-candidates[j].score)
+			if di != dj {
+				return di < dj
+			}
+			return candidates[i].rank < candidates[j].rank
+		})
+		for _, c := range candidates {
+			if !compatible(top, c, strictColors) {
+				continue
+			}
+			if pairs, ok := solve(remove(rest[1:], c)); ok {
+				return append([][2]*entrant{{top, c}}, pairs...), true
+			}
+		}
+		return nil, false
+	}
+	return solve(players)
+}
+
+// sortByStanding orders by score, then pairing number
+func sortByStanding(players []*entrant) {
+	sort.SliceStable(players, func(i, j int) bool {
+		if players[i].score != players[j].score {
+			return players[i].score > players[j].score
+		}
+		return players[i].rank < players[j].rank
+	})
+}
+
+type boardOrder struct {
+	score float64
+	rank  int
+}
+
+func (a boardOrder) less(b boardOrder) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.rank < b.rank
+}
+
+// boardKey puts the games with the highest scores on the top boards
+func boardKey(p pair) boardOrder {
+	key := boardOrder{score: p.white.score, rank: p.white.rank}
+	if p.black.score > key.score || (p.black.score == key.score && p.black.rank < key.rank) {
+		key = boardOrder{score: p.black.score, rank: p.black.rank}
+	}
+	return key
+}
+
+func nextPermutation(p []int) bool {
+	i := len(p) - 2
+	for i >= 0 && p[i] >= p[i+1] {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+	j := len(p) - 1
+	for p[j] <= p[i] {
+		j--
+	}
+	p[i], p[j] = p[j], p[i]
+	for l, r := i+1, len(p)-1; l < r; l, r = l+1, r-1 {
+		p[l], p[r] = p[r], p[l]
+	}
+	return true
+}
+
+func remove(players []*entrant, target *entrant) []*entrant {
+	result := make([]*entrant, 0, len(players))
+	for _, p := range players {
+		if p != target {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,288 @@
+package tournament
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// field returns n players without games, ranked and numbered 1 to n
+func field(n int) []*entrant {
+	players := make([]*entrant, n)
+	for i := range players {
+		players[i] = &entrant{id: uint(i + 1), rank: i + 1, opponents: map[uint]bool{}}
+	}
+	return players
+}
+
+// play records a game between two players
+func play(w, b *entrant) {
+	w.colors = append(w.colors, white)
+	b.colors = append(b.colors, black)
+	w.opponents[b.id] = true
+	b.opponents[w.id] = true
+}
+
+// history gives a player games of the given colors against players outside
+// the field, so that only their color preference changes
+func history(p *entrant, colors ...color) {
+	for i, c := range colors {
+		p.colors = append(p.colors, c)
+		p.opponents[uint(100+i)] = true
+	}
+}
+
+func ids(pairs []pair) [][2]uint {
+	result := make([][2]uint, len(pairs))
+	for i, p := range pairs {
+		result[i] = [2]uint{p.white.id, p.black.id}
+	}
+	return result
+}
+
+func TestPairSwiss(t *testing.T) {
+	tests := []struct {
+		name    string
+		players int
+		round   int
+		setup   func(p []*entrant)
+		want    [][2]uint // (white, black) board by board
+		wantBye uint
+		wantErr error
+	}{
+		{
+			name:    "first round alternates colors",
+			players: 4,
+			round:   1,
+			want:    [][2]uint{{1, 3}, {4, 2}},
+		},
+		{
+			name:    "odd count gives the lowest a bye",
+			players: 5,
+			round:   1,
+			want:    [][2]uint{{1, 3}, {4, 2}},
+			wantBye: 5,
+		},
+		{
+			name:    "bye skips a player who had one",
+			players: 5,
+			round:   2,
+			setup: func(p []*entrant) {
+				p[4].hadBye = true
+				p[3].hadBye = true
+			},
+			want:    [][2]uint{{1, 4}, {2, 5}},
+			wantBye: 3,
+		},
+		{
+			name:    "odd score group floats its lowest down",
+			players: 6,
+			round:   2,
+			setup: func(p []*entrant) {
+				play(p[0], p[3])
+				play(p[4], p[1])
+				play(p[2], p[5])
+				p[0].score, p[1].score, p[2].score = 1, 1, 1
+			},
+			want: [][2]uint{{2, 1}, {5, 3}, {4, 6}},
+		},
+		{
+			name:    "no repeat pairings",
+			players: 4,
+			round:   2,
+			setup: func(p []*entrant) {
+				play(p[0], p[2])
+				play(p[3], p[1])
+				for _, e := range p {
+					e.score = 0.5
+				}
+			},
+			want: [][2]uint{{4, 1}, {2, 3}},
+		},
+		{
+			name:    "players needing the same color are not paired",
+			players: 4,
+			round:   3,
+			setup: func(p []*entrant) {
+				history(p[0], black, black)
+				history(p[2], black, black)
+				history(p[1], white, white)
+				history(p[3], white, white)
+			},
+			want: [][2]uint{{1, 4}, {3, 2}},
+		},
+		{
+			name:    "everyone has met",
+			players: 4,
+			round:   4,
+			setup: func(p []*entrant) {
+				play(p[0], p[1])
+				play(p[2], p[3])
+				play(p[3], p[0])
+				play(p[1], p[2])
+				play(p[0], p[2])
+				play(p[3], p[1])
+			},
+			wantErr: ErrNoPairing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			players := field(tt.players)
+			if tt.setup != nil {
+				tt.setup(players)
+			}
+			pairs, bye, err := pairSwiss(players, tt.round)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := ids(pairs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pairs %v, want %v", got, tt.want)
+			}
+			var byeID uint
+			if bye != nil {
+				byeID = bye.id
+			}
+			if byeID != tt.wantBye {
+				t.Errorf("bye to %d, want %d", byeID, tt.wantBye)
+			}
+
+			seen := map[uint]bool{}
+			if bye != nil {
+				seen[bye.id] = true
+			}
+			for _, p := range pairs {
+				if p.white.opponents[p.black.id] {
+					t.Errorf("%d and %d meet again", p.white.id, p.black.id)
+				}
+				for _, e := range []*entrant{p.white, p.black} {
+					if seen[e.id] {
+						t.Errorf("%d paired twice", e.id)
+					}
+					seen[e.id] = true
+				}
+			}
+			if len(seen) != tt.players {
+				t.Errorf("%d of %d players paired", len(seen), tt.players)
+			}
+		})
+	}
+}
+
+func TestPairBracket(t *testing.T) {
+	tests := []struct {
+		name      string
+		players   int
+		last      bool
+		setup     func(p []*entrant)
+		want      [][2]uint
+		wantFloat []uint
+		wantOK    bool
+	}{
+		{
+			name:      "odd bracket floats its lowest",
+			players:   3,
+			want:      [][2]uint{{1, 2}},
+			wantFloat: []uint{3},
+			wantOK:    true,
+		},
+		{
+			name:    "floater is the lowest who leaves a pairing",
+			players: 3,
+			setup: func(p []*entrant) {
+				play(p[0], p[1])
+			},
+			want:      [][2]uint{{1, 3}},
+			wantFloat: []uint{2},
+			wantOK:    true,
+		},
+		{
+			name:    "unpairable players all float",
+			players: 2,
+			setup: func(p []*entrant) {
+				play(p[0], p[1])
+			},
+			wantFloat: []uint{1, 2},
+			wantOK:    true,
+		},
+		{
+			name:    "last bracket cannot float",
+			players: 3,
+			last:    true,
+		},
+		{
+			name:    "last bracket must be paired",
+			players: 2,
+			last:    true,
+			setup: func(p []*entrant) {
+				play(p[0], p[1])
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			players := field(tt.players)
+			if tt.setup != nil {
+				tt.setup(players)
+			}
+			games, floaters, ok := pairBracket(players, tt.last)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			var got [][2]uint
+			for _, g := range games {
+				got = append(got, [2]uint{g[0].id, g[1].id})
+			}
+			var gotFloat []uint
+			for _, f := range floaters {
+				gotFloat = append(gotFloat, f.id)
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(gotFloat, tt.wantFloat) {
+				t.Errorf("pairs %v floaters %v, want %v floaters %v", got, gotFloat, tt.want, tt.wantFloat)
+			}
+		})
+	}
+}
+
+func TestAllocateColors(t *testing.T) {
+	tests := []struct {
+		name          string
+		higher, lower []color
+		topWhite      bool
+		wantHigher    color
+	}{
+		{"no history, top white", nil, nil, true, white},
+		{"no history, top black", nil, nil, false, black},
+		{"opposite preferences", []color{white}, []color{black}, true, black},
+		{"stronger preference wins", []color{black, white}, []color{white}, true, white},
+		{"absolute beats strong", []color{white}, []color{white, white}, false, white},
+		{"equal preferences favor the higher", []color{black}, []color{black}, false, white},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			players := field(2)
+			history(players[0], tt.higher...)
+			history(players[1], tt.lower...)
+			w, b := allocateColors(players[0], players[1], tt.topWhite)
+			if w == b {
+				t.Fatal("same player on both sides")
+			}
+			got := black
+			if w == players[0] {
+				got = white
+			}
+			if got != tt.wantHigher {
+				t.Errorf("higher got %v, want %v", got, tt.wantHigher)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package uci
+
+import (
+	"context"
+
+	"chess-app/internal/bot"
+	"chess-app/internal/chess"
+	"chess-app/internal/game"
+	"chess-app/internal/models"
+)
+
+// BotEngine is a bot.Engine that asks an external UCI engine for moves.
+// The engine is sent the full move list of the game so it can detect
+// repetitions, and the game clocks so it can manage its own time.
+type BotEngine struct {
+	pool    *Pool
+	service *game.Service
+}
+
+// NewBotEngine creates a bot engine backed by a pool of engine processes
+func NewBotEngine(pool *Pool, service *game.Service) *BotEngine {
+	return &BotEngine{pool: pool, service: service}
+}
+
+// BestMove implements bot.Engine
+func (b *BotEngine) BestMove(ctx context.Context, g *models.Game, level bot.Level) (string, error) {
+	history, err := b.service.GetGameHistory(g.ID)
+	if err != nil {
+		return "", err
+	}
+
+	pos := Position{FEN: chess.NewEngine().GetFEN()}
+	for _, move := range history {
+		pos.Moves = append(pos.Moves, move.MoveNotation)
+	}
+
+	limits := ClockLimits(g)
+	limits.Depth = level.Depth
+
+	result, err := b.pool.Search(ctx, pos, limits)
+	if err != nil {
+		return "", err
+	}
+	return result.BestMove, nil
+}
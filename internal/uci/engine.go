@@ -0,0 +1,283 @@
+// Package uci drives external chess engines over the Universal Chess
+// Interface protocol.
+package uci
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEngineClosed = errors.New("engine process has exited")
+	ErrNoBestMove   = errors.New("engine returned no move")
+)
+
+const (
+	// How long to wait for the engine to answer "uci" and "isready"
+	handshakeTimeout = 10 * time.Second
+	// How long to wait for the process to exit after "quit"
+	quitTimeout = 2 * time.Second
+)
+
+// Engine is a running UCI engine process. It is safe for concurrent use,
+// but searches are serialised.
+type Engine struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan string   // stdout, closed when the process exits
+	quit  chan struct{} // closed by Close to release the reader
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Position describes the position to search: a starting FEN (empty for the
+// standard starting position) followed by moves in UCI notation
+type Position struct {
+	FEN   string
+	Moves []string
+}
+
+// SearchResult is the engine's answer to "go"
+type SearchResult struct {
+	BestMove string
+	Ponder   string
+	Info     Info // Last info line for the principal variation
+}
+
+// Start launches an engine binary and completes the UCI handshake
+func Start(ctx context.Context, path string, args ...string) (*Engine, error) {
+	cmd := exec.Command(path, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start engine %s: %w", path, err)
+	}
+
+	e := &Engine{
+		cmd:   cmd,
+		stdin: stdin,
+		lines: make(chan string, 64),
+		quit:  make(chan struct{}),
+	}
+	go e.readLoop(stdout)
+
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	if err := e.send("uci"); err != nil {
+		e.Close()
+		return nil, err
+	}
+	for {
+		line, err := e.readLine(ctx)
+		if err != nil {
+			e.Close()
+			return nil, fmt.Errorf("uci handshake failed: %w", err)
+		}
+		if name, ok := strings.CutPrefix(line, "id name "); ok {
+			e.name = name
+		}
+		if line == "uciok" {
+			break
+		}
+	}
+
+	if err := e.waitReady(ctx); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Name returns the engine name reported during the handshake
+func (e *Engine) Name() string {
+	return e.name
+}
+
+// SetOption sets an engine option, e.g. "Threads" or "Skill Level"
+func (e *Engine) SetOption(ctx context.Context, name, value string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.send(fmt.Sprintf("setoption name %s value %s", name, value)); err != nil {
+		return err
+	}
+	return e.waitReady(ctx)
+}
+
+// NewGame tells the engine the next search is from a different game
+func (e *Engine) NewGame(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.send("ucinewgame"); err != nil {
+		return err
+	}
+	return e.waitReady(ctx)
+}
+
+// Search sets up a position and searches it until the engine reports a best
+// move. If ctx is cancelled the engine is told to stop and its current best
+// move is returned.
+func (e *Engine) Search(ctx context.Context, pos Position, limits GoLimits) (*SearchResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.send(pos.command()); err != nil {
+		return nil, err
+	}
+	if err := e.send(limits.command()); err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{}
+	stopped := false
+	for {
+		line, err := e.readLine(ctx)
+		if err != nil && ctx.Err() != nil && !stopped {
+			// Ask for the move found so far and keep reading until it arrives
+			stopped = true
+			if err := e.send("stop"); err != nil {
+				return nil, err
+			}
+			stopCtx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+			defer cancel()
+			ctx = stopCtx
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "info":
+			info := ParseInfo(line)
+			if len(info.PV) > 0 && info.MultiPV <= 1 {
+				result.Info = info
+			}
+		case "bestmove":
+			if len(fields) < 2 || fields[1] == "(none)" || fields[1] == "0000" {
+				return nil, ErrNoBestMove
+			}
+			result.BestMove = fields[1]
+			if len(fields) >= 4 && fields[2] == "ponder" {
+				result.Ponder = fields[3]
+			}
+			return result, nil
+		}
+	}
+}
+
+// Close asks the engine to quit and kills it if it does not exit promptly
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	close(e.quit)
+	e.send("quit")
+	e.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- e.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(quitTimeout):
+		e.cmd.Process.Kill()
+		return <-done
+	}
+}
+
+// Closed reports whether the engine has been closed
+func (e *Engine) Closed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closed
+}
+
+func (e *Engine) waitReady(ctx context.Context) error {
+	if err := e.send("isready"); err != nil {
+		return err
+	}
+	for {
+		line, err := e.readLine(ctx)
+		if err != nil {
+			return err
+		}
+		if line == "readyok" {
+			return nil
+		}
+	}
+}
+
+func (e *Engine) send(command string) error {
+	if _, err := io.WriteString(e.stdin, command+"\n"); err != nil {
+		return ErrEngineClosed
+	}
+	return nil
+}
+
+func (e *Engine) readLine(ctx context.Context) (string, error) {
+	select {
+	case line, ok := <-e.lines:
+		if !ok {
+			return "", ErrEngineClosed
+		}
+		return line, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (e *Engine) readLoop(stdout io.Reader) {
+	defer close(e.lines)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		select {
+		case e.lines <- strings.TrimSpace(scanner.Text()):
+		case <-e.quit:
+			return
+		}
+	}
+}
+
+func (p Position) command() string {
+	var b strings.Builder
+	if p.FEN == "" {
+		b.WriteString("position startpos")
+	} else {
+		b.WriteString("position fen ")
+		b.WriteString(p.FEN)
+	}
+	if len(p.Moves) > 0 {
+		b.WriteString(" moves ")
+		b.WriteString(strings.Join(p.Moves, " "))
+	}
+	return b.String()
+}
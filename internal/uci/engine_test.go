@@ -0,0 +1,255 @@
+package uci
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as a fake engine when run as
+// "<binary> fake-engine <mode> [dir]"
+func TestMain(m *testing.M) {
+	if len(os.Args) > 2 && os.Args[1] == "fake-engine" {
+		dir := ""
+		if len(os.Args) > 3 {
+			dir = os.Args[3]
+		}
+		os.Exit(fakeEngine(os.Args[2], dir))
+	}
+	// Fake engines built with -race would otherwise wait a second on exit
+	os.Setenv("GORACE", strings.TrimSpace(os.Getenv("GORACE")+" atexit_sleep_ms=0"))
+	os.Exit(m.Run())
+}
+
+// fakeEngine speaks enough UCI for the tests. Modes change how it answers
+// "go":
+//
+//	normal      info lines, then a best move
+//	slow        the same after a short pause
+//	infinite    one info line, then a best move once told to stop
+//	nomove      no legal move
+//	crash       exits without answering
+//	crash-once  exits the first time, answers normally in later processes
+//	silent      does not even answer "uci"
+//
+// With a directory, each process leaves a file named after its PID there.
+func fakeEngine(mode, dir string) int {
+	if dir != "" {
+		os.WriteFile(filepath.Join(dir, strconv.Itoa(os.Getpid())), nil, 0o644)
+	}
+
+	searching := false
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		line := in.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			if mode == "silent" {
+				continue
+			}
+			fmt.Println("id name Fake Engine")
+			fmt.Println("id author The Tests")
+			fmt.Println("option name Threads type spin default 1 min 1 max 8")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "setoption":
+			if strings.HasPrefix(line, "setoption name Fail ") {
+				return 1
+			}
+		case "go":
+			switch mode {
+			case "crash":
+				return 1
+			case "crash-once":
+				marker := filepath.Join(dir, "crashed")
+				if _, err := os.Stat(marker); err != nil {
+					os.WriteFile(marker, nil, 0o644)
+					return 1
+				}
+			case "nomove":
+				fmt.Println("bestmove (none)")
+				continue
+			case "infinite":
+				fmt.Println("info depth 1 score cp 10 pv d2d4")
+				searching = true
+				continue
+			case "slow":
+				time.Sleep(20 * time.Millisecond)
+			}
+			fmt.Println("info depth 1 score cp 20 pv e2e4")
+			fmt.Println("info depth 2 multipv 2 score cp 5 pv d2d4 d7d5")
+			fmt.Println("info string searching hard")
+			fmt.Println("info depth 3 seldepth 5 score mate 3 nodes 1000 nps 5000 time 200 pv e2e4 e7e5 d1h5")
+			fmt.Println("info depth 3 currmove e2e4 currmovenumber 1")
+			fmt.Println("bestmove e2e4 ponder e7e5")
+		case "stop":
+			if searching {
+				searching = false
+				fmt.Println("bestmove d2d4")
+			}
+		case "quit":
+			return 0
+		}
+	}
+	return 0
+}
+
+func startFake(t *testing.T, mode string) *Engine {
+	t.Helper()
+	e, err := Start(context.Background(), os.Args[0], "fake-engine", mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+// processes counts the fake engines started with dir
+func processes(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHandshake(t *testing.T) {
+	e := startFake(t, "normal")
+	if e.Name() != "Fake Engine" {
+		t.Errorf("name %q", e.Name())
+	}
+	ctx := context.Background()
+	if err := e.SetOption(ctx, "Threads", "2"); err != nil {
+		t.Error(err)
+	}
+	if err := e.NewGame(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := Start(ctx, os.Args[0], "fake-engine", "silent")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline error", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("handshake gave up after %v", time.Since(start))
+	}
+}
+
+func TestStartMissingBinary(t *testing.T) {
+	if _, err := Start(context.Background(), filepath.Join(t.TempDir(), "no-such-engine")); err == nil {
+		t.Error("started a missing binary")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	e := startFake(t, "normal")
+	result, err := e.Search(context.Background(), Position{Moves: []string{"e2e4"}}, GoLimits{Depth: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BestMove != "e2e4" || result.Ponder != "e7e5" {
+		t.Errorf("bestmove %q ponder %q", result.BestMove, result.Ponder)
+	}
+	// The last info line with a main line, not the second variation
+	info := result.Info
+	if info.Depth != 3 || !info.Score.IsMate || info.Score.Mate != 3 || len(info.PV) != 3 {
+		t.Errorf("info %+v", info)
+	}
+
+	// The engine can search again
+	if _, err := e.Search(context.Background(), Position{}, GoLimits{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSearchStopsWhenCancelled(t *testing.T) {
+	e := startFake(t, "infinite")
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		result, err := e.Search(ctx, Position{}, GoLimits{})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.BestMove != "d2d4" || result.Info.Score.CP != 10 {
+			t.Errorf("result %+v", result)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("stopped after %v", time.Since(start))
+		}
+	}
+}
+
+func TestSearchNoMove(t *testing.T) {
+	e := startFake(t, "nomove")
+	if _, err := e.Search(context.Background(), Position{}, GoLimits{}); !errors.Is(err, ErrNoBestMove) {
+		t.Errorf("got %v, want ErrNoBestMove", err)
+	}
+}
+
+func TestSearchEngineCrash(t *testing.T) {
+	e := startFake(t, "crash")
+	if _, err := e.Search(context.Background(), Position{}, GoLimits{}); !errors.Is(err, ErrEngineClosed) {
+		t.Errorf("got %v, want ErrEngineClosed", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	e := startFake(t, "normal")
+	if err := e.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	if !e.Closed() {
+		t.Error("engine not marked closed")
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+	if _, err := e.Search(context.Background(), Position{}, GoLimits{}); !errors.Is(err, ErrEngineClosed) {
+		t.Errorf("search after close: got %v, want ErrEngineClosed", err)
+	}
+}
+
+func TestPositionCommand(t *testing.T) {
+	tests := []struct {
+		pos  Position
+		want string
+	}{
+		{Position{}, "position startpos"},
+		{Position{Moves: []string{"e2e4", "e7e5"}}, "position startpos moves e2e4 e7e5"},
+		{
+			Position{FEN: "8/8/8/8/8/8/4k3/4K3 w - - 0 1", Moves: []string{"e1d1"}},
+			"position fen 8/8/8/8/8/8/4k3/4K3 w - - 0 1 moves e1d1",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.pos.command(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
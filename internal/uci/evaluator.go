@@ -0,0 +1,44 @@
+package uci
+
+import (
+	"context"
+
+	"chess-app/internal/analysis"
+)
+
+// Evaluator is an analysis.Evaluator backed by a pool of UCI engines
+type Evaluator struct {
+	pool   *Pool
+	name   string
+	limits GoLimits
+}
+
+// NewEvaluator creates an evaluator searching each position within limits
+func NewEvaluator(pool *Pool, name string, limits GoLimits) *Evaluator {
+	return &Evaluator{pool: pool, name: name, limits: limits}
+}
+
+// Name implements analysis.Evaluator
+func (e *Evaluator) Name() string {
+	return e.name
+}
+
+// Evaluate implements analysis.Evaluator
+func (e *Evaluator) Evaluate(ctx context.Context, fen string) (*analysis.Evaluation, error) {
+	result, err := e.pool.Search(ctx, Position{FEN: fen}, e.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	score := result.Info.Score
+	eval := &analysis.Evaluation{CP: score.CP, BestMove: result.BestMove}
+	if score.IsMate {
+		eval.IsMate = true
+		eval.Mate = score.Mate
+		eval.CP = analysis.MateCP
+		if score.Mate < 0 {
+			eval.CP = -analysis.MateCP
+		}
+	}
+	return eval, nil
+}
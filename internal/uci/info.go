@@ -0,0 +1,80 @@
+package uci
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Info holds the fields of an "info" line the server cares about
+type Info struct {
+	Depth    int
+	SelDepth int
+	MultiPV  int
+	Score    Score
+	Nodes    int64
+	NPS      int64
+	TimeMs   int64
+	PV       []string
+}
+
+// Score is an engine evaluation from the side to move's point of view.
+// Either CP or Mate is meaningful, depending on IsMate.
+type Score struct {
+	CP         int
+	Mate       int // Moves to mate; negative if the side to move is mated
+	IsMate     bool
+	LowerBound bool
+	UpperBound bool
+}
+
+// ParseInfo parses an "info ..." line. Unknown tokens are skipped.
+func ParseInfo(line string) Info {
+	var info Info
+	fields := strings.Fields(line)
+
+	for i := 1; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+
+		switch fields[i] {
+		case "depth":
+			info.Depth, _ = strconv.Atoi(next())
+		case "seldepth":
+			info.SelDepth, _ = strconv.Atoi(next())
+		case "multipv":
+			info.MultiPV, _ = strconv.Atoi(next())
+		case "nodes":
+			info.Nodes, _ = strconv.ParseInt(next(), 10, 64)
+		case "nps":
+			info.NPS, _ = strconv.ParseInt(next(), 10, 64)
+		case "time":
+			info.TimeMs, _ = strconv.ParseInt(next(), 10, 64)
+		case "score":
+			switch next() {
+			case "cp":
+				info.Score.CP, _ = strconv.Atoi(next())
+			case "mate":
+				info.Score.Mate, _ = strconv.Atoi(next())
+				info.Score.IsMate = true
+			}
+		case "lowerbound":
+			info.Score.LowerBound = true
+		case "upperbound":
+			info.Score.UpperBound = true
+		case "pv":
+			// The principal variation runs to the end of the line
+			info.PV = append([]string(nil), fields[i+1:]...)
+			i = len(fields)
+		case "string":
+			// Free text runs to the end of the line
+			i = len(fields)
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,57 @@
+package uci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		line string
+		want Info
+	}{
+		{
+			"info depth 20 seldepth 31 multipv 1 score cp 34 nodes 2500000 nps 1250000 time 2000 pv e2e4 e7e5 g1f3",
+			Info{
+				Depth: 20, SelDepth: 31, MultiPV: 1, Score: Score{CP: 34},
+				Nodes: 2500000, NPS: 1250000, TimeMs: 2000, PV: []string{"e2e4", "e7e5", "g1f3"},
+			},
+		},
+		{
+			"info depth 12 score mate 4 pv d1h5",
+			Info{Depth: 12, Score: Score{Mate: 4, IsMate: true}, PV: []string{"d1h5"}},
+		},
+		{
+			"info depth 9 score mate -2 pv g8f6 d1h5",
+			Info{Depth: 9, Score: Score{Mate: -2, IsMate: true}, PV: []string{"g8f6", "d1h5"}},
+		},
+		{
+			"info depth 15 score cp -120 lowerbound",
+			Info{Depth: 15, Score: Score{CP: -120, LowerBound: true}},
+		},
+		{
+			"info depth 15 score cp 80 upperbound",
+			Info{Depth: 15, Score: Score{CP: 80, UpperBound: true}},
+		},
+		{
+			// Unknown tokens are skipped
+			"info depth 5 currmove e2e4 currmovenumber 1 hashfull 12 tbhits 0",
+			Info{Depth: 5},
+		},
+		{
+			// Free text may contain keywords
+			"info string depth 99 score cp 1000 pv a2a3",
+			Info{},
+		},
+		{
+			// A truncated line does not panic
+			"info depth",
+			Info{},
+		},
+	}
+	for _, tt := range tests {
+		if got := ParseInfo(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q:\n got %+v\nwant %+v", tt.line, got, tt.want)
+		}
+	}
+}
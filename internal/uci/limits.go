@@ -0,0 +1,54 @@
+package uci
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chess-app/internal/models"
+)
+
+// GoLimits are the parameters of a "go" command. Zero values are omitted;
+// with no limits at all the engine is asked to search to depth 1.
+type GoLimits struct {
+	WhiteTime time.Duration
+	BlackTime time.Duration
+	WhiteInc  time.Duration
+	BlackInc  time.Duration
+	MoveTime  time.Duration
+	Depth     int
+	Nodes     int64
+}
+
+// ClockLimits returns limits that let the engine manage its own time from
+// the clocks stored on the game
+func ClockLimits(game *models.Game) GoLimits {
+	increment := time.Duration(game.Increment) * time.Second
+	return GoLimits{
+		WhiteTime: time.Duration(game.WhiteTimeLeft) * time.Second,
+		BlackTime: time.Duration(game.BlackTimeLeft) * time.Second,
+		WhiteInc:  increment,
+		BlackInc:  increment,
+	}
+}
+
+func (l GoLimits) command() string {
+	parts := []string{"go"}
+	add := func(name string, value int64) {
+		if value > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", name, value))
+		}
+	}
+	add("wtime", l.WhiteTime.Milliseconds())
+	add("btime", l.BlackTime.Milliseconds())
+	add("winc", l.WhiteInc.Milliseconds())
+	add("binc", l.BlackInc.Milliseconds())
+	add("movetime", l.MoveTime.Milliseconds())
+	add("depth", int64(l.Depth))
+	add("nodes", l.Nodes)
+
+	if len(parts) == 1 {
+		return "go depth 1"
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,50 @@
+package uci
+
+import (
+	"testing"
+	"time"
+
+	"chess-app/internal/models"
+)
+
+func TestGoCommand(t *testing.T) {
+	tests := []struct {
+		limits GoLimits
+		want   string
+	}{
+		{GoLimits{}, "go depth 1"},
+		{GoLimits{Depth: 12}, "go depth 12"},
+		{GoLimits{MoveTime: 1500 * time.Millisecond, Nodes: 100000}, "go movetime 1500 nodes 100000"},
+		{
+			GoLimits{WhiteTime: time.Minute, BlackTime: 30 * time.Second, WhiteInc: time.Second, BlackInc: time.Second, Depth: 8},
+			"go wtime 60000 btime 30000 winc 1000 binc 1000 depth 8",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.limits.command(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestClockLimits(t *testing.T) {
+	game := &models.Game{TimeControl: 300, Increment: 3, WhiteTimeLeft: 250, BlackTimeLeft: 190}
+	want := GoLimits{
+		WhiteTime: 250 * time.Second,
+		BlackTime: 190 * time.Second,
+		WhiteInc:  3 * time.Second,
+		BlackInc:  3 * time.Second,
+	}
+	if got := ClockLimits(game); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := ClockLimits(game).command(); got != "go wtime 250000 btime 190000 winc 3000 binc 3000" {
+		t.Errorf("command %q", got)
+	}
+
+	// Sudden death sends no increments
+	game.Increment = 0
+	if got := ClockLimits(game).command(); got != "go wtime 250000 btime 190000" {
+		t.Errorf("command %q", got)
+	}
+}
@@ -0,0 +1,142 @@
+package uci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrPoolClosed = errors.New("engine pool is closed")
+
+// Pool limits how many engine processes run at once and reuses idle ones
+// between searches
+type Pool struct {
+	path    string
+	args    []string
+	options map[string]string
+
+	// One slot per allowed process: nil for a free slot, or an idle engine
+	slots chan *Engine
+	done  chan struct{}
+}
+
+// NewPool creates a pool running at most size processes of the engine at
+// path. Options are applied with "setoption" whenever a process starts.
+func NewPool(path string, size int, options map[string]string, args ...string) *Pool {
+	if size <= 0 {
+		size = 1
+	}
+	p := &Pool{
+		path:    path,
+		args:    args,
+		options: options,
+		slots:   make(chan *Engine, size),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		p.slots <- nil
+	}
+	return p
+}
+
+// Acquire returns an engine, waiting for a free slot if the pool is at
+// capacity. The engine must be handed back with Release or Discard.
+func (p *Pool) Acquire(ctx context.Context) (*Engine, error) {
+	var engine *Engine
+	select {
+	case engine = <-p.slots:
+	case <-p.done:
+		return nil, ErrPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// Slots freed after Close must not start new engines
+	select {
+	case <-p.done:
+		if engine != nil {
+			engine.Close()
+		}
+		p.slots <- nil
+		return nil, ErrPoolClosed
+	default:
+	}
+
+	if engine != nil && !engine.Closed() {
+		return engine, nil
+	}
+
+	engine, err := p.start(ctx)
+	if err != nil {
+		p.slots <- nil
+		return nil, err
+	}
+	return engine, nil
+}
+
+// Release returns a healthy engine to the pool for reuse
+func (p *Pool) Release(engine *Engine) {
+	select {
+	case <-p.done:
+		engine.Close()
+		p.slots <- nil
+	default:
+		p.slots <- engine
+	}
+}
+
+// Discard stops an engine that misbehaved and frees its slot
+func (p *Pool) Discard(engine *Engine) {
+	engine.Close()
+	p.slots <- nil
+}
+
+// Search runs a single search on a pooled engine
+func (p *Pool) Search(ctx context.Context, pos Position, limits GoLimits) (*SearchResult, error) {
+	engine, err := p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := engine.Search(ctx, pos, limits)
+	if err != nil && !errors.Is(err, ErrNoBestMove) {
+		p.Discard(engine)
+		return nil, err
+	}
+	p.Release(engine)
+	return result, err
+}
+
+// Close stops idle engines; engines in use are stopped when released
+func (p *Pool) Close() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+
+	for {
+		select {
+		case engine := <-p.slots:
+			if engine != nil {
+				engine.Close()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) start(ctx context.Context) (*Engine, error) {
+	engine, err := Start(ctx, p.path, p.args...)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range p.options {
+		if err := engine.SetOption(ctx, name, value); err != nil {
+			engine.Close()
+			return nil, fmt.Errorf("failed to set engine option %s: %w", name, err)
+		}
+	}
+	return engine, nil
+}
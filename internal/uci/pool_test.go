@@ -0,0 +1,159 @@
+package uci
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFakePool(t *testing.T, size int, mode string, options map[string]string) (*Pool, string) {
+	t.Helper()
+	dir := t.TempDir()
+	p := NewPool(os.Args[0], size, options, "fake-engine", mode, dir)
+	t.Cleanup(p.Close)
+	return p, dir
+}
+
+func TestPoolNeverExceedsSize(t *testing.T) {
+	const size = 3
+	p, dir := newFakePool(t, size, "slow", nil)
+
+	var inUse, maxInUse atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 24)
+	for i := 0; i < 24; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine, err := p.Acquire(context.Background())
+			if err != nil {
+				errs <- err
+				return
+			}
+			n := inUse.Add(1)
+			for {
+				max := maxInUse.Load()
+				if n <= max || maxInUse.CompareAndSwap(max, n) {
+					break
+				}
+			}
+			_, err = engine.Search(context.Background(), Position{}, GoLimits{Depth: 1})
+			inUse.Add(-1)
+			if err != nil {
+				p.Discard(engine)
+				errs <- err
+				return
+			}
+			p.Release(engine)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if max := maxInUse.Load(); max > size || max < 2 {
+		t.Errorf("%d engines in use at once, want 2 to %d", max, size)
+	}
+	// Idle engines are reused rather than started again
+	if n := processes(t, dir); n > size {
+		t.Errorf("%d processes started for a pool of %d", n, size)
+	}
+}
+
+func TestPoolWaitsForFreeSlot(t *testing.T) {
+	p, _ := newFakePool(t, 1, "normal", nil)
+	engine, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline error", err)
+	}
+
+	p.Release(engine)
+	again, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != engine {
+		t.Error("idle engine was not reused")
+	}
+	p.Release(again)
+}
+
+func TestPoolReplacesCrashedEngine(t *testing.T) {
+	p, dir := newFakePool(t, 1, "crash-once", nil)
+
+	if _, err := p.Search(context.Background(), Position{}, GoLimits{}); !errors.Is(err, ErrEngineClosed) {
+		t.Fatalf("got %v, want ErrEngineClosed", err)
+	}
+	result, err := p.Search(context.Background(), Position{}, GoLimits{})
+	if err != nil {
+		t.Fatalf("replacement engine: %v", err)
+	}
+	if result.BestMove != "e2e4" {
+		t.Errorf("bestmove %q", result.BestMove)
+	}
+	if n := processes(t, dir); n != 2 {
+		t.Errorf("%d processes started, want 2", n)
+	}
+}
+
+func TestPoolKeepsEngineWithoutMove(t *testing.T) {
+	p, dir := newFakePool(t, 1, "nomove", nil)
+	for i := 0; i < 2; i++ {
+		if _, err := p.Search(context.Background(), Position{}, GoLimits{}); !errors.Is(err, ErrNoBestMove) {
+			t.Errorf("got %v, want ErrNoBestMove", err)
+		}
+	}
+	// Having no legal move is not the engine's fault
+	if n := processes(t, dir); n != 1 {
+		t.Errorf("%d processes started, want 1", n)
+	}
+}
+
+func TestPoolStartFailureFreesSlot(t *testing.T) {
+	p, _ := newFakePool(t, 1, "normal", map[string]string{"Fail": "1"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := p.Acquire(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("attempt %d: got %v, want a start failure", i+1, err)
+		}
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p, _ := newFakePool(t, 2, "normal", nil)
+	idle, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(idle)
+
+	p.Close()
+	if !idle.Closed() {
+		t.Error("idle engine still running")
+	}
+	p.Release(busy)
+	if !busy.Closed() {
+		t.Error("engine released after close still running")
+	}
+	if _, err := p.Acquire(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("got %v, want ErrPoolClosed", err)
+	}
+	p.Close()
+}